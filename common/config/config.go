@@ -0,0 +1,251 @@
+// Package config loads the settings of a service into a typed struct.
+//
+// Settings are merged from, in increasing precedence: the values the struct
+// holds before loading (the defaults), a YAML file, a .env file and the
+// environment. Struct fields are tagged with their YAML key and environment
+// variable:
+//
+//	type Config struct {
+//		Addr     string          `yaml:"addr" env:"HTTP_ADDR"`
+//		Secret   string          `yaml:"secret" env:"CURSOR_SECRET" required:"true" secret:"true"`
+//		Database config.Database `yaml:"database"`
+//	}
+//
+// Every variable X can instead be read from the file named by X_FILE, as
+// with Docker secrets. A required field must end up non-zero, and the value
+// of a secret field never appears in an error. Structs without an env tag
+// are loaded field by field. If the struct has a Validate() error method,
+// it runs last, for rules spanning several fields; each error it joins with
+// errors.Join is reported as a problem of its own.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Sources says where to read settings from besides the environment.
+type Sources struct {
+	// YAMLFile is read if set, and must then exist. Unknown keys are errors.
+	YAMLFile string
+	// DotEnvFile is read if it exists. It never overrides a variable that
+	// is set in the real environment.
+	DotEnvFile string
+}
+
+// Error lists every problem found while loading, so that a misconfigured
+// service can be fixed in one go.
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// Load fills cfg, a pointer to a struct holding the defaults, from src and
+// the environment. Configuration problems are reported as an *Error.
+func Load(cfg interface{}, src Sources) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config: Load needs a pointer to a struct, got %T", cfg)
+	}
+
+	dotEnv := map[string]string{}
+	l := &loader{
+		lookup: func(name string) (string, bool) {
+			if value, ok := os.LookupEnv(name); ok {
+				return value, true
+			}
+			value, ok := dotEnv[name]
+			return value, ok
+		},
+	}
+
+	if src.YAMLFile != "" {
+		if err := loadYAML(cfg, src.YAMLFile); err != nil {
+			l.problems = append(l.problems, err.Error())
+		}
+	}
+
+	if src.DotEnvFile != "" {
+		values, err := ReadDotEnv(src.DotEnvFile)
+		switch {
+		case err == nil:
+			dotEnv = values
+		case !errors.Is(err, os.ErrNotExist):
+			l.problems = append(l.problems, err.Error())
+		}
+	}
+
+	l.loadStruct(v.Elem(), "")
+
+	if len(l.problems) == 0 {
+		if validator, ok := cfg.(interface{ Validate() error }); ok {
+			err := validator.Validate()
+			if joined, ok := err.(interface{ Unwrap() []error }); ok {
+				for _, err := range joined.Unwrap() {
+					l.problems = append(l.problems, err.Error())
+				}
+			} else if err != nil {
+				l.problems = append(l.problems, err.Error())
+			}
+		}
+	}
+
+	if len(l.problems) > 0 {
+		return &Error{Problems: l.problems}
+	}
+	return nil
+}
+
+func loadYAML(cfg interface{}, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+type loader struct {
+	lookup   func(name string) (string, bool)
+	problems []string
+}
+
+func (l *loader) problem(format string, args ...interface{}) {
+	l.problems = append(l.problems, fmt.Sprintf(format, args...))
+}
+
+func (l *loader) loadStruct(v reflect.Value, path string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := fieldPath(path, field)
+		env := field.Tag.Get("env")
+		if env == "" {
+			if field.Type.Kind() == reflect.Struct {
+				l.loadStruct(v.Field(i), name)
+			}
+			continue
+		}
+
+		secret := field.Tag.Get("secret") == "true"
+		if value, ok := l.value(env); ok {
+			if err := setValue(v.Field(i), value); err != nil {
+				if secret {
+					l.problem("%s: invalid value", env)
+				} else {
+					l.problem("%s: invalid value %q: %v", env, value, err)
+				}
+				continue
+			}
+		}
+
+		if field.Tag.Get("required") == "true" && v.Field(i).IsZero() {
+			l.problem("%s is required (set %s or %s_FILE, or %s in the config file)", env, env, env, name)
+		}
+	}
+}
+
+// value looks up a variable or reads the file its _FILE variant names.
+func (l *loader) value(env string) (string, bool) {
+	value, ok := l.lookup(env)
+	file, fromFile := l.lookup(env + "_FILE")
+	switch {
+	case ok && fromFile:
+		l.problem("set only one of %s and %s_FILE", env, env)
+		return "", false
+	case fromFile:
+		data, err := os.ReadFile(file)
+		if err != nil {
+			l.problem("%s_FILE: %v", env, err)
+			return "", false
+		}
+		// Secret files usually end with a newline that is not part of the value
+		return strings.TrimRight(string(data), "\r\n"), true
+	default:
+		return value, ok
+	}
+}
+
+func fieldPath(path string, field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setValue parses value into a field of a supported type. Lists are comma
+// separated.
+func setValue(field reflect.Value, value string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", field.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Addr    string        `yaml:"addr" env:"TEST_ADDR"`
+	Timeout time.Duration `yaml:"timeout" env:"TEST_TIMEOUT"`
+	Debug   bool          `yaml:"debug" env:"TEST_DEBUG"`
+	Origins []string      `yaml:"origins" env:"TEST_ORIGINS"`
+	Retries int           `yaml:"retries" env:"TEST_RETRIES"`
+	Secret  string        `yaml:"secret" env:"TEST_SECRET" required:"true" secret:"true"`
+	PIN     int           `yaml:"pin" env:"TEST_PIN" secret:"true"`
+}
+
+func defaultTestConfig() testConfig {
+	return testConfig{Addr: ":8080", Timeout: time.Second, Origins: []string{"https://default.example.com"}, Retries: 3}
+}
+
+// writeFile writes content to a file in a fresh temporary directory.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// problems returns the problems of a Load error, failing the test for any
+// other error.
+func problems(t *testing.T, err error) []string {
+	t.Helper()
+	var cfgErr *Error
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("Load error = %v, want *Error", err)
+	}
+	return cfgErr.Problems
+}
+
+func hasProblem(problems []string, substr string) bool {
+	for _, p := range problems {
+		if strings.Contains(p, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	yamlFile := writeFile(t, "config.yaml", "addr: :yaml\ntimeout: 2s\ndebug: true\norigins: [https://yaml.example.com]\n")
+	dotEnvFile := writeFile(t, ".env", "TEST_ADDR=:dotenv\nTEST_TIMEOUT=3s\nTEST_SECRET=from-dotenv\n")
+	t.Setenv("TEST_ADDR", ":env")
+
+	cfg := defaultTestConfig()
+	if err := Load(&cfg, Sources{YAMLFile: yamlFile, DotEnvFile: dotEnvFile}); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	want := testConfig{
+		Addr:    ":env",                               // environment over .env
+		Timeout: 3 * time.Second,                      // .env over YAML
+		Debug:   true,                                 // YAML over default
+		Origins: []string{"https://yaml.example.com"}, // YAML over default
+		Retries: 3,                                    // default
+		Secret:  "from-dotenv",
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("Load = %+v, want %+v", cfg, want)
+	}
+}
+
+func TestLoadMissingDotEnvFile(t *testing.T) {
+	t.Setenv("TEST_SECRET", "s3cret")
+
+	cfg := defaultTestConfig()
+	if err := Load(&cfg, Sources{DotEnvFile: filepath.Join(t.TempDir(), ".env")}); err != nil {
+		t.Errorf("Load without a .env file: %v", err)
+	}
+}
+
+func TestLoadFromFile(t *testing.T) {
+	t.Run("variable from file", func(t *testing.T) {
+		t.Setenv("TEST_SECRET_FILE", writeFile(t, "secret", "s3cret\n"))
+
+		cfg := defaultTestConfig()
+		if err := Load(&cfg, Sources{}); err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		if cfg.Secret != "s3cret" {
+			t.Errorf("Secret = %q, want the file without its newline", cfg.Secret)
+		}
+	})
+
+	t.Run("_FILE in the .env file", func(t *testing.T) {
+		dotEnvFile := writeFile(t, ".env", "TEST_SECRET_FILE="+writeFile(t, "secret", "s3cret")+"\n")
+
+		cfg := defaultTestConfig()
+		if err := Load(&cfg, Sources{DotEnvFile: dotEnvFile}); err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		if cfg.Secret != "s3cret" {
+			t.Errorf("Secret = %q, want s3cret", cfg.Secret)
+		}
+	})
+
+	t.Run("both set", func(t *testing.T) {
+		t.Setenv("TEST_SECRET", "s3cret")
+		t.Setenv("TEST_SECRET_FILE", writeFile(t, "secret", "s3cret"))
+
+		cfg := defaultTestConfig()
+		got := problems(t, Load(&cfg, Sources{}))
+		if !hasProblem(got, "set only one of TEST_SECRET and TEST_SECRET_FILE") {
+			t.Errorf("problems = %q", got)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		t.Setenv("TEST_SECRET_FILE", filepath.Join(t.TempDir(), "missing"))
+
+		cfg := defaultTestConfig()
+		got := problems(t, Load(&cfg, Sources{}))
+		if !hasProblem(got, "TEST_SECRET_FILE:") {
+			t.Errorf("problems = %q", got)
+		}
+	})
+}
+
+func TestLoadRequiredAndSecret(t *testing.T) {
+	t.Setenv("TEST_PIN", "hunter2")
+	t.Setenv("TEST_RETRIES", "many")
+
+	cfg := defaultTestConfig()
+	got := problems(t, Load(&cfg, Sources{}))
+
+	want := []string{
+		`TEST_RETRIES: invalid value "many"`,
+		"TEST_SECRET is required (set TEST_SECRET or TEST_SECRET_FILE, or secret in the config file)",
+		"TEST_PIN: invalid value",
+	}
+	for _, w := range want {
+		if !hasProblem(got, w) {
+			t.Errorf("problems = %q, want one containing %q", got, w)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d problems, want %d: %q", len(got), len(want), got)
+	}
+	if hasProblem(got, "hunter2") {
+		t.Errorf("a secret value leaked into %q", got)
+	}
+}
+
+func TestSetValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		field   interface{}
+		value   string
+		want    interface{}
+		wantErr bool
+	}{
+		{name: "duration", field: time.Duration(0), value: "1m30s", want: 90 * time.Second},
+		{name: "duration without unit", field: time.Duration(0), value: "90", wantErr: true},
+		{name: "bool", field: false, value: "true", want: true},
+		{name: "bool as number", field: false, value: "0", want: false},
+		{name: "bool as word", field: false, value: "yes", wantErr: true},
+		{name: "int", field: 0, value: "42", want: 42},
+		{name: "int64", field: int64(0), value: "-7", want: int64(-7)},
+		{name: "int not a number", field: 0, value: "4.2", wantErr: true},
+		{name: "float", field: 0.0, value: "0.5", want: 0.5},
+		{name: "slice", field: []string(nil), value: "a, b ,c", want: []string{"a", "b", "c"}},
+		{name: "slice drops empty items", field: []string(nil), value: " a, ,b,", want: []string{"a", "b"}},
+		{name: "empty slice", field: []string{"default"}, value: "", want: []string(nil)},
+		{name: "unsupported slice", field: []int(nil), value: "1,2", wantErr: true},
+		{name: "unsupported type", field: map[string]string(nil), value: "a", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field := reflect.New(reflect.TypeOf(tt.field)).Elem()
+			field.Set(reflect.ValueOf(tt.field))
+
+			err := setValue(field, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("setValue(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(field.Interface(), tt.want) {
+				t.Errorf("setValue(%q) = %#v, want %#v", tt.value, field.Interface(), tt.want)
+			}
+		})
+	}
+}
+
+type validatedConfig struct {
+	Min int `yaml:"min" env:"TEST_MIN"`
+	Max int `yaml:"max" env:"TEST_MAX"`
+}
+
+func (c *validatedConfig) Validate() error {
+	var errs []error
+	if c.Min < 0 {
+		errs = append(errs, errors.New("TEST_MIN must not be negative"))
+	}
+	if c.Max < c.Min {
+		errs = append(errs, errors.New("TEST_MAX must be at least TEST_MIN"))
+	}
+	return errors.Join(errs...)
+}
+
+func TestLoadValidate(t *testing.T) {
+	t.Run("joined errors are separate problems", func(t *testing.T) {
+		t.Setenv("TEST_MIN", "-2")
+		t.Setenv("TEST_MAX", "-3")
+
+		var cfg validatedConfig
+		got := problems(t, Load(&cfg, Sources{}))
+		want := []string{"TEST_MIN must not be negative", "TEST_MAX must be at least TEST_MIN"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("problems = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		t.Setenv("TEST_MIN", "1")
+		t.Setenv("TEST_MAX", "2")
+
+		var cfg validatedConfig
+		if err := Load(&cfg, Sources{}); err != nil {
+			t.Errorf("Load: %v", err)
+		}
+	})
+
+	t.Run("skipped after other problems", func(t *testing.T) {
+		t.Setenv("TEST_MIN", "one")
+		t.Setenv("TEST_MAX", "-3")
+
+		var cfg validatedConfig
+		got := problems(t, Load(&cfg, Sources{}))
+		if len(got) != 1 || !strings.HasPrefix(got[0], "TEST_MIN: invalid value") {
+			t.Errorf("problems = %q", got)
+		}
+	})
+}
+
+func TestLoadYAML(t *testing.T) {
+	t.Run("unknown key", func(t *testing.T) {
+		yamlFile := writeFile(t, "config.yaml", "addr: :9090\nadress: :9091\n")
+		t.Setenv("TEST_SECRET", "s3cret")
+
+		cfg := defaultTestConfig()
+		got := problems(t, Load(&cfg, Sources{YAMLFile: yamlFile}))
+		if len(got) != 1 || !strings.Contains(got[0], "field adress not found") {
+			t.Errorf("problems = %q", got)
+		}
+	})
+
+	t.Run("reported with the other problems", func(t *testing.T) {
+		yamlFile := writeFile(t, "config.yaml", "timeout: [1s]\n")
+		t.Setenv("TEST_RETRIES", "many")
+
+		cfg := defaultTestConfig()
+		got := problems(t, Load(&cfg, Sources{YAMLFile: yamlFile}))
+		for _, w := range []string{yamlFile, "TEST_RETRIES", "TEST_SECRET is required"} {
+			if !hasProblem(got, w) {
+				t.Errorf("problems = %q, want one containing %q", got, w)
+			}
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		t.Setenv("TEST_SECRET", "s3cret")
+		missing := filepath.Join(t.TempDir(), "config.yaml")
+
+		cfg := defaultTestConfig()
+		got := problems(t, Load(&cfg, Sources{YAMLFile: missing}))
+		if len(got) != 1 || !strings.Contains(got[0], missing) {
+			t.Errorf("problems = %q", got)
+		}
+	})
+
+	t.Run("empty file", func(t *testing.T) {
+		t.Setenv("TEST_SECRET", "s3cret")
+
+		cfg := defaultTestConfig()
+		if err := Load(&cfg, Sources{YAMLFile: writeFile(t, "config.yaml", "")}); err != nil {
+			t.Errorf("Load: %v", err)
+		}
+		if !reflect.DeepEqual(cfg, testConfig{Addr: ":8080", Timeout: time.Second, Origins: []string{"https://default.example.com"}, Retries: 3, Secret: "s3cret"}) {
+			t.Errorf("an empty file changed the defaults: %+v", cfg)
+		}
+	})
+}
+
+func TestLoadNeedsStructPointer(t *testing.T) {
+	var cfg testConfig
+	for _, arg := range []interface{}{cfg, new(int), nil} {
+		err := Load(arg, Sources{})
+		var cfgErr *Error
+		if err == nil || errors.As(err, &cfgErr) {
+			t.Errorf("Load(%T) error = %v, want a usage error", arg, err)
+		}
+	}
+}
+
+func TestLoadDatabase(t *testing.T) {
+	type serviceConfig struct {
+		Database Database `yaml:"database"`
+	}
+	yamlFile := writeFile(t, "config.yaml", "database:\n  host: db.internal\n  sslMode: require\n")
+	t.Setenv("DB_USER", "app")
+	t.Setenv("DB_PASSWORD", "p@ss")
+
+	cfg := serviceConfig{Database: DefaultDatabase()}
+	got := problems(t, Load(&cfg, Sources{YAMLFile: yamlFile}))
+	if len(got) != 1 || !strings.Contains(got[0], "DB_NAME is required (set DB_NAME or DB_NAME_FILE, or database.name in the config file)") {
+		t.Errorf("problems = %q", got)
+	}
+
+	t.Setenv("DB_NAME", "users")
+	cfg = serviceConfig{Database: DefaultDatabase()}
+	if err := Load(&cfg, Sources{YAMLFile: yamlFile}); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	want := Database{Host: "db.internal", Port: 5432, User: "app", Password: "p@ss", Name: "users", SSLMode: "require"}
+	if cfg.Database != want {
+		t.Errorf("Database = %+v, want %+v", cfg.Database, want)
+	}
+}
+
+func TestDatabaseDSN(t *testing.T) {
+	db := Database{Host: "localhost", Port: 5432, User: "app", Password: `it's a \ secret`, Name: "users", SSLMode: "disable"}
+
+	want := `host='localhost' port=5432 user='app' password='it\'s a \\ secret' dbname='users' sslmode='disable'`
+	if got := db.DSN(); got != want {
+		t.Errorf("DSN = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Database locates a PostgreSQL database.
+type Database struct {
+	Host     string `yaml:"host" env:"DB_HOST" required:"true"`
+	Port     int    `yaml:"port" env:"DB_PORT" required:"true"`
+	User     string `yaml:"user" env:"DB_USER" required:"true"`
+	Password string `yaml:"password" env:"DB_PASSWORD" required:"true" secret:"true"`
+	Name     string `yaml:"name" env:"DB_NAME" required:"true"`
+	SSLMode  string `yaml:"sslMode" env:"DB_SSLMODE"`
+}
+
+func DefaultDatabase() Database {
+	return Database{
+		Host:    "localhost",
+		Port:    5432,
+		SSLMode: "disable",
+	}
+}
+
+// DSN returns the connection string for lib/pq.
+func (d Database) DSN() string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		dsnValue(d.Host), d.Port, dsnValue(d.User), dsnValue(d.Password), dsnValue(d.Name), dsnValue(d.SSLMode))
+}
+
+// dsnValue quotes a value so that spaces and quotes in it, e.g. in a
+// password, cannot break the connection string.
+func dsnValue(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
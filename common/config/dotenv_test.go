@@ -0,0 +1,112 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadDotEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:    "plain values",
+			content: "HTTP_ADDR=:8080\nDB_HOST = localhost \n",
+			want:    map[string]string{"HTTP_ADDR": ":8080", "DB_HOST": "localhost"},
+		},
+		{
+			name:    "blank lines, comments and export",
+			content: "# database\n\nexport DB_HOST=localhost\n   # indented comment\n",
+			want:    map[string]string{"DB_HOST": "localhost"},
+		},
+		{
+			name:    "empty value",
+			content: "DB_SSLMODE=\n",
+			want:    map[string]string{"DB_SSLMODE": ""},
+		},
+		{
+			name:    "trailing comment",
+			content: "DB_HOST=localhost # the local database\n",
+			want:    map[string]string{"DB_HOST": "localhost"},
+		},
+		{
+			name:    "hash inside a value",
+			content: "DB_PASSWORD=pa#ss\n",
+			want:    map[string]string{"DB_PASSWORD": "pa#ss"},
+		},
+		{
+			name:    "equals sign inside a value",
+			content: "DSN=host=db port=5432\n",
+			want:    map[string]string{"DSN": "host=db port=5432"},
+		},
+		{
+			name:    "double quotes understand escapes",
+			content: `KEY="line one\nline \"two\""` + "\n",
+			want:    map[string]string{"KEY": "line one\nline \"two\""},
+		},
+		{
+			name:    "double quotes keep spaces and hashes",
+			content: `DB_PASSWORD=" pa #ss "` + "\n",
+			want:    map[string]string{"DB_PASSWORD": " pa #ss "},
+		},
+		{
+			name:    "single quotes are literal",
+			content: `DB_PASSWORD='pa\n #ss'` + "\n",
+			want:    map[string]string{"DB_PASSWORD": `pa\n #ss`},
+		},
+		{
+			name:    "later lines win",
+			content: "DB_HOST=one\nDB_HOST=two\n",
+			want:    map[string]string{"DB_HOST": "two"},
+		},
+		{name: "missing equals sign", content: "DB_HOST\n", wantErr: ":1: expected KEY=VALUE"},
+		{name: "missing key", content: "# ok\n=value\n", wantErr: ":2: expected KEY=VALUE"},
+		{name: "space in key", content: "DB HOST=localhost\n", wantErr: ":1: expected KEY=VALUE"},
+		{name: "bad escape", content: `KEY="\q"` + "\n", wantErr: ":1: invalid syntax"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, ".env", tt.content)
+
+			got, err := ReadDotEnv(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), path+tt.wantErr) {
+					t.Fatalf("ReadDotEnv error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadDotEnv: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadDotEnv = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadDotEnvMissingFile(t *testing.T) {
+	if _, err := ReadDotEnv(filepath.Join(t.TempDir(), ".env")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadDotEnv error = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestLoadReportsBadDotEnvFile(t *testing.T) {
+	dotEnvFile := writeFile(t, ".env", "TEST_SECRET\n")
+
+	cfg := defaultTestConfig()
+	got := problems(t, Load(&cfg, Sources{DotEnvFile: dotEnvFile}))
+	for _, w := range []string{dotEnvFile + ":1: expected KEY=VALUE", "TEST_SECRET is required"} {
+		if !hasProblem(got, w) {
+			t.Errorf("problems = %q, want one containing %q", got, w)
+		}
+	}
+}
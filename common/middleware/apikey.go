@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+)
+
+// APIKeyHeader carries a personal API key as an alternative to a Bearer token.
+const APIKeyHeader = "X-API-Key"
+
+// ErrInvalidAPIKey is returned by APIKeyValidator implementations for keys
+// that are unknown, revoked or expired.
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// APIKeyPrincipal is the identity behind a valid API key. Permissions are
+// already narrowed to the scopes granted to the key.
+type APIKeyPrincipal struct {
+	KeyID         string
+	UserID        string
+	Username      string
+	EmailVerified bool
+	Role          string
+	Permissions   []string
+}
+
+// APIKeyValidator resolves a presented API key to its owner.
+type APIKeyValidator interface {
+	ValidateAPIKey(ctx context.Context, key string) (*APIKeyPrincipal, error)
+}
+
+// WithAPIKeyValidator makes AuthMiddleware accept keys sent in X-API-Key.
+func WithAPIKeyValidator(v APIKeyValidator) TokenOption {
+	return func(ts *TokenService) {
+		ts.apiKeys = v
+	}
+}
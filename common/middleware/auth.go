@@ -1,49 +1,274 @@
 package middleware
 
 import (
-	"encoding/base64"
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
+// PurposeMFA marks a token proving only the first login factor. Such tokens
+// are accepted by ValidateMFAToken and never by AuthMiddleware.
+const PurposeMFA = "mfa"
+
+const mfaTokenTTL = 5 * time.Minute
+
+// AuthClaims are the claims of our tokens. OrgID is the organization the
+// session acts for, if any, and services holding shared data scope it by
+// OrgID; OrgRole is the user's role within that organization.
 type AuthClaims struct {
-	UserID   string `json:"user_id"`
-	Username string `json:"username"`
+	UserID        string   `json:"user_id"`
+	Username      string   `json:"username"`
+	EmailVerified bool     `json:"email_verified"`
+	ClientID      string   `json:"client_id,omitempty"`
+	SessionID     string   `json:"sid,omitempty"`
+	Role          string   `json:"role,omitempty"`
+	Permissions   []string `json:"permissions,omitempty"`
+	OrgID         string   `json:"org_id,omitempty"`
+	OrgRole       string   `json:"org_role,omitempty"`
+	Purpose       string   `json:"purpose,omitempty"`
+	// IssuedAtMs refines iat to the millisecond, so that a token issued
+	// right after a revocation in the same second survives it.
+	IssuedAtMs int64 `json:"iat_ms,omitempty"`
 	jwt.StandardClaims
 }
 
+// IssuedAtMillis returns when the token was issued, in milliseconds since
+// the epoch. Tokens without iat_ms only know the second.
+func (c *AuthClaims) IssuedAtMillis() int64 {
+	if c.IssuedAtMs != 0 {
+		return c.IssuedAtMs
+	}
+	return c.IssuedAt * 1000
+}
+
+// TokenSubject describes who an access token is issued to.
+type TokenSubject struct {
+	UserID        string
+	Username      string
+	EmailVerified bool
+	SessionID     string
+	Role          string
+	Permissions   []string
+	OrgID         string
+	OrgRole       string
+}
+
+const (
+	DefaultAccessTokenTTL  = 15 * time.Minute
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// RevocationStore reports whether an otherwise valid access token has been
+// revoked, either individually by its jti or by a per-user watermark.
+type RevocationStore interface {
+	IsRevoked(ctx context.Context, claims *AuthClaims) (bool, error)
+}
+
+// signingMethod is the only algorithm accepted by ValidateToken. Pinning it
+// rejects "none" and HS256 tokens forged with the public key as HMAC secret.
+var signingMethod = jwt.SigningMethodRS256
+
 type TokenService struct {
-	secretKey []byte
+	keys        KeyProvider
+	accessTTL   time.Duration
+	refreshTTL  time.Duration
+	revocations RevocationStore
+	apiKeys     APIKeyValidator
+	testAuth    bool
+}
+
+type TokenOption func(*TokenService)
+
+// WithAccessTokenTTL overrides the lifetime of issued access tokens.
+func WithAccessTokenTTL(ttl time.Duration) TokenOption {
+	return func(ts *TokenService) {
+		ts.accessTTL = ttl
+	}
 }
 
-func NewTokenService(secret string) *TokenService {
-	return &TokenService{
-		secretKey: []byte(secret),
+// WithRefreshTokenTTL overrides the lifetime of issued refresh tokens.
+func WithRefreshTokenTTL(ttl time.Duration) TokenOption {
+	return func(ts *TokenService) {
+		ts.refreshTTL = ttl
 	}
 }
 
-func (ts *TokenService) GenerateToken(userID, username string) (string, error) {
+// WithRevocationStore makes AuthMiddleware reject revoked access tokens.
+func WithRevocationStore(store RevocationStore) TokenOption {
+	return func(ts *TokenService) {
+		ts.revocations = store
+	}
+}
+
+// ErrTestAuthUnavailable is returned by CheckTestAuth when test-only
+// authentication must not be turned on.
+var ErrTestAuthUnavailable = errors.New("test authentication is not available")
+
+// WithTestAuth turns on the test-only "Authorization: Test <user_id>" scheme.
+// It has no effect unless the binary was built with -tags testauth; use
+// CheckTestAuth first to fail loudly instead.
+func WithTestAuth() TokenOption {
+	return func(ts *TokenService) {
+		ts.testAuth = testAuthCompiled
+	}
+}
+
+// CheckTestAuth reports whether test-only authentication may be enabled in
+// env. It is never allowed in production, nor in binaries built without the
+// testauth tag.
+func CheckTestAuth(env string) error {
+	if !testAuthCompiled {
+		return fmt.Errorf("%w: binary built without the testauth tag", ErrTestAuthUnavailable)
+	}
+	if strings.EqualFold(env, "production") {
+		return fmt.Errorf("%w: refusing to enable in production", ErrTestAuthUnavailable)
+	}
+	return nil
+}
+
+// ErrKeyOverlapTooShort is returned by CheckKeyOverlap when retired signing
+// keys would be pruned before the access tokens they signed expire.
+var ErrKeyOverlapTooShort = errors.New("signing key overlap is too short")
+
+// CheckKeyOverlap reports whether tokens signed just before a key rotation
+// stay verifiable until they expire. Only a KeyManager retires keys.
+func (ts *TokenService) CheckKeyOverlap() error {
+	km, ok := ts.keys.(*KeyManager)
+	if !ok {
+		return nil
+	}
+	if km.Overlap() <= ts.accessTTL {
+		return fmt.Errorf("%w: overlap %s must exceed the access token lifetime %s",
+			ErrKeyOverlapTooShort, km.Overlap(), ts.accessTTL)
+	}
+	return nil
+}
+
+func NewTokenService(keys KeyProvider, opts ...TokenOption) *TokenService {
+	ts := &TokenService{
+		keys:       keys,
+		accessTTL:  DefaultAccessTokenTTL,
+		refreshTTL: DefaultRefreshTokenTTL,
+	}
+	for _, opt := range opts {
+		opt(ts)
+	}
+	return ts
+}
+
+// GenerateToken issues a short-lived access token and returns it along with its expiry.
+func (ts *TokenService) GenerateToken(subject TokenSubject) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ts.accessTTL)
 	claims := AuthClaims{
-		UserID:   userID,
-		Username: username,
+		UserID:        subject.UserID,
+		Username:      subject.Username,
+		EmailVerified: subject.EmailVerified,
+		SessionID:     subject.SessionID,
+		Role:          subject.Role,
+		Permissions:   subject.Permissions,
+		OrgID:         subject.OrgID,
+		OrgRole:       subject.OrgRole,
+		IssuedAtMs:    now.UnixMilli(),
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * 24).Unix(), // 24-hour token
-			IssuedAt:  time.Now().Unix(),
+			Id:        uuid.NewString(),
+			ExpiresAt: expiresAt.Unix(),
+			IssuedAt:  now.Unix(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(ts.secretKey)
+	signed, err := ts.sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
 }
 
+// GenerateMFAToken issues a short-lived token stating that userID passed the
+// password check and still has to supply a second factor.
+func (ts *TokenService) GenerateMFAToken(userID string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(mfaTokenTTL)
+	claims := AuthClaims{
+		UserID:  userID,
+		Purpose: PurposeMFA,
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.NewString(),
+			ExpiresAt: expiresAt.Unix(),
+			IssuedAt:  now.Unix(),
+		},
+	}
+
+	signed, err := ts.sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+func (ts *TokenService) sign(claims AuthClaims) (string, error) {
+	key, err := ts.keys.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(signingMethod, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+// GenerateRefreshToken returns a new opaque refresh token, the hash under which
+// it should be persisted and its expiry. The plain token is never stored.
+func (ts *TokenService) GenerateRefreshToken() (token, hash string, expiresAt time.Time, err error) {
+	token, err = NewOpaqueToken()
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	return token, HashOpaqueToken(token), time.Now().Add(ts.refreshTTL), nil
+}
+
+// ValidateToken accepts only access tokens.
 func (ts *TokenService) ValidateToken(tokenString string) (*AuthClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &AuthClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return ts.secretKey, nil
+	claims, err := ts.parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, errors.New("not an access token")
+	}
+	return claims, nil
+}
+
+// ValidateMFAToken accepts only tokens issued by GenerateMFAToken.
+func (ts *TokenService) ValidateMFAToken(tokenString string) (*AuthClaims, error) {
+	claims, err := ts.parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != PurposeMFA {
+		return nil, errors.New("not an MFA token")
+	}
+	return claims, nil
+}
+
+func (ts *TokenService) parse(tokenString string) (*AuthClaims, error) {
+	parser := jwt.Parser{ValidMethods: []string{signingMethod.Alg()}}
+	token, err := parser.ParseWithClaims(tokenString, &AuthClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != signingMethod {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, ok := token.Header["kid"].(string)
+		if !ok || kid == "" {
+			return nil, errors.New("token has no key id")
+		}
+		return ts.keys.PublicKey(kid)
 	})
 
 	if err != nil {
@@ -59,6 +284,28 @@ func (ts *TokenService) ValidateToken(tokenString string) (*AuthClaims, error) {
 
 func AuthMiddleware(ts TokenService) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// ── API key ──────────────────────────────────────────────
+		if key := c.GetHeader(APIKeyHeader); key != "" && ts.apiKeys != nil {
+			principal, err := ts.apiKeys.ValidateAPIKey(c, key)
+			if err != nil {
+				if errors.Is(err, ErrInvalidAPIKey) {
+					unauthorised(c, "Invalid API key")
+					return
+				}
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Unable to verify API key"})
+				c.Abort()
+				return
+			}
+			c.Set("api_key_id", principal.KeyID)
+			c.Set("user_id", principal.UserID)
+			c.Set("username", principal.Username)
+			c.Set("email_verified", principal.EmailVerified)
+			c.Set("role", principal.Role)
+			c.Set("permissions", principal.Permissions)
+			c.Next()
+			return
+		}
+
 		ah := c.GetHeader("Authorization")
 		if ah == "" {
 			unauthorised(c, "Authorization header missing")
@@ -69,29 +316,56 @@ func AuthMiddleware(ts TokenService) gin.HandlerFunc {
 		if strings.HasPrefix(ah, "Bearer ") {
 			token := strings.TrimPrefix(ah, "Bearer ")
 			if claims, err := ts.ValidateToken(token); err == nil {
+				if ts.revocations != nil {
+					revoked, err := ts.revocations.IsRevoked(c, claims)
+					if err != nil {
+						c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Unable to verify token"})
+						c.Abort()
+						return
+					}
+					if revoked {
+						unauthorised(c, "Token has been revoked")
+						return
+					}
+				}
+				c.Set("claims", claims)
 				c.Set("user_id", claims.UserID)
 				c.Set("username", claims.Username)
+				c.Set("email_verified", claims.EmailVerified)
+				c.Set("client_id", claims.ClientID)
+				c.Set("session_id", claims.SessionID)
+				c.Set("role", claims.Role)
+				c.Set("permissions", claims.Permissions)
+				c.Set("org_id", claims.OrgID)
+				c.Set("org_role", claims.OrgRole)
 				c.Next()
 				return
 			}
 		}
 
-		// ── Basic (ONLY FOR LOCAL / TEST) ───────────────────────
-		if strings.HasPrefix(ah, "Basic ") {
-			payload, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(ah, "Basic "))
-			parts := strings.SplitN(string(payload), ":", 2)
-			if len(parts) == 2 && parts[0] == "admin" && parts[1] == "password" {
-				c.Set("user_id", "0")
-				c.Set("username", "admin")
-				c.Next()
-				return
-			}
+		// ── Test (only in binaries built with -tags testauth) ─────
+		if ts.testAuth && testAuthenticate(c, ah) {
+			c.Next()
+			return
 		}
 
 		unauthorised(c, "Invalid credentials")
 	}
 }
 
+// RequireVerifiedEmail blocks callers whose access token was issued before
+// they verified their email address. It must run after AuthMiddleware.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool("email_verified") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email address must be verified"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
 func unauthorised(c *gin.Context, msg string) {
 	c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
 	c.Abort()
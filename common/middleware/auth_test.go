@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func newTestTokenService(t *testing.T, opts ...TokenOption) *TokenService {
+	t.Helper()
+	keys, err := NewKeyManager(context.Background(), NewMemoryKeyStore(), 24*time.Hour, time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeyManager: %v", err)
+	}
+	return NewTokenService(keys, opts...)
+}
+
+func TestGenerateTokenIssuedAtMillis(t *testing.T) {
+	ts := newTestTokenService(t)
+
+	// A logout-all right before the token is issued, in the same second
+	watermark := time.Now()
+	time.Sleep(2 * time.Millisecond)
+
+	before := time.Now().UnixMilli()
+	token, _, err := ts.GenerateToken(TokenSubject{UserID: "1"})
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	after := time.Now().UnixMilli()
+
+	claims, err := ts.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if got := claims.IssuedAtMillis(); got < before || got > after {
+		t.Errorf("IssuedAtMillis = %d, want within [%d, %d]", got, before, after)
+	}
+	if claims.IssuedAt != claims.IssuedAtMs/1000 {
+		t.Errorf("iat = %d disagrees with iat_ms = %d", claims.IssuedAt, claims.IssuedAtMs)
+	}
+	if claims.IssuedAtMillis() <= watermark.UnixMilli() {
+		t.Errorf("token issued after the watermark reads as issued at %d, watermark %d", claims.IssuedAtMillis(), watermark.UnixMilli())
+	}
+}
+
+func TestIssuedAtMillis(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims AuthClaims
+		want   int64
+	}{
+		{"milliseconds", AuthClaims{IssuedAtMs: 1700000000123, StandardClaims: jwt.StandardClaims{IssuedAt: 1700000000}}, 1700000000123},
+		{"seconds only", AuthClaims{StandardClaims: jwt.StandardClaims{IssuedAt: 1700000000}}, 1700000000000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.claims.IssuedAtMillis(); got != tt.want {
+				t.Errorf("IssuedAtMillis = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestClientRegistry(t *testing.T) *ClientRegistry {
+	t.Helper()
+	hash, err := HashPassword("s3cret")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	return NewClientRegistry(ServiceClient{ID: "reports", SecretHash: hash, Scopes: []string{"users:read", "orgs:read"}})
+}
+
+func TestClientRegistryAuthenticate(t *testing.T) {
+	registry := newTestClientRegistry(t)
+
+	tests := []struct {
+		name       string
+		id, secret string
+		wantErr    error
+	}{
+		{"valid", "reports", "s3cret", nil},
+		{"bad secret", "reports", "wrong", ErrInvalidClient},
+		{"unknown client", "billing", "s3cret", ErrInvalidClient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := registry.Authenticate(tt.id, tt.secret)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && client.ID != tt.id {
+				t.Errorf("Authenticate returned client %q, want %q", client.ID, tt.id)
+			}
+		})
+	}
+}
+
+func TestClientCredentialsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ts := newTestTokenService(t)
+	router := gin.New()
+	router.POST("/token", ClientCredentialsHandler(*ts, newTestClientRegistry(t)))
+
+	tests := []struct {
+		name       string
+		form       url.Values
+		wantStatus int
+		wantError  string
+		wantScopes []string
+	}{
+		{
+			name:       "all client scopes",
+			form:       url.Values{"grant_type": {"client_credentials"}, "client_id": {"reports"}, "client_secret": {"s3cret"}},
+			wantStatus: http.StatusOK,
+			wantScopes: []string{"users:read", "orgs:read"},
+		},
+		{
+			name:       "narrowed scope",
+			form:       url.Values{"grant_type": {"client_credentials"}, "client_id": {"reports"}, "client_secret": {"s3cret"}, "scope": {"orgs:read"}},
+			wantStatus: http.StatusOK,
+			wantScopes: []string{"orgs:read"},
+		},
+		{
+			name:       "wrong grant",
+			form:       url.Values{"grant_type": {"password"}, "client_id": {"reports"}, "client_secret": {"s3cret"}},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "unsupported_grant_type",
+		},
+		{
+			name:       "missing secret",
+			form:       url.Values{"grant_type": {"client_credentials"}, "client_id": {"reports"}},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid_request",
+		},
+		{
+			name:       "bad secret",
+			form:       url.Values{"grant_type": {"client_credentials"}, "client_id": {"reports"}, "client_secret": {"wrong"}},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_client",
+		},
+		{
+			name:       "unknown client",
+			form:       url.Values{"grant_type": {"client_credentials"}, "client_id": {"billing"}, "client_secret": {"s3cret"}},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_client",
+		},
+		{
+			name:       "scope outside the client's",
+			form:       url.Values{"grant_type": {"client_credentials"}, "client_id": {"reports"}, "client_secret": {"s3cret"}, "scope": {"orgs:read users:write"}},
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid_scope",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d %s, want %d", w.Code, w.Body.String(), tt.wantStatus)
+			}
+			var body struct {
+				Error       string `json:"error"`
+				AccessToken string `json:"access_token"`
+				Scope       string `json:"scope"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if body.Error != tt.wantError {
+				t.Errorf("error = %q, want %q", body.Error, tt.wantError)
+			}
+			if tt.wantError != "" {
+				if body.AccessToken != "" {
+					t.Error("failed request was issued a token")
+				}
+				return
+			}
+
+			if want := strings.Join(tt.wantScopes, " "); body.Scope != want {
+				t.Errorf("scope = %q, want %q", body.Scope, want)
+			}
+			claims, err := ts.ValidateToken(body.AccessToken)
+			if err != nil {
+				t.Fatalf("ValidateToken: %v", err)
+			}
+			if got := strings.Join(claims.Permissions, " "); got != strings.Join(tt.wantScopes, " ") {
+				t.Errorf("token permissions = %q, want %q", got, strings.Join(tt.wantScopes, " "))
+			}
+		})
+	}
+}
+
+func TestGenerateClientTokenHasNoUser(t *testing.T) {
+	ts := newTestTokenService(t)
+
+	token, _, err := ts.GenerateClientToken("reports", []string{"users:read"})
+	if err != nil {
+		t.Fatalf("GenerateClientToken: %v", err)
+	}
+	claims, err := ts.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if claims.UserID != "" {
+		t.Errorf("client token carries user %q", claims.UserID)
+	}
+	if claims.ClientID != "reports" || claims.Subject != "reports" {
+		t.Errorf("client token for %q with subject %q, want reports", claims.ClientID, claims.Subject)
+	}
+}
+
+func TestCheckTestAuth(t *testing.T) {
+	tests := []struct {
+		env     string
+		allowed bool
+	}{
+		{"development", true},
+		{"staging", true},
+		{"production", false},
+		{"Production", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			err := CheckTestAuth(tt.env)
+			// Without the testauth tag no environment may enable it
+			if tt.allowed && testAuthCompiled {
+				if err != nil {
+					t.Errorf("CheckTestAuth(%q) = %v, want nil", tt.env, err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrTestAuthUnavailable) {
+				t.Errorf("CheckTestAuth(%q) = %v, want ErrTestAuthUnavailable", tt.env, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	jwksCacheTTL     = 5 * time.Minute
+	jwksFetchTimeout = 5 * time.Second
+	rsaKeyType       = "RSA"
+	keyUseSignature  = "sig"
+)
+
+// JWK is the public RSA key representation defined by RFC 7517.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func NewRSAJWK(kid string, key *rsa.PublicKey) JWK {
+	return JWK{
+		KeyType:   rsaKeyType,
+		Use:       keyUseSignature,
+		Algorithm: signingMethod.Alg(),
+		KeyID:     kid,
+		N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// RSAPublicKey decodes the JWK into an RSA public key.
+func (k JWK) RSAPublicKey() (*rsa.PublicKey, error) {
+	if k.KeyType != rsaKeyType {
+		return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+// JWKSHandler serves the public keys of ts at /.well-known/jwks.json.
+func JWKSHandler(ts TokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwksCacheTTL.Seconds())))
+		c.JSON(http.StatusOK, ts.keys.JWKS())
+	}
+}
+
+// RemoteKeySet verifies tokens using a JWKS published by the issuing service,
+// so that other services never need access to private keys.
+type RemoteKeySet struct {
+	url    string
+	client *http.Client
+
+	mu        sync.RWMutex
+	keys      map[string]*rsa.PublicKey
+	set       JWKS
+	fetchedAt time.Time
+}
+
+func NewRemoteKeySet(url string) *RemoteKeySet {
+	return &RemoteKeySet{
+		url:    url,
+		client: &http.Client{Timeout: jwksFetchTimeout},
+		keys:   make(map[string]*rsa.PublicKey),
+	}
+}
+
+// SigningKey always fails; a remote key set can only verify.
+func (r *RemoteKeySet) SigningKey() (*SigningKey, error) {
+	return nil, ErrNoSigningKey
+}
+
+// PublicKey returns the key for kid, refetching the JWKS when the cache is
+// stale or the kid is unknown and the last fetch was not too recent.
+func (r *RemoteKeySet) PublicKey(kid string) (crypto.PublicKey, error) {
+	r.mu.RLock()
+	key, ok := r.keys[kid]
+	age := time.Since(r.fetchedAt)
+	r.mu.RUnlock()
+
+	if ok && age < jwksCacheTTL {
+		return key, nil
+	}
+
+	if age > keyReloadCooldown {
+		if err := r.fetch(); err != nil {
+			if ok {
+				// Serve the cached key rather than failing on a transient error
+				return key, nil
+			}
+			return nil, err
+		}
+
+		r.mu.RLock()
+		key, ok = r.keys[kid]
+		r.mu.RUnlock()
+	}
+
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+	return key, nil
+}
+
+func (r *RemoteKeySet) JWKS() JWKS {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.set
+}
+
+func (r *RemoteKeySet) fetch() error {
+	resp, err := r.client.Get(r.url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	var set JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Algorithm != "" && jwk.Algorithm != signingMethod.Alg() {
+			continue
+		}
+		key, err := jwk.RSAPublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+	if len(keys) == 0 {
+		return errors.New("JWKS contains no usable keys")
+	}
+
+	r.mu.Lock()
+	r.keys = keys
+	r.set = set
+	r.fetchedAt = time.Now()
+	r.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,379 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultKeyRotation = 24 * time.Hour
+	// DefaultKeyOverlap is how long a key stays verifiable after it stopped
+	// signing. It must exceed the access token lifetime so that tokens signed
+	// just before a rotation stay verifiable until they expire; see
+	// TokenService.CheckKeyOverlap.
+	DefaultKeyOverlap = 2 * time.Hour
+
+	rsaKeyBits        = 2048
+	keyReloadInterval = time.Minute
+	keyReloadCooldown = 10 * time.Second
+)
+
+var (
+	ErrNoSigningKey = errors.New("no signing key available")
+	ErrUnknownKeyID = errors.New("unknown key id")
+)
+
+// SigningKey is an RSA key pair identified by kid.
+type SigningKey struct {
+	ID         string
+	PrivateKey *rsa.PrivateKey
+	CreatedAt  time.Time
+}
+
+func (k *SigningKey) PublicKey() crypto.PublicKey {
+	return &k.PrivateKey.PublicKey
+}
+
+// KeyProvider supplies keys to TokenService. Verification-only providers
+// (see RemoteKeySet) return ErrNoSigningKey from SigningKey.
+type KeyProvider interface {
+	SigningKey() (*SigningKey, error)
+	PublicKey(kid string) (crypto.PublicKey, error)
+	JWKS() JWKS
+}
+
+// KeyStore persists signing keys so that every replica of the issuing
+// service signs and verifies with the same key set.
+type KeyStore interface {
+	LoadKeys(ctx context.Context) ([]*SigningKey, error)
+	SaveKey(ctx context.Context, key *SigningKey) error
+	DeleteKey(ctx context.Context, kid string) error
+}
+
+// KeyManager signs with the newest key and keeps retired keys around for an
+// overlap window, rotating on a schedule when Run is active.
+type KeyManager struct {
+	store    KeyStore
+	rotation time.Duration
+	overlap  time.Duration
+
+	mu         sync.RWMutex
+	keys       []*SigningKey // newest first
+	lastReload time.Time
+}
+
+func NewKeyManager(ctx context.Context, store KeyStore, rotation, overlap time.Duration) (*KeyManager, error) {
+	km := &KeyManager{
+		store:    store,
+		rotation: rotation,
+		overlap:  overlap,
+	}
+	if err := km.reload(ctx); err != nil {
+		return nil, err
+	}
+	if err := km.rotateIfDue(ctx); err != nil {
+		return nil, err
+	}
+	return km, nil
+}
+
+// SigningKey returns the newest key.
+func (km *KeyManager) SigningKey() (*SigningKey, error) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	if len(km.keys) == 0 {
+		return nil, ErrNoSigningKey
+	}
+	return km.keys[0], nil
+}
+
+// PublicKey returns the verification key for kid. Unknown ids trigger a
+// throttled reload in case another replica has just rotated.
+func (km *KeyManager) PublicKey(kid string) (crypto.PublicKey, error) {
+	if key := km.lookup(kid); key != nil {
+		return key.PublicKey(), nil
+	}
+
+	km.mu.RLock()
+	stale := time.Since(km.lastReload) > keyReloadCooldown
+	km.mu.RUnlock()
+
+	if stale {
+		if err := km.reload(context.Background()); err == nil {
+			if key := km.lookup(kid); key != nil {
+				return key.PublicKey(), nil
+			}
+		}
+	}
+	return nil, ErrUnknownKeyID
+}
+
+// JWKS returns the public half of every key that is still verifiable.
+func (km *KeyManager) JWKS() JWKS {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	set := JWKS{Keys: make([]JWK, 0, len(km.keys))}
+	for _, key := range km.keys {
+		set.Keys = append(set.Keys, NewRSAJWK(key.ID, &key.PrivateKey.PublicKey))
+	}
+	return set
+}
+
+// Rotate generates and persists a new signing key.
+func (km *KeyManager) Rotate(ctx context.Context) error {
+	key, err := GenerateSigningKey()
+	if err != nil {
+		return err
+	}
+	if err := km.store.SaveKey(ctx, key); err != nil {
+		return fmt.Errorf("failed to save signing key: %w", err)
+	}
+	return km.reload(ctx)
+}
+
+// Run reloads the key set periodically and rotates when the newest key is
+// older than the rotation interval. It blocks until ctx is cancelled.
+func (km *KeyManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(keyReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := km.reload(ctx); err != nil {
+				continue
+			}
+			_ = km.rotateIfDue(ctx)
+		}
+	}
+}
+
+func (km *KeyManager) rotateIfDue(ctx context.Context) error {
+	km.mu.RLock()
+	due := len(km.keys) == 0 || time.Since(km.keys[0].CreatedAt) >= km.rotation
+	km.mu.RUnlock()
+
+	if !due {
+		return nil
+	}
+	return km.Rotate(ctx)
+}
+
+// reload refreshes the key set from the store and prunes keys past the
+// overlap window. A key is retired when the next one is created, which after
+// downtime can be long after its own creation.
+func (km *KeyManager) reload(ctx context.Context) error {
+	keys, err := km.store.LoadKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load signing keys: %w", err)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+
+	live := keys[:0]
+	for i, key := range keys {
+		// The newest key is always kept so there is something to sign with
+		if i > 0 && time.Since(keys[i-1].CreatedAt) >= km.overlap {
+			_ = km.store.DeleteKey(ctx, key.ID)
+			continue
+		}
+		live = append(live, key)
+	}
+
+	km.mu.Lock()
+	km.keys = live
+	km.lastReload = time.Now()
+	km.mu.Unlock()
+	return nil
+}
+
+// Overlap returns how long a retired key stays verifiable.
+func (km *KeyManager) Overlap() time.Duration {
+	return km.overlap
+}
+
+func (km *KeyManager) lookup(kid string) *SigningKey {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	for _, key := range km.keys {
+		if key.ID == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+// GenerateSigningKey creates a new RSA key with a random kid.
+func GenerateSigningKey() (*SigningKey, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{
+		ID:         hex.EncodeToString(id),
+		PrivateKey: privateKey,
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+// MemoryKeyStore keeps keys in process memory. Keys are lost on restart,
+// so it is only suitable for a single local instance.
+type MemoryKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]*SigningKey
+}
+
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{keys: make(map[string]*SigningKey)}
+}
+
+func (s *MemoryKeyStore) LoadKeys(_ context.Context) ([]*SigningKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]*SigningKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (s *MemoryKeyStore) SaveKey(_ context.Context, key *SigningKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[key.ID] = key
+	return nil
+}
+
+func (s *MemoryKeyStore) DeleteKey(_ context.Context, kid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.keys, kid)
+	return nil
+}
+
+// FileKeyStore keeps PKCS#8 PEM encoded keys as <kid>.pem files in a
+// directory, using the file modification time as the key creation time.
+// Pointing every replica at a shared volume gives them a common key set.
+type FileKeyStore struct {
+	dir string
+}
+
+func NewFileKeyStore(dir string) *FileKeyStore {
+	return &FileKeyStore{dir: dir}
+}
+
+func (s *FileKeyStore) LoadKeys(_ context.Context) ([]*SigningKey, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var keys []*SigningKey
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".pem" {
+			continue
+		}
+
+		key, err := s.readKey(entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key %s: %w", entry.Name(), err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (s *FileKeyStore) SaveKey(_ context.Context, key *SigningKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return err
+	}
+
+	// Write then rename so other replicas never read a partial file
+	path := filepath.Join(s.dir, key.ID+".pem")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp, key.CreatedAt, key.CreatedAt); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *FileKeyStore) DeleteKey(_ context.Context, kid string) error {
+	err := os.Remove(filepath.Join(s.dir, kid+".pem"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileKeyStore) readKey(entry os.DirEntry) (*SigningKey, error) {
+	info, err := entry.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA private key")
+	}
+
+	return &SigningKey{
+		ID:         strings.TrimSuffix(entry.Name(), ".pem"),
+		PrivateKey: privateKey,
+		CreatedAt:  info.ModTime(),
+	}, nil
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestKey(t *testing.T, createdAt time.Time) *SigningKey {
+	t.Helper()
+	key, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey: %v", err)
+	}
+	key.CreatedAt = createdAt
+	return key
+}
+
+func TestKeyManagerPrunesFromRetirement(t *testing.T) {
+	ctx := context.Background()
+	rotation, overlap := 24*time.Hour, 2*time.Hour
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		created []time.Duration // ages, newest first
+		live    int
+	}{
+		{"single old key is kept", []time.Duration{30 * 24 * time.Hour}, 1},
+		{"key retired within overlap is kept", []time.Duration{time.Hour, rotation + time.Hour}, 2},
+		{"key retired past overlap is pruned", []time.Duration{overlap + time.Minute, rotation + overlap}, 1},
+		// After downtime the old key signed until the restart; it was only
+		// retired by the rotation at startup.
+		{"key retired after downtime is kept", []time.Duration{time.Minute, 10 * 24 * time.Hour}, 2},
+		{"older retired keys are pruned", []time.Duration{time.Minute, 3 * time.Hour, 5 * time.Hour}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryKeyStore()
+			for _, age := range tt.created {
+				if err := store.SaveKey(ctx, newTestKey(t, now.Add(-age))); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			km := &KeyManager{store: store, rotation: rotation, overlap: overlap}
+			if err := km.reload(ctx); err != nil {
+				t.Fatalf("reload: %v", err)
+			}
+
+			if got := len(km.JWKS().Keys); got != tt.live {
+				t.Errorf("live keys = %d, want %d", got, tt.live)
+			}
+			stored, _ := store.LoadKeys(ctx)
+			if len(stored) != tt.live {
+				t.Errorf("stored keys = %d, want %d", len(stored), tt.live)
+			}
+		})
+	}
+}
+
+func TestNewKeyManagerKeepsKeyAfterDowntime(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryKeyStore()
+	old := newTestKey(t, time.Now().Add(-10*24*time.Hour))
+	if err := store.SaveKey(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+
+	km, err := NewKeyManager(ctx, store, DefaultKeyRotation, DefaultKeyOverlap)
+	if err != nil {
+		t.Fatalf("NewKeyManager: %v", err)
+	}
+
+	signing, err := km.SigningKey()
+	if err != nil || signing.ID == old.ID {
+		t.Fatalf("expected a rotation at startup, signing key %v, err %v", signing, err)
+	}
+	if err := km.reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := km.PublicKey(old.ID); err != nil {
+		t.Errorf("key signing until the restart is no longer verifiable: %v", err)
+	}
+}
+
+func TestCheckKeyOverlap(t *testing.T) {
+	km := &KeyManager{overlap: time.Hour}
+
+	tests := []struct {
+		name    string
+		keys    KeyProvider
+		ttl     time.Duration
+		wantErr bool
+	}{
+		{"overlap exceeds ttl", km, 15 * time.Minute, false},
+		{"overlap equals ttl", km, time.Hour, true},
+		{"overlap below ttl", km, 2 * time.Hour, true},
+		{"verification only provider", NewRemoteKeySet("http://localhost/jwks"), 2 * time.Hour, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := NewTokenService(tt.keys, WithAccessTokenTTL(tt.ttl))
+			err := ts.CheckKeyOverlap()
+			if got := errors.Is(err, ErrKeyOverlapTooShort); got != tt.wantErr {
+				t.Errorf("CheckKeyOverlap() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -19,6 +19,11 @@ func LoggingMiddleware(logger *zap.Logger) gin.HandlerFunc {
 			requestID = uuid.New().String()
 		}
 		c.Writer.Header().Set("X-Request-ID", requestID)
+		c.Set("request_id", requestID)
+
+		// Exposed to handlers and services, e.g. to record where a session is used
+		c.Set("client_ip", c.ClientIP())
+		c.Set("user_agent", c.Request.UserAgent())
 
 		// Log incoming request
 		logger.Info("Incoming request",
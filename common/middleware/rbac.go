@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Permissions understood across services. Roles are sets of these and are
+// managed by the user service; other services only check them.
+const (
+	PermUsersRead       = "users:read"
+	PermUsersWrite      = "users:write"
+	PermUsersDelete     = "users:delete"
+	PermRolesManage     = "roles:manage"
+	PermAuditRead       = "audit:read"
+	PermPortfoliosRead  = "portfolios:read"
+	PermPortfoliosWrite = "portfolios:write"
+	PermPreferencesRead = "preferences:read"
+)
+
+// RequirePermission blocks callers whose access token does not grant every
+// listed permission. It must run after AuthMiddleware.
+func RequirePermission(perms ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, perm := range perms {
+			if !HasPermission(c, perm) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Missing permission " + perm})
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// HasPermission reports whether the authenticated caller was granted perm.
+func HasPermission(c *gin.Context, perm string) bool {
+	return hasPermission(c.GetStringSlice("permissions"), perm)
+}
+
+func hasPermission(granted []string, perm string) bool {
+	for _, p := range granted {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
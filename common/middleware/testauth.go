@@ -0,0 +1,37 @@
+//go:build testauth
+
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testAuthCompiled = true
+
+// testAuthenticate accepts "Authorization: Test <user_id>" and grants the
+// comma separated permissions in X-Test-Permissions. Only compiled into
+// binaries built with -tags testauth.
+func testAuthenticate(c *gin.Context, header string) bool {
+	if !strings.HasPrefix(header, "Test ") {
+		return false
+	}
+	userID := strings.TrimSpace(strings.TrimPrefix(header, "Test "))
+	if userID == "" {
+		return false
+	}
+
+	var permissions []string
+	for _, p := range strings.Split(c.GetHeader("X-Test-Permissions"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			permissions = append(permissions, p)
+		}
+	}
+
+	c.Set("user_id", userID)
+	c.Set("username", "test-"+userID)
+	c.Set("email_verified", true)
+	c.Set("permissions", permissions)
+	return true
+}
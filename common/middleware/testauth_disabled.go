@@ -0,0 +1,11 @@
+//go:build !testauth
+
+package middleware
+
+import "github.com/gin-gonic/gin"
+
+const testAuthCompiled = false
+
+func testAuthenticate(*gin.Context, string) bool {
+	return false
+}
@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Ways of counting the items of a list.
+const (
+	CountExact    = "exact"
+	CountEstimate = "estimate"
+	CountNone     = "none"
+)
+
+// Cursor is a position in a list paginated by keyset: the sort values of the
+// item at the edge of a page. Clients only see it encoded and signed.
+type Cursor struct {
+	// Sort is the ordering the cursor was issued for
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+	// Before asks for the page before the position instead of after it
+	Before bool `json:"b,omitempty"`
+}
+
+// CursorCodec signs cursors so that clients cannot forge positions, e.g. to
+// probe values of rows they cannot otherwise see.
+type CursorCodec struct {
+	key []byte
+}
+
+func NewCursorCodec(key []byte) *CursorCodec {
+	return &CursorCodec{key: key}
+}
+
+// Encode returns the opaque form of cursor.
+func (c *CursorCodec) Encode(cursor Cursor) string {
+	payload, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(c.sign(payload))
+}
+
+// Decode verifies and parses a cursor returned by Encode.
+func (c *CursorCodec) Decode(token string) (*Cursor, error) {
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, c.sign(payload)) {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	if err := json.Unmarshal(payload, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+func (c *CursorCodec) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// PageRequest asks for one page of a list.
+type PageRequest struct {
+	// Cursor is nil for the first page
+	Cursor *Cursor
+	Limit  int
+	Count  string
+}
+
+// Total is the number of items of a list across all pages.
+type Total struct {
+	Count     int64
+	Estimated bool
+}
+
+// Page describes where a page sits in its list. Next and Prev are empty on
+// the last and first page.
+type Page struct {
+	Next           string `json:"next,omitempty"`
+	Prev           string `json:"prev,omitempty"`
+	Limit          int    `json:"limit"`
+	Total          *int64 `json:"total,omitempty"`
+	TotalEstimated bool   `json:"totalEstimated,omitempty"`
+}
+
+// ParsePageRequest reads the cursor, limit and count query parameters. sort
+// identifies the ordering of the list; a cursor issued for another ordering
+// is rejected.
+func (c *CursorCodec) ParsePageRequest(ctx *gin.Context, sort string, defaultLimit, maxLimit int) (PageRequest, error) {
+	limit, err := ConvertQueryParamToInt(ctx, "limit", defaultLimit, 1, maxLimit)
+	if err != nil {
+		return PageRequest{}, fmt.Errorf("invalid limit: %w", err)
+	}
+
+	req := PageRequest{Limit: limit, Count: ctx.DefaultQuery("count", CountExact)}
+	switch req.Count {
+	case CountExact, CountEstimate, CountNone:
+	default:
+		return PageRequest{}, fmt.Errorf("invalid count, expected one of %s, %s, %s",
+			CountExact, CountEstimate, CountNone)
+	}
+
+	if token := ctx.Query("cursor"); token != "" {
+		cursor, err := c.Decode(token)
+		if err != nil {
+			return PageRequest{}, err
+		}
+		if cursor.Sort != sort {
+			return PageRequest{}, fmt.Errorf("%w: issued for another sort order", ErrInvalidCursor)
+		}
+		req.Cursor = cursor
+	}
+
+	return req, nil
+}
+
+// BuildPage takes the items fetched for req, up to Limit+1 in the order they
+// were queried, and returns the items of the page in list order with the
+// page's cursors. Backward pages are queried in reverse order, so the extra
+// item tells whether there is a further page in the direction of travel.
+// key returns the sort values of an item.
+func BuildPage[T any](codec *CursorCodec, sort string, req PageRequest, items []T, key func(T) []string) ([]T, Page) {
+	more := len(items) > req.Limit
+	if more {
+		items = items[:req.Limit]
+	}
+
+	backward := req.Cursor != nil && req.Cursor.Before
+	hasNext, hasPrev := more, req.Cursor != nil
+	if backward {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+		hasNext, hasPrev = true, more
+	}
+
+	page := Page{Limit: req.Limit}
+	if len(items) > 0 {
+		if hasNext {
+			page.Next = codec.Encode(Cursor{Sort: sort, Values: key(items[len(items)-1])})
+		}
+		if hasPrev {
+			page.Prev = codec.Encode(Cursor{Sort: sort, Values: key(items[0]), Before: true})
+		}
+	}
+	return items, page
+}
+
+// WithTotal adds the item count to a page, if it was counted.
+func (p Page) WithTotal(total *Total) Page {
+	if total != nil {
+		p.Total = &total.Count
+		p.TotalEstimated = total.Estimated
+	}
+	return p
+}
@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCursorCodecRoundTrip(t *testing.T) {
+	codec := NewCursorCodec([]byte("key"))
+	cursor := Cursor{Sort: "-name", Values: []string{"Ada, \"the\" first", "42"}, Before: true}
+
+	decoded, err := codec.Decode(codec.Encode(cursor))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !reflect.DeepEqual(*decoded, cursor) {
+		t.Errorf("Decode = %+v, want %+v", *decoded, cursor)
+	}
+}
+
+func TestCursorCodecRejects(t *testing.T) {
+	codec := NewCursorCodec([]byte("key"))
+	token := codec.Encode(Cursor{Sort: "name", Values: []string{"Ada", "1"}})
+	payload, signature, _ := strings.Cut(token, ".")
+	forged := NewCursorCodec([]byte("other key")).Encode(Cursor{Sort: "name", Values: []string{"Ada", "1"}})
+	_, forgedSignature, _ := strings.Cut(forged, ".")
+
+	tampered := []byte(payload)
+	tampered[len(tampered)/2] ^= 1
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"no signature", payload},
+		{"tampered payload", string(tampered) + "." + signature},
+		{"truncated signature", payload + "." + signature[:len(signature)-2]},
+		{"signed with another key", forged},
+		{"payload with another key's signature", payload + "." + forgedSignature},
+		{"not base64", "!!!." + signature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if cursor, err := codec.Decode(tt.token); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("Decode = %+v, %v, want ErrInvalidCursor", cursor, err)
+			}
+		})
+	}
+}
+
+type pageItem struct {
+	name string
+	id   int
+}
+
+func (i pageItem) key() []string {
+	return []string{i.name, strconv.Itoa(i.id)}
+}
+
+// less orders items by name and then id, as the repositories do.
+func (i pageItem) less(j pageItem) bool {
+	if i.name != j.name {
+		return i.name < j.name
+	}
+	return i.id < j.id
+}
+
+// fetch plays the repository: it returns up to limit+1 items after or
+// before the cursor, in query order.
+func fetch(items []pageItem, req PageRequest) []pageItem {
+	var from pageItem
+	if req.Cursor != nil {
+		id, _ := strconv.Atoi(req.Cursor.Values[1])
+		from = pageItem{name: req.Cursor.Values[0], id: id}
+	}
+
+	var out []pageItem
+	backward := req.Cursor != nil && req.Cursor.Before
+	for _, item := range items {
+		switch {
+		case req.Cursor == nil, backward && item.less(from), !backward && from.less(item):
+			out = append(out, item)
+		}
+	}
+	if backward {
+		sort.Slice(out, func(a, b int) bool { return out[b].less(out[a]) })
+	}
+	if len(out) > req.Limit+1 {
+		out = out[:req.Limit+1]
+	}
+	return out
+}
+
+func TestBuildPage(t *testing.T) {
+	codec := NewCursorCodec([]byte("key"))
+	// Equal names are told apart by id
+	items := []pageItem{{"a", 1}, {"b", 2}, {"b", 3}, {"b", 4}, {"c", 5}}
+	next := func(req PageRequest) ([]pageItem, Page) {
+		return BuildPage(codec, "name", req, fetch(items, req), pageItem.key)
+	}
+	cursor := func(token string) *Cursor {
+		t.Helper()
+		c, err := codec.Decode(token)
+		if err != nil {
+			t.Fatalf("Decode(%q): %v", token, err)
+		}
+		return c
+	}
+
+	// Forward to the end
+	first, page := next(PageRequest{Limit: 2})
+	if !reflect.DeepEqual(first, items[:2]) || page.Prev != "" || page.Next == "" {
+		t.Fatalf("first page = %v, %+v", first, page)
+	}
+	second, page := next(PageRequest{Limit: 2, Cursor: cursor(page.Next)})
+	if !reflect.DeepEqual(second, items[2:4]) || page.Prev == "" || page.Next == "" {
+		t.Fatalf("second page = %v, %+v", second, page)
+	}
+	last, page := next(PageRequest{Limit: 2, Cursor: cursor(page.Next)})
+	if !reflect.DeepEqual(last, items[4:]) || page.Prev == "" || page.Next != "" {
+		t.Fatalf("last page = %v, %+v", last, page)
+	}
+
+	// And back to the start
+	second, page = next(PageRequest{Limit: 2, Cursor: cursor(page.Prev)})
+	if !reflect.DeepEqual(second, items[2:4]) || page.Prev == "" || page.Next == "" {
+		t.Fatalf("second page going back = %v, %+v", second, page)
+	}
+	first, page = next(PageRequest{Limit: 2, Cursor: cursor(page.Prev)})
+	if !reflect.DeepEqual(first, items[:2]) || page.Prev != "" || page.Next == "" {
+		t.Fatalf("first page going back = %v, %+v", first, page)
+	}
+	if c := cursor(page.Next); c.Sort != "name" || c.Before || !reflect.DeepEqual(c.Values, []string{"b", "2"}) {
+		t.Errorf("next cursor = %+v", c)
+	}
+}
+
+func TestBuildPageEdges(t *testing.T) {
+	codec := NewCursorCodec([]byte("key"))
+	items := []pageItem{{"a", 1}, {"b", 2}}
+
+	tests := []struct {
+		name     string
+		req      PageRequest
+		wantNext bool
+		wantPrev bool
+	}{
+		{"single page", PageRequest{Limit: 2}, false, false},
+		{"exactly full last page", PageRequest{Limit: 1, Cursor: &Cursor{Values: []string{"a", "1"}}}, false, true},
+		{"past the end", PageRequest{Limit: 2, Cursor: &Cursor{Values: []string{"b", "2"}}}, false, false},
+		{"back to the first page", PageRequest{Limit: 1, Cursor: &Cursor{Values: []string{"b", "2"}, Before: true}}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, page := BuildPage(codec, "name", tt.req, fetch(items, tt.req), pageItem.key)
+			if (page.Next != "") != tt.wantNext || (page.Prev != "") != tt.wantPrev {
+				t.Errorf("page = %+v, want next %v, prev %v", page, tt.wantNext, tt.wantPrev)
+			}
+		})
+	}
+}
+
+func TestParsePageRequestRejectsCursorOfAnotherSort(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	codec := NewCursorCodec([]byte("key"))
+	token := codec.Encode(Cursor{Sort: "name", Values: []string{"Ada", "1"}})
+
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/users?cursor="+token, nil)
+
+	if _, err := codec.ParsePageRequest(ctx, "name", 20, 100); err != nil {
+		t.Fatalf("ParsePageRequest for the same sort: %v", err)
+	}
+	if _, err := codec.ParsePageRequest(ctx, "-name", 20, 100); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("ParsePageRequest for another sort = %v, want ErrInvalidCursor", err)
+	}
+}
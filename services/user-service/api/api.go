@@ -1,30 +1,100 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
+	"github.com/luisVargasGu/stockTracker/common/mailer"
 	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/common/utils"
 	"github.com/luisVargasGu/stockTracker/services/user-service/controllers"
 	"github.com/luisVargasGu/stockTracker/services/user-service/repository"
 	"github.com/luisVargasGu/stockTracker/services/user-service/services"
 	"go.uber.org/zap"
 )
 
+// ServerConfig holds the listen address and timeouts of the HTTP server.
+type ServerConfig struct {
+	Addr              string        `yaml:"addr" env:"HTTP_ADDR" required:"true"`
+	ReadHeaderTimeout time.Duration `yaml:"readHeaderTimeout" env:"HTTP_READ_HEADER_TIMEOUT"`
+	ReadTimeout       time.Duration `yaml:"readTimeout" env:"HTTP_READ_TIMEOUT"`
+	// Bounds streamed responses such as user exports too
+	WriteTimeout time.Duration `yaml:"writeTimeout" env:"HTTP_WRITE_TIMEOUT"`
+	IdleTimeout  time.Duration `yaml:"idleTimeout" env:"HTTP_IDLE_TIMEOUT"`
+	// How long /ready reports not ready before the server stops accepting
+	// connections, so that load balancers stop routing to it first
+	DrainDelay time.Duration `yaml:"drainDelay" env:"HTTP_DRAIN_DELAY"`
+	// Bounds the wait for in-flight requests and background work on shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" env:"HTTP_SHUTDOWN_TIMEOUT"`
+	// Accept user updates without If-Match instead of answering 428, until
+	// every client sends it
+	AllowUnconditionalUpdates bool `yaml:"allowUnconditionalUpdates" env:"HTTP_ALLOW_UNCONDITIONAL_UPDATES"`
+}
+
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		Addr:              ":8080",
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      60 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		DrainDelay:        5 * time.Second,
+		ShutdownTimeout:   25 * time.Second,
+	}
+}
+
+// Bounds the database ping of a readiness check
+const readyTimeout = 2 * time.Second
+
 type APIServer struct {
-	addr string
-	db   *sqlx.DB
+	config    ServerConfig
+	db        *sqlx.DB
+	mailer    mailer.Mailer
+	appURL    string
+	policy    *middleware.PasswordPolicy
+	clients   *middleware.ClientRegistry
+	providers map[string]services.IdentityProvider
+	cursors   *utils.CursorCodec
+	avatars   *services.AvatarStore
+	// Goroutines that must finish before the database closes
+	background *services.Background
+	draining   atomic.Bool
 }
 
-func NewAPIServer(addr string, db *sqlx.DB) *APIServer {
+func NewAPIServer(config ServerConfig,
+	db *sqlx.DB,
+	mailer mailer.Mailer,
+	appURL string,
+	policy *middleware.PasswordPolicy,
+	clients *middleware.ClientRegistry,
+	providers map[string]services.IdentityProvider,
+	cursors *utils.CursorCodec,
+	avatars *services.AvatarStore,
+	background *services.Background) *APIServer {
 	return &APIServer{
-		addr: addr,
-		db:   db,
+		config:     config,
+		db:         db,
+		mailer:     mailer,
+		appURL:     appURL,
+		policy:     policy,
+		clients:    clients,
+		providers:  providers,
+		cursors:    cursors,
+		avatars:    avatars,
+		background: background,
 	}
 }
 
-func (s *APIServer) Run(logger *zap.Logger, tokenService middleware.TokenService) error {
+// Run serves until ctx is done, then shuts down gracefully: /ready turns not
+// ready, in-flight requests and background work get ShutdownTimeout to
+// finish and the database is closed. Background work still running then is
+// cancelled instead, and the database left open to it.
+func (s *APIServer) Run(ctx context.Context, logger *zap.Logger, tokenService middleware.TokenService) error {
 	router := gin.New()
 
 	router.Use(middleware.LoggingMiddleware(logger))
@@ -33,21 +103,107 @@ func (s *APIServer) Run(logger *zap.Logger, tokenService middleware.TokenService
 
 	// Initialize dependencies
 	userRepository := repository.NewUserStore(s.db, logger)
-	userService := services.NewUserService(userRepository, tokenService, logger)
-	userHandler := controllers.NewUserHandler(userService, tokenService, logger)
+	tokenRepository := repository.NewTokenStore(s.db, logger)
+	mfaRepository := repository.NewMFAStore(s.db, logger)
+	roleRepository := repository.NewRoleStore(s.db, logger)
+	apiKeyRepository := repository.NewAPIKeyStore(s.db, logger)
+	identityRepository := repository.NewIdentityStore(s.db, logger)
+	exportRepository := repository.NewDataExportStore(s.db, logger)
+	orgRepository := repository.NewOrgStore(s.db, logger)
+	preferencesRepository := repository.NewPreferencesStore(s.db, logger)
+	auditRepository := repository.NewAuditStore(s.db, logger)
+	transactor := repository.NewTransactor(s.db, logger)
+	notifier := services.NewAccountNotifier(s.mailer, s.appURL)
+	userService := services.NewUserService(userRepository, tokenRepository, mfaRepository,
+		roleRepository, apiKeyRepository, identityRepository, s.providers,
+		exportRepository, s.avatars, orgRepository, preferencesRepository, auditRepository, transactor, tokenService, notifier, s.policy, s.background, logger)
+	var handlerOpts []controllers.HandlerOption
+	if s.config.AllowUnconditionalUpdates {
+		handlerOpts = append(handlerOpts, controllers.WithUnconditionalUpdates())
+	}
+	userHandler := controllers.NewUserHandler(userService, tokenService, s.cursors, logger, handlerOpts...)
 	userHandler.RegisterRoutes(router.Group("/api/v1"))
 
+	// Public keys for services verifying our tokens
+	router.GET("/.well-known/jwks.json", middleware.JWKSHandler(tokenService))
+
+	// Token endpoint for service-to-service calls
+	router.POST("/oauth/token", middleware.ClientCredentialsHandler(tokenService, s.clients))
+
 	// Add a health check endpoint
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "OK"})
 	})
 
-	// Start the server with error handling
-	logger.Info("Starting server on ", zap.String("adders", s.addr))
-	if err := router.Run(s.addr); err != nil {
-		logger.Info("Server failed to start", zap.Error(err))
+	// Reports whether this replica should receive traffic
+	router.GET("/ready", s.ready)
+
+	server := &http.Server{
+		Addr:              s.config.Addr,
+		Handler:           router,
+		ReadHeaderTimeout: s.config.ReadHeaderTimeout,
+		ReadTimeout:       s.config.ReadTimeout,
+		WriteTimeout:      s.config.WriteTimeout,
+		IdleTimeout:       s.config.IdleTimeout,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+	logger.Info("Starting server", zap.String("addr", s.config.Addr))
+
+	select {
+	case err := <-serveErr:
+		logger.Error("Server failed to start", zap.Error(err))
 		return err
+	case <-ctx.Done():
+	}
+
+	return s.shutdown(server, logger)
+}
+
+func (s *APIServer) shutdown(server *http.Server, logger *zap.Logger) error {
+	s.draining.Store(true)
+	logger.Info("Shutting down, draining connections", zap.Duration("drainDelay", s.config.DrainDelay))
+	time.Sleep(s.config.DrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+
+	var errs []error
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Error("In-flight requests did not finish in time", zap.Error(err))
+		errs = append(errs, err)
+	}
+	if err := s.background.Wait(ctx); err != nil {
+		// Cancelled work may still be winding down on the database, which
+		// the process exit closes soon enough
+		logger.Error("Background work did not finish in time, leaving the database open", zap.Error(err))
+		errs = append(errs, err)
+	} else if err := s.db.Close(); err != nil {
+		logger.Error("Failed to close the database", zap.Error(err))
+		errs = append(errs, err)
+	}
+
+	logger.Info("Server stopped")
+	return errors.Join(errs...)
+}
+
+// ready fails while the server drains or cannot reach the database, unlike
+// /health which only says the process is up.
+func (s *APIServer) ready(c *gin.Context) {
+	if s.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readyTimeout)
+	defer cancel()
+	if err := s.db.PingContext(ctx); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "database unavailable"})
+		return
 	}
 
-	return nil
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
 }
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+// pingConnector hands out connections that can only be pinged, failing
+// while down is set.
+type pingConnector struct {
+	down atomic.Bool
+}
+
+func (c *pingConnector) Connect(context.Context) (driver.Conn, error) {
+	return pingConn{c}, nil
+}
+
+func (c *pingConnector) Driver() driver.Driver {
+	return nil
+}
+
+type pingConn struct {
+	connector *pingConnector
+}
+
+func (pingConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (pingConn) Close() error {
+	return nil
+}
+
+func (pingConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c pingConn) Ping(context.Context) error {
+	if c.connector.down.Load() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func newTestServer(config ServerConfig) (*APIServer, *pingConnector) {
+	connector := &pingConnector{}
+	db := sqlx.NewDb(sql.OpenDB(connector), "postgres")
+	return NewAPIServer(config, db, nil, "", nil, nil, nil, nil, nil, services.NewBackground()), connector
+}
+
+func readyStatus(s *APIServer) (int, string) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ready", s.ready)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	return w.Code, w.Body.String()
+}
+
+func TestReady(t *testing.T) {
+	s, connector := newTestServer(DefaultServerConfig())
+
+	if code, body := readyStatus(s); code != http.StatusOK {
+		t.Errorf("/ready = %d %s, want 200", code, body)
+	}
+
+	connector.down.Store(true)
+	if code, body := readyStatus(s); code != http.StatusServiceUnavailable || !strings.Contains(body, "database unavailable") {
+		t.Errorf("/ready without a database = %d %s, want 503", code, body)
+	}
+}
+
+func TestShutdownDrainsBeforeStopping(t *testing.T) {
+	config := DefaultServerConfig()
+	config.DrainDelay = 100 * time.Millisecond
+	config.ShutdownTimeout = time.Second
+	s, _ := newTestServer(config)
+
+	done := make(chan error, 1)
+	go func() { done <- s.shutdown(&http.Server{}, zap.NewNop()) }()
+
+	// Load balancers see the replica leave while it still serves
+	time.Sleep(config.DrainDelay / 2)
+	if code, body := readyStatus(s); code != http.StatusServiceUnavailable || !strings.Contains(body, "draining") {
+		t.Errorf("/ready while draining = %d %s, want 503 draining", code, body)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if err := s.db.Ping(); err == nil {
+		t.Error("database still open after a clean shutdown")
+	}
+}
+
+func TestShutdownLeavesDatabaseToUnfinishedWork(t *testing.T) {
+	config := DefaultServerConfig()
+	config.DrainDelay = 0
+	config.ShutdownTimeout = 50 * time.Millisecond
+	s, _ := newTestServer(config)
+
+	cancelled := make(chan struct{})
+	s.background.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		close(cancelled)
+	})
+
+	if err := s.shutdown(&http.Server{}, zap.NewNop()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("shutdown error = %v, want context.DeadlineExceeded", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("unfinished work was not cancelled")
+	}
+	if err := s.db.Ping(); err != nil {
+		t.Errorf("database closed under unfinished work: %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/luisVargasGu/stockTracker/common/config"
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/services/user-service/api"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+)
+
+// Config holds the settings of the user service. They are read from the
+// YAML file named by CONFIG_FILE, if any, then .env and the environment;
+// see .env for a local setup.
+type Config struct {
+	// Env is the deployment, e.g. development or production
+	Env      string           `yaml:"env" env:"APP_ENV"`
+	HTTP     api.ServerConfig `yaml:"http"`
+	AppURL   string           `yaml:"appUrl" env:"APP_URL"`
+	Database config.Database  `yaml:"database"`
+	Auth     AuthConfig       `yaml:"auth"`
+	Mail     MailConfig       `yaml:"mail"`
+	Storage  StorageConfig    `yaml:"storage"`
+	Password PasswordConfig   `yaml:"password"`
+	// Days a deleted account can be restored before it is purged
+	DeletedUserRetentionDays int `yaml:"deletedUserRetentionDays" env:"DELETED_USER_RETENTION_DAYS"`
+}
+
+type AuthConfig struct {
+	// Replicas must share the keys directory to sign and verify with the
+	// same keys
+	KeysDir string `yaml:"keysDir" env:"JWT_KEYS_DIR"`
+	// Replicas must share the cursor secret, or a cursor is only valid on
+	// the replica that issued it
+	CursorSecret       string `yaml:"cursorSecret" env:"CURSOR_SECRET" secret:"true"`
+	ServiceClientsFile string `yaml:"serviceClientsFile" env:"SERVICE_CLIENTS_FILE"`
+	OIDCProvidersFile  string `yaml:"oidcProvidersFile" env:"OIDC_PROVIDERS_FILE"`
+	TestAuth           bool   `yaml:"testAuth" env:"TEST_AUTH"`
+}
+
+type MailConfig struct {
+	// Driver is log, file or smtp
+	Driver       string `yaml:"driver" env:"MAIL_DRIVER"`
+	From         string `yaml:"from" env:"MAIL_FROM"`
+	Dir          string `yaml:"dir" env:"MAIL_DIR"`
+	SMTPAddr     string `yaml:"smtpAddr" env:"SMTP_ADDR"`
+	SMTPUsername string `yaml:"smtpUsername" env:"SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtpPassword" env:"SMTP_PASSWORD" secret:"true"`
+}
+
+type StorageConfig struct {
+	// Driver is file or s3
+	Driver        string   `yaml:"driver" env:"STORAGE_DRIVER"`
+	Dir           string   `yaml:"dir" env:"STORAGE_DIR"`
+	S3            S3Config `yaml:"s3"`
+	AvatarBaseURL string   `yaml:"avatarBaseUrl" env:"AVATAR_BASE_URL"`
+}
+
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint" env:"S3_ENDPOINT"`
+	Region    string `yaml:"region" env:"S3_REGION"`
+	Bucket    string `yaml:"bucket" env:"S3_BUCKET"`
+	AccessKey string `yaml:"accessKey" env:"S3_ACCESS_KEY" secret:"true"`
+	SecretKey string `yaml:"secretKey" env:"S3_SECRET_KEY" secret:"true"`
+	PathStyle bool   `yaml:"pathStyle" env:"S3_PATH_STYLE"`
+}
+
+type PasswordConfig struct {
+	MinLength     int    `yaml:"minLength" env:"PASSWORD_MIN_LENGTH"`
+	History       int    `yaml:"history" env:"PASSWORD_HISTORY"`
+	RequireUpper  bool   `yaml:"requireUpper" env:"PASSWORD_REQUIRE_UPPER"`
+	RequireLower  bool   `yaml:"requireLower" env:"PASSWORD_REQUIRE_LOWER"`
+	RequireDigit  bool   `yaml:"requireDigit" env:"PASSWORD_REQUIRE_DIGIT"`
+	RequireSymbol bool   `yaml:"requireSymbol" env:"PASSWORD_REQUIRE_SYMBOL"`
+	DenylistFile  string `yaml:"denylistFile" env:"PASSWORD_DENYLIST_FILE"`
+}
+
+// Shortest cursor secret accepted in production
+const minCursorSecretLength = 32
+
+func defaultConfig() Config {
+	policy := middleware.DefaultPasswordPolicy()
+	return Config{
+		Env:      "development",
+		HTTP:     api.DefaultServerConfig(),
+		Database: config.DefaultDatabase(),
+		Mail:     MailConfig{Driver: "log"},
+		Storage:  StorageConfig{Driver: "file", Dir: "data"},
+		Password: PasswordConfig{
+			MinLength:     policy.MinLength,
+			History:       policy.HistorySize,
+			RequireUpper:  policy.RequireUpper,
+			RequireLower:  policy.RequireLower,
+			RequireDigit:  policy.RequireDigit,
+			RequireSymbol: policy.RequireSymbol,
+		},
+		DeletedUserRetentionDays: int(services.DefaultDeletedUserRetention / (24 * time.Hour)),
+	}
+}
+
+// IsProduction reports whether the service runs in production, where
+// settings that only matter for a real deployment are required.
+func (c *Config) IsProduction() bool {
+	return strings.EqualFold(c.Env, "production")
+}
+
+// Validate checks the rules spanning several settings.
+func (c *Config) Validate() error {
+	var errs []error
+
+	switch c.Mail.Driver {
+	case "log":
+	case "file":
+		if c.Mail.Dir == "" {
+			errs = append(errs, errors.New("MAIL_DIR is required with MAIL_DRIVER=file"))
+		}
+	case "smtp":
+		if c.Mail.SMTPAddr == "" {
+			errs = append(errs, errors.New("SMTP_ADDR is required with MAIL_DRIVER=smtp"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("MAIL_DRIVER: unknown driver %q, expected log, file or smtp", c.Mail.Driver))
+	}
+
+	switch c.Storage.Driver {
+	case "file":
+		if c.Storage.Dir == "" {
+			errs = append(errs, errors.New("STORAGE_DIR is required with STORAGE_DRIVER=file"))
+		}
+	case "s3":
+		s3 := c.Storage.S3
+		for _, setting := range []struct{ name, value string }{
+			{"S3_ENDPOINT", s3.Endpoint},
+			{"S3_REGION", s3.Region},
+			{"S3_BUCKET", s3.Bucket},
+			{"S3_ACCESS_KEY", s3.AccessKey},
+			{"S3_SECRET_KEY", s3.SecretKey},
+		} {
+			if setting.value == "" {
+				errs = append(errs, fmt.Errorf("%s is required with STORAGE_DRIVER=s3", setting.name))
+			}
+		}
+	default:
+		errs = append(errs, fmt.Errorf("STORAGE_DRIVER: unknown driver %q, expected file or s3", c.Storage.Driver))
+	}
+
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"HTTP_READ_HEADER_TIMEOUT", c.HTTP.ReadHeaderTimeout},
+		{"HTTP_READ_TIMEOUT", c.HTTP.ReadTimeout},
+		{"HTTP_WRITE_TIMEOUT", c.HTTP.WriteTimeout},
+		{"HTTP_IDLE_TIMEOUT", c.HTTP.IdleTimeout},
+		{"HTTP_DRAIN_DELAY", c.HTTP.DrainDelay},
+		{"HTTP_SHUTDOWN_TIMEOUT", c.HTTP.ShutdownTimeout},
+	} {
+		if timeout.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", timeout.name))
+		}
+	}
+
+	if c.DeletedUserRetentionDays < 0 {
+		errs = append(errs, errors.New("DELETED_USER_RETENTION_DAYS must not be negative"))
+	}
+	if c.Password.MinLength < 1 || c.Password.History < 0 {
+		errs = append(errs, errors.New("PASSWORD_MIN_LENGTH must be positive and PASSWORD_HISTORY not negative"))
+	}
+
+	// Outside production these fall back to per-process values
+	if c.IsProduction() {
+		if c.Auth.KeysDir == "" {
+			errs = append(errs, errors.New("JWT_KEYS_DIR is required in production"))
+		}
+		if len(c.Auth.CursorSecret) < minCursorSecretLength {
+			errs = append(errs, fmt.Errorf("CURSOR_SECRET must be at least %d characters in production", minCursorSecretLength))
+		}
+	}
+
+	return errors.Join(errs...)
+}
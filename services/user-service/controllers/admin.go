@@ -0,0 +1,281 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+// Largest user import file accepted
+const maxImportSize = 1 << 20
+
+// Columns of a CSV user export
+var exportColumns = []string{
+	"id", "email", "name", "role", "emailVerified",
+	"createdAt", "updatedAt", "lastLogin", "disabledAt", "deletedAt",
+}
+
+// ImportUsers creates users from a CSV file, sent either as the multipart
+// field "file" or as a text/csv body. With ?dryRun=true the rows are only
+// checked. If any row is invalid nothing is created and the report of
+// every row comes back with 422.
+func (h *UserHandler) ImportUsers(c *gin.Context) {
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dryRun", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("invalid dryRun: %w", err)))
+		return
+	}
+
+	var file io.Reader
+	if c.ContentType() == "multipart/form-data" {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize+multipartOverhead)
+		part, _, err := c.Request.FormFile("file")
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.JSON(http.StatusRequestEntityTooLarge, errorResponse(fmt.Errorf("import file exceeds %d bytes", maxImportSize)))
+				return
+			}
+			c.JSON(http.StatusBadRequest, errorResponse(errors.New("multipart field file is required")))
+			return
+		}
+		defer part.Close()
+		file = part
+	} else {
+		file = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+	}
+
+	report, err := h.service.ImportUsers(c, file, dryRun)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.Is(err, services.ErrUnauthorized):
+			c.JSON(http.StatusForbidden, errorResponse(err))
+		case errors.Is(err, services.ErrImportFailed):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "report": report})
+		case errors.As(err, &tooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, errorResponse(fmt.Errorf("import file exceeds %d bytes", maxImportSize)))
+		case errors.Is(err, services.ErrInvalidImport):
+			c.JSON(http.StatusBadRequest, errorResponse(err))
+		default:
+			h.log.Error("Failed to import users", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, errorResponse(errors.New("failed to import users")))
+		}
+		return
+	}
+
+	status := http.StatusCreated
+	if dryRun {
+		status = http.StatusOK
+	}
+	c.JSON(status, gin.H{"report": report})
+}
+
+// ExportUsers downloads every user matching the filters of GET /users as
+// ?format=csv (the default) or json. Pagination parameters are ignored.
+func (h *UserHandler) ExportUsers(c *gin.Context) {
+	filter, _, err := h.parseUserFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	var export userExport
+	format := c.DefaultQuery("format", "csv")
+	switch format {
+	case "csv":
+		export = &csvUserExport{w: csv.NewWriter(c.Writer)}
+	case "json":
+		export = &jsonUserExport{w: c.Writer}
+	default:
+		c.JSON(http.StatusBadRequest, errorResponse(errors.New("invalid format, expected one of csv, json")))
+		return
+	}
+
+	// Headers go out with the first user, so that errors before it can
+	// still be reported properly
+	started := false
+	start := func() {
+		contentType := "text/csv; charset=utf-8"
+		if format == "json" {
+			contentType = "application/json; charset=utf-8"
+		}
+		filename := fmt.Sprintf("users-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Status(http.StatusOK)
+		started = true
+	}
+
+	err = h.service.ExportUsers(c, filter, func(user *models.User) error {
+		if !started {
+			start()
+		}
+		return export.write(user)
+	})
+	if err == nil {
+		if !started {
+			start()
+		}
+		err = export.close()
+	}
+	if err != nil {
+		if started {
+			// Too late for an error response; a truncated file is the best signal
+			h.log.Error("User export failed midway", zap.Error(err))
+			c.Abort()
+			return
+		}
+		switch {
+		case errors.Is(err, services.ErrUnauthorized):
+			c.JSON(http.StatusForbidden, errorResponse(err))
+		default:
+			h.log.Error("Failed to export users", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, errorResponse(errors.New("failed to export users")))
+		}
+	}
+}
+
+// BulkUpdateUsers disables, enables, changes the role of or deletes many
+// users at once. Either all of them change or none do.
+func (h *UserHandler) BulkUpdateUsers(c *gin.Context) {
+	var payload models.BulkUserPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		h.log.Error("Invalid request body", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		h.log.Error("Validation failed", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("validation failed: %w", err)))
+		return
+	}
+
+	count, err := h.service.BulkUpdateUsers(c, payload)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnauthorized):
+			c.JSON(http.StatusForbidden, errorResponse(err))
+		case errors.Is(err, services.ErrUserNotFound):
+			c.JSON(http.StatusNotFound, errorResponse(err))
+		case errors.Is(err, services.ErrRoleNotFound):
+			c.JSON(http.StatusBadRequest, errorResponse(err))
+		case errors.Is(err, services.ErrOwnAccount):
+			c.JSON(http.StatusConflict, errorResponse(err))
+		default:
+			h.log.Error("Failed to apply bulk action", zap.String("action", payload.Action), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, errorResponse(errors.New("failed to apply bulk action")))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"action": payload.Action, "count": count})
+}
+
+// userExport writes users to a download in some format
+type userExport interface {
+	write(user *models.User) error
+	close() error
+}
+
+type csvUserExport struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (e *csvUserExport) write(user *models.User) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+
+	return e.w.Write([]string{
+		strconv.Itoa(user.ID),
+		csvSafe(user.Email),
+		csvSafe(user.Name),
+		csvSafe(user.Role),
+		strconv.FormatBool(user.EmailVerifiedAt != nil),
+		formatExportTime(&user.CreatedAt),
+		formatExportTime(&user.UpdatedAt),
+		formatExportTime(&user.LastLogin),
+		formatExportTime(user.DisabledAt),
+		formatExportTime(user.DeletedAt),
+	})
+}
+
+func (e *csvUserExport) writeHeader() error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	return e.w.Write(exportColumns)
+}
+
+func (e *csvUserExport) close() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// csvSafe keeps spreadsheets from running a value as a formula
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func formatExportTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// jsonUserExport writes a JSON array of users, one at a time
+type jsonUserExport struct {
+	w     io.Writer
+	count int
+}
+
+func (e *jsonUserExport) write(user *models.User) error {
+	separator := ","
+	if e.count == 0 {
+		separator = "["
+	}
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(e.w, separator); err != nil {
+		return err
+	}
+	e.count++
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *jsonUserExport) close() error {
+	end := "]"
+	if e.count == 0 {
+		end = "[]"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
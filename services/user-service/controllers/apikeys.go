@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+// CreateAPIKey issues a personal API key
+func (h *UserHandler) CreateAPIKey(c *gin.Context) {
+	id, err := h.parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	var payload models.CreateAPIKeyPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		h.log.Error("Invalid request body", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		h.log.Error("Validation failed", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("validation failed: %w", err)))
+		return
+	}
+
+	key, err := h.service.CreateAPIKey(c, id, payload)
+	if err != nil {
+		h.handleAPIKeyError(c, id, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, key)
+}
+
+// ListAPIKeys lists a user's API keys
+func (h *UserHandler) ListAPIKeys(c *gin.Context) {
+	id, err := h.parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	keys, err := h.service.ListAPIKeys(c, id)
+	if err != nil {
+		h.handleAPIKeyError(c, id, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"apiKeys": keys})
+}
+
+// RevokeAPIKey revokes one of a user's API keys
+func (h *UserHandler) RevokeAPIKey(c *gin.Context) {
+	id, err := h.parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	keyID, err := strconv.Atoi(c.Param("keyId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("invalid API key ID format: %w", err)))
+		return
+	}
+
+	if err := h.service.RevokeAPIKey(c, id, keyID); err != nil {
+		h.handleAPIKeyError(c, id, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
+
+func (h *UserHandler) handleAPIKeyError(c *gin.Context, id int, err error) {
+	switch {
+	case errors.Is(err, services.ErrUnauthorized):
+		c.JSON(http.StatusForbidden, errorResponse(err))
+	case errors.Is(err, services.ErrInvalidScope), errors.Is(err, services.ErrInvalidExpiry):
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+	case errors.Is(err, services.ErrAPIKeyNotFound):
+		c.JSON(http.StatusNotFound, errorResponse(err))
+	default:
+		h.log.Error("API key request failed", zap.Int("userID", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, errorResponse(errors.New("API key request failed")))
+	}
+}
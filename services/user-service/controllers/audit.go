@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/luisVargasGu/stockTracker/common/utils"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+// ListAuditEntries searches the audit log by actor, target, action and time
+// range, e.g. /audit-log?target=42&action=user.update&from=2024-01-01T00:00:00Z
+func (h *UserHandler) ListAuditEntries(c *gin.Context) {
+	filter, err := h.parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	entries, total, err := h.service.ListAuditEntries(c, filter)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnauthorized):
+			c.JSON(http.StatusForbidden, errorResponse(err))
+		case errors.Is(err, utils.ErrInvalidCursor):
+			c.JSON(http.StatusBadRequest, errorResponse(err))
+		default:
+			h.log.Error("Failed to query audit log", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, errorResponse(errors.New("failed to query audit log")))
+		}
+		return
+	}
+
+	entries, page := utils.BuildPage(h.cursors, models.AuditSort, filter.Page, entries, (*models.AuditEntry).SortKey)
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries": entries,
+		"page":    page.WithTotal(total),
+	})
+}
+
+func (h *UserHandler) parseAuditFilter(c *gin.Context) (models.AuditFilter, error) {
+	page, err := h.cursors.ParsePageRequest(c, models.AuditSort, defaultPageLimit, maxPageLimit)
+	if err != nil {
+		return models.AuditFilter{}, err
+	}
+
+	filter := models.AuditFilter{
+		Action: c.Query("action"),
+		Page:   page,
+	}
+	if filter.ActorID, err = optionalIntQuery(c, "actor"); err != nil {
+		return models.AuditFilter{}, err
+	}
+	if filter.TargetID, err = optionalIntQuery(c, "target"); err != nil {
+		return models.AuditFilter{}, err
+	}
+	if filter.From, err = optionalTimeQuery(c, "from"); err != nil {
+		return models.AuditFilter{}, err
+	}
+	if filter.To, err = optionalTimeQuery(c, "to"); err != nil {
+		return models.AuditFilter{}, err
+	}
+	return filter, nil
+}
+
+func optionalIntQuery(c *gin.Context, name string) (*int, error) {
+	value, ok := c.GetQuery(name)
+	if !ok {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return &n, nil
+}
+
+func optionalTimeQuery(c *gin.Context, name string) (*time.Time, error) {
+	value, ok := c.GetQuery(name)
+	if !ok {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s, expected RFC 3339: %w", name, err)
+	}
+	return &t, nil
+}
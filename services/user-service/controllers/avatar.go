@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+// Room for the multipart envelope around the image
+const multipartOverhead = 64 << 10
+
+// UploadAvatar replaces a user's avatar with the image in the multipart
+// field "avatar"
+func (h *UserHandler) UploadAvatar(c *gin.Context) {
+	id, err := h.parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, services.MaxAvatarSize+multipartOverhead)
+	file, _, err := c.Request.FormFile("avatar")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, errorResponse(services.ErrAvatarTooLarge))
+			return
+		}
+		c.JSON(http.StatusBadRequest, errorResponse(errors.New("multipart field avatar is required")))
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, services.MaxAvatarSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(errors.New("failed to read avatar")))
+		return
+	}
+
+	user, err := h.service.UploadAvatar(c, id, data)
+	if err != nil {
+		h.handleAvatarError(c, id, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
+
+// DeleteAvatar removes a user's avatar
+func (h *UserHandler) DeleteAvatar(c *gin.Context) {
+	id, err := h.parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	if err := h.service.DeleteAvatar(c, id); err != nil {
+		h.handleAvatarError(c, id, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Avatar deleted"})
+}
+
+// GetAvatar serves an avatar thumbnail. A new avatar gets new URLs, so the
+// files behind them never change and clients may cache them for good.
+func (h *UserHandler) GetAvatar(c *gin.Context) {
+	file := c.Param("file")
+	etag := `"` + file + `"`
+
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	avatar, err := h.service.GetAvatar(c, c.Param("user"), file)
+	if err != nil {
+		if errors.Is(err, services.ErrAvatarNotFound) {
+			c.JSON(http.StatusNotFound, errorResponse(err))
+			return
+		}
+		h.log.Error("Failed to load avatar", zap.String("file", file), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, errorResponse(errors.New("failed to load avatar")))
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("ETag", etag)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Data(http.StatusOK, avatar.ContentType, avatar.Data)
+}
+
+func (h *UserHandler) handleAvatarError(c *gin.Context, id int, err error) {
+	switch {
+	case errors.Is(err, services.ErrUnauthorized):
+		c.JSON(http.StatusForbidden, errorResponse(err))
+	case errors.Is(err, services.ErrUserNotFound), errors.Is(err, services.ErrAvatarNotFound):
+		c.JSON(http.StatusNotFound, errorResponse(err))
+	case errors.Is(err, services.ErrAvatarTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, errorResponse(err))
+	case errors.Is(err, services.ErrUnsupportedAvatar):
+		c.JSON(http.StatusUnsupportedMediaType, errorResponse(err))
+	case errors.Is(err, services.ErrInvalidAvatar):
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+	default:
+		h.log.Error("Avatar request failed", zap.Int("userID", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, errorResponse(errors.New("avatar request failed")))
+	}
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+// LoginMFA completes a login with a second factor
+func (h *UserHandler) LoginMFA(c *gin.Context) {
+	var payload models.MFALoginPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		h.log.Error("Invalid request body", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		h.log.Error("Validation failed", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("validation failed: %w", err)))
+		return
+	}
+
+	payload.IP = c.ClientIP()
+	loginResponse, err := h.service.LoginMFA(c, payload)
+	if err != nil {
+		h.log.Warn("Failed to complete MFA login", zap.String("ip", payload.IP), zap.Error(err))
+		h.respondLoginError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, loginResponse)
+}
+
+// EnrollMFA generates a TOTP secret for the caller
+func (h *UserHandler) EnrollMFA(c *gin.Context) {
+	id, err := h.parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	enrollment, err := h.service.EnrollMFA(c, id)
+	if err != nil {
+		h.handleMFAError(c, id, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ActivateMFA confirms a TOTP secret and returns recovery codes
+func (h *UserHandler) ActivateMFA(c *gin.Context) {
+	id, payload, ok := h.bindMFACode(c)
+	if !ok {
+		return
+	}
+
+	codes, err := h.service.ActivateMFA(c, id, payload)
+	if err != nil {
+		h.handleMFAError(c, id, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, codes)
+}
+
+// DisableMFA turns off two-factor authentication for the caller
+func (h *UserHandler) DisableMFA(c *gin.Context) {
+	id, payload, ok := h.bindMFACode(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DisableMFA(c, id, payload); err != nil {
+		h.handleMFAError(c, id, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+func (h *UserHandler) bindMFACode(c *gin.Context) (int, models.MFACodePayload, bool) {
+	var payload models.MFACodePayload
+
+	id, err := h.parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return 0, payload, false
+	}
+
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		h.log.Error("Invalid request body", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("invalid request body: %w", err)))
+		return 0, payload, false
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		h.log.Error("Validation failed", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("validation failed: %w", err)))
+		return 0, payload, false
+	}
+
+	return id, payload, true
+}
+
+func (h *UserHandler) handleMFAError(c *gin.Context, id int, err error) {
+	switch {
+	case errors.Is(err, services.ErrUnauthorized):
+		c.JSON(http.StatusForbidden, errorResponse(err))
+	case errors.Is(err, services.ErrInvalidMFACode):
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+	case errors.Is(err, services.ErrMFANotEnrolled):
+		c.JSON(http.StatusNotFound, errorResponse(err))
+	case errors.Is(err, services.ErrMFAAlreadyEnabled):
+		c.JSON(http.StatusConflict, errorResponse(err))
+	default:
+		h.log.Error("MFA request failed",
+			zap.Int("userID", id),
+			zap.Error(err))
+		c.JSON(http.StatusInternalServerError, errorResponse(errors.New("two-factor authentication request failed")))
+	}
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+// oidcStateCookie holds the binding of an external login to the browser
+// that started it
+const oidcStateCookie = "oidc_state"
+
+// ListIdentityProviders returns the names of the external login providers
+func (h *UserHandler) ListIdentityProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": h.service.IdentityProviders()})
+}
+
+// AuthorizeExternal returns the URL to send the browser to for an external login
+func (h *UserHandler) AuthorizeExternal(c *gin.Context) {
+	provider := c.Param("provider")
+
+	authorization, err := h.service.AuthorizeExternal(c, provider)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrProviderNotFound):
+			c.JSON(http.StatusNotFound, errorResponse(err))
+		case errors.Is(err, services.ErrExternalLoginFailed):
+			c.JSON(http.StatusBadGateway, errorResponse(err))
+		default:
+			h.log.Error("Failed to start external login", zap.String("provider", provider), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, errorResponse(errors.New("failed to start external login")))
+		}
+		return
+	}
+
+	setOIDCStateCookie(c, authorization.StateBinding, int(services.OIDCStateTTL.Seconds()))
+	c.JSON(http.StatusOK, authorization)
+}
+
+// LoginExternal completes an external login with the code and state the
+// provider redirected back with
+func (h *UserHandler) LoginExternal(c *gin.Context) {
+	provider := c.Param("provider")
+
+	var payload models.OIDCCallbackPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		h.log.Error("Invalid request body", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		h.log.Error("Validation failed", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("validation failed: %w", err)))
+		return
+	}
+
+	// A missing cookie fails the state check
+	payload.StateBinding, _ = c.Cookie(oidcStateCookie)
+
+	loginResponse, err := h.service.LoginExternal(c, provider, payload)
+	// The state is spent unless it was refused
+	if !errors.Is(err, services.ErrInvalidOIDCState) {
+		setOIDCStateCookie(c, "", -1)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrProviderNotFound):
+			c.JSON(http.StatusNotFound, errorResponse(err))
+		case errors.Is(err, services.ErrInvalidOIDCState):
+			c.JSON(http.StatusBadRequest, errorResponse(err))
+		case errors.Is(err, services.ErrExternalEmailUnverified):
+			c.JSON(http.StatusForbidden, errorResponse(err))
+		case errors.Is(err, services.ErrUserAlreadyExists):
+			c.JSON(http.StatusConflict, errorResponse(err))
+		case errors.Is(err, services.ErrExternalLoginFailed),
+			errors.Is(err, services.ErrUserDeleted),
+			errors.Is(err, services.ErrAccountDisabled),
+			errors.Is(err, services.ErrUserNotFound):
+			c.JSON(http.StatusUnauthorized, errorResponse(services.ErrUnauthorized))
+		default:
+			h.log.Error("Failed to complete external login", zap.String("provider", provider), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, errorResponse(errors.New("external login failed")))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, loginResponse)
+}
+
+// setOIDCStateCookie stores the state binding for the callback of the same
+// provider, or deletes it when maxAge is negative. The callback is called by
+// the frontend, which must send credentials.
+func setOIDCStateCookie(c *gin.Context, value string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, value, maxAge, path.Dir(c.Request.URL.Path), "", secure, true)
+}
@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+// CreateOrg creates an organization owned by the caller
+func (h *UserHandler) CreateOrg(c *gin.Context) {
+	var payload models.CreateOrgPayload
+	if !h.bindOrgPayload(c, &payload) {
+		return
+	}
+
+	org, err := h.service.CreateOrg(c, payload)
+	if err != nil {
+		h.handleOrgError(c, 0, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"organization": org})
+}
+
+// ListOrgs lists the caller's organizations
+func (h *UserHandler) ListOrgs(c *gin.Context) {
+	orgs, err := h.service.ListOrgs(c)
+	if err != nil {
+		h.handleOrgError(c, 0, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"organizations": orgs})
+}
+
+// GetOrg returns one of the caller's organizations
+func (h *UserHandler) GetOrg(c *gin.Context) {
+	orgID, ok := parseIDParam(c, "orgId", "organization")
+	if !ok {
+		return
+	}
+
+	org, err := h.service.GetOrg(c, orgID)
+	if err != nil {
+		h.handleOrgError(c, orgID, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"organization": org})
+}
+
+// DeleteOrg deletes an organization
+func (h *UserHandler) DeleteOrg(c *gin.Context) {
+	orgID, ok := parseIDParam(c, "orgId", "organization")
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteOrg(c, orgID); err != nil {
+		h.handleOrgError(c, orgID, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Organization deleted"})
+}
+
+// ListOrgMembers lists the members of an organization
+func (h *UserHandler) ListOrgMembers(c *gin.Context) {
+	orgID, ok := parseIDParam(c, "orgId", "organization")
+	if !ok {
+		return
+	}
+
+	members, err := h.service.ListOrgMembers(c, orgID)
+	if err != nil {
+		h.handleOrgError(c, orgID, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"members": members})
+}
+
+// SetOrgMemberRole changes the role of a member
+func (h *UserHandler) SetOrgMemberRole(c *gin.Context) {
+	orgID, ok := parseIDParam(c, "orgId", "organization")
+	if !ok {
+		return
+	}
+	userID, ok := parseIDParam(c, "userId", "user")
+	if !ok {
+		return
+	}
+
+	var payload models.SetOrgMemberRolePayload
+	if !h.bindOrgPayload(c, &payload) {
+		return
+	}
+
+	if err := h.service.SetOrgMemberRole(c, orgID, userID, payload.Role); err != nil {
+		h.handleOrgError(c, orgID, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated"})
+}
+
+// RemoveOrgMember removes a member, or lets the caller leave
+func (h *UserHandler) RemoveOrgMember(c *gin.Context) {
+	orgID, ok := parseIDParam(c, "orgId", "organization")
+	if !ok {
+		return
+	}
+	userID, ok := parseIDParam(c, "userId", "user")
+	if !ok {
+		return
+	}
+
+	if err := h.service.RemoveOrgMember(c, orgID, userID); err != nil {
+		h.handleOrgError(c, orgID, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+}
+
+// InviteOrgMember emails an invitation to join an organization
+func (h *UserHandler) InviteOrgMember(c *gin.Context) {
+	orgID, ok := parseIDParam(c, "orgId", "organization")
+	if !ok {
+		return
+	}
+
+	var payload models.InviteOrgMemberPayload
+	if !h.bindOrgPayload(c, &payload) {
+		return
+	}
+
+	invitation, err := h.service.InviteOrgMember(c, orgID, payload)
+	if err != nil {
+		h.handleOrgError(c, orgID, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"invitation": invitation})
+}
+
+// ListOrgInvitations lists the pending invitations of an organization
+func (h *UserHandler) ListOrgInvitations(c *gin.Context) {
+	orgID, ok := parseIDParam(c, "orgId", "organization")
+	if !ok {
+		return
+	}
+
+	invitations, err := h.service.ListOrgInvitations(c, orgID)
+	if err != nil {
+		h.handleOrgError(c, orgID, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invitations": invitations})
+}
+
+// RevokeOrgInvitation withdraws a pending invitation
+func (h *UserHandler) RevokeOrgInvitation(c *gin.Context) {
+	orgID, ok := parseIDParam(c, "orgId", "organization")
+	if !ok {
+		return
+	}
+	invitationID, ok := parseIDParam(c, "invitationId", "invitation")
+	if !ok {
+		return
+	}
+
+	if err := h.service.RevokeOrgInvitation(c, orgID, invitationID); err != nil {
+		h.handleOrgError(c, orgID, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation revoked"})
+}
+
+// AcceptOrgInvitation joins the organization of an emailed invitation
+func (h *UserHandler) AcceptOrgInvitation(c *gin.Context) {
+	var payload models.OrgInvitationTokenPayload
+	if !h.bindOrgPayload(c, &payload) {
+		return
+	}
+
+	org, err := h.service.AcceptOrgInvitation(c, payload.Token)
+	if err != nil {
+		h.handleOrgError(c, 0, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"organization": org})
+}
+
+// DeclineOrgInvitation turns down an emailed invitation
+func (h *UserHandler) DeclineOrgInvitation(c *gin.Context) {
+	var payload models.OrgInvitationTokenPayload
+	if !h.bindOrgPayload(c, &payload) {
+		return
+	}
+
+	if err := h.service.DeclineOrgInvitation(c, payload.Token); err != nil {
+		h.handleOrgError(c, 0, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invitation declined"})
+}
+
+// SwitchOrg selects the organization the current session acts for and
+// returns an access token with the matching org_id claim
+func (h *UserHandler) SwitchOrg(c *gin.Context) {
+	var payload models.ActiveOrgPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+
+	token, err := h.service.SwitchOrg(c, payload.OrgID)
+	if err != nil {
+		orgID := 0
+		if payload.OrgID != nil {
+			orgID = *payload.OrgID
+		}
+		h.handleOrgError(c, orgID, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token})
+}
+
+func (h *UserHandler) bindOrgPayload(c *gin.Context, payload interface{}) bool {
+	if err := c.ShouldBindJSON(payload); err != nil {
+		h.log.Error("Invalid request body", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("invalid request body: %w", err)))
+		return false
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		h.log.Error("Validation failed", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("validation failed: %w", err)))
+		return false
+	}
+	return true
+}
+
+// parseIDParam reads a numeric path parameter, answering 400 if it is not one
+func parseIDParam(c *gin.Context, param, name string) (int, bool) {
+	id, err := strconv.Atoi(c.Param(param))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("invalid %s ID format: %w", name, err)))
+		return 0, false
+	}
+	return id, true
+}
+
+func (h *UserHandler) handleOrgError(c *gin.Context, orgID int, err error) {
+	switch {
+	case errors.Is(err, services.ErrUnauthorized):
+		c.JSON(http.StatusForbidden, errorResponse(err))
+	case errors.Is(err, services.ErrOrgNotFound),
+		errors.Is(err, services.ErrOrgMemberNotFound),
+		errors.Is(err, services.ErrInvitationNotFound),
+		errors.Is(err, services.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, errorResponse(err))
+	case errors.Is(err, services.ErrAlreadyOrgMember), errors.Is(err, services.ErrLastOrgOwner):
+		c.JSON(http.StatusConflict, errorResponse(err))
+	case errors.Is(err, services.ErrInvalidInvitation):
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+	case errors.Is(err, services.ErrInvitationEmailMismatch):
+		c.JSON(http.StatusForbidden, errorResponse(err))
+	default:
+		h.log.Error("Organization request failed", zap.Int("orgID", orgID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, errorResponse(errors.New("organization request failed")))
+	}
+}
@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+)
+
+const mergePatchContentType = "application/merge-patch+json"
+
+var (
+	errPreconditionFailed   = errors.New("If-Match does not name a version of this user")
+	errPreconditionRequired = errors.New("If-Match is required: send the ETag of the version being changed, or * to overwrite any version")
+)
+
+// PatchUser applies a JSON merge patch (RFC 7396) to a user
+func (h *UserHandler) PatchUser(c *gin.Context) {
+	id, err := h.parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(c.ContentType()); mediaType != mergePatchContentType {
+		c.Header("Accept-Patch", mergePatchContentType)
+		c.JSON(http.StatusUnsupportedMediaType, errorResponse(fmt.Errorf("content type must be %s", mergePatchContentType)))
+		return
+	}
+
+	version, err := parseIfMatch(c, !h.allowUnconditional)
+	if err != nil {
+		c.JSON(preconditionStatus(err), errorResponse(err))
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+
+	req, err := parseUserMergePatch(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	h.updateUser(c, id, req, version)
+}
+
+// parseUserMergePatch turns a merge patch into an update. Members left out
+// keep their value. A null member would remove it, which no patchable field
+// of a user allows, and members that cannot be patched are refused rather
+// than silently ignored.
+func parseUserMergePatch(body []byte) (models.UpdateUserPayload, error) {
+	var req models.UpdateUserPayload
+
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(body, &patch); err != nil || patch == nil {
+		return req, errors.New("merge patch must be a JSON object")
+	}
+
+	for field, raw := range patch {
+		var target **string
+		switch field {
+		case "name":
+			target = &req.Name
+		case "email":
+			target = &req.Email
+		default:
+			return req, fmt.Errorf("field %q cannot be patched", field)
+		}
+
+		if string(raw) == "null" {
+			return req, fmt.Errorf("field %q cannot be removed", field)
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return req, fmt.Errorf("field %q must be a string", field)
+		}
+		*target = &value
+	}
+
+	return req, nil
+}
+
+// parseIfMatch returns the user version a request is conditional on, or 0
+// when it is unconditional. Unless required is false, a missing If-Match is
+// refused so that clients cannot overwrite changes they have not seen; "*"
+// opts out explicitly and matches any version. Weak tags never match, as
+// If-Match compares strongly.
+func parseIfMatch(c *gin.Context, required bool) (int, error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		if required {
+			return 0, errPreconditionRequired
+		}
+		return 0, nil
+	}
+	if header == "*" {
+		return 0, nil
+	}
+
+	var versions []int
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		version, err := strconv.Atoi(strings.Trim(tag, `"`))
+		if err != nil || version <= 0 {
+			continue
+		}
+		versions = append(versions, version)
+	}
+
+	// Versions only grow, so at most one tag can be current
+	if len(versions) != 1 {
+		return 0, errPreconditionFailed
+	}
+	return versions[0], nil
+}
+
+// preconditionStatus maps an error of parseIfMatch to its response status.
+func preconditionStatus(err error) int {
+	if errors.Is(err, errPreconditionRequired) {
+		return http.StatusPreconditionRequired
+	}
+	return http.StatusPreconditionFailed
+}
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"go.uber.org/zap"
+)
+
+func TestParseIfMatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name     string
+		header   string
+		required bool
+		want     int
+		wantErr  error
+	}{
+		{name: "missing", required: true, wantErr: errPreconditionRequired},
+		{name: "missing when optional", required: false, want: 0},
+		{name: "blank", header: "  ", required: true, wantErr: errPreconditionRequired},
+		{name: "any version", header: "*", required: true, want: 0},
+		{name: "strong tag", header: `"3"`, required: true, want: 3},
+		{name: "unquoted tag", header: "3", required: true, want: 3},
+		{name: "weak tag", header: `W/"3"`, required: true, wantErr: errPreconditionFailed},
+		{name: "weak and strong tag", header: `W/"2", "3"`, required: true, want: 3},
+		{name: "several strong tags", header: `"2", "3"`, required: true, wantErr: errPreconditionFailed},
+		{name: "not a version", header: `"abc"`, required: true, wantErr: errPreconditionFailed},
+		{name: "zero", header: `"0"`, required: true, wantErr: errPreconditionFailed},
+		{name: "negative", header: `"-1"`, required: true, wantErr: errPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPatch, "/users/1", nil)
+			if tt.header != "" {
+				c.Request.Header.Set("If-Match", tt.header)
+			}
+
+			got, err := parseIfMatch(c, tt.required)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("parseIfMatch error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseIfMatch = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreconditionStatus(t *testing.T) {
+	if got := preconditionStatus(errPreconditionRequired); got != http.StatusPreconditionRequired {
+		t.Errorf("missing If-Match answers %d, want 428", got)
+	}
+	if got := preconditionStatus(errPreconditionFailed); got != http.StatusPreconditionFailed {
+		t.Errorf("stale If-Match answers %d, want 412", got)
+	}
+}
+
+func TestPatchUserRequiresIfMatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.PATCH("/users/:id", NewUserHandler(nil, middleware.TokenService{}, nil, zap.NewNop()).PatchUser)
+
+	req := httptest.NewRequest(http.MethodPatch, "/users/1", strings.NewReader(`{"name": "Ada"}`))
+	req.Header.Set("Content-Type", mergePatchContentType)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPreconditionRequired {
+		t.Errorf("PATCH without If-Match = %d, want 428", w.Code)
+	}
+}
+
+func TestParseUserMergePatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantName  *string
+		wantEmail *string
+		wantErr   bool
+	}{
+		{name: "empty patch", body: `{}`},
+		{name: "name", body: `{"name": "Ada"}`, wantName: strPtr("Ada")},
+		{name: "name and email", body: `{"name": "Ada", "email": "ada@example.com"}`, wantName: strPtr("Ada"), wantEmail: strPtr("ada@example.com")},
+		{name: "empty string is a value", body: `{"name": ""}`, wantName: strPtr("")},
+		// A null member deletes it, and no patchable field can be deleted
+		{name: "null deletes", body: `{"name": null}`, wantErr: true},
+		{name: "unknown field", body: `{"role": "admin"}`, wantErr: true},
+		{name: "unknown field beside a known one", body: `{"name": "Ada", "password": "x"}`, wantErr: true},
+		{name: "wrong type", body: `{"name": 42}`, wantErr: true},
+		{name: "nested object", body: `{"name": {"first": "Ada"}}`, wantErr: true},
+		{name: "array body", body: `[{"name": "Ada"}]`, wantErr: true},
+		{name: "string body", body: `"Ada"`, wantErr: true},
+		{name: "null body", body: `null`, wantErr: true},
+		{name: "empty body", body: ``, wantErr: true},
+		{name: "malformed", body: `{"name": "Ada"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := parseUserMergePatch([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseUserMergePatch error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !equalStrPtr(req.Name, tt.wantName) || !equalStrPtr(req.Email, tt.wantEmail) {
+				t.Errorf("parseUserMergePatch = name %v, email %v", deref(req.Name), deref(req.Email))
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func equalStrPtr(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func deref(s *string) interface{} {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
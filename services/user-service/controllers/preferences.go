@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+// GetPreferences returns a user's preferences
+func (h *UserHandler) GetPreferences(c *gin.Context) {
+	id, err := h.parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	prefs, err := h.service.GetPreferences(c, id)
+	if err != nil {
+		h.handlePreferencesError(c, id, err)
+		return
+	}
+
+	respondPreferences(c, prefs)
+}
+
+// UpdatePreferences replaces a user's preferences. Settings left out take
+// their default. Honours If-Match like PUT /users/:id.
+func (h *UserHandler) UpdatePreferences(c *gin.Context) {
+	id, err := h.parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	version, err := parseIfMatch(c, !h.allowUnconditional)
+	if err != nil {
+		c.JSON(preconditionStatus(err), errorResponse(err))
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+
+	prefs, err := parsePreferences(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(prefs); err != nil {
+		h.log.Error("Validation failed", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("validation failed: %w", err)))
+		return
+	}
+
+	saved, err := h.service.UpdatePreferences(c, id, prefs, version)
+	if err != nil {
+		h.handlePreferencesError(c, id, err)
+		return
+	}
+
+	respondPreferences(c, saved)
+}
+
+// ReadPreferences serves a user's preferences to other services
+func (h *UserHandler) ReadPreferences(c *gin.Context) {
+	id, err := h.parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	prefs, err := h.service.ReadPreferences(c, id)
+	if err != nil {
+		h.handlePreferencesError(c, id, err)
+		return
+	}
+
+	respondPreferences(c, prefs)
+}
+
+// parsePreferences decodes a full set of preferences over the defaults. The
+// version and updatedAt of a GET response may be sent back and are ignored;
+// any other unknown member is refused, so typos do not pass as defaults.
+func parsePreferences(body []byte) (models.Preferences, error) {
+	prefs := models.UserPreferences{Preferences: models.DefaultPreferences()}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&prefs); err != nil {
+		return models.Preferences{}, fmt.Errorf("invalid request body: %w", err)
+	}
+	if decoder.More() {
+		return models.Preferences{}, errors.New("invalid request body: trailing data")
+	}
+
+	return prefs.Preferences, nil
+}
+
+func respondPreferences(c *gin.Context, prefs *models.UserPreferences) {
+	// The defaults have no version a request could be conditional on
+	if prefs.Version > 0 {
+		c.Header("ETag", prefs.ETag())
+	}
+	c.JSON(http.StatusOK, gin.H{"preferences": prefs})
+}
+
+func (h *UserHandler) handlePreferencesError(c *gin.Context, id int, err error) {
+	switch {
+	case errors.Is(err, services.ErrUnauthorized):
+		c.JSON(http.StatusForbidden, errorResponse(err))
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, errorResponse(err))
+	case errors.Is(err, services.ErrVersionConflict):
+		c.JSON(http.StatusPreconditionFailed, errorResponse(err))
+	default:
+		h.log.Error("Preferences request failed", zap.Int("userID", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, errorResponse(errors.New("preferences request failed")))
+	}
+}
@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+// ExportUserData downloads a user's data export once it is ready. Until then
+// it answers 202 with the status of the export being built.
+func (h *UserHandler) ExportUserData(c *gin.Context) {
+	id, err := h.parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	export, err := h.service.RequestDataExport(c, id)
+	if err != nil {
+		h.handlePrivacyError(c, id, err)
+		return
+	}
+
+	if export.Status != models.DataExportReady {
+		c.JSON(http.StatusAccepted, gin.H{"export": export})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-export.zip"`, id))
+	c.Data(http.StatusOK, "application/zip", export.Archive)
+}
+
+// EraseUser anonymizes a user and erases their data everywhere
+func (h *UserHandler) EraseUser(c *gin.Context) {
+	id, err := h.parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	if err := h.service.EraseUser(c, id); err != nil {
+		h.handlePrivacyError(c, id, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User data erased"})
+}
+
+func (h *UserHandler) handlePrivacyError(c *gin.Context, id int, err error) {
+	switch {
+	case errors.Is(err, services.ErrUnauthorized):
+		c.JSON(http.StatusForbidden, errorResponse(err))
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, errorResponse(err))
+	default:
+		h.log.Error("Personal data request failed", zap.Int("userID", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, errorResponse(errors.New("personal data request failed")))
+	}
+}
@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+// ListRoles returns the available roles and their permissions
+func (h *UserHandler) ListRoles(c *gin.Context) {
+	roles, err := h.service.ListRoles(c)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnauthorized):
+			c.JSON(http.StatusForbidden, errorResponse(err))
+		default:
+			h.log.Error("Failed to list roles", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, errorResponse(errors.New("failed to list roles")))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// AssignRole changes the role of a user
+func (h *UserHandler) AssignRole(c *gin.Context) {
+	id, err := h.parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	var payload models.AssignRolePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		h.log.Error("Invalid request body", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		h.log.Error("Validation failed", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("validation failed: %w", err)))
+		return
+	}
+
+	if err := h.service.AssignRole(c, id, payload.Role); err != nil {
+		switch {
+		case errors.Is(err, services.ErrRoleNotFound):
+			c.JSON(http.StatusBadRequest, errorResponse(err))
+		case errors.Is(err, services.ErrUserNotFound):
+			c.JSON(http.StatusNotFound, errorResponse(err))
+		case errors.Is(err, services.ErrOwnRole):
+			c.JSON(http.StatusConflict, errorResponse(err))
+		case errors.Is(err, services.ErrUnauthorized):
+			c.JSON(http.StatusForbidden, errorResponse(err))
+		default:
+			h.log.Error("Failed to assign role",
+				zap.Int("userID", id),
+				zap.Error(err))
+			c.JSON(http.StatusInternalServerError, errorResponse(errors.New("failed to assign role")))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role assigned successfully"})
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
@@ -18,12 +20,36 @@ import (
 type UserHandler struct {
 	service models.UserService
 	ts      middleware.TokenService
-	log     *zap.Logger
+	cursors *utils.CursorCodec
+	// allowUnconditional lets updates omit If-Match
+	allowUnconditional bool
+	log                *zap.Logger
 }
 
+type HandlerOption func(*UserHandler)
+
+// WithUnconditionalUpdates lets PUT and PATCH /users/:id and PUT
+// /users/:id/preferences omit If-Match, for clients that do not send it yet.
+// Such updates overwrite whatever changed since the client read the user.
+func WithUnconditionalUpdates() HandlerOption {
+	return func(h *UserHandler) {
+		h.allowUnconditional = true
+	}
+}
+
+// Page sizes of list endpoints
+const (
+	defaultPageLimit = 10
+	maxPageLimit     = 1000
+)
+
 // TODO: add time limit context to all service calls
-func NewUserHandler(service models.UserService, tokenService middleware.TokenService, log *zap.Logger) *UserHandler {
-	return &UserHandler{service: service, ts: tokenService, log: log}
+func NewUserHandler(service models.UserService, tokenService middleware.TokenService, cursors *utils.CursorCodec, log *zap.Logger, opts ...HandlerOption) *UserHandler {
+	h := &UserHandler{service: service, ts: tokenService, cursors: cursors, log: log}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
 func (h *UserHandler) RegisterRoutes(r *gin.RouterGroup) {
@@ -33,6 +59,48 @@ func (h *UserHandler) RegisterRoutes(r *gin.RouterGroup) {
 	r.OPTIONS("/users/login", middleware.CorsMiddleware())
 	r.POST("/users/login", middleware.CorsMiddleware(), h.LoginUser)
 
+	r.OPTIONS("/users/login/mfa", middleware.CorsMiddleware())
+	r.POST("/users/login/mfa", middleware.CorsMiddleware(), h.LoginMFA)
+
+	r.OPTIONS("/auth/providers", middleware.CorsMiddleware())
+	r.GET("/auth/providers", middleware.CorsMiddleware(), h.ListIdentityProviders)
+
+	r.OPTIONS("/auth/:provider/authorize", middleware.CorsMiddleware())
+	r.POST("/auth/:provider/authorize", middleware.CorsMiddleware(), h.AuthorizeExternal)
+
+	r.OPTIONS("/auth/:provider/callback", middleware.CorsMiddleware())
+	r.POST("/auth/:provider/callback", middleware.CorsMiddleware(), h.LoginExternal)
+
+	r.OPTIONS("/users/token/refresh", middleware.CorsMiddleware())
+	r.POST("/users/token/refresh", middleware.CorsMiddleware(), h.RefreshToken)
+
+	r.OPTIONS("/users/password/forgot", middleware.CorsMiddleware())
+	r.POST("/users/password/forgot", middleware.CorsMiddleware(), h.ForgotPassword)
+
+	r.OPTIONS("/users/password/reset", middleware.CorsMiddleware())
+	r.POST("/users/password/reset", middleware.CorsMiddleware(), h.ResetPassword)
+
+	r.OPTIONS("/users/verify", middleware.CorsMiddleware())
+	r.GET("/users/verify", middleware.CorsMiddleware(), h.VerifyEmail)
+
+	r.OPTIONS("/users/verify/resend", middleware.CorsMiddleware())
+	r.POST("/users/verify/resend",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.ResendVerification)
+
+	r.OPTIONS("/users/logout", middleware.CorsMiddleware())
+	r.POST("/users/logout",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.Logout)
+
+	r.OPTIONS("/users/logout-all", middleware.CorsMiddleware())
+	r.POST("/users/logout-all",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.LogoutAll)
+
 	r.OPTIONS("/users/:id", middleware.CorsMiddleware())
 	r.GET("/users/:id",
 		middleware.CorsMiddleware(),
@@ -42,15 +110,256 @@ func (h *UserHandler) RegisterRoutes(r *gin.RouterGroup) {
 		middleware.CorsMiddleware(),
 		middleware.AuthMiddleware(h.ts),
 		h.UpdateUser)
+	r.PATCH("/users/:id",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.PatchUser)
 	r.DELETE("/users/:id",
 		middleware.CorsMiddleware(),
 		middleware.AuthMiddleware(h.ts),
+		middleware.RequireVerifiedEmail(),
 		h.DeleteUser)
 
+	r.OPTIONS("/users/:id/avatar", middleware.CorsMiddleware())
+	r.PUT("/users/:id/avatar",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.UploadAvatar)
+	r.DELETE("/users/:id/avatar",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.DeleteAvatar)
+
+	r.OPTIONS("/users/:id/preferences", middleware.CorsMiddleware())
+	r.GET("/users/:id/preferences",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.GetPreferences)
+	r.PUT("/users/:id/preferences",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.UpdatePreferences)
+
+	// For other services, with a client token granting preferences:read
+	r.GET("/internal/users/:id/preferences",
+		middleware.AuthMiddleware(h.ts),
+		middleware.RequirePermission(middleware.PermPreferencesRead),
+		h.ReadPreferences)
+
+	// Avatar URLs are public so that they work in <img> tags
+	r.GET("/avatars/:user/:file", h.GetAvatar)
+
+	r.OPTIONS("/users/:id/restore", middleware.CorsMiddleware())
+	r.POST("/users/:id/restore",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		middleware.RequirePermission(middleware.PermUsersDelete),
+		h.RestoreUser)
+
+	r.OPTIONS("/users/:id/export", middleware.CorsMiddleware())
+	r.GET("/users/:id/export",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.ExportUserData)
+
+	r.OPTIONS("/users/:id/erase", middleware.CorsMiddleware())
+	r.POST("/users/:id/erase",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		middleware.RequireVerifiedEmail(),
+		h.EraseUser)
+
+	// Not gated on a verified email, so that an unverified account or one
+	// with a pending email change can still rotate a compromised password
+	r.OPTIONS("/users/:id/password", middleware.CorsMiddleware())
+	r.PUT("/users/:id/password",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.ChangePassword)
+
+	r.OPTIONS("/users/:id/mfa/enroll", middleware.CorsMiddleware())
+	r.POST("/users/:id/mfa/enroll",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		middleware.RequireVerifiedEmail(),
+		h.EnrollMFA)
+
+	r.OPTIONS("/users/:id/mfa/activate", middleware.CorsMiddleware())
+	r.POST("/users/:id/mfa/activate",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		middleware.RequireVerifiedEmail(),
+		h.ActivateMFA)
+
+	r.OPTIONS("/users/:id/mfa", middleware.CorsMiddleware())
+	r.DELETE("/users/:id/mfa",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.DisableMFA)
+
+	r.OPTIONS("/users/:id/unlock", middleware.CorsMiddleware())
+	r.POST("/users/:id/unlock",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		middleware.RequirePermission(middleware.PermUsersWrite),
+		h.UnlockUser)
+
+	r.OPTIONS("/users/:id/sessions", middleware.CorsMiddleware())
+	r.GET("/users/:id/sessions",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.ListSessions)
+
+	r.OPTIONS("/users/:id/sessions/:sid", middleware.CorsMiddleware())
+	r.DELETE("/users/:id/sessions/:sid",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.RevokeSession)
+
+	r.OPTIONS("/users/:id/api-keys", middleware.CorsMiddleware())
+	r.POST("/users/:id/api-keys",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		middleware.RequireVerifiedEmail(),
+		h.CreateAPIKey)
+	r.GET("/users/:id/api-keys",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.ListAPIKeys)
+
+	r.OPTIONS("/users/:id/api-keys/:keyId", middleware.CorsMiddleware())
+	r.DELETE("/users/:id/api-keys/:keyId",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.RevokeAPIKey)
+
+	r.OPTIONS("/users/:id/role", middleware.CorsMiddleware())
+	r.PUT("/users/:id/role",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		middleware.RequireVerifiedEmail(),
+		middleware.RequirePermission(middleware.PermRolesManage),
+		h.AssignRole)
+
+	r.OPTIONS("/roles", middleware.CorsMiddleware())
+	r.GET("/roles",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		middleware.RequirePermission(middleware.PermRolesManage),
+		h.ListRoles)
+
+	r.OPTIONS("/audit-log", middleware.CorsMiddleware())
+	r.GET("/audit-log",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		middleware.RequirePermission(middleware.PermAuditRead),
+		h.ListAuditEntries)
+
+	r.OPTIONS("/orgs", middleware.CorsMiddleware())
+	r.POST("/orgs",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		middleware.RequireVerifiedEmail(),
+		h.CreateOrg)
+	r.GET("/orgs",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.ListOrgs)
+
+	r.OPTIONS("/orgs/:orgId", middleware.CorsMiddleware())
+	r.GET("/orgs/:orgId",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.GetOrg)
+	r.DELETE("/orgs/:orgId",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		middleware.RequireVerifiedEmail(),
+		h.DeleteOrg)
+
+	r.OPTIONS("/orgs/:orgId/members", middleware.CorsMiddleware())
+	r.GET("/orgs/:orgId/members",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.ListOrgMembers)
+
+	r.OPTIONS("/orgs/:orgId/members/:userId", middleware.CorsMiddleware())
+	r.DELETE("/orgs/:orgId/members/:userId",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.RemoveOrgMember)
+
+	r.OPTIONS("/orgs/:orgId/members/:userId/role", middleware.CorsMiddleware())
+	r.PUT("/orgs/:orgId/members/:userId/role",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		middleware.RequireVerifiedEmail(),
+		h.SetOrgMemberRole)
+
+	r.OPTIONS("/orgs/:orgId/invitations", middleware.CorsMiddleware())
+	r.POST("/orgs/:orgId/invitations",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		middleware.RequireVerifiedEmail(),
+		h.InviteOrgMember)
+	r.GET("/orgs/:orgId/invitations",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.ListOrgInvitations)
+
+	r.OPTIONS("/orgs/:orgId/invitations/:invitationId", middleware.CorsMiddleware())
+	r.DELETE("/orgs/:orgId/invitations/:invitationId",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.RevokeOrgInvitation)
+
+	r.OPTIONS("/invitations/accept", middleware.CorsMiddleware())
+	r.POST("/invitations/accept",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		middleware.RequireVerifiedEmail(),
+		h.AcceptOrgInvitation)
+
+	// The emailed token is all it takes to decline
+	r.OPTIONS("/invitations/decline", middleware.CorsMiddleware())
+	r.POST("/invitations/decline", middleware.CorsMiddleware(), h.DeclineOrgInvitation)
+
+	r.OPTIONS("/session/org", middleware.CorsMiddleware())
+	r.PUT("/session/org",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		h.SwitchOrg)
+
+	r.OPTIONS("/admin/users/import", middleware.CorsMiddleware())
+	r.POST("/admin/users/import",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		middleware.RequireVerifiedEmail(),
+		middleware.RequirePermission(middleware.PermUsersWrite),
+		h.ImportUsers)
+
+	r.OPTIONS("/admin/users/export", middleware.CorsMiddleware())
+	r.GET("/admin/users/export",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		middleware.RequireVerifiedEmail(),
+		middleware.RequirePermission(middleware.PermUsersRead),
+		h.ExportUsers)
+
+	// Each action checks its own permission
+	r.OPTIONS("/admin/users/bulk", middleware.CorsMiddleware())
+	r.POST("/admin/users/bulk",
+		middleware.CorsMiddleware(),
+		middleware.AuthMiddleware(h.ts),
+		middleware.RequireVerifiedEmail(),
+		h.BulkUpdateUsers)
+
 	r.OPTIONS("/users", middleware.CorsMiddleware())
 	r.GET("/users",
 		middleware.CorsMiddleware(),
 		middleware.AuthMiddleware(h.ts),
+		middleware.RequireVerifiedEmail(),
+		middleware.RequirePermission(middleware.PermUsersRead),
 		h.GetUsers)
 }
 
@@ -73,8 +382,15 @@ func (h *UserHandler) RegisterUser(c *gin.Context) {
 	// Call the service layer to handle registration logic
 	user, err := h.service.RegisterUser(c, payload)
 	if err != nil {
-		h.log.Error("Failed to register user", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, errorResponse(errors.New("Failed to register user")))
+		switch {
+		case errors.Is(err, services.ErrWeakPassword):
+			c.JSON(http.StatusBadRequest, errorResponse(err))
+		case errors.Is(err, services.ErrUserAlreadyExists):
+			c.JSON(http.StatusConflict, errorResponse(err))
+		default:
+			h.log.Error("Failed to register user", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, errorResponse(errors.New("Failed to register user")))
+		}
 		return
 	}
 
@@ -98,16 +414,181 @@ func (h *UserHandler) LoginUser(c *gin.Context) {
 	}
 
 	// Call the service layer to handle login logic
+	payload.IP = c.ClientIP()
 	loginResponse, err := h.service.LoginUser(c, payload)
 	if err != nil {
-		h.log.Error("Failed to login user", zap.Error(err))
-		c.JSON(http.StatusUnauthorized, errorResponse(services.ErrUnauthorized))
+		h.log.Error("Failed to login user", zap.String("ip", payload.IP), zap.Error(err))
+		h.respondLoginError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, loginResponse)
 }
 
+// RefreshToken rotates a refresh token and issues a new token pair
+func (h *UserHandler) RefreshToken(c *gin.Context) {
+	var payload models.RefreshTokenPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		h.log.Error("Invalid request body", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		h.log.Error("Validation failed", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("validation failed: %w", err)))
+		return
+	}
+
+	refreshResponse, err := h.service.RefreshToken(c, payload)
+	if err != nil {
+		h.log.Warn("Failed to refresh token", zap.Error(err))
+		c.JSON(http.StatusUnauthorized, errorResponse(services.ErrInvalidRefreshToken))
+		return
+	}
+
+	c.JSON(http.StatusOK, refreshResponse)
+}
+
+// ForgotPassword starts the password reset flow
+func (h *UserHandler) ForgotPassword(c *gin.Context) {
+	var payload models.ForgotPasswordPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		h.log.Error("Invalid request body", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		h.log.Error("Validation failed", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("validation failed: %w", err)))
+		return
+	}
+
+	if err := h.service.ForgotPassword(c, payload); err != nil {
+		h.log.Error("Failed to start password reset", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, errorResponse(errors.New("failed to start password reset")))
+		return
+	}
+
+	// Same response whether or not the account exists
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists, a reset link has been sent"})
+}
+
+// ResetPassword completes the password reset flow
+func (h *UserHandler) ResetPassword(c *gin.Context) {
+	var payload models.ResetPasswordPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		h.log.Error("Invalid request body", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		h.log.Error("Validation failed", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("validation failed: %w", err)))
+		return
+	}
+
+	if err := h.service.ResetPassword(c, payload); err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidResetToken),
+			errors.Is(err, services.ErrWeakPassword),
+			errors.Is(err, services.ErrPasswordReused):
+			c.JSON(http.StatusBadRequest, errorResponse(err))
+		default:
+			h.log.Error("Failed to reset password", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, errorResponse(errors.New("failed to reset password")))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
+
+// VerifyEmail confirms an email address from a verification link
+func (h *UserHandler) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, errorResponse(errors.New("token is required")))
+		return
+	}
+
+	user, err := h.service.VerifyEmail(c, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidVerificationToken):
+			c.JSON(http.StatusBadRequest, errorResponse(err))
+		case errors.Is(err, services.ErrDuplicateEmail):
+			c.JSON(http.StatusConflict, errorResponse(err))
+		default:
+			h.log.Error("Failed to verify email", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, errorResponse(errors.New("failed to verify email")))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully", "user": user})
+}
+
+// ResendVerification sends a new verification email to the caller
+func (h *UserHandler) ResendVerification(c *gin.Context) {
+	if err := h.service.ResendVerification(c); err != nil {
+		if errors.Is(err, services.ErrUnauthorized) {
+			c.JSON(http.StatusUnauthorized, errorResponse(err))
+			return
+		}
+		h.log.Error("Failed to resend verification email", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, errorResponse(errors.New("failed to resend verification email")))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent"})
+}
+
+// Logout revokes the caller's current token
+func (h *UserHandler) Logout(c *gin.Context) {
+	var payload models.LogoutPayload
+	// The body is optional; a missing refresh token only skips family revocation
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&payload); err != nil {
+			h.log.Error("Invalid request body", zap.Error(err))
+			c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("invalid request body: %w", err)))
+			return
+		}
+	}
+
+	if err := h.service.Logout(c, payload); err != nil {
+		if errors.Is(err, services.ErrUnauthorized) {
+			c.JSON(http.StatusUnauthorized, errorResponse(err))
+			return
+		}
+		h.log.Error("Failed to logout user", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, errorResponse(errors.New("logout failed")))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// LogoutAll revokes every token of the caller on all devices
+func (h *UserHandler) LogoutAll(c *gin.Context) {
+	if err := h.service.LogoutAll(c); err != nil {
+		if errors.Is(err, services.ErrUnauthorized) {
+			c.JSON(http.StatusUnauthorized, errorResponse(err))
+			return
+		}
+		h.log.Error("Failed to logout user from all devices", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, errorResponse(errors.New("logout failed")))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out from all devices"})
+}
+
 // GetUserByID retrieves user details by ID
 func (h *UserHandler) GetUserByID(c *gin.Context) {
 	id, err := h.parseUserID(c)
@@ -133,6 +614,7 @@ func (h *UserHandler) GetUserByID(c *gin.Context) {
 		return
 	}
 
+	c.Header("ETag", user.ETag())
 	c.JSON(http.StatusOK, gin.H{"user": user})
 }
 
@@ -144,6 +626,12 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	version, err := parseIfMatch(c, !h.allowUnconditional)
+	if err != nil {
+		c.JSON(preconditionStatus(err), errorResponse(err))
+		return
+	}
+
 	var req models.UpdateUserPayload
 	if err := c.ShouldBindJSON(&req); err != nil {
 		h.log.Error("Invalid request body", zap.Error(err))
@@ -151,6 +639,11 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	h.updateUser(c, id, req, version)
+}
+
+// updateUser validates and applies an update from PUT or PATCH /users/:id
+func (h *UserHandler) updateUser(c *gin.Context, id int, req models.UpdateUserPayload, version int) {
 	// Validate request using validator
 	validate := validator.New()
 	if err := validate.Struct(req); err != nil {
@@ -167,12 +660,9 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 	if req.Name != nil {
 		updates["name"] = *req.Name
 	}
-	if req.Avatar != nil {
-		updates["avatar"] = *req.Avatar
-	}
 
 	// Call the service layer to update user details
-	updatedUser, err := h.service.UpdateUser(c, id, updates)
+	updatedUser, err := h.service.UpdateUser(c, id, updates, version)
 	if err != nil {
 		switch {
 		case errors.Is(err, services.ErrUserNotFound):
@@ -181,6 +671,8 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 			c.JSON(http.StatusUnauthorized, errorResponse(err))
 		case errors.Is(err, services.ErrDuplicateEmail):
 			c.JSON(http.StatusConflict, errorResponse(err))
+		case errors.Is(err, services.ErrVersionConflict):
+			c.JSON(http.StatusPreconditionFailed, errorResponse(err))
 		default:
 			h.log.Error("Failed to update user",
 				zap.Int("userID", id),
@@ -190,9 +682,53 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	c.Header("ETag", updatedUser.ETag())
 	c.JSON(http.StatusOK, gin.H{"user": updatedUser})
 }
 
+// ChangePassword handles changing the caller's password
+func (h *UserHandler) ChangePassword(c *gin.Context) {
+	id, err := h.parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	var payload models.ChangePasswordPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		h.log.Error("Invalid request body", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("invalid request body: %w", err)))
+		return
+	}
+
+	validate := validator.New()
+	if err := validate.Struct(payload); err != nil {
+		h.log.Error("Validation failed", zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("validation failed: %w", err)))
+		return
+	}
+
+	if err := h.service.ChangePassword(c, id, payload); err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnauthorized):
+			c.JSON(http.StatusForbidden, errorResponse(err))
+		case errors.Is(err, services.ErrInvalidCredentials):
+			c.JSON(http.StatusUnauthorized, errorResponse(err))
+		case errors.Is(err, services.ErrWeakPassword),
+			errors.Is(err, services.ErrPasswordReused):
+			c.JSON(http.StatusBadRequest, errorResponse(err))
+		default:
+			h.log.Error("Failed to change password",
+				zap.Int("userID", id),
+				zap.Error(err))
+			c.JSON(http.StatusInternalServerError, errorResponse(errors.New("failed to change password")))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed, please sign in again"})
+}
+
 // DeleteUser handles deleting a user
 func (h *UserHandler) DeleteUser(c *gin.Context) {
 	id, err := h.parseUserID(c)
@@ -220,51 +756,152 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
 }
 
-// GetUsers retrieves a list of users with pagination
+// RestoreUser undoes the deletion of a user (admin only)
+func (h *UserHandler) RestoreUser(c *gin.Context) {
+	id, err := h.parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	if err := h.service.RestoreUser(c, id); err != nil {
+		switch {
+		case errors.Is(err, services.ErrUserNotFound):
+			c.JSON(http.StatusNotFound, errorResponse(err))
+		case errors.Is(err, services.ErrUnauthorized):
+			c.JSON(http.StatusForbidden, errorResponse(err))
+		case errors.Is(err, services.ErrDuplicateEmail):
+			// The email was registered again after the deletion
+			c.JSON(http.StatusConflict, errorResponse(err))
+		default:
+			h.log.Error("Failed to restore user",
+				zap.Int("userID", id),
+				zap.Error(err))
+			c.JSON(http.StatusInternalServerError, errorResponse(errors.New("failed to restore user")))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User restored successfully"})
+}
+
+// UnlockUser lifts a login lockout (admin only)
+func (h *UserHandler) UnlockUser(c *gin.Context) {
+	id, err := h.parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	if err := h.service.UnlockUser(c, id); err != nil {
+		switch {
+		case errors.Is(err, services.ErrUserNotFound):
+			c.JSON(http.StatusNotFound, errorResponse(err))
+		case errors.Is(err, services.ErrUnauthorized):
+			c.JSON(http.StatusForbidden, errorResponse(err))
+		default:
+			h.log.Error("Failed to unlock user",
+				zap.Int("userID", id),
+				zap.Error(err))
+			c.JSON(http.StatusInternalServerError, errorResponse(errors.New("failed to unlock user")))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked successfully"})
+}
+
+// respondLoginError hides why a login failed, apart from IP throttling,
+// so that locked and unknown accounts look the same as a wrong password.
+func (h *UserHandler) respondLoginError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrTooManyAttempts) {
+		c.JSON(http.StatusTooManyRequests, errorResponse(err))
+		return
+	}
+	c.JSON(http.StatusUnauthorized, errorResponse(services.ErrUnauthorized))
+}
+
+// GetUsers retrieves a page of users, e.g.
+// /users?q=smith&role=admin&createdFrom=2024-01-01T00:00:00Z&sort=-lastLogin
+// Follow page.next and page.prev with ?cursor= and the same parameters.
 func (h *UserHandler) GetUsers(c *gin.Context) {
-	// Centralize pagination parameter parsing
-	pagination, err := h.parsePaginationParams(c)
+	filter, sort, err := h.parseUserFilter(c)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
 	}
 
-	// Get users with the validated offset and limit
-	users, total, err := h.service.GetUsers(c, pagination.Offset, pagination.Limit)
+	users, total, err := h.service.GetUsers(c, filter)
 	if err != nil {
-		h.log.Error("Failed to fetch users",
-			zap.Int("offset", pagination.Offset),
-			zap.Int("limit", pagination.Limit),
-			zap.Error(err),
-		)
-		c.JSON(http.StatusInternalServerError, errorResponse(err))
+		switch {
+		case errors.Is(err, services.ErrUnauthorized):
+			c.JSON(http.StatusForbidden, errorResponse(err))
+		case errors.Is(err, utils.ErrInvalidCursor):
+			c.JSON(http.StatusBadRequest, errorResponse(err))
+		default:
+			h.log.Error("Failed to fetch users", zap.Int("limit", filter.Page.Limit), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, errorResponse(err))
+		}
 		return
 	}
 
-	// Return the list of users and metadata
+	users, page := utils.BuildPage(h.cursors, sort, filter.Page, users, func(u *models.User) []string {
+		return u.SortKey(filter.SortBy)
+	})
+
 	c.JSON(http.StatusOK, gin.H{
-		"users":  users,
-		"total":  total,
-		"offset": pagination.Offset,
-		"limit":  pagination.Limit,
+		"users": users,
+		"page":  page.WithTotal(total),
 	})
 }
 
-// Parse and validate pagination parameters
-func (h *UserHandler) parsePaginationParams(c *gin.Context) (models.Pagination, error) {
-	offset, err := utils.ConvertQueryParamToInt(c, "offset", 0, 0, 10000)
-	if err != nil {
-		return models.Pagination{}, fmt.Errorf("invalid offset: %w", err)
+// maxSearchLength bounds the search term of a user listing.
+const maxSearchLength = 100
+
+// parseUserFilter reads the search, filter, sort and page parameters of
+// GET /users. sort takes a User JSON field, prefixed with "-" for descending
+// order, and is returned as given.
+func (h *UserHandler) parseUserFilter(c *gin.Context) (models.UserFilter, string, error) {
+	filter := models.UserFilter{
+		Search:  strings.TrimSpace(c.Query("q")),
+		Role:    c.Query("role"),
+		Deleted: c.DefaultQuery("deleted", models.DeletedExclude),
+	}
+	if len(filter.Search) > maxSearchLength {
+		return models.UserFilter{}, "", fmt.Errorf("search is longer than %d characters", maxSearchLength)
 	}
 
-	limit, err := utils.ConvertQueryParamToInt(c, "limit", 10, 1, 1000)
-	if err != nil {
-		return models.Pagination{}, fmt.Errorf("invalid limit: %w", err)
+	switch filter.Deleted {
+	case models.DeletedExclude, models.DeletedInclude, models.DeletedOnly:
+	default:
+		return models.UserFilter{}, "", fmt.Errorf("invalid deleted, expected one of %s, %s, %s",
+			models.DeletedExclude, models.DeletedInclude, models.DeletedOnly)
+	}
+
+	sort := c.DefaultQuery("sort", "-createdAt")
+	filter.SortBy = strings.TrimPrefix(sort, "-")
+	filter.SortDesc = strings.HasPrefix(sort, "-")
+	if !models.UserSortFields[filter.SortBy] {
+		return models.UserFilter{}, "", fmt.Errorf("cannot sort by %q", filter.SortBy)
+	}
+
+	var err error
+	for name, dest := range map[string]**time.Time{
+		"createdFrom":   &filter.CreatedFrom,
+		"createdTo":     &filter.CreatedTo,
+		"lastLoginFrom": &filter.LastLoginFrom,
+		"lastLoginTo":   &filter.LastLoginTo,
+	} {
+		if *dest, err = optionalTimeQuery(c, name); err != nil {
+			return models.UserFilter{}, "", err
+		}
+	}
+
+	if filter.Page, err = h.cursors.ParsePageRequest(c, sort, defaultPageLimit, maxPageLimit); err != nil {
+		return models.UserFilter{}, "", err
 	}
 
-	return models.Pagination{
-		Offset: offset,
-		Limit:  limit,
-	}, nil
+	return filter, sort, nil
 }
 
 // Helper method to parse and validate user ID
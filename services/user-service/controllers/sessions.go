@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+// ListSessions lists the devices a user is logged in on
+func (h *UserHandler) ListSessions(c *gin.Context) {
+	id, err := h.parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	sessions, err := h.service.ListSessions(c, id)
+	if err != nil {
+		h.handleSessionError(c, id, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// RevokeSession logs a user out of one device
+func (h *UserHandler) RevokeSession(c *gin.Context) {
+	id, err := h.parseUserID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(err))
+		return
+	}
+
+	sessionID := c.Param("sid")
+	if _, err := uuid.Parse(sessionID); err != nil {
+		c.JSON(http.StatusBadRequest, errorResponse(fmt.Errorf("invalid session ID format: %w", err)))
+		return
+	}
+
+	if err := h.service.RevokeSession(c, id, sessionID); err != nil {
+		h.handleSessionError(c, id, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+func (h *UserHandler) handleSessionError(c *gin.Context, id int, err error) {
+	switch {
+	case errors.Is(err, services.ErrUnauthorized):
+		c.JSON(http.StatusForbidden, errorResponse(err))
+	case errors.Is(err, services.ErrSessionNotFound):
+		c.JSON(http.StatusNotFound, errorResponse(err))
+	default:
+		h.log.Error("Session request failed", zap.Int("userID", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, errorResponse(errors.New("session request failed")))
+	}
+}
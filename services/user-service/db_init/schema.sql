@@ -6,19 +6,284 @@ BEGIN
     END LOOP;
 END$$;
 
+CREATE TABLE roles (
+    name VARCHAR(50) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE role_permissions (
+    role VARCHAR(50) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+    permission VARCHAR(100) NOT NULL,
+    PRIMARY KEY (role, permission)
+);
+
+INSERT INTO roles (name, description) VALUES
+    ('user', 'Default role, access to own account and portfolios'),
+    ('support', 'Can view and manage user accounts'),
+    ('admin', 'Full access, including role assignment');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('user', 'portfolios:read'),
+    ('user', 'portfolios:write'),
+    ('support', 'users:read'),
+    ('support', 'users:write'),
+    ('support', 'portfolios:read'),
+    ('admin', 'users:read'),
+    ('admin', 'users:write'),
+    ('admin', 'users:delete'),
+    ('admin', 'roles:manage'),
+    ('admin', 'audit:read'),
+    ('admin', 'portfolios:read'),
+    ('admin', 'portfolios:write');
+
 CREATE TABLE Users (
     id SERIAL PRIMARY KEY,
     name VARCHAR(255) NOT NULL,
-    email VARCHAR(255) UNIQUE NOT NULL,
-    role VARCHAR(50) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(50) NOT NULL DEFAULT 'user' REFERENCES roles(name),
     password_hash TEXT NOT NULL,
-    avatar BYTEA,
-    last_login TIMESTAMP,
+    avatar_key VARCHAR(255),
+    -- Not nullable, so it sorts by keyset without coalescing; set at creation
+    last_login TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
     created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-    deleted_at TIMESTAMP NULL
+    deleted_at TIMESTAMP NULL,
+    -- Set by erasure, which is final: an erased user cannot be restored
+    erased_at TIMESTAMP NULL,
+    disabled_at TIMESTAMP NULL,
+    tokens_revoked_before TIMESTAMP NULL,
+    email_verified_at TIMESTAMP NULL,
+    pending_email VARCHAR(255) NULL,
+    failed_login_attempts INTEGER NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP NULL,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+-- Substring search on name and email (ILIKE '%term%') in GET /users
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX idx_users_name_trgm ON Users USING gin (name gin_trgm_ops);
+CREATE INDEX idx_users_email_trgm ON Users USING gin (email gin_trgm_ops);
+CREATE INDEX idx_users_created ON Users (created_at);
+CREATE INDEX idx_users_last_login ON Users (last_login);
+-- An email belongs to at most one live user, whatever its case. Soft deleted
+-- users release theirs, so it can be registered again.
+CREATE UNIQUE INDEX idx_users_email_live ON Users (LOWER(email)) WHERE deleted_at IS NULL;
+
+-- Households and teams sharing data. Members reach it through the org_id
+-- claim of sessions that switched to the organization.
+CREATE TABLE organizations (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    created_by INTEGER NULL REFERENCES Users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE org_memberships (
+    org_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'admin', 'member', 'viewer')),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (org_id, user_id)
+);
+
+CREATE INDEX idx_org_memberships_user ON org_memberships (user_id);
+
+CREATE TABLE org_invitations (
+    id SERIAL PRIMARY KEY,
+    org_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'admin', 'member', 'viewer')),
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    invited_by INTEGER NULL REFERENCES Users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    accepted_at TIMESTAMP NULL,
+    declined_at TIMESTAMP NULL
+);
+
+CREATE INDEX idx_org_invitations_org ON org_invitations (org_id, created_at DESC);
+
+CREATE TABLE sessions (
+    id UUID PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP NULL,
+    active_org_id INTEGER NULL REFERENCES organizations(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_sessions_user ON sessions (user_id);
+
+CREATE TABLE refresh_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    family_id UUID NOT NULL,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    rotated_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL
+);
+
+CREATE INDEX idx_refresh_tokens_family ON refresh_tokens (family_id);
+CREATE INDEX idx_refresh_tokens_user ON refresh_tokens (user_id);
+
+CREATE TABLE revoked_tokens (
+    jti VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE password_reset_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    used_at TIMESTAMP NULL
+);
+
+CREATE INDEX idx_password_reset_tokens_user ON password_reset_tokens (user_id);
+
+CREATE TABLE password_history (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    password_hash TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_password_history_user ON password_history (user_id, created_at DESC);
+
+CREATE TABLE email_verification_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    used_at TIMESTAMP NULL
+);
+
+CREATE INDEX idx_email_verification_tokens_user ON email_verification_tokens (user_id);
+
+CREATE TABLE user_mfa (
+    user_id INTEGER PRIMARY KEY REFERENCES Users(id) ON DELETE CASCADE,
+    secret VARCHAR(64) NOT NULL,
+    enabled_at TIMESTAMP NULL,
+    last_used_step BIGINT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE mfa_recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, code_hash)
+);
+
+-- Settings shared with other services. preferences holds the JSON of
+-- models.Preferences in the layout numbered schema_version.
+CREATE TABLE user_preferences (
+    user_id INTEGER PRIMARY KEY REFERENCES Users(id) ON DELETE CASCADE,
+    schema_version INTEGER NOT NULL,
+    preferences JSONB NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE api_keys (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    key_hash CHAR(64) UNIQUE NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMP NULL,
+    last_used_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP NULL
 );
 
+CREATE INDEX idx_api_keys_user ON api_keys (user_id);
+
+CREATE TABLE user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    provider VARCHAR(50) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX idx_user_identities_user ON user_identities (user_id);
+
+CREATE TABLE oidc_states (
+    state_hash CHAR(64) PRIMARY KEY,
+    provider VARCHAR(50) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    nonce VARCHAR(128) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE data_exports (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    archive BYTEA NULL,
+    error TEXT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP NULL,
+    expires_at TIMESTAMP NULL
+);
+
+CREATE INDEX idx_data_exports_user ON data_exports (user_id, created_at DESC);
+
+-- actor_id and target_id are not foreign keys so that entries outlive
+-- purged users
+CREATE TABLE audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id INTEGER NULL,
+    client_id VARCHAR(100) NOT NULL DEFAULT '',
+    target_id INTEGER NULL,
+    action VARCHAR(50) NOT NULL,
+    changes JSONB NULL,
+    request_id TEXT NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_audit_log_created ON audit_log (created_at DESC);
+CREATE INDEX idx_audit_log_actor ON audit_log (actor_id, created_at DESC);
+CREATE INDEX idx_audit_log_target ON audit_log (target_id, created_at DESC);
+CREATE INDEX idx_audit_log_action ON audit_log (action, created_at DESC);
+
+-- The audit log is append-only. The one exception is erasing a user, which
+-- clears the changed values and IP address and nothing else.
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND NEW.changes IS NULL AND NEW.ip = ''
+        AND ROW(NEW.id, NEW.actor_id, NEW.client_id, NEW.target_id, NEW.action, NEW.request_id, NEW.created_at)
+            IS NOT DISTINCT FROM
+            ROW(OLD.id, OLD.actor_id, OLD.client_id, OLD.target_id, OLD.action, OLD.request_id, OLD.created_at)
+    THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_append_only
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
 GRANT ALL PRIVILEGES ON DATABASE users TO "admin";
 GRANT SELECT, INSERT ON ALL TABLES IN SCHEMA public TO "admin";
 GRANT USAGE, SELECT, UPDATE ON ALL SEQUENCES IN SCHEMA public TO "admin";
@@ -1,25 +1,180 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/luisVargasGu/stockTracker/common/config"
+	"github.com/luisVargasGu/stockTracker/common/mailer"
 	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/common/storage"
+	"github.com/luisVargasGu/stockTracker/common/utils"
 	"github.com/luisVargasGu/stockTracker/services/user-service/api"
 	"github.com/luisVargasGu/stockTracker/services/user-service/db"
+	"github.com/luisVargasGu/stockTracker/services/user-service/repository"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
 	"go.uber.org/zap"
 )
 
 func main() {
-	tokenService := middleware.NewTokenService(os.Getenv("JWT_SECRET"))
-
 	logger, err := zap.NewDevelopment() // For production: zap.NewProduction()
 	if err != nil {
 		log.Fatalf("Failed to initialize logger: %v", err)
 	}
 	defer logger.Sync()
 
-	db := db.DbConnect(logger)
-	server := api.NewAPIServer(":8080", db)
-	server.Run(logger, *tokenService)
+	cfg := defaultConfig()
+	if err := config.Load(&cfg, config.Sources{YAMLFile: os.Getenv("CONFIG_FILE"), DotEnvFile: ".env"}); err != nil {
+		logger.Fatal("Failed to load configuration", zap.Error(err))
+	}
+
+	// Cancelled on the first SIGINT or SIGTERM, which starts a graceful
+	// shutdown; a second one kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
+	db := db.DbConnect(cfg.Database, logger)
+
+	var keyStore middleware.KeyStore = middleware.NewMemoryKeyStore()
+	if cfg.Auth.KeysDir != "" {
+		keyStore = middleware.NewFileKeyStore(cfg.Auth.KeysDir)
+	} else {
+		logger.Warn("JWT_KEYS_DIR not set, signing keys will not survive a restart")
+	}
+
+	keys, err := middleware.NewKeyManager(context.Background(), keyStore,
+		middleware.DefaultKeyRotation, middleware.DefaultKeyOverlap)
+	if err != nil {
+		logger.Fatal("Failed to initialize signing keys", zap.Error(err))
+	}
+	background := services.NewBackground()
+	background.Go(func(context.Context) { keys.Run(ctx) })
+
+	apiKeys := services.NewAPIKeyValidator(repository.NewAPIKeyStore(db, logger),
+		repository.NewUserStore(db, logger), repository.NewRoleStore(db, logger), logger)
+
+	tokenOptions := []middleware.TokenOption{
+		middleware.WithRevocationStore(repository.NewTokenStore(db, logger)),
+		middleware.WithAPIKeyValidator(apiKeys),
+	}
+	if cfg.Auth.TestAuth {
+		if err := middleware.CheckTestAuth(cfg.Env); err != nil {
+			logger.Fatal("TEST_AUTH is set but test authentication cannot be enabled", zap.Error(err))
+		}
+		logger.Warn("Test authentication enabled, never use this build in production")
+		tokenOptions = append(tokenOptions, middleware.WithTestAuth())
+	}
+	tokenService := middleware.NewTokenService(keys, tokenOptions...)
+	if err := tokenService.CheckKeyOverlap(); err != nil {
+		logger.Fatal("Invalid signing key settings", zap.Error(err))
+	}
+
+	avatars := services.NewAvatarStore(newBlobStore(cfg.Storage), cfg.Storage.AvatarBaseURL)
+
+	retention := time.Duration(cfg.DeletedUserRetentionDays) * 24 * time.Hour
+	purger := services.NewUserPurger(repository.NewUserStore(db, logger), avatars, retention, logger)
+	background.Go(func(context.Context) { purger.Run(ctx) })
+
+	server := api.NewAPIServer(cfg.HTTP, db, newMailer(cfg.Mail, logger), cfg.AppURL,
+		newPasswordPolicy(cfg.Password, logger), newClientRegistry(cfg.Auth, logger),
+		newIdentityProviders(cfg.Auth, logger), newCursorCodec(cfg.Auth, logger), avatars, background)
+	if err := server.Run(ctx, logger, *tokenService); err != nil {
+		logger.Fatal("Server stopped with an error", zap.Error(err))
+	}
+}
+
+// newMailer picks the mail transport from MAIL_DRIVER. The default logs
+// messages so that local setups work without an SMTP relay.
+func newMailer(cfg MailConfig, logger *zap.Logger) mailer.Mailer {
+	switch cfg.Driver {
+	case "smtp":
+		return mailer.NewSMTPMailer(cfg.SMTPAddr, cfg.From, cfg.SMTPUsername, cfg.SMTPPassword)
+	case "file":
+		return mailer.NewFileMailer(cfg.Dir, cfg.From)
+	default:
+		return mailer.NewLogMailer(logger)
+	}
+}
+
+// newBlobStore picks where uploaded files are kept from STORAGE_DRIVER. The
+// default is the local filesystem under STORAGE_DIR.
+func newBlobStore(cfg StorageConfig) storage.Store {
+	if cfg.Driver == "s3" {
+		return storage.NewS3Store(storage.S3Config{
+			Endpoint:  cfg.S3.Endpoint,
+			Region:    cfg.S3.Region,
+			Bucket:    cfg.S3.Bucket,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+			PathStyle: cfg.S3.PathStyle,
+		})
+	}
+	return storage.NewFileStore(cfg.Dir)
+}
+
+// newClientRegistry loads the service clients allowed to use the client
+// credentials grant. Without SERVICE_CLIENTS_FILE no client can get a token.
+func newClientRegistry(cfg AuthConfig, logger *zap.Logger) *middleware.ClientRegistry {
+	if cfg.ServiceClientsFile == "" {
+		return middleware.NewClientRegistry()
+	}
+
+	clients, err := middleware.LoadClientRegistry(cfg.ServiceClientsFile)
+	if err != nil {
+		logger.Fatal("Failed to load service clients", zap.String("path", cfg.ServiceClientsFile), zap.Error(err))
+	}
+	return clients
+}
+
+// newIdentityProviders loads the external login providers from
+// OIDC_PROVIDERS_FILE. Without it only password login is available.
+func newIdentityProviders(cfg AuthConfig, logger *zap.Logger) map[string]services.IdentityProvider {
+	if cfg.OIDCProvidersFile == "" {
+		return map[string]services.IdentityProvider{}
+	}
+
+	providers, err := services.LoadIdentityProviders(cfg.OIDCProvidersFile)
+	if err != nil {
+		logger.Fatal("Failed to load identity providers", zap.String("path", cfg.OIDCProvidersFile), zap.Error(err))
+	}
+	return providers
+}
+
+// newCursorCodec signs pagination cursors with CURSOR_SECRET. Outside
+// production a missing secret is replaced by a random one.
+func newCursorCodec(cfg AuthConfig, logger *zap.Logger) *utils.CursorCodec {
+	secret := cfg.CursorSecret
+	if secret == "" {
+		logger.Warn("CURSOR_SECRET not set, pagination cursors will not survive a restart")
+		random, err := middleware.NewOpaqueToken()
+		if err != nil {
+			logger.Fatal("Failed to generate cursor secret", zap.Error(err))
+		}
+		secret = random
+	}
+	return utils.NewCursorCodec([]byte(secret))
+}
+
+// newPasswordPolicy builds the password policy from the PASSWORD_* settings.
+func newPasswordPolicy(cfg PasswordConfig, logger *zap.Logger) *middleware.PasswordPolicy {
+	policy := middleware.DefaultPasswordPolicy()
+	policy.MinLength = cfg.MinLength
+	policy.HistorySize = cfg.History
+	policy.RequireUpper = cfg.RequireUpper
+	policy.RequireLower = cfg.RequireLower
+	policy.RequireDigit = cfg.RequireDigit
+	policy.RequireSymbol = cfg.RequireSymbol
+
+	if cfg.DenylistFile != "" {
+		if err := policy.LoadDenylist(cfg.DenylistFile); err != nil {
+			logger.Fatal("Failed to load password denylist", zap.String("path", cfg.DenylistFile), zap.Error(err))
+		}
+	}
+
+	return policy
 }
@@ -0,0 +1,43 @@
+package models
+
+// Actions of POST /admin/users/bulk.
+const (
+	BulkDisable = "disable"
+	BulkEnable  = "enable"
+	BulkRole    = "role"
+	BulkDelete  = "delete"
+)
+
+// BulkUserPayload applies one action to many users. Role is the role to
+// assign for BulkRole.
+type BulkUserPayload struct {
+	Action  string `json:"action" validate:"required,oneof=disable enable role delete"`
+	UserIDs []int  `json:"userIds" validate:"required,min=1,max=1000,dive,gt=0"`
+	Role    string `json:"role" validate:"required_if=Action role"`
+}
+
+// Outcome of a row of a user import.
+const (
+	ImportRowValid   = "valid"
+	ImportRowInvalid = "invalid"
+	ImportRowCreated = "created"
+)
+
+// UserImportRow reports on one CSV row. Row counts from 1 for the first
+// row after the header.
+type UserImportRow struct {
+	Row    int      `json:"row"`
+	Email  string   `json:"email"`
+	Status string   `json:"status"`
+	UserID int      `json:"userId,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// UserImportReport is the result of a user import. Imports are all or
+// nothing: if any row is invalid no user is created.
+type UserImportReport struct {
+	DryRun  bool             `json:"dryRun"`
+	Created int              `json:"created"`
+	Invalid int              `json:"invalid"`
+	Rows    []*UserImportRow `json:"rows"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// APIKey is a personal access key for scripts. Only a hash of the key is
+// stored; Prefix is kept in clear so users can tell their keys apart.
+type APIKey struct {
+	ID         int        `json:"id" db:"id"`
+	UserID     int        `json:"userId" db:"user_id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"prefix"`
+	KeyHash    string     `json:"-" db:"key_hash"`
+	Scopes     []string   `json:"scopes" db:"-"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty" db:"expires_at"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty" db:"last_used_at"`
+	CreatedAt  time.Time  `json:"createdAt" db:"created_at"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty" db:"revoked_at"`
+}
+
+// CreatedAPIKey is returned once on creation and is the only time the
+// plain key is available.
+type CreatedAPIKey struct {
+	*APIKey
+	Key string `json:"key"`
+}
+
+type CreateAPIKeyPayload struct {
+	Name      string     `json:"name" validate:"required,max=100"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1,dive,required"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
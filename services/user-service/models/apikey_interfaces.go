@@ -0,0 +1,12 @@
+package models
+
+import "context"
+
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key *APIKey) error
+	ListAPIKeys(ctx context.Context, userID int) ([]*APIKey, error)
+	GetActiveAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID, keyID int) error
+	TouchAPIKey(ctx context.Context, keyID int) error
+	DeleteAPIKeys(ctx context.Context, userID int) error
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/luisVargasGu/stockTracker/common/utils"
+)
+
+// Audited actions, named <resource>.<verb>.
+const (
+	AuditUserUpdate        = "user.update"
+	AuditUserDelete        = "user.delete"
+	AuditUserRestore       = "user.restore"
+	AuditUserErase         = "user.erase"
+	AuditUserUnlock        = "user.unlock"
+	AuditUserDisable       = "user.disable"
+	AuditUserEnable        = "user.enable"
+	AuditUserImport        = "user.import"
+	AuditAvatarUpdate      = "user.avatar_update"
+	AuditAvatarDelete      = "user.avatar_delete"
+	AuditPreferencesUpdate = "user.preferences_update"
+	AuditRoleAssign        = "user.role_assign"
+	AuditPasswordChange    = "user.password_change"
+	AuditPasswordReset     = "user.password_reset"
+	AuditAccountClaim      = "user.account_claim"
+	AuditMFAEnable         = "mfa.enable"
+	AuditMFADisable        = "mfa.disable"
+	AuditAPIKeyCreate      = "api_key.create"
+	AuditAPIKeyRevoke      = "api_key.revoke"
+	AuditSessionRevoke     = "session.revoke"
+	AuditOrgCreate         = "org.create"
+	AuditOrgDelete         = "org.delete"
+	AuditOrgInvite         = "org.invite"
+	AuditOrgInviteRevoke   = "org.invite_revoke"
+	AuditOrgInviteDecline  = "org.invite_decline"
+	AuditOrgJoin           = "org.join"
+	AuditOrgMemberRole     = "org.member_role"
+	AuditOrgMemberRemove   = "org.member_remove"
+)
+
+// AuditChange is the value of one field before and after a change.
+type AuditChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// AuditEntry records who did what to whom. ActorID is nil when the actor
+// is not signed in, e.g. during a password reset, and ClientID is set when
+// the actor is another service.
+type AuditEntry struct {
+	ID        int                    `json:"id"`
+	ActorID   *int                   `json:"actorId,omitempty"`
+	ClientID  string                 `json:"clientId,omitempty"`
+	TargetID  *int                   `json:"targetId,omitempty"`
+	Action    string                 `json:"action"`
+	Changes   map[string]AuditChange `json:"changes,omitempty"`
+	RequestID string                 `json:"requestId,omitempty"`
+	IP        string                 `json:"ip,omitempty"`
+	CreatedAt time.Time              `json:"createdAt"`
+}
+
+type AuditFilter struct {
+	ActorID  *int
+	TargetID *int
+	Action   string
+	From     *time.Time
+	To       *time.Time
+	Page     utils.PageRequest
+}
+
+// AuditSort is the only order of the audit log, newest first.
+const AuditSort = "-createdAt"
+
+// SortKey returns the values locating the entry in the audit log, as stored
+// in a pagination cursor.
+func (e *AuditEntry) SortKey() []string {
+	return []string{e.CreatedAt.Format(time.RFC3339Nano), strconv.Itoa(e.ID)}
+}
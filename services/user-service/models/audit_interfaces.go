@@ -0,0 +1,14 @@
+package models
+
+import (
+	"context"
+
+	"github.com/luisVargasGu/stockTracker/common/utils"
+)
+
+type AuditRepository interface {
+	CreateAuditEntry(ctx context.Context, entry *AuditEntry) error
+	ListAuditEntries(ctx context.Context, filter AuditFilter) ([]*AuditEntry, *utils.Total, error)
+	ListUserAuditEntries(ctx context.Context, userID int) ([]*AuditEntry, error)
+	RedactUserAuditEntries(ctx context.Context, userID int) error
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// UserIdentity links an account at an external identity provider to a user.
+type UserIdentity struct {
+	ID        int       `json:"id" db:"id"`
+	UserID    int       `json:"userId" db:"user_id"`
+	Provider  string    `json:"provider" db:"provider"`
+	Subject   string    `json:"-" db:"subject"`
+	Email     string    `json:"email" db:"email"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// OIDCState remembers an authorization request between the redirect to the
+// provider and the callback. The state itself is stored hashed.
+type OIDCState struct {
+	StateHash    string    `db:"state_hash"`
+	Provider     string    `db:"provider"`
+	CodeVerifier string    `db:"code_verifier"`
+	Nonce        string    `db:"nonce"`
+	ExpiresAt    time.Time `db:"expires_at"`
+	CreatedAt    time.Time `db:"created_at"`
+}
+
+type OIDCAuthorization struct {
+	AuthorizationURL string `json:"authorizationUrl"`
+	State            string `json:"state"`
+	// StateBinding ties the state to the browser that started the login. It
+	// travels in an HttpOnly cookie, never in a response body.
+	StateBinding string `json:"-"`
+}
+
+type OIDCCallbackPayload struct {
+	Code  string `json:"code" validate:"required"`
+	State string `json:"state" validate:"required"`
+	// StateBinding is read from the cookie set by AuthorizeExternal
+	StateBinding string `json:"-"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "context"
+
+type IdentityRepository interface {
+	CreateOIDCState(ctx context.Context, state *OIDCState) error
+	ConsumeOIDCState(ctx context.Context, stateHash string) (*OIDCState, error)
+	GetIdentity(ctx context.Context, provider, subject string) (*UserIdentity, error)
+	LinkIdentity(ctx context.Context, identity *UserIdentity) error
+	ListIdentities(ctx context.Context, userID int) ([]*UserIdentity, error)
+	DeleteIdentities(ctx context.Context, userID int) error
+}
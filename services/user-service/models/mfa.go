@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// UserMFA holds a user's TOTP secret. MFA is only enforced once EnabledAt is
+// set, i.e. after the user proved their authenticator works.
+type UserMFA struct {
+	UserID       int        `db:"user_id"`
+	Secret       string     `db:"secret"`
+	EnabledAt    *time.Time `db:"enabled_at"`
+	LastUsedStep *int64     `db:"last_used_step"`
+	CreatedAt    time.Time  `db:"created_at"`
+}
+
+type MFAEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauthUri"`
+}
+
+type MFARecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type MFALoginPayload struct {
+	MFAToken string `json:"mfaToken" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+	IP       string `json:"-"` // Set from the request, used for throttling
+}
+
+type MFACodePayload struct {
+	Code string `json:"code" validate:"required"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "context"
+
+type MFARepository interface {
+	GetMFA(ctx context.Context, userID int) (*UserMFA, error)
+	SaveMFASecret(ctx context.Context, userID int, secret string) error
+	EnableMFA(ctx context.Context, userID int, recoveryCodeHashes []string) error
+	DisableMFA(ctx context.Context, userID int) error
+	MarkTOTPStepUsed(ctx context.Context, userID int, step int64) error
+	ConsumeRecoveryCode(ctx context.Context, userID int, codeHash string) error
+}
@@ -0,0 +1,84 @@
+package models
+
+import "time"
+
+// Roles of a member within an organization, from most to least privileged.
+// Owners manage the organization itself, admins manage its members, members
+// manage its data and viewers can only read it.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+	OrgRoleViewer = "viewer"
+)
+
+var orgRoleRanks = map[string]int{
+	OrgRoleOwner:  4,
+	OrgRoleAdmin:  3,
+	OrgRoleMember: 2,
+	OrgRoleViewer: 1,
+}
+
+// OrgRoleAtLeast reports whether role grants everything min does.
+func OrgRoleAtLeast(role, min string) bool {
+	return orgRoleRanks[role] >= orgRoleRanks[min] && orgRoleRanks[role] > 0
+}
+
+// Organization is a household or team whose members share data. Role is
+// the caller's role in it when listed for a user.
+type Organization struct {
+	ID        int       `json:"id" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	CreatedBy *int      `json:"createdBy,omitempty" db:"created_by"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+	Role      string    `json:"role,omitempty" db:"role"`
+}
+
+// OrgMember is a user's membership of an organization.
+type OrgMember struct {
+	OrgID     int       `json:"orgId" db:"org_id"`
+	UserID    int       `json:"userId" db:"user_id"`
+	Name      string    `json:"name" db:"name"`
+	Email     string    `json:"email" db:"email"`
+	Role      string    `json:"role" db:"role"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// OrgInvitation invites whoever owns Email to join an organization. Only a
+// hash of the emailed token is stored.
+type OrgInvitation struct {
+	ID         int        `json:"id" db:"id"`
+	OrgID      int        `json:"orgId" db:"org_id"`
+	Email      string     `json:"email" db:"email"`
+	Role       string     `json:"role" db:"role"`
+	TokenHash  string     `json:"-" db:"token_hash"`
+	InvitedBy  *int       `json:"invitedBy,omitempty" db:"invited_by"`
+	ExpiresAt  time.Time  `json:"expiresAt" db:"expires_at"`
+	CreatedAt  time.Time  `json:"createdAt" db:"created_at"`
+	AcceptedAt *time.Time `json:"acceptedAt,omitempty" db:"accepted_at"`
+	DeclinedAt *time.Time `json:"declinedAt,omitempty" db:"declined_at"`
+}
+
+type CreateOrgPayload struct {
+	Name string `json:"name" validate:"required,max=255"`
+}
+
+type InviteOrgMemberPayload struct {
+	Email string `json:"email" validate:"required,email"`
+	Role  string `json:"role" validate:"required,oneof=owner admin member viewer"`
+}
+
+type SetOrgMemberRolePayload struct {
+	Role string `json:"role" validate:"required,oneof=owner admin member viewer"`
+}
+
+type OrgInvitationTokenPayload struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// ActiveOrgPayload selects the organization a session acts for, or none
+// when OrgID is null.
+type ActiveOrgPayload struct {
+	OrgID *int `json:"orgId"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "context"
+
+type OrgRepository interface {
+	CreateOrg(ctx context.Context, org *Organization, ownerID int) error
+	GetOrg(ctx context.Context, orgID int) (*Organization, error)
+	LockOrg(ctx context.Context, orgID int) error
+	ListUserOrgs(ctx context.Context, userID int) ([]*Organization, error)
+	DeleteOrg(ctx context.Context, orgID int) error
+	GetMember(ctx context.Context, orgID, userID int) (*OrgMember, error)
+	ListMembers(ctx context.Context, orgID int) ([]*OrgMember, error)
+	AddMember(ctx context.Context, orgID, userID int, role string) error
+	SetMemberRole(ctx context.Context, orgID, userID int, role string) error
+	RemoveMember(ctx context.Context, orgID, userID int) error
+	CountOwners(ctx context.Context, orgID int) (int, error)
+	DeleteMemberships(ctx context.Context, userID int) error
+	CreateInvitation(ctx context.Context, invitation *OrgInvitation) error
+	ListInvitations(ctx context.Context, orgID int) ([]*OrgInvitation, error)
+	GetPendingInvitationByHash(ctx context.Context, tokenHash string) (*OrgInvitation, error)
+	ResolveInvitation(ctx context.Context, invitationID int, accepted bool) error
+	DeleteInvitation(ctx context.Context, orgID, invitationID int) error
+}
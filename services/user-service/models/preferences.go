@@ -0,0 +1,75 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	// Timezones are validated against the embedded database, since the
+	// runtime image does not ship one
+	_ "time/tzdata"
+)
+
+// PreferencesSchemaVersion is the layout of Preferences written by this
+// build. Stored preferences are decoded over DefaultPreferences, so fields
+// added in later layouts take their default until the user saves them.
+const PreferencesSchemaVersion = 1
+
+// Number formats, named after how they write one thousand and a half
+const (
+	NumberFormatLocale     = "locale"      // as the locale writes it
+	NumberFormatCommaDot   = "comma_dot"   // 1,000.5
+	NumberFormatDotComma   = "dot_comma"   // 1.000,5
+	NumberFormatSpaceComma = "space_comma" // 1 000,5
+)
+
+// Date formats
+const (
+	DateFormatLocale = "locale" // as the locale writes it
+	DateFormatISO    = "iso"    // 2006-01-02
+	DateFormatDMY    = "dmy"    // 02/01/2006
+	DateFormatMDY    = "mdy"    // 01/02/2006
+)
+
+// NotificationChannels says how a user wants to be notified
+type NotificationChannels struct {
+	Email bool `json:"email"`
+	SMS   bool `json:"sms"`
+	Push  bool `json:"push"`
+}
+
+// Preferences are a user's settings, shared with other services
+type Preferences struct {
+	BaseCurrency       string               `json:"baseCurrency" validate:"required,iso4217"`
+	Timezone           string               `json:"timezone" validate:"required,timezone"`
+	Locale             string               `json:"locale" validate:"required,bcp47_language_tag"`
+	DefaultPortfolioID *int                 `json:"defaultPortfolioId" validate:"omitempty,gt=0"`
+	Notifications      NotificationChannels `json:"notifications"`
+	NumberFormat       string               `json:"numberFormat" validate:"required,oneof=locale comma_dot dot_comma space_comma"`
+	DateFormat         string               `json:"dateFormat" validate:"required,oneof=locale iso dmy mdy"`
+}
+
+// DefaultPreferences are the settings of a user who never saved any.
+func DefaultPreferences() Preferences {
+	return Preferences{
+		BaseCurrency:  "USD",
+		Timezone:      "UTC",
+		Locale:        "en-US",
+		Notifications: NotificationChannels{Email: true},
+		NumberFormat:  NumberFormatLocale,
+		DateFormat:    DateFormatLocale,
+	}
+}
+
+// UserPreferences are the stored preferences of a user. Version counts
+// saves and is 0 while the user still has the defaults.
+type UserPreferences struct {
+	Preferences
+	Version   int        `json:"version"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+// ETag is the entity tag of the preferences' current version, for
+// conditional requests.
+func (p *UserPreferences) ETag() string {
+	return `"` + strconv.Itoa(p.Version) + `"`
+}
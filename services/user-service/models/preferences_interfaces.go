@@ -0,0 +1,9 @@
+package models
+
+import "context"
+
+type PreferencesRepository interface {
+	GetPreferences(ctx context.Context, userID int) (*UserPreferences, error)
+	SavePreferences(ctx context.Context, userID int, prefs Preferences, version int) (*UserPreferences, error)
+	DeletePreferences(ctx context.Context, userID int) error
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+const (
+	DataExportPending = "pending"
+	DataExportReady   = "ready"
+	DataExportFailed  = "failed"
+)
+
+// DataExport is an archive of everything held about a user, built in the
+// background after the user asks for it.
+type DataExport struct {
+	ID          int        `json:"id" db:"id"`
+	UserID      int        `json:"userId" db:"user_id"`
+	Status      string     `json:"status" db:"status"`
+	Archive     []byte     `json:"-" db:"archive"`
+	Error       *string    `json:"error,omitempty" db:"error"`
+	CreatedAt   time.Time  `json:"createdAt" db:"created_at"`
+	CompletedAt *time.Time `json:"completedAt,omitempty" db:"completed_at"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty" db:"expires_at"`
+}
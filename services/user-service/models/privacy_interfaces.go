@@ -0,0 +1,14 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+type DataExportRepository interface {
+	CreateDataExport(ctx context.Context, export *DataExport) error
+	GetLatestDataExport(ctx context.Context, userID int) (*DataExport, error)
+	CompleteDataExport(ctx context.Context, id int, archive []byte, expiresAt time.Time) error
+	FailDataExport(ctx context.Context, id int, reason string) error
+	DeleteDataExports(ctx context.Context, userID int) error
+}
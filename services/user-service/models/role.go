@@ -0,0 +1,12 @@
+package models
+
+// Role is a named set of permissions. Every user holds exactly one role.
+type Role struct {
+	Name        string   `json:"name" db:"name"`
+	Description string   `json:"description" db:"description"`
+	Permissions []string `json:"permissions" db:"-"`
+}
+
+type AssignRolePayload struct {
+	Role string `json:"role" validate:"required"`
+}
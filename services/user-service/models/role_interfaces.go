@@ -0,0 +1,8 @@
+package models
+
+import "context"
+
+type RoleRepository interface {
+	ListRoles(ctx context.Context) ([]*Role, error)
+	GetRole(ctx context.Context, name string) (*Role, error)
+}
@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// RefreshToken is a persisted, hashed refresh token. Tokens issued from the
+// same login share a FamilyID so that reuse of a rotated token can revoke
+// every descendant of that login at once.
+type RefreshToken struct {
+	ID        int        `db:"id"`
+	UserID    int        `db:"user_id"`
+	FamilyID  string     `db:"family_id"`
+	TokenHash string     `db:"token_hash"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	CreatedAt time.Time  `db:"created_at"`
+	RotatedAt *time.Time `db:"rotated_at"`
+	RevokedAt *time.Time `db:"revoked_at"`
+}
+
+// PasswordResetToken is a hashed, single-use token emailed to a user who
+// forgot their password.
+type PasswordResetToken struct {
+	ID        int        `db:"id"`
+	UserID    int        `db:"user_id"`
+	TokenHash string     `db:"token_hash"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	CreatedAt time.Time  `db:"created_at"`
+	UsedAt    *time.Time `db:"used_at"`
+}
+
+// EmailVerificationToken confirms ownership of Email, which is either the
+// user's current address or the pending address they asked to switch to.
+type EmailVerificationToken struct {
+	ID        int        `db:"id"`
+	UserID    int        `db:"user_id"`
+	Email     string     `db:"email"`
+	TokenHash string     `db:"token_hash"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	CreatedAt time.Time  `db:"created_at"`
+	UsedAt    *time.Time `db:"used_at"`
+}
+
+// Session is one login on one device. Its ID is the family ID of the
+// refresh tokens issued to it and the sid claim of its access tokens.
+type Session struct {
+	ID         string     `json:"id" db:"id"`
+	UserID     int        `json:"userId" db:"user_id"`
+	UserAgent  string     `json:"userAgent" db:"user_agent"`
+	IP         string     `json:"ip" db:"ip"`
+	CreatedAt  time.Time  `json:"createdAt" db:"created_at"`
+	LastSeenAt time.Time  `json:"lastSeenAt" db:"last_seen_at"`
+	RevokedAt  *time.Time `json:"-" db:"revoked_at"`
+	Current    bool       `json:"current" db:"-"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+)
+
+type TokenRepository interface {
+	CreateRefreshToken(ctx context.Context, token *RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, hash string) (*RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, currentID int, next *RefreshToken) error
+	RevokeTokenFamily(ctx context.Context, familyID string) error
+	RevokeAccessToken(ctx context.Context, jti string, userID int, expiresAt time.Time) error
+	RevokeAllUserTokens(ctx context.Context, userID int) error
+	IsRevoked(ctx context.Context, claims *middleware.AuthClaims) (bool, error)
+	CreatePasswordResetToken(ctx context.Context, token *PasswordResetToken) error
+	ConsumePasswordResetToken(ctx context.Context, hash string) (int, error)
+	CreateEmailVerificationToken(ctx context.Context, token *EmailVerificationToken) error
+	ConsumeEmailVerificationToken(ctx context.Context, hash string) (*EmailVerificationToken, error)
+	CreateSession(ctx context.Context, session *Session) error
+	TouchSession(ctx context.Context, sessionID, ip, userAgent string) error
+	SetSessionOrg(ctx context.Context, sessionID string, orgID *int) error
+	GetSessionOrg(ctx context.Context, sessionID string) (*int, error)
+	ListSessions(ctx context.Context, userID int) ([]*Session, error)
+	RevokeSession(ctx context.Context, userID int, sessionID string) error
+	ListSessionHistory(ctx context.Context, userID int) ([]*Session, error)
+	DeleteSessions(ctx context.Context, userID int) error
+}
@@ -0,0 +1,9 @@
+package models
+
+import "context"
+
+// Transactor runs fn in a transaction that every repository called with the
+// context passed to fn joins.
+type Transactor interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strconv"
 	"time"
 )
 
@@ -10,11 +11,24 @@ type User struct {
 	Email        string     `json:"email" validate:"email" db:"email"`
 	Role         string     `json:"role" db:"role"` // e.g., "admin", "user"
 	PasswordHash string     `json:"-" db:"password_hash"`
-	Avatar       []byte     `json:"avatar,omitempty" db:"avatar"`
+	AvatarKey    *string    `json:"-" db:"avatar_key"`
 	LastLogin    time.Time  `json:"lastLogin" db:"last_login"`
 	UpdatedAt    time.Time  `json:"updatedAt" db:"updated_at"`
 	CreatedAt    time.Time  `json:"createdAt" db:"created_at"`
 	DeletedAt    *time.Time `json:"deletedAt,omitempty" db:"deleted_at"`
+	DisabledAt   *time.Time `json:"disabledAt,omitempty" db:"disabled_at"`
+
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt,omitempty" db:"email_verified_at"`
+	PendingEmail    *string    `json:"pendingEmail,omitempty" db:"pending_email"`
+
+	FailedLoginAttempts int        `json:"-" db:"failed_login_attempts"`
+	LockedUntil         *time.Time `json:"lockedUntil,omitempty" db:"locked_until"`
+
+	// Version is incremented by every change to the profile
+	Version int `json:"version" db:"version"`
+
+	// AvatarURLs maps thumbnail sizes in pixels to their URL
+	AvatarURLs map[string]string `json:"avatarUrls,omitempty" db:"-"`
 }
 
 // TODO: may not need this
@@ -24,4 +38,41 @@ type UserInfo struct {
 	Email     string `json:"email"`
 	Role      string `json:"role"`
 	AvatarURL string `json:"avatarUrl,omitempty"`
+
+	EmailVerified bool `json:"emailVerified"`
+}
+
+// ETag is the entity tag of the user's current version, for conditional
+// requests.
+func (u *User) ETag() string {
+	return `"` + strconv.Itoa(u.Version) + `"`
+}
+
+// SortKey returns the values locating the user in a listing sorted by
+// field, as stored in a pagination cursor.
+func (u *User) SortKey(field string) []string {
+	var value string
+	switch field {
+	case "name":
+		value = u.Name
+	case "email":
+		value = u.Email
+	case "role":
+		value = u.Role
+	case "createdAt":
+		value = u.CreatedAt.Format(time.RFC3339Nano)
+	case "updatedAt":
+		value = u.UpdatedAt.Format(time.RFC3339Nano)
+	case "lastLogin":
+		value = u.LastLogin.Format(time.RFC3339Nano)
+	case "deletedAt":
+		// Users that are not deleted sort as if deleted at the dawn of time
+		value = "-infinity"
+		if u.DeletedAt != nil {
+			value = u.DeletedAt.Format(time.RFC3339Nano)
+		}
+	default:
+		value = strconv.Itoa(u.ID)
+	}
+	return []string{value, strconv.Itoa(u.ID)}
 }
@@ -1,21 +1,89 @@
 package models
 
-import "context"
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/luisVargasGu/stockTracker/common/storage"
+	"github.com/luisVargasGu/stockTracker/common/utils"
+)
 
 type UserRepository interface {
 	GetUserByEmail(ctx context.Context, email string) (*User, error)
 	GetUserByID(ctx context.Context, id int) (*User, error)
-	GetUsers(ctx context.Context, offset, limit int) ([]*User, int, error)
+	GetUsers(ctx context.Context, filter UserFilter) ([]*User, *utils.Total, error)
 	CreateUser(ctx context.Context, user *User) (*User, error)
 	UpdateUser(ctx context.Context, id int, updates map[string]interface{}) (*User, error)
+	UpdateUserIfVersion(ctx context.Context, id, version int, updates map[string]interface{}) (*User, error)
 	DeleteUser(ctx context.Context, id int) error
+	AddPasswordHistory(ctx context.Context, userID int, passwordHash string) error
+	GetPasswordHistory(ctx context.Context, userID int, limit int) ([]string, error)
+	RecordFailedLogin(ctx context.Context, userID int, threshold int, base, max time.Duration) (*time.Time, error)
+	RecordSuccessfulLogin(ctx context.Context, userID int) error
+	UnlockUser(ctx context.Context, userID int) error
+	SetUserRole(ctx context.Context, userID int, role string) error
+	RestoreUser(ctx context.Context, userID int) error
+	PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) ([]*User, error)
+	AnonymizeUser(ctx context.Context, userID int, passwordHash string) error
+	ClearAvatar(ctx context.Context, userID int) (*string, error)
+	SetUserDisabled(ctx context.Context, userID int, disabled bool) error
 }
 
 type UserService interface {
-	GetUsers(ctx context.Context, offset, limit int) ([]*User, int, error)
+	GetUsers(ctx context.Context, filter UserFilter) ([]*User, *utils.Total, error)
 	LoginUser(ctx context.Context, user LoginUserPayload) (*LoginResponse, error)
+	RefreshToken(ctx context.Context, payload RefreshTokenPayload) (*LoginResponse, error)
+	Logout(ctx context.Context, payload LogoutPayload) error
+	LogoutAll(ctx context.Context) error
+	ListSessions(ctx context.Context, id int) ([]*Session, error)
+	RevokeSession(ctx context.Context, id int, sessionID string) error
+	ForgotPassword(ctx context.Context, payload ForgotPasswordPayload) error
+	ResetPassword(ctx context.Context, payload ResetPasswordPayload) error
+	ChangePassword(ctx context.Context, id int, payload ChangePasswordPayload) error
+	VerifyEmail(ctx context.Context, token string) (*User, error)
+	ResendVerification(ctx context.Context) error
+	LoginMFA(ctx context.Context, payload MFALoginPayload) (*LoginResponse, error)
+	EnrollMFA(ctx context.Context, id int) (*MFAEnrollment, error)
+	ActivateMFA(ctx context.Context, id int, payload MFACodePayload) (*MFARecoveryCodes, error)
+	DisableMFA(ctx context.Context, id int, payload MFACodePayload) error
+	UnlockUser(ctx context.Context, id int) error
+	ListRoles(ctx context.Context) ([]*Role, error)
+	AssignRole(ctx context.Context, id int, role string) error
+	CreateAPIKey(ctx context.Context, id int, payload CreateAPIKeyPayload) (*CreatedAPIKey, error)
+	ListAPIKeys(ctx context.Context, id int) ([]*APIKey, error)
+	RevokeAPIKey(ctx context.Context, id, keyID int) error
+	IdentityProviders() []string
+	AuthorizeExternal(ctx context.Context, provider string) (*OIDCAuthorization, error)
+	LoginExternal(ctx context.Context, provider string, payload OIDCCallbackPayload) (*LoginResponse, error)
 	GetUserByID(ctx context.Context, id int) (*User, error)
 	RegisterUser(ctx context.Context, user RegisterUserPayload) (*User, error)
-	UpdateUser(ctx context.Context, id int, updates map[string]interface{}) (*User, error)
+	UpdateUser(ctx context.Context, id int, updates map[string]interface{}, version int) (*User, error)
 	DeleteUser(ctx context.Context, id int) error
+	RestoreUser(ctx context.Context, id int) error
+	UploadAvatar(ctx context.Context, id int, data []byte) (*User, error)
+	DeleteAvatar(ctx context.Context, id int) error
+	GetAvatar(ctx context.Context, userID, file string) (*storage.Object, error)
+	RequestDataExport(ctx context.Context, id int) (*DataExport, error)
+	EraseUser(ctx context.Context, id int) error
+	ListAuditEntries(ctx context.Context, filter AuditFilter) ([]*AuditEntry, *utils.Total, error)
+	CreateOrg(ctx context.Context, payload CreateOrgPayload) (*Organization, error)
+	ListOrgs(ctx context.Context) ([]*Organization, error)
+	GetOrg(ctx context.Context, orgID int) (*Organization, error)
+	DeleteOrg(ctx context.Context, orgID int) error
+	ListOrgMembers(ctx context.Context, orgID int) ([]*OrgMember, error)
+	SetOrgMemberRole(ctx context.Context, orgID, userID int, role string) error
+	RemoveOrgMember(ctx context.Context, orgID, userID int) error
+	InviteOrgMember(ctx context.Context, orgID int, payload InviteOrgMemberPayload) (*OrgInvitation, error)
+	ListOrgInvitations(ctx context.Context, orgID int) ([]*OrgInvitation, error)
+	RevokeOrgInvitation(ctx context.Context, orgID, invitationID int) error
+	AcceptOrgInvitation(ctx context.Context, token string) (*Organization, error)
+	DeclineOrgInvitation(ctx context.Context, token string) error
+	SwitchOrg(ctx context.Context, orgID *int) (*AuthToken, error)
+	ImportUsers(ctx context.Context, csv io.Reader, dryRun bool) (*UserImportReport, error)
+	ExportUsers(ctx context.Context, filter UserFilter, fn func(*User) error) error
+	BulkUpdateUsers(ctx context.Context, payload BulkUserPayload) (int, error)
+	GetPreferences(ctx context.Context, id int) (*UserPreferences, error)
+	UpdatePreferences(ctx context.Context, id int, prefs Preferences, version int) (*UserPreferences, error)
+	ReadPreferences(ctx context.Context, id int) (*UserPreferences, error)
 }
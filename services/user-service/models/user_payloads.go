@@ -1,17 +1,21 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"github.com/luisVargasGu/stockTracker/common/utils"
+)
 
 type LoginUserPayload struct {
 	Email    string `json:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required,min=8"`
+	IP       string `json:"-"` // Set from the request, used for throttling
 }
 
 type RegisterUserPayload struct {
 	Email    string `json:"email" validate:"required,email"`
 	Name     string `json:"name,omitempty"`
 	Password string `json:"password" validate:"required,min=8"`
-	Avatar   string `json:"avatar,omitempty"` // URL or base64 encoded
 }
 
 type LoginResponse struct {
@@ -19,17 +23,75 @@ type LoginResponse struct {
 	Message string     `json:"message"`
 	User    *UserInfo  `json:"user,omitempty"`
 	Token   *AuthToken `json:"token,omitempty"` // For authentication
+
+	RefreshToken *AuthToken `json:"refreshToken,omitempty"`
+
+	// Set instead of the tokens above when a second factor is still required
+	MFARequired bool       `json:"mfaRequired,omitempty"`
+	MFAToken    *AuthToken `json:"mfaToken,omitempty"`
+}
+
+type RefreshTokenPayload struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+type ForgotPasswordPayload struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordPayload struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8"`
+}
+
+type ChangePasswordPayload struct {
+	CurrentPassword string `json:"currentPassword" validate:"required"`
+	NewPassword     string `json:"newPassword" validate:"required,min=8"`
+}
+
+type LogoutPayload struct {
+	RefreshToken string `json:"refreshToken,omitempty"`
 }
 
 type UpdateUserPayload struct {
-	Name   *string `json:"name,omitempty"`
-	Email  *string `json:"email,omitemty" validate:"email"`
-	Avatar *[]byte `json:"avatar,omitempty"`
+	Name  *string `json:"name,omitempty"`
+	Email *string `json:"email,omitemty" validate:"email"`
+}
+
+// Whether UserFilter matches soft deleted users.
+const (
+	DeletedExclude = "exclude"
+	DeletedInclude = "include"
+	DeletedOnly    = "only"
+)
+
+// UserSortFields are the User JSON fields a user listing can be sorted by.
+var UserSortFields = map[string]bool{
+	"id":        true,
+	"name":      true,
+	"email":     true,
+	"role":      true,
+	"createdAt": true,
+	"updatedAt": true,
+	"lastLogin": true,
+	"deletedAt": true,
 }
 
-type Pagination struct {
-	Offset int
-	Limit  int
+// UserFilter narrows and orders a user listing. Zero values match everything
+// except soft deleted users.
+type UserFilter struct {
+	// Search matches a substring of the name or email, ignoring case
+	Search        string
+	Role          string
+	CreatedFrom   *time.Time
+	CreatedTo     *time.Time
+	LastLoginFrom *time.Time
+	LastLoginTo   *time.Time
+	Deleted       string
+	// SortBy is a field name as in the User JSON, e.g. "lastLogin"
+	SortBy   string
+	SortDesc bool
+	Page     utils.PageRequest
 }
 
 type AuthToken struct {
@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+type APIKeyStore struct {
+	db  *sqlx.DB
+	log *zap.Logger
+}
+
+func NewAPIKeyStore(db *sqlx.DB, logger *zap.Logger) *APIKeyStore {
+	return &APIKeyStore{db: db, log: logger}
+}
+
+const (
+	allAPIKeyFields   = "id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, created_at, revoked_at"
+	createAPIKeyQuery = `INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`
+	listAPIKeysQuery = "SELECT " + allAPIKeyFields + ` FROM api_keys
+		WHERE user_id = $1 ORDER BY created_at DESC`
+	getActiveAPIKeyQuery = "SELECT " + allAPIKeyFields + ` FROM api_keys
+		WHERE key_hash = $1 AND revoked_at IS NULL
+		AND (expires_at IS NULL OR expires_at > NOW())`
+	revokeAPIKeyQuery = `UPDATE api_keys SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	// last_used_at is only precise to the minute to avoid a write per request
+	touchAPIKeyQuery = `UPDATE api_keys SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+	deleteAPIKeysQuery = "DELETE FROM api_keys WHERE user_id = $1"
+)
+
+type apiKeyRow struct {
+	ID         int            `db:"id"`
+	UserID     int            `db:"user_id"`
+	Name       string         `db:"name"`
+	Prefix     string         `db:"prefix"`
+	KeyHash    string         `db:"key_hash"`
+	Scopes     pq.StringArray `db:"scopes"`
+	ExpiresAt  *time.Time     `db:"expires_at"`
+	LastUsedAt *time.Time     `db:"last_used_at"`
+	CreatedAt  time.Time      `db:"created_at"`
+	RevokedAt  *time.Time     `db:"revoked_at"`
+}
+
+func (r apiKeyRow) toModel() *models.APIKey {
+	return &models.APIKey{
+		ID:         r.ID,
+		UserID:     r.UserID,
+		Name:       r.Name,
+		Prefix:     r.Prefix,
+		KeyHash:    r.KeyHash,
+		Scopes:     []string(r.Scopes),
+		ExpiresAt:  r.ExpiresAt,
+		LastUsedAt: r.LastUsedAt,
+		CreatedAt:  r.CreatedAt,
+		RevokedAt:  r.RevokedAt,
+	}
+}
+
+// CreateAPIKey stores a new hashed API key and sets its ID.
+func (s *APIKeyStore) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	err := conn(ctx, s.db).QueryRowxContext(ctx, createAPIKeyQuery,
+		key.UserID, key.Name, key.Prefix, key.KeyHash,
+		pq.StringArray(key.Scopes), key.ExpiresAt, key.CreatedAt,
+	).Scan(&key.ID)
+	if err != nil {
+		s.log.Error("Failed to create API key", zap.Int("userID", key.UserID), zap.Error(err))
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	s.log.Info("API key created", zap.Int("userID", key.UserID), zap.Int("keyID", key.ID))
+	return nil
+}
+
+// ListAPIKeys returns all keys of a user, including revoked and expired ones.
+func (s *APIKeyStore) ListAPIKeys(ctx context.Context, userID int) ([]*models.APIKey, error) {
+	var rows []apiKeyRow
+
+	if err := conn(ctx, s.db).SelectContext(ctx, &rows, listAPIKeysQuery, userID); err != nil {
+		s.log.Error("Error querying API keys", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+
+	keys := make([]*models.APIKey, len(rows))
+	for i, row := range rows {
+		keys[i] = row.toModel()
+	}
+	return keys, nil
+}
+
+// GetActiveAPIKeyByHash looks up an unrevoked, unexpired key by its hash.
+func (s *APIKeyStore) GetActiveAPIKeyByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	var row apiKeyRow
+
+	err := conn(ctx, s.db).GetContext(ctx, &row, getActiveAPIKeyQuery, keyHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, services.ErrAPIKeyNotFound
+		}
+		s.log.Error("Error querying API key", zap.Error(err))
+		return nil, err
+	}
+
+	return row.toModel(), nil
+}
+
+// RevokeAPIKey revokes one of the user's keys.
+func (s *APIKeyStore) RevokeAPIKey(ctx context.Context, userID, keyID int) error {
+	result, err := conn(ctx, s.db).ExecContext(ctx, revokeAPIKeyQuery, keyID, userID)
+	if err != nil {
+		s.log.Error("Failed to revoke API key", zap.Int("keyID", keyID), zap.Error(err))
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return services.ErrAPIKeyNotFound
+	}
+
+	s.log.Info("API key revoked", zap.Int("userID", userID), zap.Int("keyID", keyID))
+	return nil
+}
+
+// TouchAPIKey records that a key was just used.
+func (s *APIKeyStore) TouchAPIKey(ctx context.Context, keyID int) error {
+	if _, err := conn(ctx, s.db).ExecContext(ctx, touchAPIKeyQuery, keyID); err != nil {
+		s.log.Error("Failed to update API key last use", zap.Int("keyID", keyID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// DeleteAPIKeys removes every key of a user, revoked or not.
+func (s *APIKeyStore) DeleteAPIKeys(ctx context.Context, userID int) error {
+	if _, err := conn(ctx, s.db).ExecContext(ctx, deleteAPIKeysQuery, userID); err != nil {
+		s.log.Error("Failed to delete API keys", zap.Int("userID", userID), zap.Error(err))
+		return fmt.Errorf("failed to delete API keys: %w", err)
+	}
+	return nil
+}
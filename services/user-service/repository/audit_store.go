@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/luisVargasGu/stockTracker/common/utils"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"go.uber.org/zap"
+)
+
+// AuditStore appends to the audit log. Entries are never updated, except to
+// redact the personal data of an erased user.
+type AuditStore struct {
+	db  *sqlx.DB
+	log *zap.Logger
+}
+
+func NewAuditStore(db *sqlx.DB, logger *zap.Logger) *AuditStore {
+	return &AuditStore{db: db, log: logger}
+}
+
+const (
+	allAuditFields        = "id, actor_id, client_id, target_id, action, changes, request_id, ip, created_at"
+	createAuditEntryQuery = `INSERT INTO audit_log
+		(actor_id, client_id, target_id, action, changes, request_id, ip, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`
+	listUserAuditEntriesQuery = "SELECT " + allAuditFields + ` FROM audit_log
+		WHERE actor_id = $1 OR target_id = $1
+		ORDER BY created_at, id`
+	redactUserAuditEntriesQuery = `UPDATE audit_log SET changes = NULL, ip = ''
+		WHERE (actor_id = $1 OR target_id = $1) AND (changes IS NOT NULL OR ip <> '')`
+)
+
+type auditRow struct {
+	ID        int       `db:"id"`
+	ActorID   *int      `db:"actor_id"`
+	ClientID  string    `db:"client_id"`
+	TargetID  *int      `db:"target_id"`
+	Action    string    `db:"action"`
+	Changes   []byte    `db:"changes"`
+	RequestID string    `db:"request_id"`
+	IP        string    `db:"ip"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+func (r auditRow) toModel() (*models.AuditEntry, error) {
+	entry := &models.AuditEntry{
+		ID:        r.ID,
+		ActorID:   r.ActorID,
+		ClientID:  r.ClientID,
+		TargetID:  r.TargetID,
+		Action:    r.Action,
+		RequestID: r.RequestID,
+		IP:        r.IP,
+		CreatedAt: r.CreatedAt,
+	}
+	if r.Changes != nil {
+		if err := json.Unmarshal(r.Changes, &entry.Changes); err != nil {
+			return nil, fmt.Errorf("failed to decode changes of audit entry %d: %w", r.ID, err)
+		}
+	}
+	return entry, nil
+}
+
+func toAuditEntries(rows []auditRow) ([]*models.AuditEntry, error) {
+	entries := make([]*models.AuditEntry, 0, len(rows))
+	for _, row := range rows {
+		entry, err := row.toModel()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// CreateAuditEntry appends an entry and sets its ID. Call it with the
+// context of the transaction making the change so both commit together.
+func (s *AuditStore) CreateAuditEntry(ctx context.Context, entry *models.AuditEntry) error {
+	// lib/pq sends []byte as bytea, which jsonb does not accept
+	var changes *string
+	if len(entry.Changes) > 0 {
+		encoded, err := json.Marshal(entry.Changes)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit changes: %w", err)
+		}
+		str := string(encoded)
+		changes = &str
+	}
+
+	err := conn(ctx, s.db).QueryRowxContext(ctx, createAuditEntryQuery,
+		entry.ActorID, entry.ClientID, entry.TargetID, entry.Action,
+		changes, entry.RequestID, entry.IP, entry.CreatedAt,
+	).Scan(&entry.ID)
+	if err != nil {
+		s.log.Error("Failed to write audit entry", zap.String("action", entry.Action), zap.Error(err))
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	return nil
+}
+
+// ListAuditEntries returns a page of entries matching filter, newest first,
+// and their total count if the page request asks for it.
+func (s *AuditStore) ListAuditEntries(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEntry, *utils.Total, error) {
+	var c conditions
+	if filter.ActorID != nil {
+		c.add("actor_id = $%d", *filter.ActorID)
+	}
+	if filter.TargetID != nil {
+		c.add("target_id = $%d", *filter.TargetID)
+	}
+	if filter.Action != "" {
+		c.add("action = $%d", filter.Action)
+	}
+	if filter.From != nil {
+		c.add("created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		c.add("created_at < $%d", *filter.To)
+	}
+
+	total, err := countRows(ctx, conn(ctx, s.db), "audit_log", c, filter.Page.Count)
+	if err != nil {
+		s.log.Error("Error counting audit log", zap.Error(err))
+		return nil, nil, err
+	}
+
+	if filter.Page.Cursor != nil {
+		if err := keysetCondition(&c, "created_at", true, filter.Page.Cursor); err != nil {
+			return nil, nil, err
+		}
+	}
+	query := "SELECT " + allAuditFields + " FROM audit_log" + c.where() +
+		keysetOrder(&c, "created_at", true, filter.Page)
+
+	var rows []auditRow
+	if err := conn(ctx, s.db).SelectContext(ctx, &rows, query, c.args...); err != nil {
+		s.log.Error("Error querying audit log", zap.Error(err))
+		return nil, nil, err
+	}
+
+	entries, err := toAuditEntries(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	return entries, total, nil
+}
+
+// ListUserAuditEntries returns every entry a user is the actor or target of.
+func (s *AuditStore) ListUserAuditEntries(ctx context.Context, userID int) ([]*models.AuditEntry, error) {
+	var rows []auditRow
+	if err := conn(ctx, s.db).SelectContext(ctx, &rows, listUserAuditEntriesQuery, userID); err != nil {
+		s.log.Error("Error querying audit log", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+
+	return toAuditEntries(rows)
+}
+
+// RedactUserAuditEntries removes the changed values and IP addresses from
+// entries about an erased user. That a change happened, and who made it,
+// stays on record.
+func (s *AuditStore) RedactUserAuditEntries(ctx context.Context, userID int) error {
+	if _, err := conn(ctx, s.db).ExecContext(ctx, redactUserAuditEntriesQuery, userID); err != nil {
+		s.log.Error("Failed to redact audit log", zap.Int("userID", userID), zap.Error(err))
+		return fmt.Errorf("failed to redact audit log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+type IdentityStore struct {
+	db  *sqlx.DB
+	log *zap.Logger
+}
+
+func NewIdentityStore(db *sqlx.DB, logger *zap.Logger) *IdentityStore {
+	return &IdentityStore{db: db, log: logger}
+}
+
+const (
+	deleteExpiredOIDCStatesQuery = "DELETE FROM oidc_states WHERE expires_at < NOW()"
+	createOIDCStateQuery         = `INSERT INTO oidc_states
+		(state_hash, provider, code_verifier, nonce, expires_at, created_at)
+		VALUES (:state_hash, :provider, :code_verifier, :nonce, :expires_at, :created_at)`
+	consumeOIDCStateQuery = `DELETE FROM oidc_states
+		WHERE state_hash = $1 AND expires_at > NOW()
+		RETURNING state_hash, provider, code_verifier, nonce, expires_at, created_at`
+	getIdentityQuery = `SELECT id, user_id, provider, subject, email, created_at
+		FROM user_identities WHERE provider = $1 AND subject = $2`
+	linkIdentityQuery = `INSERT INTO user_identities (user_id, provider, subject, email, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`
+	listIdentitiesQuery = `SELECT id, user_id, provider, subject, email, created_at
+		FROM user_identities WHERE user_id = $1 ORDER BY created_at`
+	deleteIdentitiesQuery = "DELETE FROM user_identities WHERE user_id = $1"
+)
+
+// CreateOIDCState stores a pending authorization request and drops expired ones.
+func (s *IdentityStore) CreateOIDCState(ctx context.Context, state *models.OIDCState) error {
+	if _, err := conn(ctx, s.db).ExecContext(ctx, deleteExpiredOIDCStatesQuery); err != nil {
+		s.log.Warn("Failed to delete expired OIDC states", zap.Error(err))
+	}
+
+	if _, err := conn(ctx, s.db).NamedExecContext(ctx, createOIDCStateQuery, state); err != nil {
+		s.log.Error("Failed to create OIDC state", zap.String("provider", state.Provider), zap.Error(err))
+		return fmt.Errorf("failed to create OIDC state: %w", err)
+	}
+	return nil
+}
+
+// ConsumeOIDCState deletes and returns an unexpired authorization request,
+// so that each state can complete at most one login.
+func (s *IdentityStore) ConsumeOIDCState(ctx context.Context, stateHash string) (*models.OIDCState, error) {
+	var state models.OIDCState
+
+	err := conn(ctx, s.db).GetContext(ctx, &state, consumeOIDCStateQuery, stateHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, services.ErrInvalidOIDCState
+		}
+		s.log.Error("Failed to consume OIDC state", zap.Error(err))
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// GetIdentity finds the identity linked to a provider account.
+func (s *IdentityStore) GetIdentity(ctx context.Context, provider, subject string) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+
+	err := conn(ctx, s.db).GetContext(ctx, &identity, getIdentityQuery, provider, subject)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, services.ErrIdentityNotFound
+		}
+		s.log.Error("Error querying identity", zap.String("provider", provider), zap.Error(err))
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+// LinkIdentity links a provider account to a user and sets the identity's ID.
+func (s *IdentityStore) LinkIdentity(ctx context.Context, identity *models.UserIdentity) error {
+	err := conn(ctx, s.db).QueryRowxContext(ctx, linkIdentityQuery,
+		identity.UserID, identity.Provider, identity.Subject, identity.Email, identity.CreatedAt,
+	).Scan(&identity.ID)
+	if err != nil {
+		s.log.Error("Failed to link identity",
+			zap.Int("userID", identity.UserID),
+			zap.String("provider", identity.Provider),
+			zap.Error(err))
+		return fmt.Errorf("failed to link identity: %w", err)
+	}
+
+	s.log.Info("Identity linked", zap.Int("userID", identity.UserID), zap.String("provider", identity.Provider))
+	return nil
+}
+
+// ListIdentities returns the provider accounts linked to a user.
+func (s *IdentityStore) ListIdentities(ctx context.Context, userID int) ([]*models.UserIdentity, error) {
+	var identities []*models.UserIdentity
+
+	if err := conn(ctx, s.db).SelectContext(ctx, &identities, listIdentitiesQuery, userID); err != nil {
+		s.log.Error("Error querying identities", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+// DeleteIdentities unlinks every provider account from a user.
+func (s *IdentityStore) DeleteIdentities(ctx context.Context, userID int) error {
+	if _, err := conn(ctx, s.db).ExecContext(ctx, deleteIdentitiesQuery, userID); err != nil {
+		s.log.Error("Failed to delete identities", zap.Int("userID", userID), zap.Error(err))
+		return fmt.Errorf("failed to delete identities: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+type MFAStore struct {
+	db  *sqlx.DB
+	log *zap.Logger
+}
+
+func NewMFAStore(db *sqlx.DB, logger *zap.Logger) *MFAStore {
+	return &MFAStore{db: db, log: logger}
+}
+
+const (
+	getMFAQuery = `SELECT user_id, secret, enabled_at, last_used_step, created_at
+		FROM user_mfa WHERE user_id = $1`
+	// Re-enrolling replaces a pending secret but never an active one
+	saveMFASecretQuery = `INSERT INTO user_mfa (user_id, secret, created_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, created_at = EXCLUDED.created_at, last_used_step = NULL
+		WHERE user_mfa.enabled_at IS NULL`
+	enableMFAQuery = `UPDATE user_mfa SET enabled_at = NOW()
+		WHERE user_id = $1 AND enabled_at IS NULL`
+	deleteRecoveryCodesQuery = "DELETE FROM mfa_recovery_codes WHERE user_id = $1"
+	insertRecoveryCodeQuery  = "INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)"
+	deleteMFAQuery           = "DELETE FROM user_mfa WHERE user_id = $1"
+	markTOTPStepUsedQuery    = `UPDATE user_mfa SET last_used_step = $2
+		WHERE user_id = $1 AND (last_used_step IS NULL OR last_used_step < $2)`
+	consumeRecoveryCodeQuery = `UPDATE mfa_recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+)
+
+// GetMFA retrieves the MFA enrollment of a user.
+func (s *MFAStore) GetMFA(ctx context.Context, userID int) (*models.UserMFA, error) {
+	var mfa models.UserMFA
+
+	err := conn(ctx, s.db).GetContext(ctx, &mfa, getMFAQuery, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, services.ErrMFANotEnrolled
+		}
+		s.log.Error("Error querying MFA enrollment", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+
+	return &mfa, nil
+}
+
+// SaveMFASecret stores a pending TOTP secret for a user who has not enabled MFA yet.
+func (s *MFAStore) SaveMFASecret(ctx context.Context, userID int, secret string) error {
+	result, err := conn(ctx, s.db).ExecContext(ctx, saveMFASecretQuery, userID, secret)
+	if err != nil {
+		s.log.Error("Failed to save MFA secret", zap.Int("userID", userID), zap.Error(err))
+		return fmt.Errorf("failed to save MFA secret: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return services.ErrMFAAlreadyEnabled
+	}
+
+	return nil
+}
+
+// EnableMFA activates a pending enrollment and replaces the user's recovery codes.
+func (s *MFAStore) EnableMFA(ctx context.Context, userID int, recoveryCodeHashes []string) error {
+	logger := s.log.With(zap.Int("userID", userID))
+
+	tx, err := beginTx(ctx, s.db)
+	if err != nil {
+		logger.Error("Failed to begin transaction", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, enableMFAQuery, userID)
+	if err != nil {
+		logger.Error("Failed to enable MFA", zap.Error(err))
+		return fmt.Errorf("failed to enable MFA: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return services.ErrMFAAlreadyEnabled
+	}
+
+	if _, err := tx.ExecContext(ctx, deleteRecoveryCodesQuery, userID); err != nil {
+		logger.Error("Failed to delete recovery codes", zap.Error(err))
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		if _, err := tx.ExecContext(ctx, insertRecoveryCodeQuery, userID, hash); err != nil {
+			logger.Error("Failed to store recovery code", zap.Error(err))
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	logger.Info("MFA enabled")
+	return nil
+}
+
+// DisableMFA removes the user's TOTP secret and recovery codes.
+func (s *MFAStore) DisableMFA(ctx context.Context, userID int) error {
+	logger := s.log.With(zap.Int("userID", userID))
+
+	tx, err := beginTx(ctx, s.db)
+	if err != nil {
+		logger.Error("Failed to begin transaction", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, deleteRecoveryCodesQuery, userID); err != nil {
+		logger.Error("Failed to delete recovery codes", zap.Error(err))
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, deleteMFAQuery, userID); err != nil {
+		logger.Error("Failed to delete MFA enrollment", zap.Error(err))
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	logger.Info("MFA disabled")
+	return nil
+}
+
+// MarkTOTPStepUsed records the time step of an accepted code. It returns
+// ErrInvalidMFACode if that step, or a later one, was already used.
+func (s *MFAStore) MarkTOTPStepUsed(ctx context.Context, userID int, step int64) error {
+	result, err := conn(ctx, s.db).ExecContext(ctx, markTOTPStepUsedQuery, userID, step)
+	if err != nil {
+		s.log.Error("Failed to record TOTP step", zap.Int("userID", userID), zap.Error(err))
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		s.log.Warn("TOTP code replay rejected", zap.Int("userID", userID))
+		return services.ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+// ConsumeRecoveryCode marks an unused recovery code as used. It returns
+// ErrInvalidMFACode if the code does not exist or was already used.
+func (s *MFAStore) ConsumeRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	result, err := conn(ctx, s.db).ExecContext(ctx, consumeRecoveryCodeQuery, userID, codeHash)
+	if err != nil {
+		s.log.Error("Failed to consume recovery code", zap.Int("userID", userID), zap.Error(err))
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return services.ErrInvalidMFACode
+	}
+
+	s.log.Info("Recovery code used", zap.Int("userID", userID))
+	return nil
+}
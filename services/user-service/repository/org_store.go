@@ -0,0 +1,341 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+type OrgStore struct {
+	db  *sqlx.DB
+	log *zap.Logger
+}
+
+func NewOrgStore(db *sqlx.DB, logger *zap.Logger) *OrgStore {
+	return &OrgStore{db: db, log: logger}
+}
+
+const (
+	createOrgQuery = `INSERT INTO organizations (name, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`
+	getOrgQuery = `SELECT id, name, created_by, created_at, updated_at
+		FROM organizations WHERE id = $1`
+	// Membership changes lock their organization so that two of them cannot
+	// both remove what each thinks is the second to last owner
+	lockOrgQuery      = "SELECT id FROM organizations WHERE id = $1 FOR UPDATE"
+	listUserOrgsQuery = `SELECT o.id, o.name, o.created_by, o.created_at, o.updated_at, m.role
+		FROM organizations o
+		JOIN org_memberships m ON m.org_id = o.id
+		WHERE m.user_id = $1
+		ORDER BY o.name, o.id`
+	deleteOrgQuery = "DELETE FROM organizations WHERE id = $1"
+
+	memberFields = `SELECT m.org_id, m.user_id, u.name, u.email, m.role, m.created_at
+		FROM org_memberships m
+		JOIN Users u ON u.id = m.user_id `
+	getMemberQuery   = memberFields + "WHERE m.org_id = $1 AND m.user_id = $2 AND u.deleted_at IS NULL"
+	listMembersQuery = memberFields + `WHERE m.org_id = $1 AND u.deleted_at IS NULL
+		ORDER BY m.created_at, m.user_id`
+	addMemberQuery = `INSERT INTO org_memberships (org_id, user_id, role, created_at)
+		VALUES ($1, $2, $3, NOW())`
+	setMemberRoleQuery = `UPDATE org_memberships SET role = $3
+		WHERE org_id = $1 AND user_id = $2`
+	removeMemberQuery      = "DELETE FROM org_memberships WHERE org_id = $1 AND user_id = $2"
+	countOwnersQuery       = "SELECT COUNT(*) FROM org_memberships WHERE org_id = $1 AND role = 'owner'"
+	deleteMembershipsQuery = "DELETE FROM org_memberships WHERE user_id = $1"
+
+	allInvitationFields   = "id, org_id, email, role, token_hash, invited_by, expires_at, created_at, accepted_at, declined_at"
+	createInvitationQuery = `INSERT INTO org_invitations (org_id, email, role, token_hash, invited_by, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`
+	listInvitationsQuery = "SELECT " + allInvitationFields + ` FROM org_invitations
+		WHERE org_id = $1 AND accepted_at IS NULL AND declined_at IS NULL AND expires_at > NOW()
+		ORDER BY created_at DESC`
+	getPendingInvitationQuery = "SELECT " + allInvitationFields + ` FROM org_invitations
+		WHERE token_hash = $1 AND accepted_at IS NULL AND declined_at IS NULL AND expires_at > NOW()`
+	acceptInvitationQuery = `UPDATE org_invitations SET accepted_at = NOW()
+		WHERE id = $1 AND accepted_at IS NULL AND declined_at IS NULL AND expires_at > NOW()`
+	declineInvitationQuery = `UPDATE org_invitations SET declined_at = NOW()
+		WHERE id = $1 AND accepted_at IS NULL AND declined_at IS NULL AND expires_at > NOW()`
+	deleteInvitationQuery = "DELETE FROM org_invitations WHERE id = $1 AND org_id = $2"
+)
+
+// CreateOrg stores a new organization, sets its ID and makes ownerID its
+// first owner.
+func (s *OrgStore) CreateOrg(ctx context.Context, org *models.Organization, ownerID int) error {
+	tx, err := beginTx(ctx, s.db)
+	if err != nil {
+		s.log.Error("Failed to begin transaction", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowxContext(ctx, createOrgQuery, org.Name, org.CreatedBy, org.CreatedAt, org.UpdatedAt).Scan(&org.ID)
+	if err != nil {
+		s.log.Error("Failed to create organization", zap.Int("userID", ownerID), zap.Error(err))
+		return fmt.Errorf("failed to create organization: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, addMemberQuery, org.ID, ownerID, models.OrgRoleOwner); err != nil {
+		s.log.Error("Failed to add organization owner", zap.Int("orgID", org.ID), zap.Error(err))
+		return fmt.Errorf("failed to add organization owner: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.log.Info("Organization created", zap.Int("orgID", org.ID), zap.Int("userID", ownerID))
+	return nil
+}
+
+func (s *OrgStore) GetOrg(ctx context.Context, orgID int) (*models.Organization, error) {
+	var org models.Organization
+
+	err := conn(ctx, s.db).GetContext(ctx, &org, getOrgQuery, orgID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, services.ErrOrgNotFound
+		}
+		s.log.Error("Error querying organization", zap.Int("orgID", orgID), zap.Error(err))
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+// LockOrg locks an organization until the surrounding transaction ends.
+func (s *OrgStore) LockOrg(ctx context.Context, orgID int) error {
+	var id int
+
+	err := conn(ctx, s.db).GetContext(ctx, &id, lockOrgQuery, orgID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return services.ErrOrgNotFound
+		}
+		s.log.Error("Failed to lock organization", zap.Int("orgID", orgID), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// ListUserOrgs returns the organizations a user belongs to with their role in each.
+func (s *OrgStore) ListUserOrgs(ctx context.Context, userID int) ([]*models.Organization, error) {
+	orgs := []*models.Organization{}
+
+	if err := conn(ctx, s.db).SelectContext(ctx, &orgs, listUserOrgsQuery, userID); err != nil {
+		s.log.Error("Error querying organizations", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+
+	return orgs, nil
+}
+
+// DeleteOrg deletes an organization along with its memberships and invitations.
+func (s *OrgStore) DeleteOrg(ctx context.Context, orgID int) error {
+	result, err := conn(ctx, s.db).ExecContext(ctx, deleteOrgQuery, orgID)
+	if err != nil {
+		s.log.Error("Failed to delete organization", zap.Int("orgID", orgID), zap.Error(err))
+		return fmt.Errorf("failed to delete organization with id %d: %w", orgID, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return services.ErrOrgNotFound
+	}
+
+	s.log.Info("Organization deleted", zap.Int("orgID", orgID))
+	return nil
+}
+
+// GetMember returns a user's membership of an organization. Deleted users
+// are no longer members.
+func (s *OrgStore) GetMember(ctx context.Context, orgID, userID int) (*models.OrgMember, error) {
+	var member models.OrgMember
+
+	err := conn(ctx, s.db).GetContext(ctx, &member, getMemberQuery, orgID, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, services.ErrOrgMemberNotFound
+		}
+		s.log.Error("Error querying organization member", zap.Int("orgID", orgID), zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+// ListMembers returns the members of an organization, oldest first.
+func (s *OrgStore) ListMembers(ctx context.Context, orgID int) ([]*models.OrgMember, error) {
+	members := []*models.OrgMember{}
+
+	if err := conn(ctx, s.db).SelectContext(ctx, &members, listMembersQuery, orgID); err != nil {
+		s.log.Error("Error querying organization members", zap.Int("orgID", orgID), zap.Error(err))
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func (s *OrgStore) AddMember(ctx context.Context, orgID, userID int, role string) error {
+	if _, err := conn(ctx, s.db).ExecContext(ctx, addMemberQuery, orgID, userID, role); err != nil {
+		if isUniqueViolation(err) {
+			return services.ErrAlreadyOrgMember
+		}
+		s.log.Error("Failed to add organization member", zap.Int("orgID", orgID), zap.Int("userID", userID), zap.Error(err))
+		return fmt.Errorf("failed to add organization member: %w", err)
+	}
+
+	s.log.Info("Organization member added", zap.Int("orgID", orgID), zap.Int("userID", userID), zap.String("role", role))
+	return nil
+}
+
+func (s *OrgStore) SetMemberRole(ctx context.Context, orgID, userID int, role string) error {
+	result, err := conn(ctx, s.db).ExecContext(ctx, setMemberRoleQuery, orgID, userID, role)
+	if err != nil {
+		s.log.Error("Failed to set organization role", zap.Int("orgID", orgID), zap.Int("userID", userID), zap.Error(err))
+		return fmt.Errorf("failed to set organization role: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return services.ErrOrgMemberNotFound
+	}
+	return nil
+}
+
+func (s *OrgStore) RemoveMember(ctx context.Context, orgID, userID int) error {
+	result, err := conn(ctx, s.db).ExecContext(ctx, removeMemberQuery, orgID, userID)
+	if err != nil {
+		s.log.Error("Failed to remove organization member", zap.Int("orgID", orgID), zap.Int("userID", userID), zap.Error(err))
+		return fmt.Errorf("failed to remove organization member: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return services.ErrOrgMemberNotFound
+	}
+
+	s.log.Info("Organization member removed", zap.Int("orgID", orgID), zap.Int("userID", userID))
+	return nil
+}
+
+func (s *OrgStore) CountOwners(ctx context.Context, orgID int) (int, error) {
+	var count int
+	if err := conn(ctx, s.db).GetContext(ctx, &count, countOwnersQuery, orgID); err != nil {
+		s.log.Error("Failed to count organization owners", zap.Int("orgID", orgID), zap.Error(err))
+		return 0, err
+	}
+	return count, nil
+}
+
+// DeleteMemberships removes a user from every organization.
+func (s *OrgStore) DeleteMemberships(ctx context.Context, userID int) error {
+	if _, err := conn(ctx, s.db).ExecContext(ctx, deleteMembershipsQuery, userID); err != nil {
+		s.log.Error("Failed to delete memberships", zap.Int("userID", userID), zap.Error(err))
+		return fmt.Errorf("failed to delete memberships of user with id %d: %w", userID, err)
+	}
+	return nil
+}
+
+// CreateInvitation stores a hashed invitation and sets its ID.
+func (s *OrgStore) CreateInvitation(ctx context.Context, invitation *models.OrgInvitation) error {
+	err := conn(ctx, s.db).QueryRowxContext(ctx, createInvitationQuery,
+		invitation.OrgID, invitation.Email, invitation.Role, invitation.TokenHash,
+		invitation.InvitedBy, invitation.ExpiresAt, invitation.CreatedAt,
+	).Scan(&invitation.ID)
+	if err != nil {
+		s.log.Error("Failed to create invitation", zap.Int("orgID", invitation.OrgID), zap.Error(err))
+		return fmt.Errorf("failed to create invitation: %w", err)
+	}
+	return nil
+}
+
+// ListInvitations returns the pending invitations of an organization.
+func (s *OrgStore) ListInvitations(ctx context.Context, orgID int) ([]*models.OrgInvitation, error) {
+	invitations := []*models.OrgInvitation{}
+
+	if err := conn(ctx, s.db).SelectContext(ctx, &invitations, listInvitationsQuery, orgID); err != nil {
+		s.log.Error("Error querying invitations", zap.Int("orgID", orgID), zap.Error(err))
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// GetPendingInvitationByHash looks up an invitation that was neither
+// answered nor expired.
+func (s *OrgStore) GetPendingInvitationByHash(ctx context.Context, tokenHash string) (*models.OrgInvitation, error) {
+	var invitation models.OrgInvitation
+
+	err := conn(ctx, s.db).GetContext(ctx, &invitation, getPendingInvitationQuery, tokenHash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, services.ErrInvalidInvitation
+		}
+		s.log.Error("Error querying invitation", zap.Error(err))
+		return nil, err
+	}
+
+	return &invitation, nil
+}
+
+// ResolveInvitation marks a pending invitation accepted or declined. It
+// fails with ErrInvalidInvitation if it was answered in the meantime.
+func (s *OrgStore) ResolveInvitation(ctx context.Context, invitationID int, accepted bool) error {
+	query := declineInvitationQuery
+	if accepted {
+		query = acceptInvitationQuery
+	}
+
+	result, err := conn(ctx, s.db).ExecContext(ctx, query, invitationID)
+	if err != nil {
+		s.log.Error("Failed to resolve invitation", zap.Int("invitationID", invitationID), zap.Error(err))
+		return fmt.Errorf("failed to resolve invitation: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return services.ErrInvalidInvitation
+	}
+	return nil
+}
+
+// DeleteInvitation withdraws an invitation of an organization.
+func (s *OrgStore) DeleteInvitation(ctx context.Context, orgID, invitationID int) error {
+	result, err := conn(ctx, s.db).ExecContext(ctx, deleteInvitationQuery, invitationID, orgID)
+	if err != nil {
+		s.log.Error("Failed to delete invitation", zap.Int("invitationID", invitationID), zap.Error(err))
+		return fmt.Errorf("failed to delete invitation: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return services.ErrInvitationNotFound
+	}
+	return nil
+}
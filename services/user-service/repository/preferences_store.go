@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+type PreferencesStore struct {
+	db  *sqlx.DB
+	log *zap.Logger
+}
+
+func NewPreferencesStore(db *sqlx.DB, logger *zap.Logger) *PreferencesStore {
+	return &PreferencesStore{db: db, log: logger}
+}
+
+const (
+	getPreferencesQuery = `SELECT user_id, schema_version, preferences, version, updated_at
+		FROM user_preferences WHERE user_id = $1`
+	upsertPreferencesQuery = `INSERT INTO user_preferences (user_id, schema_version, preferences, version, updated_at)
+		VALUES ($1, $2, $3, 1, NOW())
+		ON CONFLICT (user_id) DO UPDATE
+		SET schema_version = EXCLUDED.schema_version, preferences = EXCLUDED.preferences,
+			version = user_preferences.version + 1, updated_at = EXCLUDED.updated_at
+		RETURNING version, updated_at`
+	updatePreferencesIfVersionQuery = `UPDATE user_preferences
+		SET schema_version = $2, preferences = $3, version = version + 1, updated_at = NOW()
+		WHERE user_id = $1 AND version = $4
+		RETURNING version, updated_at`
+	deletePreferencesQuery = "DELETE FROM user_preferences WHERE user_id = $1"
+)
+
+type preferencesRow struct {
+	UserID        int       `db:"user_id"`
+	SchemaVersion int       `db:"schema_version"`
+	Preferences   []byte    `db:"preferences"`
+	Version       int       `db:"version"`
+	UpdatedAt     time.Time `db:"updated_at"`
+}
+
+// GetPreferences returns the saved preferences of a user. Settings missing
+// from an older layout keep their defaults.
+func (s *PreferencesStore) GetPreferences(ctx context.Context, userID int) (*models.UserPreferences, error) {
+	var row preferencesRow
+	if err := conn(ctx, s.db).GetContext(ctx, &row, getPreferencesQuery, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, services.ErrPreferencesNotFound
+		}
+		s.log.Error("Error querying preferences", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+
+	prefs := &models.UserPreferences{
+		Preferences: models.DefaultPreferences(),
+		Version:     row.Version,
+		UpdatedAt:   &row.UpdatedAt,
+	}
+	if err := json.Unmarshal(row.Preferences, &prefs.Preferences); err != nil {
+		return nil, fmt.Errorf("failed to decode preferences of user %d (schema version %d): %w",
+			userID, row.SchemaVersion, err)
+	}
+	return prefs, nil
+}
+
+// SavePreferences replaces the preferences of a user. A non-zero version
+// makes the save conditional: it fails with ErrVersionConflict unless the
+// saved preferences are still at that version.
+func (s *PreferencesStore) SavePreferences(ctx context.Context, userID int, prefs models.Preferences, version int) (*models.UserPreferences, error) {
+	// lib/pq sends []byte as bytea, which jsonb does not accept
+	encoded, err := json.Marshal(prefs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode preferences: %w", err)
+	}
+
+	query, args := upsertPreferencesQuery, []interface{}{userID, models.PreferencesSchemaVersion, string(encoded)}
+	if version != 0 {
+		query, args = updatePreferencesIfVersionQuery, append(args, version)
+	}
+
+	saved := &models.UserPreferences{Preferences: prefs}
+	var updatedAt time.Time
+	if err := conn(ctx, s.db).QueryRowxContext(ctx, query, args...).Scan(&saved.Version, &updatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, services.ErrVersionConflict
+		}
+		s.log.Error("Failed to save preferences", zap.Int("userID", userID), zap.Error(err))
+		return nil, fmt.Errorf("failed to save preferences of user %d: %w", userID, err)
+	}
+	saved.UpdatedAt = &updatedAt
+
+	s.log.Info("Preferences saved", zap.Int("userID", userID), zap.Int("version", saved.Version))
+	return saved, nil
+}
+
+// DeletePreferences forgets the preferences of a user, who is back to the
+// defaults.
+func (s *PreferencesStore) DeletePreferences(ctx context.Context, userID int) error {
+	if _, err := conn(ctx, s.db).ExecContext(ctx, deletePreferencesQuery, userID); err != nil {
+		s.log.Error("Failed to delete preferences", zap.Int("userID", userID), zap.Error(err))
+		return fmt.Errorf("failed to delete preferences of user %d: %w", userID, err)
+	}
+	return nil
+}
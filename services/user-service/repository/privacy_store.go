@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+type DataExportStore struct {
+	db  *sqlx.DB
+	log *zap.Logger
+}
+
+func NewDataExportStore(db *sqlx.DB, logger *zap.Logger) *DataExportStore {
+	return &DataExportStore{db: db, log: logger}
+}
+
+const (
+	createDataExportQuery = `INSERT INTO data_exports (user_id, status, created_at)
+		VALUES ($1, $2, $3)
+		RETURNING id`
+	getLatestDataExportQuery = `SELECT id, user_id, status, archive, error, created_at, completed_at, expires_at
+		FROM data_exports WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC LIMIT 1`
+	completeDataExportQuery = `UPDATE data_exports
+		SET status = 'ready', archive = $2, expires_at = $3, completed_at = NOW()
+		WHERE id = $1`
+	failDataExportQuery = `UPDATE data_exports
+		SET status = 'failed', error = $2, completed_at = NOW()
+		WHERE id = $1`
+	deleteDataExportsQuery = "DELETE FROM data_exports WHERE user_id = $1"
+)
+
+// CreateDataExport records a pending export and sets its ID.
+func (s *DataExportStore) CreateDataExport(ctx context.Context, export *models.DataExport) error {
+	err := conn(ctx, s.db).QueryRowxContext(ctx, createDataExportQuery,
+		export.UserID, export.Status, export.CreatedAt,
+	).Scan(&export.ID)
+	if err != nil {
+		s.log.Error("Failed to create data export", zap.Int("userID", export.UserID), zap.Error(err))
+		return fmt.Errorf("failed to create data export: %w", err)
+	}
+	return nil
+}
+
+// GetLatestDataExport returns the user's most recent export, whatever its status.
+func (s *DataExportStore) GetLatestDataExport(ctx context.Context, userID int) (*models.DataExport, error) {
+	var export models.DataExport
+
+	err := conn(ctx, s.db).GetContext(ctx, &export, getLatestDataExportQuery, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, services.ErrDataExportNotFound
+		}
+		s.log.Error("Error querying data export", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+// CompleteDataExport stores the finished archive.
+func (s *DataExportStore) CompleteDataExport(ctx context.Context, id int, archive []byte, expiresAt time.Time) error {
+	if _, err := conn(ctx, s.db).ExecContext(ctx, completeDataExportQuery, id, archive, expiresAt); err != nil {
+		s.log.Error("Failed to complete data export", zap.Int("exportID", id), zap.Error(err))
+		return fmt.Errorf("failed to complete data export: %w", err)
+	}
+	return nil
+}
+
+// FailDataExport records why an export could not be built.
+func (s *DataExportStore) FailDataExport(ctx context.Context, id int, reason string) error {
+	if _, err := conn(ctx, s.db).ExecContext(ctx, failDataExportQuery, id, reason); err != nil {
+		s.log.Error("Failed to mark data export failed", zap.Int("exportID", id), zap.Error(err))
+		return fmt.Errorf("failed to mark data export failed: %w", err)
+	}
+	return nil
+}
+
+// DeleteDataExports removes every export of a user.
+func (s *DataExportStore) DeleteDataExports(ctx context.Context, userID int) error {
+	if _, err := conn(ctx, s.db).ExecContext(ctx, deleteDataExportsQuery, userID); err != nil {
+		s.log.Error("Failed to delete data exports", zap.Int("userID", userID), zap.Error(err))
+		return fmt.Errorf("failed to delete data exports: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/luisVargasGu/stockTracker/common/utils"
+)
+
+// Below this many rows an estimated count is replaced by an exact one,
+// which is cheap at that size.
+const exactCountThreshold = 10000
+
+// conditions builds a WHERE clause with numbered parameters.
+type conditions struct {
+	clauses []string
+	args    []interface{}
+}
+
+// add appends a condition. Its format refers to each parameter with %d, or
+// %[n]d to use one more than once.
+func (c *conditions) add(format string, args ...interface{}) {
+	numbers := make([]interface{}, len(args))
+	for i, arg := range args {
+		numbers[i] = c.param(arg)
+	}
+	c.clauses = append(c.clauses, fmt.Sprintf(format, numbers...))
+}
+
+// addRaw appends a condition without parameters.
+func (c *conditions) addRaw(clause string) {
+	c.clauses = append(c.clauses, clause)
+}
+
+// param adds a parameter outside the WHERE clause and returns its number.
+func (c *conditions) param(arg interface{}) int {
+	c.args = append(c.args, arg)
+	return len(c.args)
+}
+
+// where returns the WHERE clause, or nothing if there are no conditions.
+func (c *conditions) where() string {
+	if len(c.clauses) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(c.clauses, " AND ")
+}
+
+// likePattern matches s anywhere in a string with ILIKE.
+func likePattern(s string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+	return "%" + escaped + "%"
+}
+
+// keysetCondition restricts a query to the rows after cursor in a list
+// ordered by sortExpr and then id, both ascending unless desc.
+func keysetCondition(c *conditions, sortExpr string, desc bool, cursor *utils.Cursor) error {
+	if len(cursor.Values) != 2 {
+		return utils.ErrInvalidCursor
+	}
+
+	// Going forward in ascending order, or back in descending order, means
+	// towards greater values
+	op := "<"
+	if cursor.Before == desc {
+		op = ">"
+	}
+	c.add(fmt.Sprintf("(%s, id) %s ($%%d, $%%d)", sortExpr, op), cursor.Values[0], cursor.Values[1])
+	return nil
+}
+
+// keysetOrder returns the ORDER BY and LIMIT of a keyset page. Pages before a
+// cursor are queried in reverse and put back in order by utils.BuildPage.
+// One row more than the limit is fetched to tell whether another page follows.
+func keysetOrder(c *conditions, sortExpr string, desc bool, page utils.PageRequest) string {
+	if page.Cursor != nil && page.Cursor.Before {
+		desc = !desc
+	}
+	direction := "ASC"
+	if desc {
+		direction = "DESC"
+	}
+	return fmt.Sprintf(" ORDER BY %[1]s %[2]s, id %[2]s LIMIT $%[3]d", sortExpr, direction, c.param(page.Limit+1))
+}
+
+// countRows counts the rows of table matching c as mode asks, or returns
+// nil if it asks for no count.
+func countRows(ctx context.Context, q queryer, table string, c conditions, mode string) (*utils.Total, error) {
+	switch mode {
+	case utils.CountNone:
+		return nil, nil
+	case utils.CountEstimate:
+		estimate, err := estimateRows(ctx, q, "SELECT 1 FROM "+table+c.where(), c.args)
+		if err != nil {
+			return nil, err
+		}
+		if estimate >= exactCountThreshold {
+			return &utils.Total{Count: estimate, Estimated: true}, nil
+		}
+	}
+
+	var count int64
+	if err := q.GetContext(ctx, &count, "SELECT COUNT(*) FROM "+table+c.where(), c.args...); err != nil {
+		return nil, err
+	}
+	return &utils.Total{Count: count}, nil
+}
+
+// estimateRows returns the planner's estimate of the rows query returns.
+func estimateRows(ctx context.Context, q queryer, query string, args []interface{}) (int64, error) {
+	var explained string
+	if err := q.GetContext(ctx, &explained, "EXPLAIN (FORMAT JSON) "+query, args...); err != nil {
+		return 0, err
+	}
+
+	var plans []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(explained), &plans); err != nil || len(plans) == 0 {
+		return 0, fmt.Errorf("unexpected query plan: %s", explained)
+	}
+	return int64(plans[0].Plan.Rows), nil
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/luisVargasGu/stockTracker/common/utils"
+)
+
+func TestKeysetCondition(t *testing.T) {
+	tests := []struct {
+		name   string
+		desc   bool
+		before bool
+		want   string
+	}{
+		{"ascending forward", false, false, "(name, id) > ($2, $3)"},
+		{"ascending backward", false, true, "(name, id) < ($2, $3)"},
+		{"descending forward", true, false, "(name, id) < ($2, $3)"},
+		{"descending backward", true, true, "(name, id) > ($2, $3)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c conditions
+			c.add("role = $%d", "admin")
+			cursor := &utils.Cursor{Values: []string{"Ada", "7"}, Before: tt.before}
+
+			if err := keysetCondition(&c, "name", tt.desc, cursor); err != nil {
+				t.Fatalf("keysetCondition: %v", err)
+			}
+			// The row comparison breaks ties on the sort key by id
+			if got := c.clauses[1]; got != tt.want {
+				t.Errorf("condition = %q, want %q", got, tt.want)
+			}
+			if want := []interface{}{"admin", "Ada", "7"}; !reflect.DeepEqual(c.args, want) {
+				t.Errorf("args = %v, want %v", c.args, want)
+			}
+		})
+	}
+}
+
+func TestKeysetConditionRejectsMalformedCursor(t *testing.T) {
+	for _, values := range [][]string{nil, {"Ada"}, {"Ada", "7", "extra"}} {
+		var c conditions
+		err := keysetCondition(&c, "name", false, &utils.Cursor{Values: values})
+		if !errors.Is(err, utils.ErrInvalidCursor) {
+			t.Errorf("keysetCondition(%v) = %v, want ErrInvalidCursor", values, err)
+		}
+	}
+}
+
+func TestKeysetOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		desc bool
+		page utils.PageRequest
+		want string
+	}{
+		{"first page ascending", false, utils.PageRequest{Limit: 20}, " ORDER BY name ASC, id ASC LIMIT $1"},
+		{"first page descending", true, utils.PageRequest{Limit: 20}, " ORDER BY name DESC, id DESC LIMIT $1"},
+		{"backward ascending", false, utils.PageRequest{Limit: 20, Cursor: &utils.Cursor{Before: true}}, " ORDER BY name DESC, id DESC LIMIT $1"},
+		{"backward descending", true, utils.PageRequest{Limit: 20, Cursor: &utils.Cursor{Before: true}}, " ORDER BY name ASC, id ASC LIMIT $1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c conditions
+			if got := keysetOrder(&c, "name", tt.desc, tt.page); got != tt.want {
+				t.Errorf("keysetOrder = %q, want %q", got, tt.want)
+			}
+			// One extra row tells whether another page follows
+			if len(c.args) != 1 || c.args[0] != 21 {
+				t.Errorf("args = %v, want [21]", c.args)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+type RoleStore struct {
+	db  *sqlx.DB
+	log *zap.Logger
+}
+
+func NewRoleStore(db *sqlx.DB, logger *zap.Logger) *RoleStore {
+	return &RoleStore{db: db, log: logger}
+}
+
+const (
+	getRolesBase = `SELECT r.name, r.description,
+			COALESCE(array_agg(rp.permission ORDER BY rp.permission)
+				FILTER (WHERE rp.permission IS NOT NULL), '{}') AS permissions
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role = r.name `
+	listRolesQuery = getRolesBase + "GROUP BY r.name, r.description ORDER BY r.name"
+	getRoleQuery   = getRolesBase + "WHERE r.name = $1 GROUP BY r.name, r.description"
+)
+
+type roleRow struct {
+	Name        string         `db:"name"`
+	Description string         `db:"description"`
+	Permissions pq.StringArray `db:"permissions"`
+}
+
+func (r roleRow) toModel() *models.Role {
+	return &models.Role{
+		Name:        r.Name,
+		Description: r.Description,
+		Permissions: []string(r.Permissions),
+	}
+}
+
+// ListRoles returns every role along with its permissions.
+func (s *RoleStore) ListRoles(ctx context.Context) ([]*models.Role, error) {
+	var rows []roleRow
+
+	if err := conn(ctx, s.db).SelectContext(ctx, &rows, listRolesQuery); err != nil {
+		s.log.Error("Error querying roles", zap.Error(err))
+		return nil, err
+	}
+
+	roles := make([]*models.Role, len(rows))
+	for i, row := range rows {
+		roles[i] = row.toModel()
+	}
+	return roles, nil
+}
+
+// GetRole retrieves a role and its permissions by name.
+func (s *RoleStore) GetRole(ctx context.Context, name string) (*models.Role, error) {
+	var row roleRow
+
+	err := conn(ctx, s.db).GetContext(ctx, &row, getRoleQuery, name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, services.ErrRoleNotFound
+		}
+		s.log.Error("Error querying role", zap.String("role", name), zap.Error(err))
+		return nil, err
+	}
+
+	return row.toModel(), nil
+}
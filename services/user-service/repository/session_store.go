@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+// Sessions share the TokenStore because revoking one must revoke its tokens
+// in the same transaction.
+
+const (
+	createSessionQuery = `INSERT INTO sessions (id, user_id, user_agent, ip, created_at, last_seen_at)
+		VALUES (:id, :user_id, :user_agent, :ip, :created_at, :last_seen_at)`
+	touchSessionQuery = `UPDATE sessions SET last_seen_at = NOW(), ip = $2, user_agent = $3
+		WHERE id = $1 AND revoked_at IS NULL`
+	// A session is active while it still holds a usable refresh token
+	listSessionsQuery = `SELECT s.id, s.user_id, s.user_agent, s.ip, s.created_at, s.last_seen_at, s.revoked_at
+		FROM sessions s
+		WHERE s.user_id = $1 AND s.revoked_at IS NULL
+		AND EXISTS (
+			SELECT 1 FROM refresh_tokens rt
+			WHERE rt.family_id = s.id AND rt.revoked_at IS NULL
+			AND rt.rotated_at IS NULL AND rt.expires_at > NOW()
+		)
+		ORDER BY s.last_seen_at DESC`
+	revokeSessionQuery = `UPDATE sessions SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	revokeSessionByIDQuery = `UPDATE sessions SET revoked_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL`
+	listSessionHistoryQuery = `SELECT id, user_id, user_agent, ip, created_at, last_seen_at, revoked_at
+		FROM sessions WHERE user_id = $1 ORDER BY created_at DESC`
+	deleteSessionsQuery = "DELETE FROM sessions WHERE user_id = $1"
+	setSessionOrgQuery  = `UPDATE sessions SET active_org_id = $2
+		WHERE id = $1 AND revoked_at IS NULL`
+	getSessionOrgQuery = "SELECT active_org_id FROM sessions WHERE id = $1"
+)
+
+// CreateSession records a new login.
+func (s *TokenStore) CreateSession(ctx context.Context, session *models.Session) error {
+	if _, err := conn(ctx, s.db).NamedExecContext(ctx, createSessionQuery, session); err != nil {
+		s.log.Error("Failed to create session", zap.Int("userID", session.UserID), zap.Error(err))
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+// TouchSession records that a session was just used and from where.
+func (s *TokenStore) TouchSession(ctx context.Context, sessionID, ip, userAgent string) error {
+	if _, err := conn(ctx, s.db).ExecContext(ctx, touchSessionQuery, sessionID, ip, userAgent); err != nil {
+		s.log.Error("Failed to update session", zap.String("sessionID", sessionID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// SetSessionOrg sets the organization a session acts for, nil for none.
+func (s *TokenStore) SetSessionOrg(ctx context.Context, sessionID string, orgID *int) error {
+	result, err := conn(ctx, s.db).ExecContext(ctx, setSessionOrgQuery, sessionID, orgID)
+	if err != nil {
+		s.log.Error("Failed to set session organization", zap.String("sessionID", sessionID), zap.Error(err))
+		return fmt.Errorf("failed to set session organization: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return services.ErrSessionNotFound
+	}
+	return nil
+}
+
+// GetSessionOrg returns the organization a session acts for, nil for none.
+func (s *TokenStore) GetSessionOrg(ctx context.Context, sessionID string) (*int, error) {
+	var orgID *int
+
+	err := conn(ctx, s.db).GetContext(ctx, &orgID, getSessionOrgQuery, sessionID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, services.ErrSessionNotFound
+		}
+		s.log.Error("Error querying session organization", zap.String("sessionID", sessionID), zap.Error(err))
+		return nil, err
+	}
+
+	return orgID, nil
+}
+
+// ListSessions returns the user's active sessions, most recently used first.
+func (s *TokenStore) ListSessions(ctx context.Context, userID int) ([]*models.Session, error) {
+	var sessions []*models.Session
+
+	if err := conn(ctx, s.db).SelectContext(ctx, &sessions, listSessionsQuery, userID); err != nil {
+		s.log.Error("Error querying sessions", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession ends one of the user's sessions. Its refresh tokens are
+// revoked and its access tokens fail the sid check in IsRevoked.
+func (s *TokenStore) RevokeSession(ctx context.Context, userID int, sessionID string) error {
+	logger := s.log.With(zap.Int("userID", userID), zap.String("sessionID", sessionID))
+
+	tx, err := beginTx(ctx, s.db)
+	if err != nil {
+		logger.Error("Failed to begin transaction", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, revokeSessionQuery, sessionID, userID)
+	if err != nil {
+		logger.Error("Failed to revoke session", zap.Error(err))
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return services.ErrSessionNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, revokeTokenFamilyQuery, sessionID); err != nil {
+		logger.Error("Failed to revoke session tokens", zap.Error(err))
+		return fmt.Errorf("failed to revoke session tokens: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	logger.Info("Session revoked")
+	return nil
+}
+
+// ListSessionHistory returns every session of a user, including ended ones.
+func (s *TokenStore) ListSessionHistory(ctx context.Context, userID int) ([]*models.Session, error) {
+	var sessions []*models.Session
+
+	if err := conn(ctx, s.db).SelectContext(ctx, &sessions, listSessionHistoryQuery, userID); err != nil {
+		s.log.Error("Error querying session history", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteSessions removes the session history of a user. Revoke the user's
+// tokens first; access tokens of a deleted session no longer fail the sid
+// check in IsRevoked.
+func (s *TokenStore) DeleteSessions(ctx context.Context, userID int) error {
+	if _, err := conn(ctx, s.db).ExecContext(ctx, deleteSessionsQuery, userID); err != nil {
+		s.log.Error("Failed to delete sessions", zap.Int("userID", userID), zap.Error(err))
+		return fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	return nil
+}
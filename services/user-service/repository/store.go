@@ -6,10 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
 
+	"github.com/luisVargasGu/stockTracker/common/utils"
 	"github.com/luisVargasGu/stockTracker/services/user-service/models"
 	"github.com/luisVargasGu/stockTracker/services/user-service/services"
 	"go.uber.org/zap"
@@ -26,33 +28,102 @@ func NewUserStore(db *sqlx.DB, logger *zap.Logger) *UserStore {
 
 const (
 	createUserQuery = `INSERT INTO Users 
-		(name, email, role, password_hash, avatar, last_login, updated_at, created_at) 
-		VALUES (:name, :email, :role, :password_hash, :avatar, :last_login, :updated_at, :created_at) 
-		RETURNING ID`
-	allUserFields       = "id, name, email, role, avatar, last_login, updated_at, created_at, deleted_at"
-	getUserByBase       = "SELECT " + allUserFields + " FROM Users "
-	getUserByEmailQuery = getUserByBase + "WHERE email = $1"
-	getUserByIDQuery    = getUserByBase + "WHERE id = $1"
+		(name, email, role, password_hash, avatar_key, last_login, updated_at, created_at) 
+		VALUES (:name, :email, :role, :password_hash, :avatar_key, :last_login, :updated_at, :created_at) 
+		RETURNING id, version`
+	allUserFields = "id, name, email, role, password_hash, avatar_key, last_login, updated_at, created_at, deleted_at, disabled_at, email_verified_at, pending_email, failed_login_attempts, locked_until, version"
+	getUserByBase = "SELECT " + allUserFields + " FROM Users "
+	// Soft deleted users are invisible to every query unless stated otherwise.
+	// Emails compare case-insensitively, as mail providers treat them.
+	getUserByEmailQuery = getUserByBase + "WHERE LOWER(email) = LOWER($1) AND deleted_at IS NULL"
+	getUserByIDQuery    = getUserByBase + "WHERE id = $1 AND deleted_at IS NULL"
+
+	addPasswordHistoryQuery = `INSERT INTO password_history (user_id, password_hash)
+		VALUES ($1, $2)`
+	getPasswordHistoryQuery = `SELECT password_hash FROM password_history
+		WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2`
+
+	// Once attempts reach the threshold every further failure locks the
+	// account for base * 2^(attempts - threshold) seconds, capped at max.
+	recordFailedLoginQuery = `UPDATE Users SET
+			failed_login_attempts = failed_login_attempts + 1,
+			locked_until = CASE
+				WHEN failed_login_attempts + 1 >= $2
+				THEN NOW() + LEAST($3 * power(2, failed_login_attempts + 1 - $2), $4) * INTERVAL '1 second'
+				ELSE locked_until
+			END
+		WHERE id = $1
+		RETURNING locked_until`
+	recordSuccessfulLoginQuery = `UPDATE Users
+		SET failed_login_attempts = 0, locked_until = NULL, last_login = NOW()
+		WHERE id = $1`
+	userExistsQuery = "SELECT EXISTS (SELECT 1 FROM Users WHERE id = $1 AND deleted_at IS NULL)"
+	// Mirrors idx_users_email_live: an email belongs to at most one live user
+	emailTakenQuery = "SELECT EXISTS (SELECT 1 FROM Users WHERE LOWER(email) = LOWER($1) AND deleted_at IS NULL)"
+	unlockUserQuery = `UPDATE Users
+		SET failed_login_attempts = 0, locked_until = NULL
+		WHERE id = $1 AND deleted_at IS NULL`
+	// Every change to the profile increments version, login bookkeeping does not
+	setUserRoleQuery = `UPDATE Users SET role = $2, updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL`
+	softDeleteUserQuery = `UPDATE Users SET deleted_at = NOW(), updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL`
+	setUserDisabledQuery = `UPDATE Users
+		SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, NOW()) END, updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL`
+	restoreUserQuery = `UPDATE Users SET deleted_at = NULL, updated_at = NOW(), version = version + 1
+		WHERE id = $1 AND deleted_at IS NOT NULL AND erased_at IS NULL`
+	purgeDeletedUsersQuery = "DELETE FROM Users WHERE deleted_at < $1 RETURNING " + allUserFields
+	// Reads the key in the same statement that clears it, for users in any state
+	clearAvatarQuery = `UPDATE Users SET avatar_key = NULL, updated_at = NOW(), version = version + 1
+		FROM (SELECT id, avatar_key FROM Users WHERE id = $1 FOR UPDATE) AS old
+		WHERE Users.id = old.id
+		RETURNING old.avatar_key`
+	// Erased users keep their row, and ID, until purged so that references
+	// from other services stay valid. Everything identifying goes now.
+	anonymizeUserQuery = `WITH
+			history AS (DELETE FROM password_history WHERE user_id = $1),
+			resets AS (DELETE FROM password_reset_tokens WHERE user_id = $1),
+			verifications AS (DELETE FROM email_verification_tokens WHERE user_id = $1)
+		UPDATE Users SET
+			name = 'Deleted user',
+			email = 'erased-' || id || '@invalid',
+			password_hash = $2,
+			avatar_key = NULL,
+			pending_email = NULL,
+			email_verified_at = NULL,
+			failed_login_attempts = 0,
+			locked_until = NULL,
+			deleted_at = COALESCE(deleted_at, NOW()),
+			erased_at = COALESCE(erased_at, NOW()),
+			updated_at = NOW(),
+			version = version + 1
+		WHERE id = $1`
 )
 
 // CreateUser inserts a new user into the database.
 func (s *UserStore) CreateUser(ctx context.Context, user *models.User) (*models.User, error) {
 	// Check if user already exists
-	var count int
-	err := s.db.GetContext(ctx, &count, "SELECT COUNT(*) FROM Users WHERE email = $1", user.Email)
+	var taken bool
+	err := conn(ctx, s.db).GetContext(ctx, &taken, emailTakenQuery, user.Email)
 	if err != nil {
 		s.log.Error("Error querying database for existing user", zap.Error(err))
 		return nil, err
 	}
 
-	if count > 0 {
+	if taken {
 		s.log.Warn("User already exists", zap.String("email", user.Email))
 		return nil, services.ErrUserAlreadyExists
 	}
 
 	// Insert new user
-	rows, err := s.db.NamedQueryContext(ctx, createUserQuery, user)
+	rows, err := sqlx.NamedQueryContext(ctx, conn(ctx, s.db), createUserQuery, user)
 	if err != nil {
+		// Lost a race with a concurrent registration
+		if isUniqueViolation(err) {
+			s.log.Warn("User already exists", zap.String("email", user.Email))
+			return nil, services.ErrUserAlreadyExists
+		}
 		s.log.Error("Error creating user", zap.String("email", user.Email), zap.Error(err))
 		return nil, err
 	}
@@ -60,7 +131,7 @@ func (s *UserStore) CreateUser(ctx context.Context, user *models.User) (*models.
 
 	// Scan the returned ID into the user struct
 	if rows.Next() {
-		err = rows.Scan(&user.ID)
+		err = rows.Scan(&user.ID, &user.Version)
 		if err != nil {
 			s.log.Error("Error scanning user ID", zap.Error(err))
 			return nil, err
@@ -75,7 +146,7 @@ func (s *UserStore) CreateUser(ctx context.Context, user *models.User) (*models.
 func (s *UserStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
 	var user models.User
 
-	err := s.db.GetContext(ctx, &user, getUserByEmailQuery, email)
+	err := conn(ctx, s.db).GetContext(ctx, &user, getUserByEmailQuery, email)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			s.log.Warn("User not found", zap.String("email", email))
@@ -93,7 +164,7 @@ func (s *UserStore) GetUserByEmail(ctx context.Context, email string) (*models.U
 func (s *UserStore) GetUserByID(ctx context.Context, userID int) (*models.User, error) {
 	var user models.User
 
-	err := s.db.GetContext(ctx, &user, getUserByIDQuery, userID)
+	err := conn(ctx, s.db).GetContext(ctx, &user, getUserByIDQuery, userID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			s.log.Warn("User not found", zap.Int("userID", userID))
@@ -107,25 +178,92 @@ func (s *UserStore) GetUserByID(ctx context.Context, userID int) (*models.User,
 	return &user, nil
 }
 
-// GetUsers retrieves multiple users
-func (s *UserStore) GetUsers(ctx context.Context, offset, limit int) ([]*models.User, int, error) {
-	const query = getUserByBase + `
-		ORDER BY created_at DESC
-		LIMIT $1 OFFSET $2
-	`
+// userSortColumns maps the sortable User JSON fields to their columns.
+// Nullable columns are coalesced since keyset comparisons skip NULLs;
+// last_login is NOT NULL.
+var userSortColumns = map[string]string{
+	"id":        "id",
+	"name":      "name",
+	"email":     "email",
+	"role":      "role",
+	"createdAt": "created_at",
+	"updatedAt": "updated_at",
+	"lastLogin": "last_login",
+	"deletedAt": "COALESCE(deleted_at, '-infinity'::timestamp)",
+}
 
-	var users []*models.User
-	err := s.db.SelectContext(ctx, &users, query, limit, offset)
+// GetUsers retrieves a page of users matching filter, and their total count
+// if the page request asks for it.
+func (s *UserStore) GetUsers(ctx context.Context, filter models.UserFilter) ([]*models.User, *utils.Total, error) {
+	var c conditions
+	switch filter.Deleted {
+	case models.DeletedInclude:
+	case models.DeletedOnly:
+		c.addRaw("deleted_at IS NOT NULL")
+	default:
+		c.addRaw("deleted_at IS NULL")
+	}
+	// Served by the trigram indexes on name and email
+	if filter.Search != "" {
+		c.add("(name ILIKE $%[1]d OR email ILIKE $%[1]d)", likePattern(filter.Search))
+	}
+	if filter.Role != "" {
+		c.add("role = $%d", filter.Role)
+	}
+	if filter.CreatedFrom != nil {
+		c.add("created_at >= $%d", *filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		c.add("created_at < $%d", *filter.CreatedTo)
+	}
+	if filter.LastLoginFrom != nil {
+		c.add("last_login >= $%d", *filter.LastLoginFrom)
+	}
+	if filter.LastLoginTo != nil {
+		c.add("last_login < $%d", *filter.LastLoginTo)
+	}
+
+	total, err := countRows(ctx, conn(ctx, s.db), "Users", c, filter.Page.Count)
 	if err != nil {
-		s.log.Error("Error querying users", zap.Int("offset", offset), zap.Int("limit", limit))
-		return nil, 0, err
+		s.log.Error("Error counting users", zap.Error(err))
+		return nil, nil, err
+	}
+
+	// The sort expression comes from the whitelist above, never from input
+	sortExpr, ok := userSortColumns[filter.SortBy]
+	if !ok {
+		sortExpr = "created_at"
+	}
+	if filter.Page.Cursor != nil {
+		if err := keysetCondition(&c, sortExpr, filter.SortDesc, filter.Page.Cursor); err != nil {
+			return nil, nil, err
+		}
+	}
+	query := getUserByBase + c.where() + keysetOrder(&c, sortExpr, filter.SortDesc, filter.Page)
+
+	var users []*models.User
+	if err := conn(ctx, s.db).SelectContext(ctx, &users, query, c.args...); err != nil {
+		s.log.Error("Error querying users", zap.Int("limit", filter.Page.Limit), zap.Error(err))
+		return nil, nil, err
 	}
 
-	s.log.Info("Users retrieved successfully", zap.Int("count", len(users)), zap.Int("offset", offset), zap.Int("limit", limit))
-	return users, len(users), nil
+	s.log.Info("Users retrieved successfully", zap.Int("count", len(users)), zap.Int("limit", filter.Page.Limit))
+	return users, total, nil
 }
 
 func (s *UserStore) UpdateUser(ctx context.Context, id int, updates map[string]interface{}) (*models.User, error) {
+	return s.updateUser(ctx, id, 0, updates)
+}
+
+// UpdateUserIfVersion updates a user only if it is still at the given
+// version, and fails with ErrVersionConflict if someone else changed it.
+func (s *UserStore) UpdateUserIfVersion(ctx context.Context, id, version int, updates map[string]interface{}) (*models.User, error) {
+	return s.updateUser(ctx, id, version, updates)
+}
+
+// updateUser applies updates and increments the version of the user. A
+// version of 0 updates whatever version the user is at.
+func (s *UserStore) updateUser(ctx context.Context, id, version int, updates map[string]interface{}) (*models.User, error) {
 	logger := s.log.With(zap.Int("ID", id))
 
 	// Validate input
@@ -136,10 +274,13 @@ func (s *UserStore) UpdateUser(ctx context.Context, id int, updates map[string]i
 
 	// Strict field validation
 	validFields := map[string]bool{
-		"email":      true,
-		"updated_at": true,
-		"avatar":     true,
-		"name":       true,
+		"email":             true,
+		"password_hash":     true,
+		"updated_at":        true,
+		"avatar_key":        true,
+		"name":              true,
+		"email_verified_at": true,
+		"pending_email":     true,
 	}
 
 	// Prepare query builder
@@ -164,15 +305,30 @@ func (s *UserStore) UpdateUser(ctx context.Context, id int, updates map[string]i
 		i++
 	}
 
-	queryBuilder.WriteString(fmt.Sprintf(" WHERE id = $%d RETURNING "+allUserFields, i))
+	queryBuilder.WriteString(fmt.Sprintf(", version = version + 1 WHERE id = $%d AND deleted_at IS NULL", i))
 	args = append(args, id)
+	if version != 0 {
+		queryBuilder.WriteString(fmt.Sprintf(" AND version = $%d", i+1))
+		args = append(args, version)
+	}
+	queryBuilder.WriteString(" RETURNING " + allUserFields)
 
 	// Use QueryRowContext for single row return
 	var updatedUser models.User
-	err := s.db.GetContext(ctx, &updatedUser, queryBuilder.String(), args...)
+	err := conn(ctx, s.db).GetContext(ctx, &updatedUser, queryBuilder.String(), args...)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
+			if version != 0 {
+				var exists bool
+				if err := conn(ctx, s.db).GetContext(ctx, &exists, userExistsQuery, id); err != nil {
+					return nil, err
+				}
+				if exists {
+					logger.Info("Update lost to a concurrent change", zap.Int("version", version))
+					return nil, services.ErrVersionConflict
+				}
+			}
 			logger.Warn("No user found for update", zap.Int("ID", id))
 			return nil, services.ErrUserNotFound
 		}
@@ -194,11 +350,12 @@ func (s *UserStore) UpdateUser(ctx context.Context, id int, updates map[string]i
 	return &updatedUser, nil
 }
 
+// DeleteUser soft deletes a user. The row is kept until PurgeDeletedUsers
+// removes it, so the account can be restored in the meantime.
 func (s *UserStore) DeleteUser(ctx context.Context, id int) error {
 	logger := s.log.With(zap.Int("ID", id))
 
-	query := "DELETE FROM Users WHERE id = $1"
-	result, err := s.db.ExecContext(ctx, query, id)
+	result, err := conn(ctx, s.db).ExecContext(ctx, softDeleteUserQuery, id)
 	if err != nil {
 		logger.Error("Failed to delete user", zap.Error(err))
 		return fmt.Errorf("failed to delete user with id %d: %w", id, err)
@@ -218,3 +375,193 @@ func (s *UserStore) DeleteUser(ctx context.Context, id int) error {
 	logger.Info("User successfully deleted")
 	return nil
 }
+
+// AddPasswordHistory records a password hash so that it cannot be reused later.
+func (s *UserStore) AddPasswordHistory(ctx context.Context, userID int, passwordHash string) error {
+	if _, err := conn(ctx, s.db).ExecContext(ctx, addPasswordHistoryQuery, userID, passwordHash); err != nil {
+		s.log.Error("Failed to record password history", zap.Int("userID", userID), zap.Error(err))
+		return fmt.Errorf("failed to record password history: %w", err)
+	}
+	return nil
+}
+
+// GetPasswordHistory returns up to limit of the user's most recent password hashes.
+func (s *UserStore) GetPasswordHistory(ctx context.Context, userID int, limit int) ([]string, error) {
+	var hashes []string
+	if err := conn(ctx, s.db).SelectContext(ctx, &hashes, getPasswordHistoryQuery, userID, limit); err != nil {
+		s.log.Error("Failed to query password history", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// RecordFailedLogin counts a failed login and returns the time until which the
+// account is locked, or nil if it is not locked.
+func (s *UserStore) RecordFailedLogin(ctx context.Context, userID int, threshold int, base, max time.Duration) (*time.Time, error) {
+	var lockedUntil *time.Time
+
+	err := conn(ctx, s.db).GetContext(ctx, &lockedUntil, recordFailedLoginQuery,
+		userID, threshold, base.Seconds(), max.Seconds())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, services.ErrUserNotFound
+		}
+		s.log.Error("Failed to record failed login", zap.Int("userID", userID), zap.Error(err))
+		return nil, err
+	}
+
+	return lockedUntil, nil
+}
+
+// RecordSuccessfulLogin clears the failure counter and stamps the last login.
+func (s *UserStore) RecordSuccessfulLogin(ctx context.Context, userID int) error {
+	if _, err := conn(ctx, s.db).ExecContext(ctx, recordSuccessfulLoginQuery, userID); err != nil {
+		s.log.Error("Failed to record successful login", zap.Int("userID", userID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// UnlockUser lifts a lockout and clears the failure counter.
+func (s *UserStore) UnlockUser(ctx context.Context, userID int) error {
+	result, err := conn(ctx, s.db).ExecContext(ctx, unlockUserQuery, userID)
+	if err != nil {
+		s.log.Error("Failed to unlock user", zap.Int("userID", userID), zap.Error(err))
+		return fmt.Errorf("failed to unlock user with id %d: %w", userID, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return services.ErrUserNotFound
+	}
+
+	s.log.Info("User unlocked", zap.Int("userID", userID))
+	return nil
+}
+
+// SetUserRole assigns a role to a user. The role must already exist.
+func (s *UserStore) SetUserRole(ctx context.Context, userID int, role string) error {
+	result, err := conn(ctx, s.db).ExecContext(ctx, setUserRoleQuery, userID, role)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+			return services.ErrRoleNotFound
+		}
+		s.log.Error("Failed to set user role", zap.Int("userID", userID), zap.Error(err))
+		return fmt.Errorf("failed to set role of user with id %d: %w", userID, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return services.ErrUserNotFound
+	}
+
+	s.log.Info("User role changed", zap.Int("userID", userID), zap.String("role", role))
+	return nil
+}
+
+// RestoreUser undoes a soft delete. Erased users are not found.
+func (s *UserStore) RestoreUser(ctx context.Context, userID int) error {
+	result, err := conn(ctx, s.db).ExecContext(ctx, restoreUserQuery, userID)
+	if err != nil {
+		// Someone registered the email after the deletion
+		if isUniqueViolation(err) {
+			return services.ErrDuplicateEmail
+		}
+		s.log.Error("Failed to restore user", zap.Int("userID", userID), zap.Error(err))
+		return fmt.Errorf("failed to restore user with id %d: %w", userID, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return services.ErrUserNotFound
+	}
+
+	s.log.Info("User restored", zap.Int("userID", userID))
+	return nil
+}
+
+// SetUserDisabled disables or re-enables a user. Disabling an already
+// disabled user keeps the original time.
+func (s *UserStore) SetUserDisabled(ctx context.Context, userID int, disabled bool) error {
+	result, err := conn(ctx, s.db).ExecContext(ctx, setUserDisabledQuery, userID, disabled)
+	if err != nil {
+		s.log.Error("Failed to set user disabled", zap.Int("userID", userID), zap.Error(err))
+		return fmt.Errorf("failed to set user with id %d disabled: %w", userID, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return services.ErrUserNotFound
+	}
+
+	s.log.Info("User disabled state changed", zap.Int("userID", userID), zap.Bool("disabled", disabled))
+	return nil
+}
+
+// PurgeDeletedUsers permanently deletes users soft deleted before the given
+// time, along with everything that references them. It returns the purged
+// users so that data stored elsewhere, such as avatars, can go too.
+func (s *UserStore) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time) ([]*models.User, error) {
+	var purged []*models.User
+	if err := conn(ctx, s.db).SelectContext(ctx, &purged, purgeDeletedUsersQuery, deletedBefore); err != nil {
+		s.log.Error("Failed to purge deleted users", zap.Error(err))
+		return nil, fmt.Errorf("failed to purge deleted users: %w", err)
+	}
+
+	return purged, nil
+}
+
+// ClearAvatar removes the avatar of a user, including soft deleted ones,
+// and returns the key it had so that its images can be deleted.
+func (s *UserStore) ClearAvatar(ctx context.Context, userID int) (*string, error) {
+	var key *string
+	err := conn(ctx, s.db).GetContext(ctx, &key, clearAvatarQuery, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, services.ErrUserNotFound
+		}
+		s.log.Error("Failed to clear avatar", zap.Int("userID", userID), zap.Error(err))
+		return nil, fmt.Errorf("failed to clear avatar of user with id %d: %w", userID, err)
+	}
+
+	return key, nil
+}
+
+// AnonymizeUser replaces the personal data of a user, including soft
+// deleted ones, and marks the user deleted and erased for good.
+func (s *UserStore) AnonymizeUser(ctx context.Context, userID int, passwordHash string) error {
+	result, err := conn(ctx, s.db).ExecContext(ctx, anonymizeUserQuery, userID, passwordHash)
+	if err != nil {
+		s.log.Error("Failed to anonymize user", zap.Int("userID", userID), zap.Error(err))
+		return fmt.Errorf("failed to anonymize user with id %d: %w", userID, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return services.ErrUserNotFound
+	}
+
+	s.log.Info("User anonymized", zap.Int("userID", userID))
+	return nil
+}
+
+// isUniqueViolation reports whether err is a Postgres unique_violation.
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
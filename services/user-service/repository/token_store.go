@@ -0,0 +1,363 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"github.com/luisVargasGu/stockTracker/services/user-service/services"
+	"go.uber.org/zap"
+)
+
+type TokenStore struct {
+	db  *sqlx.DB
+	log *zap.Logger
+}
+
+func NewTokenStore(db *sqlx.DB, logger *zap.Logger) *TokenStore {
+	return &TokenStore{db: db, log: logger}
+}
+
+const (
+	createRefreshTokenQuery = `INSERT INTO refresh_tokens
+		(user_id, family_id, token_hash, expires_at, created_at)
+		VALUES (:user_id, :family_id, :token_hash, :expires_at, :created_at)
+		RETURNING id`
+	allRefreshTokenFields      = "id, user_id, family_id, token_hash, expires_at, created_at, rotated_at, revoked_at"
+	getRefreshTokenByHashQuery = "SELECT " + allRefreshTokenFields + " FROM refresh_tokens WHERE token_hash = $1"
+	rotateRefreshTokenQuery    = `UPDATE refresh_tokens SET rotated_at = NOW()
+		WHERE id = $1 AND rotated_at IS NULL AND revoked_at IS NULL`
+	revokeTokenFamilyQuery = `UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE family_id = $1 AND revoked_at IS NULL`
+	revokeUserRefreshTokensQuery = `UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL`
+	revokeUserSessionsQuery = `UPDATE sessions SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL`
+	revokeAccessTokenQuery = `INSERT INTO revoked_tokens (jti, user_id, expires_at)
+		VALUES ($1, $2, $3) ON CONFLICT (jti) DO NOTHING`
+	purgeRevokedTokensQuery       = "DELETE FROM revoked_tokens WHERE expires_at < NOW()"
+	setTokenWatermarkQuery        = "UPDATE Users SET tokens_revoked_before = NOW() WHERE id = $1"
+	createPasswordResetTokenQuery = `INSERT INTO password_reset_tokens
+		(user_id, token_hash, expires_at, created_at)
+		VALUES (:user_id, :token_hash, :expires_at, :created_at)
+		RETURNING id`
+	// Outstanding reset links are invalidated whenever a new one is requested
+	invalidatePasswordResetTokensQuery = `UPDATE password_reset_tokens SET used_at = NOW()
+		WHERE user_id = $1 AND used_at IS NULL`
+	consumePasswordResetTokenQuery = `UPDATE password_reset_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id`
+	createEmailVerificationTokenQuery = `INSERT INTO email_verification_tokens
+		(user_id, email, token_hash, expires_at, created_at)
+		VALUES (:user_id, :email, :token_hash, :expires_at, :created_at)
+		RETURNING id`
+	invalidateEmailVerificationTokensQuery = `UPDATE email_verification_tokens SET used_at = NOW()
+		WHERE user_id = $1 AND used_at IS NULL`
+	consumeEmailVerificationTokenQuery = `UPDATE email_verification_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING id, user_id, email, token_hash, expires_at, created_at, used_at`
+	// A token is revoked if its jti is denylisted, it was issued before the
+	// user's watermark, its session is revoked or the user is deleted. $3 is
+	// the issue time in milliseconds. A missing user row yields no rows.
+	isTokenRevokedQuery = `SELECT
+			EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+			OR COALESCE(u.tokens_revoked_before > to_timestamp($3 / 1000.0)::timestamp, FALSE)
+			OR EXISTS (SELECT 1 FROM sessions WHERE id = NULLIF($4, '')::uuid AND revoked_at IS NOT NULL)
+			OR u.deleted_at IS NOT NULL
+		FROM Users u WHERE u.id = $2`
+	isJTIRevokedQuery = "SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)"
+)
+
+// CreateRefreshToken persists a newly issued refresh token.
+func (s *TokenStore) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	return insertRefreshToken(ctx, conn(ctx, s.db), token)
+}
+
+// GetRefreshTokenByHash retrieves a refresh token by the hash of its value.
+func (s *TokenStore) GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+
+	err := conn(ctx, s.db).GetContext(ctx, &token, getRefreshTokenByHashQuery, hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, services.ErrInvalidRefreshToken
+		}
+		s.log.Error("Error querying refresh token", zap.Error(err))
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// RotateRefreshToken marks the current token as used and stores its successor
+// in a single transaction. If the current token was already rotated or revoked
+// by a concurrent request, ErrRefreshTokenReused is returned and nothing is stored.
+func (s *TokenStore) RotateRefreshToken(ctx context.Context, currentID int, next *models.RefreshToken) error {
+	logger := s.log.With(zap.Int("tokenID", currentID), zap.String("familyID", next.FamilyID))
+
+	tx, err := beginTx(ctx, s.db)
+	if err != nil {
+		logger.Error("Failed to begin transaction", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, rotateRefreshTokenQuery, currentID)
+	if err != nil {
+		logger.Error("Failed to rotate refresh token", zap.Error(err))
+		return fmt.Errorf("failed to rotate refresh token %d: %w", currentID, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		logger.Warn("Refresh token already rotated or revoked")
+		return services.ErrRefreshTokenReused
+	}
+
+	if err := insertRefreshToken(ctx, tx, next); err != nil {
+		logger.Error("Failed to store rotated refresh token", zap.Error(err))
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RevokeTokenFamily revokes every refresh token descending from the same
+// login, along with the session they belong to.
+func (s *TokenStore) RevokeTokenFamily(ctx context.Context, familyID string) error {
+	logger := s.log.With(zap.String("familyID", familyID))
+
+	tx, err := beginTx(ctx, s.db)
+	if err != nil {
+		logger.Error("Failed to begin transaction", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, revokeTokenFamilyQuery, familyID)
+	if err != nil {
+		logger.Error("Failed to revoke token family", zap.Error(err))
+		return fmt.Errorf("failed to revoke token family %s: %w", familyID, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, revokeSessionByIDQuery, familyID); err != nil {
+		logger.Error("Failed to revoke session", zap.Error(err))
+		return fmt.Errorf("failed to revoke session %s: %w", familyID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	logger.Info("Token family revoked", zap.Int64("count", rowsAffected))
+	return nil
+}
+
+// RevokeAccessToken denylists a single access token until it expires.
+func (s *TokenStore) RevokeAccessToken(ctx context.Context, jti string, userID int, expiresAt time.Time) error {
+	logger := s.log.With(zap.Int("userID", userID), zap.String("jti", jti))
+
+	if _, err := conn(ctx, s.db).ExecContext(ctx, revokeAccessTokenQuery, jti, userID, expiresAt); err != nil {
+		logger.Error("Failed to revoke access token", zap.Error(err))
+		return fmt.Errorf("failed to revoke access token: %w", err)
+	}
+
+	// Entries are useless once the token has expired anyway
+	if _, err := conn(ctx, s.db).ExecContext(ctx, purgeRevokedTokensQuery); err != nil {
+		logger.Warn("Failed to purge expired revoked tokens", zap.Error(err))
+	}
+
+	logger.Info("Access token revoked")
+	return nil
+}
+
+// RevokeAllUserTokens moves the user's "tokens issued before" watermark to now and
+// revokes all of their refresh tokens, logging them out of every device.
+func (s *TokenStore) RevokeAllUserTokens(ctx context.Context, userID int) error {
+	logger := s.log.With(zap.Int("userID", userID))
+
+	tx, err := beginTx(ctx, s.db)
+	if err != nil {
+		logger.Error("Failed to begin transaction", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, setTokenWatermarkQuery, userID); err != nil {
+		logger.Error("Failed to set token watermark", zap.Error(err))
+		return fmt.Errorf("failed to revoke tokens for user %d: %w", userID, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, revokeUserRefreshTokensQuery, userID); err != nil {
+		logger.Error("Failed to revoke refresh tokens", zap.Error(err))
+		return fmt.Errorf("failed to revoke tokens for user %d: %w", userID, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, revokeUserSessionsQuery, userID); err != nil {
+		logger.Error("Failed to revoke sessions", zap.Error(err))
+		return fmt.Errorf("failed to revoke sessions for user %d: %w", userID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	logger.Info("All user tokens revoked")
+	return nil
+}
+
+// IsRevoked implements middleware.RevocationStore.
+func (s *TokenStore) IsRevoked(ctx context.Context, claims *middleware.AuthClaims) (bool, error) {
+	// Service client tokens have no user, only their own jti can be revoked
+	if claims.UserID == "" && claims.ClientID != "" {
+		var revoked bool
+		if err := conn(ctx, s.db).GetContext(ctx, &revoked, isJTIRevokedQuery, claims.Id); err != nil {
+			s.log.Error("Error checking token revocation", zap.String("clientID", claims.ClientID), zap.Error(err))
+			return false, err
+		}
+		return revoked, nil
+	}
+
+	userID, err := strconv.Atoi(claims.UserID)
+	if err != nil {
+		return true, nil
+	}
+
+	var revoked bool
+	err = conn(ctx, s.db).GetContext(ctx, &revoked, isTokenRevokedQuery, claims.Id, userID, claims.IssuedAtMillis(), claims.SessionID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// The user no longer exists
+			return true, nil
+		}
+		s.log.Error("Error checking token revocation", zap.Int("userID", userID), zap.Error(err))
+		return false, err
+	}
+
+	return revoked, nil
+}
+
+// CreatePasswordResetToken stores a new reset token, invalidating any earlier ones for the user.
+func (s *TokenStore) CreatePasswordResetToken(ctx context.Context, token *models.PasswordResetToken) error {
+	logger := s.log.With(zap.Int("userID", token.UserID))
+
+	tx, err := beginTx(ctx, s.db)
+	if err != nil {
+		logger.Error("Failed to begin transaction", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, invalidatePasswordResetTokensQuery, token.UserID); err != nil {
+		logger.Error("Failed to invalidate password reset tokens", zap.Error(err))
+		return err
+	}
+
+	rows, err := sqlx.NamedQueryContext(ctx, tx, createPasswordResetTokenQuery, token)
+	if err != nil {
+		logger.Error("Failed to create password reset token", zap.Error(err))
+		return err
+	}
+	if rows.Next() {
+		if err := rows.Scan(&token.ID); err != nil {
+			rows.Close()
+			return err
+		}
+	}
+	rows.Close()
+
+	return tx.Commit()
+}
+
+// ConsumePasswordResetToken marks an unexpired, unused reset token as used and
+// returns the user it belongs to. It returns ErrInvalidResetToken otherwise.
+func (s *TokenStore) ConsumePasswordResetToken(ctx context.Context, hash string) (int, error) {
+	var userID int
+
+	err := conn(ctx, s.db).GetContext(ctx, &userID, consumePasswordResetTokenQuery, hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, services.ErrInvalidResetToken
+		}
+		s.log.Error("Failed to consume password reset token", zap.Error(err))
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// CreateEmailVerificationToken stores a new verification token, invalidating
+// earlier ones so only the most recently requested address can be confirmed.
+func (s *TokenStore) CreateEmailVerificationToken(ctx context.Context, token *models.EmailVerificationToken) error {
+	logger := s.log.With(zap.Int("userID", token.UserID))
+
+	tx, err := beginTx(ctx, s.db)
+	if err != nil {
+		logger.Error("Failed to begin transaction", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, invalidateEmailVerificationTokensQuery, token.UserID); err != nil {
+		logger.Error("Failed to invalidate email verification tokens", zap.Error(err))
+		return err
+	}
+
+	rows, err := sqlx.NamedQueryContext(ctx, tx, createEmailVerificationTokenQuery, token)
+	if err != nil {
+		logger.Error("Failed to create email verification token", zap.Error(err))
+		return err
+	}
+	if rows.Next() {
+		if err := rows.Scan(&token.ID); err != nil {
+			rows.Close()
+			return err
+		}
+	}
+	rows.Close()
+
+	return tx.Commit()
+}
+
+// ConsumeEmailVerificationToken marks an unexpired, unused verification token
+// as used and returns it. It returns ErrInvalidVerificationToken otherwise.
+func (s *TokenStore) ConsumeEmailVerificationToken(ctx context.Context, hash string) (*models.EmailVerificationToken, error) {
+	var token models.EmailVerificationToken
+
+	err := conn(ctx, s.db).GetContext(ctx, &token, consumeEmailVerificationTokenQuery, hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, services.ErrInvalidVerificationToken
+		}
+		s.log.Error("Failed to consume email verification token", zap.Error(err))
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func insertRefreshToken(ctx context.Context, db sqlx.ExtContext, token *models.RefreshToken) error {
+	rows, err := sqlx.NamedQueryContext(ctx, db, createRefreshTokenQuery, token)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.Scan(&token.ID); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
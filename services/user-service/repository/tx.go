@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+type txKey struct{}
+
+// queryer is implemented by both *sqlx.DB and *sqlx.Tx.
+type queryer interface {
+	sqlx.ExtContext
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error)
+}
+
+// Transactor runs a function in a database transaction carried by its
+// context. Every store called with that context joins the transaction, so
+// changes spanning several stores commit or roll back together.
+type Transactor struct {
+	db  *sqlx.DB
+	log *zap.Logger
+}
+
+func NewTransactor(db *sqlx.DB, logger *zap.Logger) *Transactor {
+	return &Transactor{db: db, log: logger}
+}
+
+// WithinTransaction calls fn with a context carrying a transaction, which is
+// committed if fn succeeds and rolled back otherwise. Nested calls join the
+// outer transaction.
+func (t *Transactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := t.db.BeginTxx(ctx, nil)
+	if err != nil {
+		t.log.Error("Failed to begin transaction", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// conn returns the transaction carried by ctx, or db outside of one.
+func conn(ctx context.Context, db *sqlx.DB) queryer {
+	if tx, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return tx
+	}
+	return db
+}
+
+// storeTx is a transaction used by a single store method. When the method
+// joined a transaction carried by its context, Commit and Rollback are left
+// to whoever started it.
+type storeTx struct {
+	*sqlx.Tx
+	joined bool
+}
+
+// beginTx starts a transaction for a store method, or joins the one carried
+// by ctx.
+func beginTx(ctx context.Context, db *sqlx.DB) (*storeTx, error) {
+	if tx, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return &storeTx{Tx: tx, joined: true}, nil
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &storeTx{Tx: tx}, nil
+}
+
+func (t *storeTx) Commit() error {
+	if t.joined {
+		return nil
+	}
+	return t.Tx.Commit()
+}
+
+func (t *storeTx) Rollback() error {
+	if t.joined {
+		return nil
+	}
+	return t.Tx.Rollback()
+}
@@ -0,0 +1,363 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/common/utils"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"go.uber.org/zap"
+)
+
+const (
+	// Rows of a user import. Every password is hashed, which takes a while.
+	maxImportRows = 500
+
+	// Users read per query by an export
+	exportBatchSize = 500
+)
+
+// Columns of a user import file
+var importColumns = map[string]bool{"email": true, "name": true, "password": true}
+
+// ImportUsers creates users from CSV whose header names the columns email,
+// password and optionally name, in any order. Every row is checked as a
+// registration would be. Nothing is created if any row is invalid, failing
+// with ErrImportFailed, or if dryRun is set. The report describes each row
+// either way. Imported users get a verification email like everyone else.
+func (s UserService) ImportUsers(ctx context.Context, r io.Reader, dryRun bool) (*models.UserImportReport, error) {
+	if !hasPermission(ctx, middleware.PermUsersWrite) {
+		return nil, ErrUnauthorized
+	}
+
+	payloads, err := readUserImport(r)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.UserImportReport{DryRun: dryRun, Rows: make([]*models.UserImportRow, 0, len(payloads))}
+	validate := validator.New()
+	seen := make(map[string]int, len(payloads))
+	for i, payload := range payloads {
+		row := &models.UserImportRow{Row: i + 1, Email: payload.Email, Status: models.ImportRowValid}
+		row.Errors, err = s.validateImportRow(ctx, validate, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		email := strings.ToLower(payload.Email)
+		if first, ok := seen[email]; ok {
+			row.Errors = append(row.Errors, fmt.Sprintf("email repeats row %d", first))
+		} else {
+			seen[email] = row.Row
+		}
+
+		if len(row.Errors) > 0 {
+			row.Status = models.ImportRowInvalid
+			report.Invalid++
+		}
+		report.Rows = append(report.Rows, row)
+	}
+	if report.Invalid > 0 {
+		return report, ErrImportFailed
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	// Hashing is slow, so it is done before the transaction starts
+	hashes := make([]string, len(payloads))
+	for i, payload := range payloads {
+		if hashes[i], err = middleware.HashPassword(payload.Password); err != nil {
+			return nil, err
+		}
+	}
+
+	created := make([]*models.User, len(payloads))
+	err = s.withAudit(ctx, models.AuditUserImport, 0, func(ctx context.Context, entry *models.AuditEntry) error {
+		ids := make([]int, len(payloads))
+		for i, payload := range payloads {
+			now := time.Now()
+			user, err := s.repo.CreateUser(ctx, &models.User{
+				Email:        payload.Email,
+				Name:         payload.Name,
+				PasswordHash: hashes[i],
+				Role:         DefaultRole,
+				CreatedAt:    now,
+				LastLogin:    now,
+				UpdatedAt:    now,
+			})
+			if err != nil {
+				if errors.Is(err, ErrUserAlreadyExists) {
+					// Registered since the check above
+					report.Rows[i].Status = models.ImportRowInvalid
+					report.Rows[i].Errors = append(report.Rows[i].Errors, "email is already registered")
+					report.Invalid++
+					return ErrImportFailed
+				}
+				return err
+			}
+
+			if err := s.repo.AddPasswordHistory(ctx, user.ID, hashes[i]); err != nil {
+				return err
+			}
+			created[i] = user
+			ids[i] = user.ID
+		}
+
+		recordChange(entry, "users", nil, ids)
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrImportFailed) {
+			return report, err
+		}
+		return nil, err
+	}
+
+	for i, user := range created {
+		report.Rows[i].Status = models.ImportRowCreated
+		report.Rows[i].UserID = user.ID
+		report.Created++
+
+		if err := s.sendVerification(ctx, user, user.Email); err != nil {
+			s.log.Error("Failed to send verification email", zap.Int("userID", user.ID), zap.Error(err))
+		}
+	}
+
+	s.log.Info("Users imported", zap.Int("count", report.Created))
+	return report, nil
+}
+
+// readUserImport parses a user import file into registration payloads.
+func readUserImport(r io.Reader) ([]models.RegisterUserPayload, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: file is empty", ErrInvalidImport)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImport, err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		// Spreadsheets like to start UTF-8 files with a byte order mark
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !importColumns[name] {
+			return nil, fmt.Errorf("%w: unknown column %q", ErrInvalidImport, name)
+		}
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("%w: column %q appears twice", ErrInvalidImport, name)
+		}
+		columns[name] = i
+	}
+	for _, name := range []string{"email", "password"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%w: column %q is required", ErrInvalidImport, name)
+		}
+	}
+
+	var payloads []models.RegisterUserPayload
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidImport, err)
+		}
+		if len(payloads) == maxImportRows {
+			return nil, fmt.Errorf("%w: more than %d rows", ErrInvalidImport, maxImportRows)
+		}
+
+		payload := models.RegisterUserPayload{
+			Email:    strings.TrimSpace(record[columns["email"]]),
+			Password: record[columns["password"]],
+		}
+		if i, ok := columns["name"]; ok {
+			payload.Name = strings.TrimSpace(record[i])
+		}
+		payloads = append(payloads, payload)
+	}
+	if len(payloads) == 0 {
+		return nil, fmt.Errorf("%w: no rows", ErrInvalidImport)
+	}
+
+	return payloads, nil
+}
+
+// validateImportRow returns what is wrong with a row, if anything, applying
+// the checks of RegisterUser.
+func (s UserService) validateImportRow(ctx context.Context, validate *validator.Validate, payload models.RegisterUserPayload) ([]string, error) {
+	var problems []string
+	invalid := make(map[string]bool)
+
+	if err := validate.Struct(payload); err != nil {
+		var fieldErrors validator.ValidationErrors
+		if !errors.As(err, &fieldErrors) {
+			return nil, err
+		}
+		for _, fieldError := range fieldErrors {
+			invalid[fieldError.Field()] = true
+			problems = append(problems, describeFieldError(fieldError))
+		}
+	}
+
+	if !invalid["Password"] {
+		if err := s.validatePassword(payload.Password); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if !invalid["Email"] {
+		_, err := s.repo.GetUserByEmail(ctx, payload.Email)
+		switch {
+		case err == nil:
+			problems = append(problems, "email is already registered")
+		case !errors.Is(err, ErrUserNotFound):
+			return nil, err
+		}
+	}
+
+	return problems, nil
+}
+
+func describeFieldError(fieldError validator.FieldError) string {
+	field := strings.ToLower(fieldError.Field())
+	switch fieldError.Tag() {
+	case "required":
+		return field + " is required"
+	case "email":
+		return field + " is not a valid email address"
+	case "min":
+		return fmt.Sprintf("%s must be at least %s characters long", field, fieldError.Param())
+	default:
+		return fmt.Sprintf("%s failed the %s check", field, fieldError.Tag())
+	}
+}
+
+// ExportUsers calls fn with every user matching filter, in the order of
+// the filter, reading them in batches so that a large export never sits in
+// memory at once. filter.Page is ignored. Requires the permissions of
+// GetUsers.
+func (s UserService) ExportUsers(ctx context.Context, filter models.UserFilter, fn func(*models.User) error) error {
+	if !canListUsers(ctx, filter) {
+		return ErrUnauthorized
+	}
+
+	filter.Page = utils.PageRequest{Limit: exportBatchSize, Count: utils.CountNone}
+	for {
+		users, _, err := s.repo.GetUsers(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		// The store reads one user past the limit to tell if there are more
+		more := len(users) > exportBatchSize
+		if more {
+			users = users[:exportBatchSize]
+		}
+		for _, user := range users {
+			if err := fn(s.withAvatarURLs(user)); err != nil {
+				return err
+			}
+		}
+		if !more {
+			return nil
+		}
+
+		last := users[len(users)-1]
+		filter.Page.Cursor = &utils.Cursor{Values: last.SortKey(filter.SortBy)}
+	}
+}
+
+// Permission each bulk action requires
+var bulkActionPermissions = map[string]string{
+	models.BulkDisable: middleware.PermUsersWrite,
+	models.BulkEnable:  middleware.PermUsersWrite,
+	models.BulkRole:    middleware.PermRolesManage,
+	models.BulkDelete:  middleware.PermUsersDelete,
+}
+
+// BulkUpdateUsers applies one action to many users in a single transaction:
+// if it fails for any user, it is undone for all of them. Each user gets
+// their own audit entry, as if changed one at a time. Callers cannot
+// include themselves. It returns the number of users changed.
+func (s UserService) BulkUpdateUsers(ctx context.Context, payload models.BulkUserPayload) (int, error) {
+	currentUser, err := s.extractUserFromContext(ctx)
+	if err != nil {
+		return 0, ErrUnauthorized
+	}
+	perm, ok := bulkActionPermissions[payload.Action]
+	if !ok || !hasPermission(ctx, perm) {
+		return 0, ErrUnauthorized
+	}
+
+	if payload.Action == models.BulkRole {
+		if _, err := s.roles.GetRole(ctx, payload.Role); err != nil {
+			return 0, err
+		}
+	}
+
+	ids := make([]int, 0, len(payload.UserIDs))
+	seen := make(map[int]bool, len(payload.UserIDs))
+	for _, id := range payload.UserIDs {
+		if id == currentUser.ID {
+			return 0, ErrOwnAccount
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		for _, id := range ids {
+			if err := s.bulkUpdateUser(ctx, payload, id); err != nil {
+				return fmt.Errorf("user %d: %w", id, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.log.Info("Bulk action applied",
+		zap.String("action", payload.Action),
+		zap.Int("count", len(ids)),
+		zap.Int("appliedBy", currentUser.ID))
+	return len(ids), nil
+}
+
+func (s UserService) bulkUpdateUser(ctx context.Context, payload models.BulkUserPayload, id int) error {
+	switch payload.Action {
+	case models.BulkDisable:
+		return s.withAudit(ctx, models.AuditUserDisable, id, func(ctx context.Context, _ *models.AuditEntry) error {
+			if err := s.repo.SetUserDisabled(ctx, id, true); err != nil {
+				return err
+			}
+			return s.tokens.RevokeAllUserTokens(ctx, id)
+		})
+	case models.BulkEnable:
+		return s.withAudit(ctx, models.AuditUserEnable, id, func(ctx context.Context, _ *models.AuditEntry) error {
+			return s.repo.SetUserDisabled(ctx, id, false)
+		})
+	case models.BulkRole:
+		return s.setRole(ctx, id, payload.Role)
+	case models.BulkDelete:
+		return s.deleteUser(ctx, id)
+	default:
+		return fmt.Errorf("unknown bulk action %q", payload.Action)
+	}
+}
@@ -0,0 +1,600 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luisVargasGu/stockTracker/common/mailer"
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/common/utils"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"go.uber.org/zap"
+)
+
+func TestReadUserImport(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		want    []models.RegisterUserPayload
+		wantErr string
+	}{
+		{
+			name: "columns in any order",
+			csv:  "password,name,email\nSecret123,Ada,ada@example.com\n",
+			want: []models.RegisterUserPayload{{Email: "ada@example.com", Name: "Ada", Password: "Secret123"}},
+		},
+		{
+			name: "name is optional",
+			csv:  "email,password\nada@example.com,Secret123\n",
+			want: []models.RegisterUserPayload{{Email: "ada@example.com", Password: "Secret123"}},
+		},
+		{
+			name: "byte order mark and header case",
+			csv:  "\ufeffEmail, Password\nada@example.com,Secret123\n",
+			want: []models.RegisterUserPayload{{Email: "ada@example.com", Password: "Secret123"}},
+		},
+		{
+			name: "fields are trimmed but passwords kept",
+			csv:  "email,name,password\n ada@example.com , Ada ,\" Secret123 \"\n",
+			want: []models.RegisterUserPayload{{Email: "ada@example.com", Name: "Ada", Password: " Secret123 "}},
+		},
+		{
+			name: "rows are kept in order, duplicates included",
+			csv:  "email,password\na@example.com,x\nb@example.com,y\na@example.com,z\n",
+			want: []models.RegisterUserPayload{
+				{Email: "a@example.com", Password: "x"},
+				{Email: "b@example.com", Password: "y"},
+				{Email: "a@example.com", Password: "z"},
+			},
+		},
+		{name: "empty file", csv: "", wantErr: "file is empty"},
+		{name: "header only", csv: "email,password\n", wantErr: "no rows"},
+		{name: "unknown column", csv: "email,password,role\na@example.com,x,admin\n", wantErr: `unknown column "role"`},
+		{name: "repeated column", csv: "email,password,Email\na@example.com,x,b@example.com\n", wantErr: `column "email" appears twice`},
+		{name: "missing email column", csv: "name,password\nAda,x\n", wantErr: `column "email" is required`},
+		{name: "missing password column", csv: "email,name\na@example.com,Ada\n", wantErr: `column "password" is required`},
+		{name: "short row", csv: "email,password\na@example.com\n", wantErr: "wrong number of fields"},
+		{name: "unterminated quote", csv: "email,password\n\"a@example.com,x\n", wantErr: "extraneous or missing"},
+		{name: "too many rows", csv: "email,password\n" + strings.Repeat("a@example.com,x\n", maxImportRows+1), wantErr: "more than 500 rows"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readUserImport(strings.NewReader(tt.csv))
+			if tt.wantErr != "" {
+				if !errors.Is(err, ErrInvalidImport) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("readUserImport error = %v, want ErrInvalidImport with %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readUserImport: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readUserImport = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// adminUserStore is an in-memory user table. CreateUser loses a race for
+// the email raced, and SetUserDisabled fails for the user failOn.
+type adminUserStore struct {
+	models.UserRepository
+	users   map[int]*models.User
+	history map[int]string
+	raced   string
+	failOn  int
+	pages   []utils.PageRequest
+}
+
+func (f *adminUserStore) GetUserByID(_ context.Context, id int) (*models.User, error) {
+	user, ok := f.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (f *adminUserStore) GetUserByEmail(_ context.Context, email string) (*models.User, error) {
+	for _, user := range f.users {
+		if strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (f *adminUserStore) CreateUser(_ context.Context, user *models.User) (*models.User, error) {
+	if strings.EqualFold(user.Email, f.raced) {
+		return nil, ErrUserAlreadyExists
+	}
+	user.ID = len(f.users) + 1
+	f.users[user.ID] = user
+	return user, nil
+}
+
+func (f *adminUserStore) AddPasswordHistory(_ context.Context, userID int, hash string) error {
+	f.history[userID] = hash
+	return nil
+}
+
+func (f *adminUserStore) SetUserDisabled(_ context.Context, userID int, disabled bool) error {
+	user, ok := f.users[userID]
+	if !ok || userID == f.failOn {
+		return ErrUserNotFound
+	}
+	user.DisabledAt = nil
+	if disabled {
+		user.DisabledAt = timePtr(time.Now())
+	}
+	return nil
+}
+
+func (f *adminUserStore) SetUserRole(_ context.Context, userID int, role string) error {
+	f.users[userID].Role = role
+	return nil
+}
+
+func (f *adminUserStore) DeleteUser(_ context.Context, userID int) error {
+	f.users[userID].DeletedAt = timePtr(time.Now())
+	return nil
+}
+
+// GetUsers pages through the users by ID, reading one past the limit as
+// the real store does.
+func (f *adminUserStore) GetUsers(_ context.Context, filter models.UserFilter) ([]*models.User, *utils.Total, error) {
+	f.pages = append(f.pages, filter.Page)
+
+	after := 0
+	if filter.Page.Cursor != nil {
+		after, _ = strconv.Atoi(filter.Page.Cursor.Values[1])
+	}
+	var users []*models.User
+	for id := after + 1; id <= len(f.users) && len(users) <= filter.Page.Limit; id++ {
+		users = append(users, f.users[id])
+	}
+	return users, nil, nil
+}
+
+type adminTokenStore struct {
+	models.TokenRepository
+	revokedAll    []int
+	verifications []*models.EmailVerificationToken
+}
+
+func (f *adminTokenStore) RevokeAllUserTokens(_ context.Context, userID int) error {
+	f.revokedAll = append(f.revokedAll, userID)
+	return nil
+}
+
+func (f *adminTokenStore) CreateEmailVerificationToken(_ context.Context, token *models.EmailVerificationToken) error {
+	f.verifications = append(f.verifications, token)
+	return nil
+}
+
+type adminTxKey struct{}
+
+// adminTransactor rolls the fake stores back when a transaction fails.
+// Nested calls join the outer transaction.
+type adminTransactor struct {
+	users  *adminUserStore
+	tokens *adminTokenStore
+	audit  *fakeAuditStore
+}
+
+func (f adminTransactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if ctx.Value(adminTxKey{}) != nil {
+		return fn(ctx)
+	}
+
+	users := make(map[int]models.User, len(f.users.users))
+	for id, user := range f.users.users {
+		users[id] = *user
+	}
+	revoked, entries := len(f.tokens.revokedAll), len(f.audit.entries)
+
+	err := fn(context.WithValue(ctx, adminTxKey{}, true))
+	if err != nil {
+		f.users.users = make(map[int]*models.User, len(users))
+		for id, user := range users {
+			user := user
+			f.users.users[id] = &user
+		}
+		f.tokens.revokedAll = f.tokens.revokedAll[:revoked]
+		f.audit.entries = f.audit.entries[:entries]
+	}
+	return err
+}
+
+func newAdminTestService(users ...*models.User) (UserService, *adminUserStore, *adminTokenStore, *fakeAuditStore) {
+	repo := &adminUserStore{users: make(map[int]*models.User), history: make(map[int]string)}
+	for _, user := range users {
+		repo.users[user.ID] = user
+	}
+	tokens := &adminTokenStore{}
+	audit := &fakeAuditStore{}
+
+	return UserService{
+		repo:     repo,
+		tokens:   tokens,
+		roles:    staticRoles{},
+		tx:       adminTransactor{users: repo, tokens: tokens, audit: audit},
+		audit:    audit,
+		policy:   middleware.DefaultPasswordPolicy(),
+		notifier: NewAccountNotifier(mailer.NewLogMailer(zap.NewNop()), "https://app.example.com"),
+		log:      zap.NewNop(),
+	}, repo, tokens, audit
+}
+
+// adminContext signs in as user 1 with perms.
+func adminContext(perms ...string) context.Context {
+	ctx := context.WithValue(context.Background(), "user_id", "1")
+	return context.WithValue(ctx, "permissions", perms)
+}
+
+func importContext() context.Context {
+	return adminContext(middleware.PermUsersWrite)
+}
+
+func TestImportUsersReportsEveryInvalidRow(t *testing.T) {
+	existing := &models.User{ID: 1, Email: "taken@example.com"}
+	csv := "email,password\n" +
+		"ada@example.com,Secret123\n" +
+		"not-an-email,Secret123\n" +
+		"weak@example.com,short\n" +
+		"Taken@Example.com,Secret123\n" +
+		"ADA@example.com,Secret123\n" +
+		",weak\n"
+
+	for _, dryRun := range []bool{true, false} {
+		s, repo, _, audit := newAdminTestService(existing)
+
+		report, err := s.ImportUsers(importContext(), strings.NewReader(csv), dryRun)
+		if !errors.Is(err, ErrImportFailed) {
+			t.Fatalf("dryRun %v: ImportUsers error = %v, want ErrImportFailed", dryRun, err)
+		}
+
+		want := []struct {
+			status string
+			errors []string
+		}{
+			{models.ImportRowValid, nil},
+			{models.ImportRowInvalid, []string{"email is not a valid email address"}},
+			{models.ImportRowInvalid, []string{"password must be at least 8 characters long"}},
+			{models.ImportRowInvalid, []string{"email is already registered"}},
+			{models.ImportRowInvalid, []string{"email repeats row 1"}},
+			{models.ImportRowInvalid, []string{"email is required", "password must be at least 8 characters long"}},
+		}
+		if report.DryRun != dryRun || report.Invalid != 5 || report.Created != 0 || len(report.Rows) != len(want) {
+			t.Fatalf("dryRun %v: report = %+v", dryRun, report)
+		}
+		for i, row := range report.Rows {
+			if row.Row != i+1 || row.Status != want[i].status || !reflect.DeepEqual(row.Errors, want[i].errors) {
+				t.Errorf("dryRun %v: row %d = %+v, want %+v", dryRun, i+1, row, want[i])
+			}
+		}
+
+		if len(repo.users) != 1 || len(audit.entries) != 0 {
+			t.Errorf("dryRun %v: an invalid import changed something: %d users, %d audit entries", dryRun, len(repo.users), len(audit.entries))
+		}
+	}
+}
+
+func TestImportUsersDryRun(t *testing.T) {
+	s, repo, tokens, audit := newAdminTestService()
+	csv := "email,name,password\nada@example.com,Ada,Secret123\ngrace@example.com,Grace,Secret456\n"
+
+	report, err := s.ImportUsers(importContext(), strings.NewReader(csv), true)
+	if err != nil {
+		t.Fatalf("ImportUsers: %v", err)
+	}
+	if !report.DryRun || report.Created != 0 || report.Invalid != 0 || len(report.Rows) != 2 {
+		t.Fatalf("report = %+v", report)
+	}
+	for _, row := range report.Rows {
+		if row.Status != models.ImportRowValid || row.UserID != 0 || len(row.Errors) != 0 {
+			t.Errorf("row = %+v, want valid", row)
+		}
+	}
+	if len(repo.users) != 0 || len(audit.entries) != 0 || len(tokens.verifications) != 0 {
+		t.Error("a dry run changed something")
+	}
+}
+
+func TestImportUsersCommit(t *testing.T) {
+	s, repo, tokens, audit := newAdminTestService()
+	csv := "email,name,password\nada@example.com,Ada,Secret123\ngrace@example.com,Grace,Secret456\n"
+
+	report, err := s.ImportUsers(importContext(), strings.NewReader(csv), false)
+	if err != nil {
+		t.Fatalf("ImportUsers: %v", err)
+	}
+	if report.DryRun || report.Created != 2 || report.Invalid != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+	for i, row := range report.Rows {
+		user := repo.users[row.UserID]
+		if row.Status != models.ImportRowCreated || user == nil || user.Email != row.Email {
+			t.Errorf("row %d = %+v, user %+v", i+1, row, user)
+			continue
+		}
+		if !middleware.ComparePasswords(user.PasswordHash, []byte([]string{"Secret123", "Secret456"}[i])) {
+			t.Errorf("row %d: password was not hashed from the file", i+1)
+		}
+		if repo.history[user.ID] != user.PasswordHash {
+			t.Errorf("row %d: password history not recorded", i+1)
+		}
+		if user.Role != DefaultRole || user.EmailVerifiedAt != nil {
+			t.Errorf("row %d: imported user %+v", i+1, user)
+		}
+	}
+
+	if len(audit.entries) != 1 || audit.entries[0].Action != models.AuditUserImport {
+		t.Errorf("audit entries = %+v, want one %s", audit.entries, models.AuditUserImport)
+	}
+	if len(tokens.verifications) != 2 {
+		t.Errorf("sent %d verification emails, want 2", len(tokens.verifications))
+	}
+}
+
+func TestImportUsersRaceWithRegistration(t *testing.T) {
+	s, repo, tokens, _ := newAdminTestService()
+	repo.raced = "grace@example.com"
+	csv := "email,password\nada@example.com,Secret123\ngrace@example.com,Secret456\n"
+
+	report, err := s.ImportUsers(importContext(), strings.NewReader(csv), false)
+	if !errors.Is(err, ErrImportFailed) {
+		t.Fatalf("ImportUsers error = %v, want ErrImportFailed", err)
+	}
+	row := report.Rows[1]
+	if row.Status != models.ImportRowInvalid || !reflect.DeepEqual(row.Errors, []string{"email is already registered"}) {
+		t.Errorf("row 2 = %+v", row)
+	}
+	if report.Created != 0 || report.Rows[0].Status == models.ImportRowCreated || len(tokens.verifications) != 0 {
+		t.Errorf("a failed import reports users as created: %+v", report)
+	}
+	if len(repo.users) != 0 {
+		t.Errorf("a failed import kept %d users", len(repo.users))
+	}
+}
+
+func TestImportUsersRequiresPermission(t *testing.T) {
+	s, _, _, _ := newAdminTestService()
+	ctx := context.WithValue(context.Background(), "permissions", []string{middleware.PermUsersRead})
+
+	if _, err := s.ImportUsers(ctx, strings.NewReader("email,password\na@example.com,Secret123\n"), true); err != ErrUnauthorized {
+		t.Errorf("ImportUsers = %v, want ErrUnauthorized", err)
+	}
+}
+
+func newBulkTestService() (UserService, *adminUserStore, *adminTokenStore, *fakeAuditStore) {
+	users := make([]*models.User, 0, 4)
+	for id := 1; id <= 4; id++ {
+		users = append(users, &models.User{ID: id, Email: "user" + strconv.Itoa(id) + "@example.com", Role: DefaultRole})
+	}
+	return newAdminTestService(users...)
+}
+
+func TestBulkUpdateUsers(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload models.BulkUserPayload
+		perms   []string
+		check   func(*models.User) bool
+		revoked bool
+		audit   string
+	}{
+		{
+			name:    "disable",
+			payload: models.BulkUserPayload{Action: models.BulkDisable, UserIDs: []int{2, 3, 2}},
+			perms:   []string{middleware.PermUsersWrite},
+			check:   func(u *models.User) bool { return u.DisabledAt != nil },
+			revoked: true,
+			audit:   models.AuditUserDisable,
+		},
+		{
+			name:    "role",
+			payload: models.BulkUserPayload{Action: models.BulkRole, UserIDs: []int{2, 3}, Role: "admin"},
+			perms:   []string{middleware.PermRolesManage},
+			check:   func(u *models.User) bool { return u.Role == "admin" },
+			revoked: true,
+			audit:   models.AuditRoleAssign,
+		},
+		{
+			name:    "delete",
+			payload: models.BulkUserPayload{Action: models.BulkDelete, UserIDs: []int{2, 3}},
+			perms:   []string{middleware.PermUsersDelete},
+			check:   func(u *models.User) bool { return u.DeletedAt != nil },
+			revoked: true,
+			audit:   models.AuditUserDelete,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, repo, tokens, audit := newBulkTestService()
+
+			n, err := s.BulkUpdateUsers(adminContext(tt.perms...), tt.payload)
+			if err != nil || n != 2 {
+				t.Fatalf("BulkUpdateUsers = %d, %v; want 2 users changed", n, err)
+			}
+			for id := 1; id <= 4; id++ {
+				if changed := tt.check(repo.users[id]); changed != (id == 2 || id == 3) {
+					t.Errorf("user %d changed: %v", id, changed)
+				}
+			}
+			if tt.revoked && !reflect.DeepEqual(tokens.revokedAll, []int{2, 3}) {
+				t.Errorf("revoked tokens of %v, want [2 3]", tokens.revokedAll)
+			}
+			if len(audit.entries) != 2 {
+				t.Fatalf("audit entries = %+v, want one per user", audit.entries)
+			}
+			for i, entry := range audit.entries {
+				if entry.Action != tt.audit || *entry.TargetID != i+2 || *entry.ActorID != 1 {
+					t.Errorf("audit entry %d = %+v", i, entry)
+				}
+			}
+		})
+	}
+}
+
+func TestBulkUpdateUsersIsAllOrNothing(t *testing.T) {
+	s, repo, tokens, audit := newBulkTestService()
+	repo.failOn = 3
+
+	_, err := s.BulkUpdateUsers(adminContext(middleware.PermUsersWrite),
+		models.BulkUserPayload{Action: models.BulkDisable, UserIDs: []int{2, 3, 4}})
+	if !errors.Is(err, ErrUserNotFound) || !strings.Contains(err.Error(), "user 3") {
+		t.Fatalf("BulkUpdateUsers error = %v, want ErrUserNotFound for user 3", err)
+	}
+	for id, user := range repo.users {
+		if user.DisabledAt != nil {
+			t.Errorf("user %d stayed disabled after the bulk action failed", id)
+		}
+	}
+	if len(tokens.revokedAll) != 0 || len(audit.entries) != 0 {
+		t.Errorf("a failed bulk action kept revocations %v and audit entries %+v", tokens.revokedAll, audit.entries)
+	}
+}
+
+func TestBulkUpdateUsersExcludesCaller(t *testing.T) {
+	s, repo, _, audit := newBulkTestService()
+
+	_, err := s.BulkUpdateUsers(adminContext(middleware.PermUsersWrite),
+		models.BulkUserPayload{Action: models.BulkDisable, UserIDs: []int{2, 1}})
+	if !errors.Is(err, ErrOwnAccount) {
+		t.Fatalf("BulkUpdateUsers error = %v, want ErrOwnAccount", err)
+	}
+	if repo.users[2].DisabledAt != nil || len(audit.entries) != 0 {
+		t.Error("the other users were changed although the caller was included")
+	}
+}
+
+func TestBulkUpdateUsersPermissions(t *testing.T) {
+	tests := []struct {
+		action string
+		perm   string
+	}{
+		{models.BulkDisable, middleware.PermUsersWrite},
+		{models.BulkEnable, middleware.PermUsersWrite},
+		{models.BulkRole, middleware.PermRolesManage},
+		{models.BulkDelete, middleware.PermUsersDelete},
+	}
+	all := []string{middleware.PermUsersWrite, middleware.PermRolesManage, middleware.PermUsersDelete}
+
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			// Every permission but the one the action needs
+			var others []string
+			for _, perm := range all {
+				if perm != tt.perm {
+					others = append(others, perm)
+				}
+			}
+			payload := models.BulkUserPayload{Action: tt.action, UserIDs: []int{2}, Role: "admin"}
+
+			s, repo, _, audit := newBulkTestService()
+			if _, err := s.BulkUpdateUsers(adminContext(others...), payload); err != ErrUnauthorized {
+				t.Errorf("without %s: got %v, want ErrUnauthorized", tt.perm, err)
+			}
+			if repo.users[2].DisabledAt != nil || repo.users[2].DeletedAt != nil || repo.users[2].Role != DefaultRole || len(audit.entries) != 0 {
+				t.Errorf("unauthorized bulk action changed %+v", repo.users[2])
+			}
+
+			if _, err := s.BulkUpdateUsers(adminContext(tt.perm), payload); err != nil {
+				t.Errorf("with %s: %v", tt.perm, err)
+			}
+		})
+	}
+
+	s, _, _, _ := newBulkTestService()
+	if _, err := s.BulkUpdateUsers(adminContext(all...), models.BulkUserPayload{Action: "purge", UserIDs: []int{2}}); err != ErrUnauthorized {
+		t.Errorf("unknown action: got %v, want ErrUnauthorized", err)
+	}
+	if _, err := s.BulkUpdateUsers(context.WithValue(context.Background(), "permissions", all), models.BulkUserPayload{Action: models.BulkDisable, UserIDs: []int{2}}); err != ErrUnauthorized {
+		t.Errorf("signed out: got %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestExportUsersReadsInBatches(t *testing.T) {
+	const total = 2*exportBatchSize + 7
+	users := make([]*models.User, 0, total)
+	for id := 1; id <= total; id++ {
+		users = append(users, &models.User{ID: id})
+	}
+	s, repo, _, _ := newAdminTestService(users...)
+
+	var exported []int
+	err := s.ExportUsers(adminContext(middleware.PermUsersRead), models.UserFilter{}, func(user *models.User) error {
+		exported = append(exported, user.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ExportUsers: %v", err)
+	}
+
+	if len(exported) != total {
+		t.Fatalf("exported %d users, want %d", len(exported), total)
+	}
+	for i, id := range exported {
+		if id != i+1 {
+			t.Fatalf("user %d exported at position %d", id, i)
+		}
+	}
+
+	if len(repo.pages) != 3 {
+		t.Fatalf("read %d batches, want 3", len(repo.pages))
+	}
+	for i, page := range repo.pages {
+		if page.Limit != exportBatchSize || page.Count != utils.CountNone {
+			t.Errorf("batch %d read with %+v", i, page)
+		}
+		var want []string
+		if i > 0 {
+			last := strconv.Itoa(i * exportBatchSize)
+			want = []string{last, last}
+		}
+		if got := page.Cursor; (got == nil) != (want == nil) || (got != nil && !reflect.DeepEqual(got.Values, want)) {
+			t.Errorf("batch %d continues after %+v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestExportUsersStops(t *testing.T) {
+	users := make([]*models.User, 0, exportBatchSize+1)
+	for id := 1; id <= exportBatchSize+1; id++ {
+		users = append(users, &models.User{ID: id})
+	}
+	s, repo, _, _ := newAdminTestService(users...)
+
+	errWrite := errors.New("client went away")
+	exported := 0
+	err := s.ExportUsers(adminContext(middleware.PermUsersRead), models.UserFilter{}, func(*models.User) error {
+		if exported++; exported == 3 {
+			return errWrite
+		}
+		return nil
+	})
+	if !errors.Is(err, errWrite) || exported != 3 || len(repo.pages) != 1 {
+		t.Errorf("ExportUsers = %v after %d users and %d batches, want to stop at the failed write", err, exported, len(repo.pages))
+	}
+
+	for _, tt := range []struct {
+		name   string
+		perms  []string
+		filter models.UserFilter
+	}{
+		{"without users:read", []string{middleware.PermUsersWrite}, models.UserFilter{}},
+		{"deleted users without users:delete", []string{middleware.PermUsersRead}, models.UserFilter{Deleted: models.DeletedOnly}},
+	} {
+		if err := s.ExportUsers(adminContext(tt.perms...), tt.filter, func(*models.User) error { return nil }); err != ErrUnauthorized {
+			t.Errorf("%s: got %v, want ErrUnauthorized", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"go.uber.org/zap"
+)
+
+// apiKeyPrefix makes keys recognisable, e.g. by secret scanners.
+const (
+	apiKeyPrefix       = "stk_"
+	apiKeyDisplayChars = 8
+)
+
+// CreateAPIKey issues a new API key for the caller. The key may only carry
+// scopes the caller currently holds, and the plain key is returned only once.
+func (s UserService) CreateAPIKey(ctx context.Context, id int, payload models.CreateAPIKeyPayload) (*models.CreatedAPIKey, error) {
+	// requireSelf refuses API keys, so a leaked key cannot mint further keys
+	currentUser, err := s.requireSelf(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, scope := range payload.Scopes {
+		if !hasPermission(ctx, scope) {
+			return nil, ErrInvalidScope
+		}
+	}
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidExpiry
+	}
+
+	secret, err := middleware.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	plain := apiKeyPrefix + secret
+
+	key := &models.APIKey{
+		UserID:    currentUser.ID,
+		Name:      payload.Name,
+		Prefix:    plain[:len(apiKeyPrefix)+apiKeyDisplayChars],
+		KeyHash:   middleware.HashOpaqueToken(plain),
+		Scopes:    payload.Scopes,
+		ExpiresAt: payload.ExpiresAt,
+		CreatedAt: time.Now(),
+	}
+	err = s.withAudit(ctx, models.AuditAPIKeyCreate, currentUser.ID, func(ctx context.Context, entry *models.AuditEntry) error {
+		if err := s.apiKeys.CreateAPIKey(ctx, key); err != nil {
+			return err
+		}
+		recordChange(entry, "api_key", nil, map[string]interface{}{
+			"id":     key.ID,
+			"name":   key.Name,
+			"prefix": key.Prefix,
+			"scopes": key.Scopes,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.CreatedAPIKey{APIKey: key, Key: plain}, nil
+}
+
+// isAPIKeyCaller reports whether the request was authenticated with an API
+// key rather than by the user logging in.
+func isAPIKeyCaller(ctx context.Context) bool {
+	keyID, _ := ctx.Value("api_key_id").(string)
+	return keyID != ""
+}
+
+// scopedCaller returns the caller, who must hold scope if authenticated with
+// an API key. For actions only the caller can take on their own behalf.
+func (s UserService) scopedCaller(ctx context.Context, scope string) (*models.User, error) {
+	if isAPIKeyCaller(ctx) && !hasPermission(ctx, scope) {
+		return nil, ErrUnauthorized
+	}
+	user, err := s.extractUserFromContext(ctx)
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+	return user, nil
+}
+
+// ListAPIKeys returns the keys of a user without their secrets.
+func (s UserService) ListAPIKeys(ctx context.Context, id int) ([]*models.APIKey, error) {
+	if _, err := s.checkAccountManagement(ctx, id, middleware.PermUsersRead); err != nil {
+		return nil, err
+	}
+
+	return s.apiKeys.ListAPIKeys(ctx, id)
+}
+
+// RevokeAPIKey permanently disables one of a user's keys.
+func (s UserService) RevokeAPIKey(ctx context.Context, id, keyID int) error {
+	if _, err := s.checkAccountManagement(ctx, id, middleware.PermUsersWrite); err != nil {
+		return err
+	}
+
+	return s.withAudit(ctx, models.AuditAPIKeyRevoke, id, func(ctx context.Context, entry *models.AuditEntry) error {
+		recordChange(entry, "api_key", keyID, nil)
+		return s.apiKeys.RevokeAPIKey(ctx, id, keyID)
+	})
+}
+
+// APIKeyValidator lets AuthMiddleware authenticate requests by API key.
+type APIKeyValidator struct {
+	keys  models.APIKeyRepository
+	users models.UserRepository
+	roles models.RoleRepository
+	log   *zap.Logger
+}
+
+func NewAPIKeyValidator(keys models.APIKeyRepository, users models.UserRepository, roles models.RoleRepository, log *zap.Logger) *APIKeyValidator {
+	return &APIKeyValidator{keys: keys, users: users, roles: roles, log: log}
+}
+
+// ValidateAPIKey resolves key to its owner. The granted permissions are the
+// key's scopes that the owner's role still holds, so demoting a user also
+// narrows their existing keys.
+func (v *APIKeyValidator) ValidateAPIKey(ctx context.Context, key string) (*middleware.APIKeyPrincipal, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, middleware.ErrInvalidAPIKey
+	}
+
+	apiKey, err := v.keys.GetActiveAPIKeyByHash(ctx, middleware.HashOpaqueToken(key))
+	if err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			return nil, middleware.ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	user, err := v.users.GetUserByID(ctx, apiKey.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, middleware.ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+	if user.DeletedAt != nil || user.DisabledAt != nil {
+		return nil, middleware.ErrInvalidAPIKey
+	}
+
+	role, err := v.roles.GetRole(ctx, user.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	var permissions []string
+	for _, scope := range apiKey.Scopes {
+		for _, perm := range role.Permissions {
+			if scope == perm {
+				permissions = append(permissions, scope)
+				break
+			}
+		}
+	}
+
+	if err := v.keys.TouchAPIKey(ctx, apiKey.ID); err != nil {
+		v.log.Warn("Failed to record API key use", zap.Int("keyID", apiKey.ID), zap.Error(err))
+	}
+
+	return &middleware.APIKeyPrincipal{
+		KeyID:         strconv.Itoa(apiKey.ID),
+		UserID:        strconv.Itoa(user.ID),
+		Username:      user.Email,
+		EmailVerified: user.EmailVerifiedAt != nil,
+		Role:          role.Name,
+		Permissions:   permissions,
+	}, nil
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+)
+
+func TestHasAccessToUserAPIKeyScopes(t *testing.T) {
+	owner := &models.User{ID: 1}
+
+	tests := []struct {
+		name   string
+		keyID  string
+		scopes []string
+		target int
+		want   bool
+	}{
+		{"login on own account", "", nil, 1, true},
+		{"login on another account", "", nil, 2, false},
+		{"login with permission on another account", "", []string{middleware.PermUsersDelete}, 2, true},
+		{"key without scopes on own account", "7", nil, 1, false},
+		{"key with other scope on own account", "7", []string{middleware.PermUsersRead}, 1, false},
+		{"key with scope on own account", "7", []string{middleware.PermUsersDelete}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), "permissions", tt.scopes)
+			if tt.keyID != "" {
+				ctx = context.WithValue(ctx, "api_key_id", tt.keyID)
+			}
+
+			got := UserService{}.hasAccessToUser(ctx, owner, tt.target, middleware.PermUsersDelete)
+			if got != tt.want {
+				t.Errorf("hasAccessToUser = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAccountManagementRefusesAPIKeys(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "api_key_id", "7")
+	ctx = context.WithValue(ctx, "user_id", "1")
+	ctx = context.WithValue(ctx, "permissions", []string{middleware.PermUsersRead, middleware.PermUsersWrite, middleware.PermUsersDelete})
+
+	s := UserService{}
+	if _, err := s.checkAccountManagement(ctx, 1, middleware.PermUsersDelete); err != ErrUnauthorized {
+		t.Errorf("checkAccountManagement = %v, want ErrUnauthorized", err)
+	}
+	if _, err := s.requireSelf(ctx, 1); err != ErrUnauthorized {
+		t.Errorf("requireSelf = %v, want ErrUnauthorized", err)
+	}
+	if err := s.LogoutAll(ctx); err != ErrUnauthorized {
+		t.Errorf("LogoutAll = %v, want ErrUnauthorized", err)
+	}
+	if _, err := s.scopedCaller(context.WithValue(ctx, "permissions", []string(nil)), middleware.PermUsersWrite); err != ErrUnauthorized {
+		t.Errorf("scopedCaller without scope = %v, want ErrUnauthorized", err)
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/common/utils"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+)
+
+// Values of these fields never reach the audit log, only the fact that
+// they changed.
+var redactedAuditFields = map[string]string{
+	"password_hash": "[REDACTED]",
+}
+
+// withAudit applies change and appends an audit entry for it in a single
+// transaction, so there is no change without an entry and no entry for a
+// change that was rolled back. change may add to the entry, e.g. the fields
+// it changed.
+func (s UserService) withAudit(ctx context.Context, action string, targetID int,
+	change func(ctx context.Context, entry *models.AuditEntry) error) error {
+	return s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		entry := newAuditEntry(ctx, action, targetID)
+		if err := change(ctx, entry); err != nil {
+			return err
+		}
+		return s.audit.CreateAuditEntry(ctx, entry)
+	})
+}
+
+// newAuditEntry fills in the actor and request from the values set by
+// LoggingMiddleware and AuthMiddleware. A zero targetID means no target.
+func newAuditEntry(ctx context.Context, action string, targetID int) *models.AuditEntry {
+	entry := &models.AuditEntry{
+		Action:    action,
+		CreatedAt: time.Now(),
+	}
+	if targetID != 0 {
+		entry.TargetID = &targetID
+	}
+	if userID, ok := ctx.Value("user_id").(string); ok {
+		if id, err := strconv.Atoi(userID); err == nil {
+			entry.ActorID = &id
+		}
+	}
+	entry.ClientID, _ = ctx.Value("client_id").(string)
+	entry.RequestID, _ = ctx.Value("request_id").(string)
+	entry.IP, _ = clientInfo(ctx)
+	return entry
+}
+
+// recordChange adds a changed field to entry, redacting secrets.
+func recordChange(entry *models.AuditEntry, field string, old, new interface{}) {
+	if entry.Changes == nil {
+		entry.Changes = make(map[string]models.AuditChange)
+	}
+	if placeholder, ok := redactedAuditFields[field]; ok {
+		old, new = redactedValue(old, placeholder), redactedValue(new, placeholder)
+	}
+	entry.Changes[field] = models.AuditChange{Old: old, New: new}
+}
+
+// redactedValue keeps whether a value was set but not the value itself.
+func redactedValue(value interface{}, placeholder string) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		if v == nil {
+			return nil
+		}
+	case *string:
+		if v == nil {
+			return nil
+		}
+	}
+	return placeholder
+}
+
+// userFieldValue returns the current value of the column an update sets.
+func userFieldValue(user *models.User, field string) interface{} {
+	switch field {
+	case "name":
+		return user.Name
+	case "email":
+		return user.Email
+	case "role":
+		return user.Role
+	case "password_hash":
+		return user.PasswordHash
+	case "avatar_key":
+		return user.AvatarKey
+	case "email_verified_at":
+		return user.EmailVerifiedAt
+	case "pending_email":
+		return user.PendingEmail
+	}
+	return nil
+}
+
+// ListAuditEntries searches the audit log. Requires audit:read.
+func (s UserService) ListAuditEntries(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEntry, *utils.Total, error) {
+	if !hasPermission(ctx, middleware.PermAuditRead) {
+		return nil, nil, ErrUnauthorized
+	}
+
+	return s.audit.ListAuditEntries(ctx, filter)
+}
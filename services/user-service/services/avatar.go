@@ -0,0 +1,300 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/common/storage"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"go.uber.org/zap"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// MaxAvatarSize is the largest avatar upload in bytes.
+	MaxAvatarSize = 5 << 20
+	// Larger images are refused before decoding, a small file can
+	// decompress to gigabytes
+	maxAvatarPixels = 40_000_000
+
+	avatarJPEGQuality = 85
+	// DefaultAvatarBaseURL is where the API serves avatars.
+	DefaultAvatarBaseURL = "/api/v1/avatars"
+)
+
+// AvatarSizes are the square thumbnails, in pixels, generated for every avatar.
+var AvatarSizes = []int{64, 128, 256}
+
+var avatarContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Names of served avatar files: <hash>-<size>.<ext>
+var avatarFilePattern = regexp.MustCompile(`^[0-9a-f]{16}-[0-9]+\.(jpg|png)$`)
+
+// AvatarStore keeps avatar thumbnails in blob storage. A user's avatar is
+// identified by a key "<userID>/<hash>.<ext>" where hash is taken from the
+// uploaded image; every thumbnail is stored as
+// "avatars/<userID>/<hash>-<size>.<ext>". Since a new image gets a new key,
+// served files never change and can be cached forever.
+type AvatarStore struct {
+	blobs   storage.Store
+	baseURL string
+}
+
+func NewAvatarStore(blobs storage.Store, baseURL string) *AvatarStore {
+	if baseURL == "" {
+		baseURL = DefaultAvatarBaseURL
+	}
+	return &AvatarStore{blobs: blobs, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// avatar is an upload resized to every size in AvatarSizes.
+type avatar struct {
+	hash        string
+	ext         string
+	contentType string
+	thumbnails  map[int][]byte
+}
+
+// save stores the thumbnails of a user's avatar and returns its key.
+func (a *AvatarStore) save(ctx context.Context, userID int, av *avatar) (string, error) {
+	key := fmt.Sprintf("%d/%s.%s", userID, av.hash, av.ext)
+	for _, size := range AvatarSizes {
+		if err := a.blobs.Put(ctx, a.blobKey(key, size), av.thumbnails[size], av.contentType); err != nil {
+			a.delete(ctx, key)
+			return "", fmt.Errorf("failed to store avatar: %w", err)
+		}
+	}
+	return key, nil
+}
+
+// delete removes every thumbnail of an avatar, carrying on past failures.
+func (a *AvatarStore) delete(ctx context.Context, key string) error {
+	var errs []error
+	for _, size := range AvatarSizes {
+		if err := a.blobs.Delete(ctx, a.blobKey(key, size)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// get returns a served avatar file.
+func (a *AvatarStore) get(ctx context.Context, userID, file string) (*storage.Object, error) {
+	if _, err := strconv.Atoi(userID); err != nil || !avatarFilePattern.MatchString(file) {
+		return nil, ErrAvatarNotFound
+	}
+
+	object, err := a.blobs.Get(ctx, "avatars/"+userID+"/"+file)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrAvatarNotFound
+	}
+	return object, err
+}
+
+// URLs maps each thumbnail size of an avatar to the URL serving it.
+func (a *AvatarStore) URLs(key string) map[string]string {
+	urls := make(map[string]string, len(AvatarSizes))
+	for _, size := range AvatarSizes {
+		urls[strconv.Itoa(size)] = a.baseURL + "/" + thumbnailName(key, size)
+	}
+	return urls
+}
+
+func (a *AvatarStore) blobKey(key string, size int) string {
+	return "avatars/" + thumbnailName(key, size)
+}
+
+// thumbnailName turns "<userID>/<hash>.<ext>" into "<userID>/<hash>-<size>.<ext>".
+func thumbnailName(key string, size int) string {
+	dot := strings.LastIndex(key, ".")
+	return fmt.Sprintf("%s-%d%s", key[:dot], size, key[dot:])
+}
+
+// processAvatar validates an uploaded image and renders its thumbnails.
+// The content type is sniffed, what the client claims is ignored.
+func processAvatar(data []byte) (*avatar, error) {
+	if len(data) > MaxAvatarSize {
+		return nil, ErrAvatarTooLarge
+	}
+	if !avatarContentTypes[http.DetectContentType(data)] {
+		return nil, ErrUnsupportedAvatar
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidAvatar
+	}
+	if config.Width*config.Height > maxAvatarPixels {
+		return nil, ErrAvatarTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidAvatar
+	}
+
+	// Photos become JPEGs, images with transparency keep it as PNGs
+	av := &avatar{ext: "jpg", contentType: "image/jpeg", thumbnails: make(map[int][]byte, len(AvatarSizes))}
+	if opaque, ok := src.(interface{ Opaque() bool }); !ok || !opaque.Opaque() {
+		av.ext, av.contentType = "png", "image/png"
+	}
+
+	sum := sha256.Sum256(data)
+	av.hash = hex.EncodeToString(sum[:8])
+
+	for _, size := range AvatarSizes {
+		var buf bytes.Buffer
+		thumbnail := resizeAvatar(src, size)
+		if av.ext == "jpg" {
+			err = jpeg.Encode(&buf, thumbnail, &jpeg.Options{Quality: avatarJPEGQuality})
+		} else {
+			err = png.Encode(&buf, thumbnail)
+		}
+		if err != nil {
+			return nil, err
+		}
+		av.thumbnails[size] = buf.Bytes()
+	}
+	return av, nil
+}
+
+// resizeAvatar crops the center square of src and scales it to size.
+func resizeAvatar(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	x := bounds.Min.X + (bounds.Dx()-side)/2
+	y := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, image.Rect(x, y, x+side, y+side), draw.Src, nil)
+	return dst
+}
+
+// UploadAvatar replaces a user's avatar with data, a JPEG, PNG, GIF or WebP
+// image. Requires users:write to change another user's avatar.
+func (s UserService) UploadAvatar(ctx context.Context, id int, data []byte) (*models.User, error) {
+	if _, err := s.checkPermissions(ctx, id, middleware.PermUsersWrite); err != nil {
+		return nil, err
+	}
+
+	target, err := s.repo.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	av, err := processAvatar(data)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := s.avatars.save(ctx, id, av)
+	if err != nil {
+		return nil, err
+	}
+	if target.AvatarKey != nil && *target.AvatarKey == key {
+		return s.withAvatarURLs(target), nil
+	}
+
+	var updatedUser *models.User
+	err = s.withAudit(ctx, models.AuditAvatarUpdate, id, func(ctx context.Context, entry *models.AuditEntry) error {
+		recordChange(entry, "avatar_key", target.AvatarKey, key)
+		updatedUser, err = s.repo.UpdateUser(ctx, id, map[string]interface{}{
+			"avatar_key": key,
+			"updated_at": time.Now(),
+		})
+		return err
+	})
+	if err != nil {
+		s.deleteAvatarFiles(ctx, id, key)
+		return nil, err
+	}
+
+	if target.AvatarKey != nil {
+		s.deleteAvatarFiles(ctx, id, *target.AvatarKey)
+	}
+	return s.withAvatarURLs(updatedUser), nil
+}
+
+// DeleteAvatar removes a user's avatar. Requires users:write to remove
+// another user's avatar.
+func (s UserService) DeleteAvatar(ctx context.Context, id int) error {
+	if _, err := s.checkPermissions(ctx, id, middleware.PermUsersWrite); err != nil {
+		return err
+	}
+
+	target, err := s.repo.GetUserByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if target.AvatarKey == nil {
+		return ErrAvatarNotFound
+	}
+
+	err = s.withAudit(ctx, models.AuditAvatarDelete, id, func(ctx context.Context, entry *models.AuditEntry) error {
+		recordChange(entry, "avatar_key", target.AvatarKey, nil)
+		_, err := s.repo.ClearAvatar(ctx, id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	s.deleteAvatarFiles(ctx, id, *target.AvatarKey)
+	return nil
+}
+
+// GetAvatar returns a thumbnail by the file name in its URL. Avatars are
+// public, like the URLs handed out for them.
+func (s UserService) GetAvatar(ctx context.Context, userID, file string) (*storage.Object, error) {
+	return s.avatars.get(ctx, userID, file)
+}
+
+// eraseAvatar deletes the avatar of a user in any state, for erasure. The
+// images are deleted once the erasure commits, since a rollback restores
+// the key.
+func (s UserService) eraseAvatar(ctx context.Context, userID int) error {
+	key, err := s.repo.ClearAvatar(ctx, userID)
+	if err != nil || key == nil {
+		return err
+	}
+	afterErasure(ctx, func(ctx context.Context) {
+		s.deleteAvatarFiles(ctx, userID, *key)
+	})
+	return nil
+}
+
+// deleteAvatarFiles removes the images of a replaced avatar. Failing only
+// leaves unreferenced files behind, so it is logged and not returned.
+func (s UserService) deleteAvatarFiles(ctx context.Context, userID int, key string) {
+	if err := s.avatars.delete(ctx, key); err != nil {
+		s.log.Error("Failed to delete avatar images", zap.Int("userID", userID), zap.String("key", key), zap.Error(err))
+	}
+}
+
+// withAvatarURLs fills in the avatar URLs of a user before it is returned.
+func (s UserService) withAvatarURLs(user *models.User) *models.User {
+	if user != nil && user.AvatarKey != nil {
+		user.AvatarURLs = s.avatars.URLs(*user.AvatarKey)
+	}
+	return user
+}
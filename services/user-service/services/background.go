@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"sync"
+)
+
+// Background tracks goroutines that outlive the request or call that
+// started them, so that shutdown can wait for them instead of cutting them
+// off.
+type Background struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	stopped bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func NewBackground() *Background {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Background{ctx: ctx, cancel: cancel}
+}
+
+// Go runs fn in a tracked goroutine. fn's context is cancelled when Wait
+// gives up on it. Once Wait has been called, fn runs in the caller instead,
+// so that late work is neither lost nor untracked.
+func (b *Background) Go(fn func(ctx context.Context)) {
+	b.mu.Lock()
+	if b.stopped {
+		b.mu.Unlock()
+		fn(b.ctx)
+		return
+	}
+	b.wg.Add(1)
+	b.mu.Unlock()
+
+	go func() {
+		defer b.wg.Done()
+		fn(b.ctx)
+	}()
+}
+
+// Wait blocks until every tracked goroutine has returned. If ctx ends
+// first, it cancels the goroutines still running and returns ctx.Err()
+// without waiting for them to notice.
+func (b *Background) Wait(ctx context.Context) error {
+	b.mu.Lock()
+	b.stopped = true
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		return ctx.Err()
+	}
+}
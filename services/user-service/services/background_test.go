@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackgroundWaitsForWork(t *testing.T) {
+	b := NewBackground()
+	var finished atomic.Int32
+	for i := 0; i < 3; i++ {
+		b.Go(func(context.Context) {
+			time.Sleep(20 * time.Millisecond)
+			finished.Add(1)
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := b.Wait(ctx); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if got := finished.Load(); got != 3 {
+		t.Errorf("Wait returned after %d of 3 goroutines", got)
+	}
+}
+
+func TestBackgroundWaitTimeoutCancelsWork(t *testing.T) {
+	b := NewBackground()
+	started, cancelled := make(chan struct{}), make(chan struct{})
+	b.Go(func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := b.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait error = %v, want context.DeadlineExceeded", err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("work was not cancelled when Wait gave up")
+	}
+}
+
+func TestBackgroundRunsLateWorkInCaller(t *testing.T) {
+	b := NewBackground()
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	ran := false
+	b.Go(func(context.Context) { ran = true })
+	if !ran {
+		t.Error("work started after Wait did not run before Go returned")
+	}
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+)
+
+// fakeTransactor runs functions without a transaction, so nothing is
+// rolled back.
+type fakeTransactor struct{}
+
+func (fakeTransactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+type fakeAuditStore struct {
+	models.AuditRepository
+	entries []*models.AuditEntry
+}
+
+func (f *fakeAuditStore) CreateAuditEntry(_ context.Context, entry *models.AuditEntry) error {
+	f.entries = append(f.entries, entry)
+	return nil
+}
+
+// staticRoles grants every role read access to users.
+type staticRoles struct {
+	models.RoleRepository
+}
+
+func (staticRoles) GetRole(_ context.Context, name string) (*models.Role, error) {
+	return &models.Role{Name: name, Permissions: []string{middleware.PermUsersRead}}, nil
+}
+
+// newTestTokenService signs with a fresh in-memory key.
+func newTestTokenService(t *testing.T) middleware.TokenService {
+	t.Helper()
+	keys, err := middleware.NewKeyManager(context.Background(), middleware.NewMemoryKeyStore(), 24*time.Hour, time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeyManager: %v", err)
+	}
+	return *middleware.NewTokenService(keys)
+}
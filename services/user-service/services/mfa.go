@@ -0,0 +1,222 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"go.uber.org/zap"
+)
+
+// LoginMFA completes a login started by LoginUser using either a TOTP code
+// or a recovery code. The MFA token is single-use once a code is accepted.
+func (s UserService) LoginMFA(ctx context.Context, payload models.MFALoginPayload) (*models.LoginResponse, error) {
+	response := &models.LoginResponse{
+		Success: false,
+		Message: "Login failed",
+	}
+
+	if s.throttle.RetryAfter(payload.IP) > 0 {
+		response.Message = ErrTooManyAttempts.Error()
+		return response, ErrTooManyAttempts
+	}
+
+	claims, err := s.tokenService.ValidateMFAToken(payload.MFAToken)
+	if err != nil {
+		response.Message = ErrInvalidMFAToken.Error()
+		return response, ErrInvalidMFAToken
+	}
+
+	revoked, err := s.tokens.IsRevoked(ctx, claims)
+	if err != nil {
+		response.Message = ErrInternalServerError.Error()
+		return response, err
+	}
+	if revoked {
+		response.Message = ErrInvalidMFAToken.Error()
+		return response, ErrInvalidMFAToken
+	}
+
+	userID, err := strconv.Atoi(claims.UserID)
+	if err != nil {
+		response.Message = ErrInvalidMFAToken.Error()
+		return response, ErrInvalidMFAToken
+	}
+
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		response.Message = ErrInvalidMFAToken.Error()
+		return response, ErrInvalidMFAToken
+	}
+
+	if user.DeletedAt != nil {
+		response.Message = ErrUserDeleted.Error()
+		return response, ErrUserDeleted
+	}
+	if user.DisabledAt != nil {
+		response.Message = ErrAccountDisabled.Error()
+		return response, ErrAccountDisabled
+	}
+
+	if isLocked(user) {
+		response.Message = ErrInvalidCredentials.Error()
+		return response, ErrAccountLocked
+	}
+
+	if err := s.verifySecondFactor(ctx, user.ID, payload.Code); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			s.recordFailedLogin(ctx, user.ID, payload.IP)
+		}
+		response.Message = ErrInvalidMFACode.Error()
+		return response, err
+	}
+
+	if err := s.tokens.RevokeAccessToken(ctx, claims.Id, user.ID, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		response.Message = ErrInternalServerError.Error()
+		return response, err
+	}
+
+	return s.completeLogin(ctx, user, response)
+}
+
+// EnrollMFA creates a new pending TOTP secret for the caller. MFA is not
+// enforced until the secret is confirmed with ActivateMFA.
+func (s UserService) EnrollMFA(ctx context.Context, id int) (*models.MFAEnrollment, error) {
+	currentUser, err := s.requireSelf(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.mfa.SaveMFASecret(ctx, currentUser.ID, secret); err != nil {
+		return nil, err
+	}
+
+	return &models.MFAEnrollment{
+		Secret:     secret,
+		OTPAuthURI: totpURI(currentUser.Email, secret),
+	}, nil
+}
+
+// ActivateMFA enables MFA once the caller proves their authenticator works
+// and returns recovery codes, which are shown only this once.
+func (s UserService) ActivateMFA(ctx context.Context, id int, payload models.MFACodePayload) (*models.MFARecoveryCodes, error) {
+	currentUser, err := s.requireSelf(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	mfa, err := s.mfa.GetMFA(ctx, currentUser.ID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa.EnabledAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	step, ok := validateTOTP(mfa.Secret, payload.Code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+	if err := s.mfa.MarkTOTPStepUsed(ctx, currentUser.ID, step); err != nil {
+		return nil, err
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = middleware.HashOpaqueToken(normalizeRecoveryCode(code))
+	}
+
+	err = s.withAudit(ctx, models.AuditMFAEnable, currentUser.ID, func(ctx context.Context, _ *models.AuditEntry) error {
+		return s.mfa.EnableMFA(ctx, currentUser.ID, hashes)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.MFARecoveryCodes{RecoveryCodes: codes}, nil
+}
+
+// DisableMFA turns MFA off for the caller after checking a current code.
+func (s UserService) DisableMFA(ctx context.Context, id int, payload models.MFACodePayload) error {
+	currentUser, err := s.requireSelf(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	enabled, err := s.isMFAEnabled(ctx, currentUser.ID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrMFANotEnrolled
+	}
+
+	if err := s.verifySecondFactor(ctx, currentUser.ID, payload.Code); err != nil {
+		return err
+	}
+
+	return s.withAudit(ctx, models.AuditMFADisable, currentUser.ID, func(ctx context.Context, _ *models.AuditEntry) error {
+		return s.mfa.DisableMFA(ctx, currentUser.ID)
+	})
+}
+
+// verifySecondFactor accepts a TOTP code or, failing that, an unused recovery code.
+func (s UserService) verifySecondFactor(ctx context.Context, userID int, code string) error {
+	mfa, err := s.mfa.GetMFA(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if step, ok := validateTOTP(mfa.Secret, code, time.Now()); ok {
+		return s.mfa.MarkTOTPStepUsed(ctx, userID, step)
+	}
+
+	if err := s.mfa.ConsumeRecoveryCode(ctx, userID, middleware.HashOpaqueToken(normalizeRecoveryCode(code))); err != nil {
+		if !errors.Is(err, ErrInvalidMFACode) {
+			s.log.Error("Failed to check recovery code", zap.Int("userID", userID), zap.Error(err))
+		}
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+func (s UserService) isMFAEnabled(ctx context.Context, userID int) (bool, error) {
+	mfa, err := s.mfa.GetMFA(ctx, userID)
+	if err != nil {
+		if errors.Is(err, ErrMFANotEnrolled) {
+			return false, nil
+		}
+		return false, err
+	}
+	return mfa.EnabledAt != nil, nil
+}
+
+// requireSelf returns the caller if they are acting on their own account,
+// logged in rather than through an API key.
+func (s UserService) requireSelf(ctx context.Context, id int) (*models.User, error) {
+	if isAPIKeyCaller(ctx) {
+		return nil, ErrUnauthorized
+	}
+	currentUser, err := s.extractUserFromContext(ctx)
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+	if currentUser.ID != id {
+		return nil, ErrUnauthorized
+	}
+	return currentUser, nil
+}
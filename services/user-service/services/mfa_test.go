@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"go.uber.org/zap"
+)
+
+// fakeMFAStore keeps one user's enrollment with the replay semantics of
+// repository.MFAStore.
+type fakeMFAStore struct {
+	mfa           models.UserMFA
+	recoveryCodes map[string]bool // hash to used
+}
+
+func (f *fakeMFAStore) GetMFA(_ context.Context, userID int) (*models.UserMFA, error) {
+	if userID != f.mfa.UserID {
+		return nil, ErrMFANotEnrolled
+	}
+	mfa := f.mfa
+	return &mfa, nil
+}
+
+func (f *fakeMFAStore) SaveMFASecret(_ context.Context, _ int, secret string) error {
+	f.mfa.Secret = secret
+	return nil
+}
+
+func (f *fakeMFAStore) EnableMFA(_ context.Context, _ int, hashes []string) error {
+	now := time.Now()
+	f.mfa.EnabledAt = &now
+	f.recoveryCodes = make(map[string]bool)
+	for _, hash := range hashes {
+		f.recoveryCodes[hash] = false
+	}
+	return nil
+}
+
+func (f *fakeMFAStore) DisableMFA(_ context.Context, _ int) error {
+	f.mfa = models.UserMFA{}
+	return nil
+}
+
+func (f *fakeMFAStore) MarkTOTPStepUsed(_ context.Context, _ int, step int64) error {
+	if f.mfa.LastUsedStep != nil && *f.mfa.LastUsedStep >= step {
+		return ErrInvalidMFACode
+	}
+	f.mfa.LastUsedStep = &step
+	return nil
+}
+
+func (f *fakeMFAStore) ConsumeRecoveryCode(_ context.Context, _ int, hash string) error {
+	used, ok := f.recoveryCodes[hash]
+	if !ok || used {
+		return ErrInvalidMFACode
+	}
+	f.recoveryCodes[hash] = true
+	return nil
+}
+
+func newMFATestService(t *testing.T) (UserService, *fakeMFAStore, []string) {
+	t.Helper()
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = middleware.HashOpaqueToken(normalizeRecoveryCode(code))
+	}
+
+	store := &fakeMFAStore{mfa: models.UserMFA{UserID: 1, Secret: rfc6238Secret}}
+	if err := store.EnableMFA(context.Background(), 1, hashes); err != nil {
+		t.Fatal(err)
+	}
+	return UserService{mfa: store, log: zap.NewNop()}, store, codes
+}
+
+func currentTOTPCode(offset int64) string {
+	return totpCode([]byte("12345678901234567890"), time.Now().Unix()/totpPeriod+offset)
+}
+
+func TestVerifySecondFactorRejectsReplay(t *testing.T) {
+	ctx := context.Background()
+	s, _, _ := newMFATestService(t)
+
+	code := currentTOTPCode(0)
+	if err := s.verifySecondFactor(ctx, 1, code); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := s.verifySecondFactor(ctx, 1, code); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("replayed code: got %v, want ErrInvalidMFACode", err)
+	}
+}
+
+func TestVerifySecondFactorSkew(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("code of an earlier step after a later one", func(t *testing.T) {
+		s, _, _ := newMFATestService(t)
+		if err := s.verifySecondFactor(ctx, 1, currentTOTPCode(1)); err != nil {
+			t.Fatalf("next step: %v", err)
+		}
+		if err := s.verifySecondFactor(ctx, 1, currentTOTPCode(0)); !errors.Is(err, ErrInvalidMFACode) {
+			t.Errorf("earlier step accepted after a later one: %v", err)
+		}
+	})
+
+	t.Run("codes of successive steps", func(t *testing.T) {
+		s, _, _ := newMFATestService(t)
+		// Steps ahead, so that crossing a step boundary mid-test cannot push
+		// a code out of the window
+		if err := s.verifySecondFactor(ctx, 1, currentTOTPCode(0)); err != nil {
+			t.Fatalf("current step: %v", err)
+		}
+		if err := s.verifySecondFactor(ctx, 1, currentTOTPCode(1)); err != nil {
+			t.Errorf("next step after the current one: %v", err)
+		}
+	})
+
+	t.Run("code outside the window", func(t *testing.T) {
+		s, _, _ := newMFATestService(t)
+		if err := s.verifySecondFactor(ctx, 1, currentTOTPCode(-3)); !errors.Is(err, ErrInvalidMFACode) {
+			t.Errorf("got %v, want ErrInvalidMFACode", err)
+		}
+	})
+}
+
+func TestVerifySecondFactorRecoveryCodes(t *testing.T) {
+	ctx := context.Background()
+	s, store, codes := newMFATestService(t)
+
+	if err := s.verifySecondFactor(ctx, 1, codes[0]); err != nil {
+		t.Fatalf("recovery code: %v", err)
+	}
+	if err := s.verifySecondFactor(ctx, 1, codes[0]); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("reused recovery code: got %v, want ErrInvalidMFACode", err)
+	}
+
+	// Typed without the dash and in upper case
+	relaxed := codes[1][:5] + codes[1][6:]
+	if err := s.verifySecondFactor(ctx, 1, " "+strings.ToUpper(relaxed)+" "); err != nil {
+		t.Errorf("normalized recovery code: %v", err)
+	}
+
+	if err := s.verifySecondFactor(ctx, 1, "aaaaa-aaaaa"); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("unknown recovery code: got %v, want ErrInvalidMFACode", err)
+	}
+	if store.mfa.LastUsedStep != nil {
+		t.Error("a recovery code recorded a TOTP step")
+	}
+}
+
+func TestVerifySecondFactorNotEnrolled(t *testing.T) {
+	s, _, _ := newMFATestService(t)
+	if err := s.verifySecondFactor(context.Background(), 2, currentTOTPCode(0)); !errors.Is(err, ErrMFANotEnrolled) {
+		t.Errorf("got %v, want ErrMFANotEnrolled", err)
+	}
+}
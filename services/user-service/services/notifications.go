@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/luisVargasGu/stockTracker/common/mailer"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+)
+
+// AccountNotifier composes the account related emails sent to users and
+// builds the links in them from the public URL of the web client.
+type AccountNotifier struct {
+	mailer mailer.Mailer
+	appURL string
+}
+
+func NewAccountNotifier(m mailer.Mailer, appURL string) *AccountNotifier {
+	return &AccountNotifier{mailer: m, appURL: strings.TrimRight(appURL, "/")}
+}
+
+func (n *AccountNotifier) SendPasswordReset(ctx context.Context, user *models.User, token string, ttl time.Duration) error {
+	link := n.link("/reset-password", token)
+
+	return n.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Stock Tracker password",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"We received a request to reset your password. Use the link below to choose a new one:\n\n"+
+			"%s\n\n"+
+			"The link expires in %s and can only be used once. "+
+			"If you did not request a reset you can ignore this email.\n",
+			displayName(user), link, ttl),
+	})
+}
+
+// SendEmailVerification asks the owner of email to confirm it. For an email
+// change this is the new address, not the one currently on the account.
+func (n *AccountNotifier) SendEmailVerification(ctx context.Context, user *models.User, email, token string, ttl time.Duration) error {
+	link := n.link("/verify-email", token)
+
+	return n.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Confirm your Stock Tracker email address",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Please confirm that %s is your email address by opening the link below:\n\n"+
+			"%s\n\n"+
+			"The link expires in %s. If you did not create an account or change your email, "+
+			"you can ignore this email.\n",
+			displayName(user), email, link, ttl),
+	})
+}
+
+// SendDataExportReady tells the user their personal data export can be
+// downloaded.
+func (n *AccountNotifier) SendDataExportReady(ctx context.Context, user *models.User, ttl time.Duration) error {
+	return n.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your Stock Tracker data export is ready",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"The copy of your personal data you requested is ready. "+
+			"Sign in to %s to download it within the next %s.\n\n"+
+			"If you did not request an export, change your password.\n",
+			displayName(user), n.appURL, ttl),
+	})
+}
+
+// SendOrgInvitation invites the owner of email to join org on behalf of inviter.
+func (n *AccountNotifier) SendOrgInvitation(ctx context.Context, email string, org *models.Organization,
+	inviter *models.User, token string, ttl time.Duration) error {
+	link := n.link("/invitations", token)
+
+	return n.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: fmt.Sprintf("Join %s on Stock Tracker", org.Name),
+		Body: fmt.Sprintf("Hi,\n\n"+
+			"%s invited you to join %s on Stock Tracker. Open the link below to accept or decline:\n\n"+
+			"%s\n\n"+
+			"The invitation expires in %s. To accept it, sign in or sign up with this email address. "+
+			"If you do not know %s you can ignore this email.\n",
+			displayName(inviter), org.Name, link, ttl, displayName(inviter)),
+	})
+}
+
+func (n *AccountNotifier) link(path, token string) string {
+	return n.appURL + path + "?token=" + url.QueryEscape(token)
+}
+
+func displayName(user *models.User) string {
+	if user.Name != "" {
+		return user.Name
+	}
+	return user.Email
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"go.uber.org/zap"
+)
+
+// OIDCStateTTL bounds how long a user may take at the provider's login page.
+const OIDCStateTTL = 10 * time.Minute
+
+// IdentityProviders lists the names of the configured providers.
+func (s UserService) IdentityProviders() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AuthorizeExternal starts a login at provider. The returned URL carries a
+// fresh state, nonce and PKCE challenge; the matching verifier never leaves
+// the server. The state binding must be kept by the browser and presented
+// with the callback.
+func (s UserService) AuthorizeExternal(ctx context.Context, provider string) (*models.OIDCAuthorization, error) {
+	p, ok := s.providers[provider]
+	if !ok {
+		return nil, ErrProviderNotFound
+	}
+
+	state, err := middleware.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := middleware.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := middleware.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	authURL, err := p.AuthCodeURL(ctx, state, nonce, pkceChallenge(verifier))
+	if err != nil {
+		s.log.Error("Failed to build authorization URL", zap.String("provider", provider), zap.Error(err))
+		return nil, ErrExternalLoginFailed
+	}
+
+	stateHash := middleware.HashOpaqueToken(state)
+	err = s.identities.CreateOIDCState(ctx, &models.OIDCState{
+		StateHash:    stateHash,
+		Provider:     provider,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		ExpiresAt:    time.Now().Add(OIDCStateTTL),
+		CreatedAt:    time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.OIDCAuthorization{AuthorizationURL: authURL, State: state, StateBinding: stateHash}, nil
+}
+
+// LoginExternal completes a login started by AuthorizeExternal. The provider
+// account is matched to a linked identity first, then to a user with the same
+// verified email, and otherwise a new user is registered.
+func (s UserService) LoginExternal(ctx context.Context, provider string, payload models.OIDCCallbackPayload) (*models.LoginResponse, error) {
+	response := &models.LoginResponse{
+		Success: false,
+		Message: "Login failed",
+	}
+
+	p, ok := s.providers[provider]
+	if !ok {
+		response.Message = ErrProviderNotFound.Error()
+		return response, ErrProviderNotFound
+	}
+
+	// Without this anyone could start a login, finish it with their own
+	// provider account and have a victim's browser complete it (login CSRF).
+	// Checked before the state is consumed, so a forged callback does not
+	// spoil the victim's own login.
+	stateHash := middleware.HashOpaqueToken(payload.State)
+	if subtle.ConstantTimeCompare([]byte(stateHash), []byte(payload.StateBinding)) != 1 {
+		response.Message = ErrInvalidOIDCState.Error()
+		return response, ErrInvalidOIDCState
+	}
+
+	state, err := s.identities.ConsumeOIDCState(ctx, stateHash)
+	if err != nil {
+		response.Message = ErrInvalidOIDCState.Error()
+		return response, err
+	}
+	if state.Provider != provider {
+		response.Message = ErrInvalidOIDCState.Error()
+		return response, ErrInvalidOIDCState
+	}
+
+	identity, err := p.Exchange(ctx, payload.Code, state.CodeVerifier, state.Nonce)
+	if err != nil {
+		s.log.Warn("External login failed", zap.String("provider", provider), zap.Error(err))
+		response.Message = ErrExternalLoginFailed.Error()
+		return response, ErrExternalLoginFailed
+	}
+
+	user, err := s.resolveIdentity(ctx, identity)
+	if err != nil {
+		response.Message = err.Error()
+		return response, err
+	}
+
+	if user.DeletedAt != nil {
+		response.Message = ErrUserDeleted.Error()
+		return response, ErrUserDeleted
+	}
+	if user.DisabledAt != nil {
+		response.Message = ErrAccountDisabled.Error()
+		return response, ErrAccountDisabled
+	}
+
+	return s.continueLogin(ctx, user, response)
+}
+
+// resolveIdentity finds or creates the user owning an external identity.
+func (s UserService) resolveIdentity(ctx context.Context, identity *ExternalIdentity) (*models.User, error) {
+	linked, err := s.identities.GetIdentity(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		return s.repo.GetUserByID(ctx, linked.UserID)
+	}
+	if !errors.Is(err, ErrIdentityNotFound) {
+		return nil, err
+	}
+
+	// Linking by email is only safe when the provider vouches for it
+	identity.Email = strings.ToLower(strings.TrimSpace(identity.Email))
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, ErrExternalEmailUnverified
+	}
+
+	user, err := s.repo.GetUserByEmail(ctx, identity.Email)
+	switch {
+	case err == nil:
+		if user.EmailVerifiedAt == nil {
+			if user, err = s.claimUnverifiedAccount(ctx, user); err != nil {
+				return nil, err
+			}
+		}
+	case errors.Is(err, ErrUserNotFound):
+		if user, err = s.registerExternalUser(ctx, identity); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	err = s.identities.LinkIdentity(ctx, &models.UserIdentity{
+		UserID:    user.ID,
+		Provider:  identity.Provider,
+		Subject:   identity.Subject,
+		Email:     identity.Email,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// claimUnverifiedAccount hands an account whose email was never verified to
+// the person who just proved they own that email. Anyone else could have
+// registered it, so the password is replaced and existing sessions revoked.
+func (s UserService) claimUnverifiedAccount(ctx context.Context, user *models.User) (*models.User, error) {
+	passwordHash, err := unusablePasswordHash()
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed *models.User
+	err = s.withAudit(ctx, models.AuditAccountClaim, user.ID, func(ctx context.Context, entry *models.AuditEntry) error {
+		if err := s.tokens.RevokeAllUserTokens(ctx, user.ID); err != nil {
+			return err
+		}
+
+		verifiedAt := time.Now()
+		recordChange(entry, "password_hash", user.PasswordHash, passwordHash)
+		recordChange(entry, "email_verified_at", nil, verifiedAt)
+		claimed, err = s.repo.UpdateUser(ctx, user.ID, map[string]interface{}{
+			"password_hash":     passwordHash,
+			"email_verified_at": verifiedAt,
+			"updated_at":        time.Now(),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Warn("Unverified account claimed through external login", zap.Int("userID", user.ID))
+	return claimed, nil
+}
+
+// registerExternalUser creates a user for a first time external login. The
+// user has no usable password until they set one with a password reset.
+func (s UserService) registerExternalUser(ctx context.Context, identity *ExternalIdentity) (*models.User, error) {
+	passwordHash, err := unusablePasswordHash()
+	if err != nil {
+		return nil, err
+	}
+
+	name := identity.Name
+	if name == "" {
+		name = identity.Email
+	}
+
+	createdUser, err := s.repo.CreateUser(ctx, &models.User{
+		Email:        identity.Email,
+		Name:         name,
+		PasswordHash: passwordHash,
+		Role:         DefaultRole,
+		CreatedAt:    time.Now(),
+		LastLogin:    time.Now(),
+		UpdatedAt:    time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repo.UpdateUser(ctx, createdUser.ID, map[string]interface{}{
+		"email_verified_at": time.Now(),
+	})
+}
+
+// unusablePasswordHash hashes a random secret nobody knows.
+func unusablePasswordHash() (string, error) {
+	secret, err := middleware.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	return middleware.HashPassword(secret)
+}
@@ -0,0 +1,442 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+)
+
+const (
+	providerTypeOIDC   = "oidc"
+	providerTypeGitHub = "github"
+
+	providerTimeout = 10 * time.Second
+	// Clock skew tolerated on ID token timestamps
+	idTokenLeeway = time.Minute
+
+	githubAuthURL  = "https://github.com/login/oauth/authorize"
+	githubTokenURL = "https://github.com/login/oauth/access_token"
+	githubAPIURL   = "https://api.github.com"
+)
+
+// OIDCProviderConfig configures one external identity provider. Any OIDC
+// compliant provider only needs Issuer; its endpoints are discovered. GitHub
+// is not an OIDC provider and is configured with Type "github".
+type OIDCProviderConfig struct {
+	Name         string   `json:"name"`
+	Type         string   `json:"type"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RedirectURL  string   `json:"redirect_url"`
+	Scopes       []string `json:"scopes"`
+
+	// Optional endpoint overrides, e.g. for GitHub Enterprise
+	AuthURL     string `json:"auth_url"`
+	TokenURL    string `json:"token_url"`
+	UserInfoURL string `json:"userinfo_url"`
+}
+
+// ExternalIdentity is the account a user proved to own at a provider.
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// IdentityProvider runs the authorization code flow with PKCE against one provider.
+type IdentityProvider interface {
+	Name() string
+	AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error)
+	Exchange(ctx context.Context, code, codeVerifier, nonce string) (*ExternalIdentity, error)
+}
+
+// LoadIdentityProviders reads a JSON array of OIDCProviderConfig from path.
+func LoadIdentityProviders(path string) (map[string]IdentityProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []OIDCProviderConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("invalid identity provider config %s: %w", path, err)
+	}
+
+	return NewIdentityProviders(configs...)
+}
+
+func NewIdentityProviders(configs ...OIDCProviderConfig) (map[string]IdentityProvider, error) {
+	providers := make(map[string]IdentityProvider, len(configs))
+	for _, cfg := range configs {
+		if cfg.Name == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+			return nil, fmt.Errorf("identity provider %q needs a name, client_id and redirect_url", cfg.Name)
+		}
+		if _, ok := providers[cfg.Name]; ok {
+			return nil, fmt.Errorf("duplicate identity provider %q", cfg.Name)
+		}
+
+		switch cfg.Type {
+		case "", providerTypeOIDC:
+			if cfg.Issuer == "" {
+				return nil, fmt.Errorf("identity provider %q needs an issuer", cfg.Name)
+			}
+			providers[cfg.Name] = newOIDCProvider(cfg)
+		case providerTypeGitHub:
+			providers[cfg.Name] = newGitHubProvider(cfg)
+		default:
+			return nil, fmt.Errorf("identity provider %q has unknown type %q", cfg.Name, cfg.Type)
+		}
+	}
+	return providers, nil
+}
+
+// pkceChallenge derives the S256 code challenge of RFC 7636.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcProvider struct {
+	cfg    OIDCProviderConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      *middleware.RemoteKeySet
+}
+
+func newOIDCProvider(cfg OIDCProviderConfig) *oidcProvider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	cfg.Issuer = strings.TrimRight(cfg.Issuer, "/")
+	return &oidcProvider{cfg: cfg, client: &http.Client{Timeout: providerTimeout}}
+}
+
+func (p *oidcProvider) Name() string {
+	return p.cfg.Name
+}
+
+// discover fetches the provider metadata once and keeps it for the life of
+// the process. Failures are not cached so a provider outage is retried.
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, *middleware.RemoteKeySet, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, p.keys, nil
+	}
+
+	var doc oidcDiscovery
+	if err := getJSON(ctx, p.client, p.cfg.Issuer+"/.well-known/openid-configuration", "", &doc); err != nil {
+		return nil, nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if strings.TrimRight(doc.Issuer, "/") != p.cfg.Issuer {
+		return nil, nil, fmt.Errorf("OIDC discovery returned issuer %q, expected %q", doc.Issuer, p.cfg.Issuer)
+	}
+	if p.cfg.AuthURL != "" {
+		doc.AuthorizationEndpoint = p.cfg.AuthURL
+	}
+	if p.cfg.TokenURL != "" {
+		doc.TokenEndpoint = p.cfg.TokenURL
+	}
+
+	p.discovery = &doc
+	p.keys = middleware.NewRemoteKeySet(doc.JWKSURI)
+	return p.discovery, p.keys, nil
+}
+
+func (p *oidcProvider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	doc, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", p.cfg.RedirectURL)
+	query.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+
+	return appendQuery(doc.AuthorizationEndpoint, query), nil
+}
+
+func (p *oidcProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*ExternalIdentity, error) {
+	doc, keys, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := exchangeCode(ctx, p.client, doc.TokenEndpoint, p.cfg, code, codeVerifier, &token); err != nil {
+		return nil, err
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	claims, err := p.verifyIDToken(token.IDToken, keys, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := &ExternalIdentity{Provider: p.cfg.Name}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	// Some providers send email_verified as a string
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = v
+	case string:
+		identity.EmailVerified, _ = strconv.ParseBool(v)
+	}
+
+	if identity.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	return identity, nil
+}
+
+// verifyIDToken checks the signature, issuer, audience, lifetime and nonce
+// of an ID token as required by OIDC Core section 3.1.3.7.
+func (p *oidcProvider) verifyIDToken(raw string, keys *middleware.RemoteKeySet, nonce string) (jwt.MapClaims, error) {
+	parser := jwt.Parser{
+		ValidMethods: []string{jwt.SigningMethodRS256.Alg()},
+		// Timestamps are checked below with leeway
+		SkipClaimsValidation: true,
+	}
+	token, err := parser.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return keys.PublicKey(kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid ID token claims")
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("ID token issued by %q", iss)
+	}
+	if !audienceContains(claims["aud"], p.cfg.ClientID) {
+		return nil, errors.New("ID token not issued for this client")
+	}
+
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(idTokenLeeway)) {
+		return nil, errors.New("ID token expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(idTokenLeeway)) {
+		return nil, errors.New("ID token issued in the future")
+	}
+
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("ID token nonce mismatch")
+	}
+
+	return claims, nil
+}
+
+// audienceContains accepts aud as either a string or an array of strings.
+func audienceContains(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if s, _ := a.(string); s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// githubProvider signs users in with GitHub OAuth apps, which support PKCE
+// but not OIDC, so the identity is read from the REST API.
+type githubProvider struct {
+	cfg    OIDCProviderConfig
+	client *http.Client
+}
+
+func newGitHubProvider(cfg OIDCProviderConfig) *githubProvider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"read:user", "user:email"}
+	}
+	if cfg.AuthURL == "" {
+		cfg.AuthURL = githubAuthURL
+	}
+	if cfg.TokenURL == "" {
+		cfg.TokenURL = githubTokenURL
+	}
+	if cfg.UserInfoURL == "" {
+		cfg.UserInfoURL = githubAPIURL
+	}
+	cfg.UserInfoURL = strings.TrimRight(cfg.UserInfoURL, "/")
+	return &githubProvider{cfg: cfg, client: &http.Client{Timeout: providerTimeout}}
+}
+
+func (p *githubProvider) Name() string {
+	return p.cfg.Name
+}
+
+// AuthCodeURL ignores nonce, which only applies to ID tokens.
+func (p *githubProvider) AuthCodeURL(_ context.Context, state, _, codeChallenge string) (string, error) {
+	query := url.Values{}
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", p.cfg.RedirectURL)
+	query.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	query.Set("state", state)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+
+	return appendQuery(p.cfg.AuthURL, query), nil
+}
+
+func (p *githubProvider) Exchange(ctx context.Context, code, codeVerifier, _ string) (*ExternalIdentity, error) {
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := exchangeCode(ctx, p.client, p.cfg.TokenURL, p.cfg, code, codeVerifier, &token); err != nil {
+		return nil, err
+	}
+	// GitHub reports exchange errors with a 200 status
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("GitHub token exchange failed: %s", token.Error)
+	}
+
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := getJSON(ctx, p.client, p.cfg.UserInfoURL+"/user", token.AccessToken, &user); err != nil {
+		return nil, err
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, p.client, p.cfg.UserInfoURL+"/user/emails", token.AccessToken, &emails); err != nil {
+		return nil, err
+	}
+
+	identity := &ExternalIdentity{
+		Provider: p.cfg.Name,
+		Subject:  strconv.FormatInt(user.ID, 10),
+		Name:     user.Name,
+	}
+	if identity.Name == "" {
+		identity.Name = user.Login
+	}
+	for _, e := range emails {
+		if e.Primary {
+			identity.Email = e.Email
+			identity.EmailVerified = e.Verified
+			break
+		}
+	}
+
+	if user.ID == 0 {
+		return nil, errors.New("GitHub user has no id")
+	}
+	return identity, nil
+}
+
+// exchangeCode redeems an authorization code at the token endpoint using
+// client_secret_post authentication and decodes the JSON response into out.
+func exchangeCode(ctx context.Context, client *http.Client, tokenURL string, cfg OIDCProviderConfig, code, codeVerifier string, out interface{}) error {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", cfg.RedirectURL)
+	form.Set("client_id", cfg.ClientID)
+	form.Set("client_secret", cfg.ClientSecret)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	return doJSON(client, req, out)
+}
+
+func getJSON(ctx context.Context, client *http.Client, url, bearer string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	return doJSON(client, req, out)
+}
+
+func doJSON(client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to %s failed: %w", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("request to %s failed with status %d: %s", req.URL.Host, resp.StatusCode, body)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", req.URL.Host, err)
+	}
+	return nil
+}
+
+func appendQuery(endpoint string, query url.Values) string {
+	sep := "?"
+	if strings.Contains(endpoint, "?") {
+		sep = "&"
+	}
+	return endpoint + sep + query.Encode()
+}
@@ -0,0 +1,578 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"go.uber.org/zap"
+)
+
+const (
+	testClientID     = "user-service"
+	testClientSecret = "client-secret"
+	testRedirectURL  = "https://app.example.com/auth/callback"
+	testKeyID        = "idp-key-1"
+)
+
+// fakeIdP is an OIDC provider serving discovery, JWKS and a token endpoint
+// that enforces PKCE. Authorization codes are registered by the test in
+// place of a browser visiting the authorization endpoint.
+type fakeIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]fakeAuthRequest
+
+	// claims may adjust the ID token claims before signing
+	claims func(jwt.MapClaims)
+	// sign may replace the RS256 signature
+	sign func(jwt.MapClaims) string
+}
+
+type fakeAuthRequest struct {
+	challenge string
+	nonce     string
+	subject   string
+	email     string
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &fakeIdP{key: key, codes: make(map[string]fakeAuthRequest)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, oidcDiscovery{
+			Issuer:                idp.URL,
+			AuthorizationEndpoint: idp.URL + "/authorize",
+			TokenEndpoint:         idp.URL + "/token",
+			JWKSURI:               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, middleware.JWKS{Keys: []middleware.JWK{middleware.NewRSAJWK(testKeyID, &key.PublicKey)}})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" ||
+		r.PostForm.Get("client_id") != testClientID ||
+		r.PostForm.Get("client_secret") != testClientSecret ||
+		r.PostForm.Get("redirect_uri") != testRedirectURL {
+		http.Error(w, "invalid_client", http.StatusUnauthorized)
+		return
+	}
+
+	idp.mu.Lock()
+	req, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+	if !ok || pkceChallenge(r.PostForm.Get("code_verifier")) != req.challenge {
+		http.Error(w, "invalid_grant", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            idp.URL,
+		"aud":            testClientID,
+		"sub":            req.subject,
+		"email":          req.email,
+		"email_verified": true,
+		"name":           "Ada Lovelace",
+		"nonce":          req.nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+	}
+	if idp.claims != nil {
+		idp.claims(claims)
+	}
+
+	var idToken string
+	if idp.sign != nil {
+		idToken = idp.sign(claims)
+	} else {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = testKeyID
+		idToken, _ = token.SignedString(idp.key)
+	}
+	writeTestJSON(w, map[string]string{"id_token": idToken, "token_type": "Bearer"})
+}
+
+// authorize plays the user signing in at the authorization URL and returns
+// the code the provider would redirect back with.
+func (idp *fakeIdP) authorize(t *testing.T, authURL, subject, email string) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("authorization URL has no S256 challenge: %s", authURL)
+	}
+	if query.Get("client_id") != testClientID || query.Get("redirect_uri") != testRedirectURL {
+		t.Fatalf("authorization URL has wrong client: %s", authURL)
+	}
+
+	code, err := middleware.NewOpaqueToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.mu.Lock()
+	idp.codes[code] = fakeAuthRequest{
+		challenge: query.Get("code_challenge"),
+		nonce:     query.Get("nonce"),
+		subject:   subject,
+		email:     email,
+	}
+	idp.mu.Unlock()
+	return code
+}
+
+func (idp *fakeIdP) provider() *oidcProvider {
+	return newOIDCProvider(OIDCProviderConfig{
+		Name:         "idp",
+		Issuer:       idp.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+	})
+}
+
+func writeTestJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// fakeIdentityStore keeps OIDC states and links in memory.
+type fakeIdentityStore struct {
+	models.IdentityRepository
+	states   map[string]*models.OIDCState
+	links    []*models.UserIdentity
+	consumed int
+}
+
+func newFakeIdentityStore() *fakeIdentityStore {
+	return &fakeIdentityStore{states: make(map[string]*models.OIDCState)}
+}
+
+func (f *fakeIdentityStore) CreateOIDCState(_ context.Context, state *models.OIDCState) error {
+	f.states[state.StateHash] = state
+	return nil
+}
+
+func (f *fakeIdentityStore) ConsumeOIDCState(_ context.Context, stateHash string) (*models.OIDCState, error) {
+	f.consumed++
+	state, ok := f.states[stateHash]
+	delete(f.states, stateHash)
+	if !ok || time.Now().After(state.ExpiresAt) {
+		return nil, ErrInvalidOIDCState
+	}
+	return state, nil
+}
+
+func (f *fakeIdentityStore) GetIdentity(_ context.Context, provider, subject string) (*models.UserIdentity, error) {
+	for _, link := range f.links {
+		if link.Provider == provider && link.Subject == subject {
+			return link, nil
+		}
+	}
+	return nil, ErrIdentityNotFound
+}
+
+func (f *fakeIdentityStore) LinkIdentity(_ context.Context, identity *models.UserIdentity) error {
+	f.links = append(f.links, identity)
+	return nil
+}
+
+// fakeUserStore holds users keyed by id, looked up by email as the
+// repository does.
+type fakeUserStore struct {
+	models.UserRepository
+	users map[int]*models.User
+}
+
+func (f *fakeUserStore) GetUserByID(_ context.Context, id int) (*models.User, error) {
+	user, ok := f.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (f *fakeUserStore) GetUserByEmail(_ context.Context, email string) (*models.User, error) {
+	for _, user := range f.users {
+		if strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (f *fakeUserStore) CreateUser(_ context.Context, user *models.User) (*models.User, error) {
+	user.ID = len(f.users) + 1
+	f.users[user.ID] = user
+	return user, nil
+}
+
+func (f *fakeUserStore) UpdateUser(_ context.Context, id int, updates map[string]interface{}) (*models.User, error) {
+	user := f.users[id]
+	if hash, ok := updates["password_hash"].(string); ok {
+		user.PasswordHash = hash
+	}
+	if verified, ok := updates["email_verified_at"].(time.Time); ok {
+		user.EmailVerifiedAt = &verified
+	}
+	return user, nil
+}
+
+type fakeTokenStore struct {
+	models.TokenRepository
+	revokedAll []int
+}
+
+func (f *fakeTokenStore) RevokeAllUserTokens(_ context.Context, userID int) error {
+	f.revokedAll = append(f.revokedAll, userID)
+	return nil
+}
+
+func newOIDCTestService(idp *fakeIdP, users ...*models.User) (UserService, *fakeIdentityStore, *fakeUserStore, *fakeTokenStore) {
+	identities := newFakeIdentityStore()
+	repo := &fakeUserStore{users: make(map[int]*models.User)}
+	for _, user := range users {
+		repo.users[user.ID] = user
+	}
+	tokens := &fakeTokenStore{}
+
+	providers := map[string]IdentityProvider{}
+	if idp != nil {
+		providers["idp"] = idp.provider()
+	}
+	return UserService{
+		repo:       repo,
+		tokens:     tokens,
+		identities: identities,
+		providers:  providers,
+		tx:         fakeTransactor{},
+		audit:      &fakeAuditStore{},
+		log:        zap.NewNop(),
+	}, identities, repo, tokens
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	// RFC 7636 appendix B
+	got := pkceChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("pkceChallenge = %q, want %q", got, want)
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	ctx := context.Background()
+	idp := newFakeIdP(t)
+	p := idp.provider()
+
+	authURL, err := p.AuthCodeURL(ctx, "state", "nonce-1", pkceChallenge("verifier-1"))
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	code := idp.authorize(t, authURL, "sub-1", "Ada@Example.com")
+
+	identity, err := p.Exchange(ctx, code, "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	want := ExternalIdentity{Provider: "idp", Subject: "sub-1", Email: "Ada@Example.com", EmailVerified: true, Name: "Ada Lovelace"}
+	if *identity != want {
+		t.Errorf("Exchange = %+v, want %+v", *identity, want)
+	}
+}
+
+func TestOIDCExchangeRejects(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		verifier string
+		nonce    string
+		claims   func(idp *fakeIdP, c jwt.MapClaims)
+		sign     func(idp *fakeIdP, c jwt.MapClaims) string
+	}{
+		{name: "wrong PKCE verifier", verifier: "other-verifier"},
+		{name: "nonce mismatch", nonce: "other-nonce"},
+		{name: "wrong issuer", claims: func(_ *fakeIdP, c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{name: "wrong audience", claims: func(_ *fakeIdP, c jwt.MapClaims) { c["aud"] = "other-client" }},
+		{name: "audience list without client", claims: func(_ *fakeIdP, c jwt.MapClaims) { c["aud"] = []string{"a", "b"} }},
+		{name: "expired", claims: func(_ *fakeIdP, c jwt.MapClaims) { c["exp"] = time.Now().Add(-2 * idTokenLeeway).Unix() }},
+		{name: "missing expiry", claims: func(_ *fakeIdP, c jwt.MapClaims) { delete(c, "exp") }},
+		{name: "issued in the future", claims: func(_ *fakeIdP, c jwt.MapClaims) { c["iat"] = time.Now().Add(2 * idTokenLeeway).Unix() }},
+		{name: "missing subject", claims: func(_ *fakeIdP, c jwt.MapClaims) { delete(c, "sub") }},
+		{name: "HS256 signed with the public key", sign: func(idp *fakeIdP, c jwt.MapClaims) string {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, c)
+			token.Header["kid"] = testKeyID
+			s, _ := token.SignedString(idp.key.PublicKey.N.Bytes())
+			return s
+		}},
+		{name: "unsigned", sign: func(_ *fakeIdP, c jwt.MapClaims) string {
+			s, _ := jwt.NewWithClaims(jwt.SigningMethodNone, c).SignedString(jwt.UnsafeAllowNoneSignatureType)
+			return s
+		}},
+		{name: "signed by an unknown key", sign: func(_ *fakeIdP, c jwt.MapClaims) string {
+			other, _ := rsa.GenerateKey(rand.Reader, 2048)
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+			token.Header["kid"] = testKeyID
+			s, _ := token.SignedString(other)
+			return s
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newFakeIdP(t)
+			if tt.claims != nil {
+				idp.claims = func(c jwt.MapClaims) { tt.claims(idp, c) }
+			}
+			if tt.sign != nil {
+				idp.sign = func(c jwt.MapClaims) string { return tt.sign(idp, c) }
+			}
+			verifier, nonce := "verifier", "nonce"
+			if tt.verifier != "" {
+				verifier = tt.verifier
+			}
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+
+			p := idp.provider()
+			authURL, err := p.AuthCodeURL(ctx, "state", "nonce", pkceChallenge("verifier"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			code := idp.authorize(t, authURL, "sub-1", "ada@example.com")
+
+			if identity, err := p.Exchange(ctx, code, verifier, nonce); err == nil {
+				t.Errorf("Exchange accepted the ID token: %+v", identity)
+			}
+		})
+	}
+}
+
+func TestOIDCDiscoveryRejectsIssuerMismatch(t *testing.T) {
+	idp := newFakeIdP(t)
+	// Serves the discovery document of idp under another issuer
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, oidcDiscovery{Issuer: idp.URL, TokenEndpoint: idp.URL + "/token", JWKSURI: idp.URL + "/jwks"})
+	}))
+	defer proxy.Close()
+
+	p := newOIDCProvider(OIDCProviderConfig{Name: "idp", Issuer: proxy.URL, ClientID: testClientID, RedirectURL: testRedirectURL})
+	if _, err := p.AuthCodeURL(context.Background(), "state", "nonce", "challenge"); err == nil {
+		t.Error("AuthCodeURL accepted a discovery document for another issuer")
+	}
+}
+
+func TestLoginExternalState(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		provider string
+		payload  func(auth *models.OIDCAuthorization) models.OIDCCallbackPayload
+		consumed bool
+	}{
+		{
+			name:     "missing binding",
+			provider: "idp",
+			payload: func(auth *models.OIDCAuthorization) models.OIDCCallbackPayload {
+				return models.OIDCCallbackPayload{Code: "code", State: auth.State}
+			},
+		},
+		{
+			name:     "binding of another login",
+			provider: "idp",
+			payload: func(auth *models.OIDCAuthorization) models.OIDCCallbackPayload {
+				return models.OIDCCallbackPayload{Code: "code", State: auth.State, StateBinding: middleware.HashOpaqueToken("other")}
+			},
+		},
+		{
+			name:     "unknown state",
+			provider: "idp",
+			payload: func(auth *models.OIDCAuthorization) models.OIDCCallbackPayload {
+				return models.OIDCCallbackPayload{Code: "code", State: "forged", StateBinding: middleware.HashOpaqueToken("forged")}
+			},
+			consumed: true,
+		},
+		{
+			name:     "state of another provider",
+			provider: "other",
+			payload: func(auth *models.OIDCAuthorization) models.OIDCCallbackPayload {
+				return models.OIDCCallbackPayload{Code: "code", State: auth.State, StateBinding: auth.StateBinding}
+			},
+			consumed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newFakeIdP(t)
+			s, identities, _, _ := newOIDCTestService(idp)
+			s.providers["other"] = idp.provider()
+
+			auth, err := s.AuthorizeExternal(ctx, "idp")
+			if err != nil {
+				t.Fatalf("AuthorizeExternal: %v", err)
+			}
+			if auth.StateBinding != middleware.HashOpaqueToken(auth.State) {
+				t.Fatal("state binding is not the state hash")
+			}
+
+			_, err = s.LoginExternal(ctx, tt.provider, tt.payload(auth))
+			if !errors.Is(err, ErrInvalidOIDCState) {
+				t.Errorf("LoginExternal = %v, want ErrInvalidOIDCState", err)
+			}
+			if got := identities.consumed > 0; got != tt.consumed {
+				t.Errorf("state consumed = %v, want %v", got, tt.consumed)
+			}
+		})
+	}
+}
+
+func TestLoginExternalStateIsSingleUse(t *testing.T) {
+	ctx := context.Background()
+	idp := newFakeIdP(t)
+	// The provider rejects the code, so the login fails after the state
+	// has been consumed
+	s, _, _, _ := newOIDCTestService(idp)
+
+	auth, err := s.AuthorizeExternal(ctx, "idp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := models.OIDCCallbackPayload{Code: "unknown-code", State: auth.State, StateBinding: auth.StateBinding}
+
+	if _, err := s.LoginExternal(ctx, "idp", payload); !errors.Is(err, ErrExternalLoginFailed) {
+		t.Fatalf("first callback = %v, want ErrExternalLoginFailed", err)
+	}
+	if _, err := s.LoginExternal(ctx, "idp", payload); !errors.Is(err, ErrInvalidOIDCState) {
+		t.Errorf("replayed callback = %v, want ErrInvalidOIDCState", err)
+	}
+}
+
+func TestResolveIdentity(t *testing.T) {
+	ctx := context.Background()
+	verifiedAt := time.Now().Add(-24 * time.Hour)
+
+	t.Run("links an existing user by verified email", func(t *testing.T) {
+		existing := &models.User{ID: 1, Email: "Ada@Example.com", PasswordHash: "hash", EmailVerifiedAt: &verifiedAt}
+		s, identities, _, tokens := newOIDCTestService(nil, existing)
+
+		user, err := s.resolveIdentity(ctx, &ExternalIdentity{Provider: "idp", Subject: "sub-1", Email: " ada@EXAMPLE.com ", EmailVerified: true})
+		if err != nil {
+			t.Fatalf("resolveIdentity: %v", err)
+		}
+		if user.ID != 1 || user.PasswordHash != "hash" {
+			t.Errorf("resolved %+v, want the existing user untouched", user)
+		}
+		if len(identities.links) != 1 || identities.links[0].UserID != 1 || identities.links[0].Email != "ada@example.com" {
+			t.Errorf("links = %+v", identities.links)
+		}
+		if len(tokens.revokedAll) != 0 {
+			t.Error("sessions of a verified user were revoked")
+		}
+
+		// The link is used from then on, whatever the email
+		user, err = s.resolveIdentity(ctx, &ExternalIdentity{Provider: "idp", Subject: "sub-1"})
+		if err != nil || user.ID != 1 {
+			t.Errorf("linked identity resolved to %+v, %v", user, err)
+		}
+	})
+
+	t.Run("rejects an unverified external email", func(t *testing.T) {
+		existing := &models.User{ID: 1, Email: "ada@example.com", EmailVerifiedAt: &verifiedAt}
+		s, identities, _, _ := newOIDCTestService(nil, existing)
+
+		for _, identity := range []*ExternalIdentity{
+			{Provider: "idp", Subject: "sub-1", Email: "ada@example.com"},
+			{Provider: "idp", Subject: "sub-1", EmailVerified: true},
+		} {
+			if _, err := s.resolveIdentity(ctx, identity); err != ErrExternalEmailUnverified {
+				t.Errorf("resolveIdentity(%+v) = %v, want ErrExternalEmailUnverified", identity, err)
+			}
+		}
+		if len(identities.links) != 0 {
+			t.Errorf("links = %+v, want none", identities.links)
+		}
+	})
+
+	t.Run("claims an unverified account", func(t *testing.T) {
+		existing := &models.User{ID: 1, Email: "ada@example.com", PasswordHash: "squatter"}
+		s, identities, _, tokens := newOIDCTestService(nil, existing)
+
+		user, err := s.resolveIdentity(ctx, &ExternalIdentity{Provider: "idp", Subject: "sub-1", Email: "ada@example.com", EmailVerified: true})
+		if err != nil {
+			t.Fatalf("resolveIdentity: %v", err)
+		}
+		if user.PasswordHash == "squatter" || user.PasswordHash == "" {
+			t.Error("password of the claimed account was not replaced")
+		}
+		if user.EmailVerifiedAt == nil {
+			t.Error("claimed account is still unverified")
+		}
+		if len(tokens.revokedAll) != 1 || tokens.revokedAll[0] != 1 {
+			t.Errorf("revoked sessions of %v, want [1]", tokens.revokedAll)
+		}
+		if len(identities.links) != 1 {
+			t.Errorf("links = %+v", identities.links)
+		}
+
+		entries := s.audit.(*fakeAuditStore).entries
+		if len(entries) != 1 || entries[0].Action != models.AuditAccountClaim || *entries[0].TargetID != 1 {
+			t.Fatalf("audit entries = %+v, want one %s of user 1", entries, models.AuditAccountClaim)
+		}
+		if change := entries[0].Changes["password_hash"]; change.Old != "[REDACTED]" || change.New != "[REDACTED]" {
+			t.Errorf("password change audited as %+v, want it redacted", change)
+		}
+	})
+
+	t.Run("registers a new user", func(t *testing.T) {
+		s, identities, repo, _ := newOIDCTestService(nil)
+
+		user, err := s.resolveIdentity(ctx, &ExternalIdentity{Provider: "idp", Subject: "sub-1", Email: "Ada@Example.com", EmailVerified: true})
+		if err != nil {
+			t.Fatalf("resolveIdentity: %v", err)
+		}
+		if len(repo.users) != 1 || user.Email != "ada@example.com" || user.Name != "ada@example.com" || user.EmailVerifiedAt == nil {
+			t.Errorf("registered %+v", user)
+		}
+		if len(identities.links) != 1 || identities.links[0].UserID != user.ID {
+			t.Errorf("links = %+v", identities.links)
+		}
+	})
+}
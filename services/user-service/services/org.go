@@ -0,0 +1,389 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"go.uber.org/zap"
+)
+
+const orgInvitationTTL = 7 * 24 * time.Hour
+
+// CreateOrg creates an organization owned by the caller.
+func (s UserService) CreateOrg(ctx context.Context, payload models.CreateOrgPayload) (*models.Organization, error) {
+	currentUser, err := s.scopedCaller(ctx, middleware.PermUsersWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	org := &models.Organization{
+		Name:      strings.TrimSpace(payload.Name),
+		CreatedBy: &currentUser.ID,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		Role:      models.OrgRoleOwner,
+	}
+	err = s.withAudit(ctx, models.AuditOrgCreate, currentUser.ID, func(ctx context.Context, entry *models.AuditEntry) error {
+		if err := s.orgs.CreateOrg(ctx, org, currentUser.ID); err != nil {
+			return err
+		}
+		recordChange(entry, "org", nil, map[string]interface{}{"id": org.ID, "name": org.Name})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return org, nil
+}
+
+// ListOrgs returns the organizations the caller belongs to.
+func (s UserService) ListOrgs(ctx context.Context) ([]*models.Organization, error) {
+	currentUser, err := s.scopedCaller(ctx, middleware.PermUsersRead)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.orgs.ListUserOrgs(ctx, currentUser.ID)
+}
+
+// GetOrg returns an organization the caller belongs to.
+func (s UserService) GetOrg(ctx context.Context, orgID int) (*models.Organization, error) {
+	_, membership, err := s.requireOrgRole(ctx, orgID, models.OrgRoleViewer, middleware.PermUsersRead)
+	if err != nil {
+		return nil, err
+	}
+
+	org, err := s.orgs.GetOrg(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	org.Role = membership.Role
+	return org, nil
+}
+
+// DeleteOrg deletes an organization. Only owners can delete it.
+func (s UserService) DeleteOrg(ctx context.Context, orgID int) error {
+	currentUser, _, err := s.requireOrgRole(ctx, orgID, models.OrgRoleOwner, middleware.PermUsersWrite)
+	if err != nil {
+		return err
+	}
+
+	return s.withAudit(ctx, models.AuditOrgDelete, currentUser.ID, func(ctx context.Context, entry *models.AuditEntry) error {
+		recordChange(entry, "org", orgID, nil)
+		return s.orgs.DeleteOrg(ctx, orgID)
+	})
+}
+
+// ListOrgMembers returns the members of an organization the caller belongs to.
+func (s UserService) ListOrgMembers(ctx context.Context, orgID int) ([]*models.OrgMember, error) {
+	if _, _, err := s.requireOrgRole(ctx, orgID, models.OrgRoleViewer, middleware.PermUsersRead); err != nil {
+		return nil, err
+	}
+
+	return s.orgs.ListMembers(ctx, orgID)
+}
+
+// SetOrgMemberRole changes the role of a member. Admins manage admins,
+// members and viewers; only owners can make or unmake owners. An
+// organization always keeps at least one owner.
+func (s UserService) SetOrgMemberRole(ctx context.Context, orgID, userID int, role string) error {
+	_, caller, err := s.requireOrgRole(ctx, orgID, models.OrgRoleAdmin, middleware.PermUsersWrite)
+	if err != nil {
+		return err
+	}
+
+	return s.withAudit(ctx, models.AuditOrgMemberRole, userID, func(ctx context.Context, entry *models.AuditEntry) error {
+		if err := s.orgs.LockOrg(ctx, orgID); err != nil {
+			return err
+		}
+
+		target, err := s.orgs.GetMember(ctx, orgID, userID)
+		if err != nil {
+			return err
+		}
+		if (target.Role == models.OrgRoleOwner || role == models.OrgRoleOwner) && caller.Role != models.OrgRoleOwner {
+			return ErrUnauthorized
+		}
+		if target.Role == models.OrgRoleOwner && role != models.OrgRoleOwner {
+			if err := s.requireAnotherOwner(ctx, orgID); err != nil {
+				return err
+			}
+		}
+
+		recordChange(entry, "org_id", nil, orgID)
+		recordChange(entry, "org_role", target.Role, role)
+		return s.orgs.SetMemberRole(ctx, orgID, userID, role)
+	})
+}
+
+// RemoveOrgMember removes a member from an organization. Members may leave
+// on their own, admins may remove anyone but owners, and owners anyone. The
+// last owner cannot leave. Sessions of the member keep the organization in
+// their access tokens until those expire, but are not issued new ones for it.
+func (s UserService) RemoveOrgMember(ctx context.Context, orgID, userID int) error {
+	currentUser, caller, err := s.requireOrgRole(ctx, orgID, models.OrgRoleViewer, middleware.PermUsersWrite)
+	if err != nil {
+		return err
+	}
+
+	return s.withAudit(ctx, models.AuditOrgMemberRemove, userID, func(ctx context.Context, entry *models.AuditEntry) error {
+		if err := s.orgs.LockOrg(ctx, orgID); err != nil {
+			return err
+		}
+
+		target, err := s.orgs.GetMember(ctx, orgID, userID)
+		if err != nil {
+			return err
+		}
+		if currentUser.ID != userID {
+			if !models.OrgRoleAtLeast(caller.Role, models.OrgRoleAdmin) ||
+				(target.Role == models.OrgRoleOwner && caller.Role != models.OrgRoleOwner) {
+				return ErrUnauthorized
+			}
+		}
+		if target.Role == models.OrgRoleOwner {
+			if err := s.requireAnotherOwner(ctx, orgID); err != nil {
+				return err
+			}
+		}
+
+		recordChange(entry, "org_id", orgID, nil)
+		recordChange(entry, "org_role", target.Role, nil)
+		return s.orgs.RemoveMember(ctx, orgID, userID)
+	})
+}
+
+// InviteOrgMember emails an invitation to join an organization. Admins can
+// invite with any role but owner, which only owners can hand out.
+func (s UserService) InviteOrgMember(ctx context.Context, orgID int, payload models.InviteOrgMemberPayload) (*models.OrgInvitation, error) {
+	currentUser, caller, err := s.requireOrgRole(ctx, orgID, models.OrgRoleAdmin, middleware.PermUsersWrite)
+	if err != nil {
+		return nil, err
+	}
+	if payload.Role == models.OrgRoleOwner && caller.Role != models.OrgRoleOwner {
+		return nil, ErrUnauthorized
+	}
+
+	org, err := s.orgs.GetOrg(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	email := strings.ToLower(strings.TrimSpace(payload.Email))
+	if invitee, err := s.repo.GetUserByEmail(ctx, email); err == nil {
+		if _, err := s.orgs.GetMember(ctx, orgID, invitee.ID); err == nil {
+			return nil, ErrAlreadyOrgMember
+		} else if !errors.Is(err, ErrOrgMemberNotFound) {
+			return nil, err
+		}
+	} else if !errors.Is(err, ErrUserNotFound) {
+		return nil, err
+	}
+
+	token, err := middleware.NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	invitation := &models.OrgInvitation{
+		OrgID:     orgID,
+		Email:     email,
+		Role:      payload.Role,
+		TokenHash: middleware.HashOpaqueToken(token),
+		InvitedBy: &currentUser.ID,
+		ExpiresAt: time.Now().Add(orgInvitationTTL),
+		CreatedAt: time.Now(),
+	}
+	err = s.withAudit(ctx, models.AuditOrgInvite, 0, func(ctx context.Context, entry *models.AuditEntry) error {
+		if err := s.orgs.CreateInvitation(ctx, invitation); err != nil {
+			return err
+		}
+		recordChange(entry, "org_invitation", nil, map[string]interface{}{
+			"id":     invitation.ID,
+			"org_id": orgID,
+			"email":  invitation.Email,
+			"role":   invitation.Role,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.notifier.SendOrgInvitation(ctx, invitation.Email, org, currentUser, token, orgInvitationTTL); err != nil {
+		s.log.Error("Failed to send invitation email", zap.Int("orgID", orgID), zap.Int("invitationID", invitation.ID), zap.Error(err))
+	}
+
+	return invitation, nil
+}
+
+// ListOrgInvitations returns the pending invitations of an organization.
+func (s UserService) ListOrgInvitations(ctx context.Context, orgID int) ([]*models.OrgInvitation, error) {
+	if _, _, err := s.requireOrgRole(ctx, orgID, models.OrgRoleAdmin, middleware.PermUsersRead); err != nil {
+		return nil, err
+	}
+
+	return s.orgs.ListInvitations(ctx, orgID)
+}
+
+// RevokeOrgInvitation withdraws an invitation before it is answered.
+func (s UserService) RevokeOrgInvitation(ctx context.Context, orgID, invitationID int) error {
+	if _, _, err := s.requireOrgRole(ctx, orgID, models.OrgRoleAdmin, middleware.PermUsersWrite); err != nil {
+		return err
+	}
+
+	return s.withAudit(ctx, models.AuditOrgInviteRevoke, 0, func(ctx context.Context, entry *models.AuditEntry) error {
+		recordChange(entry, "org_invitation", invitationID, nil)
+		return s.orgs.DeleteInvitation(ctx, orgID, invitationID)
+	})
+}
+
+// AcceptOrgInvitation makes the caller a member with the invited role. The
+// invitation must have been sent to the caller's verified email.
+func (s UserService) AcceptOrgInvitation(ctx context.Context, token string) (*models.Organization, error) {
+	currentUser, err := s.scopedCaller(ctx, middleware.PermUsersWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	invitation, err := s.orgs.GetPendingInvitationByHash(ctx, middleware.HashOpaqueToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if currentUser.EmailVerifiedAt == nil || !strings.EqualFold(currentUser.Email, invitation.Email) {
+		return nil, ErrInvitationEmailMismatch
+	}
+
+	err = s.withAudit(ctx, models.AuditOrgJoin, currentUser.ID, func(ctx context.Context, entry *models.AuditEntry) error {
+		if err := s.orgs.ResolveInvitation(ctx, invitation.ID, true); err != nil {
+			return err
+		}
+		recordChange(entry, "org_id", nil, invitation.OrgID)
+		recordChange(entry, "org_role", nil, invitation.Role)
+		return s.orgs.AddMember(ctx, invitation.OrgID, currentUser.ID, invitation.Role)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	org, err := s.orgs.GetOrg(ctx, invitation.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	org.Role = invitation.Role
+	return org, nil
+}
+
+// DeclineOrgInvitation turns an invitation down. Holding the token is
+// enough, the invitee may not even have an account.
+func (s UserService) DeclineOrgInvitation(ctx context.Context, token string) error {
+	invitation, err := s.orgs.GetPendingInvitationByHash(ctx, middleware.HashOpaqueToken(token))
+	if err != nil {
+		return err
+	}
+
+	return s.withAudit(ctx, models.AuditOrgInviteDecline, 0, func(ctx context.Context, entry *models.AuditEntry) error {
+		recordChange(entry, "org_invitation", map[string]interface{}{
+			"id":     invitation.ID,
+			"org_id": invitation.OrgID,
+			"email":  invitation.Email,
+		}, nil)
+		return s.orgs.ResolveInvitation(ctx, invitation.ID, false)
+	})
+}
+
+// SwitchOrg makes the caller's session act for an organization they belong
+// to, or for none when orgID is nil, and returns an access token carrying
+// the new org_id claim. Later refreshes of the session keep the choice.
+func (s UserService) SwitchOrg(ctx context.Context, orgID *int) (*models.AuthToken, error) {
+	currentUser, err := s.extractUserFromContext(ctx)
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+	// API keys and service tokens have no session to switch
+	sessionID, _ := ctx.Value("session_id").(string)
+	if sessionID == "" {
+		return nil, ErrUnauthorized
+	}
+
+	if orgID != nil {
+		if _, err := s.orgs.GetMember(ctx, *orgID, currentUser.ID); err != nil {
+			if errors.Is(err, ErrOrgMemberNotFound) {
+				return nil, ErrOrgNotFound
+			}
+			return nil, err
+		}
+	}
+
+	if err := s.tokens.SetSessionOrg(ctx, sessionID, orgID); err != nil {
+		return nil, err
+	}
+
+	subject, err := s.tokenSubject(ctx, currentUser, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	token, expiresAt, err := s.tokenService.GenerateToken(*subject)
+	if err != nil {
+		return nil, err
+	}
+	return &models.AuthToken{Token: token, ExpiresAt: expiresAt}, nil
+}
+
+// orgClaims returns the organization a session acts for and the user's role
+// in it, or empty strings if none or if the user has since left it.
+func (s UserService) orgClaims(ctx context.Context, userID int, sessionID string) (orgID, role string, err error) {
+	activeOrg, err := s.tokens.GetSessionOrg(ctx, sessionID)
+	if err != nil || activeOrg == nil {
+		return "", "", err
+	}
+
+	member, err := s.orgs.GetMember(ctx, *activeOrg, userID)
+	if err != nil {
+		if errors.Is(err, ErrOrgMemberNotFound) {
+			return "", "", nil
+		}
+		return "", "", err
+	}
+	return strconv.Itoa(member.OrgID), member.Role, nil
+}
+
+// requireOrgRole returns the caller and their membership of an organization
+// if their role there is at least min. Non-members are told the
+// organization does not exist. An API key must also hold scope.
+func (s UserService) requireOrgRole(ctx context.Context, orgID int, min, scope string) (*models.User, *models.OrgMember, error) {
+	currentUser, err := s.scopedCaller(ctx, scope)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	member, err := s.orgs.GetMember(ctx, orgID, currentUser.ID)
+	if err != nil {
+		if errors.Is(err, ErrOrgMemberNotFound) {
+			return nil, nil, ErrOrgNotFound
+		}
+		return nil, nil, err
+	}
+	if !models.OrgRoleAtLeast(member.Role, min) {
+		return nil, nil, ErrUnauthorized
+	}
+	return currentUser, member, nil
+}
+
+// requireAnotherOwner fails if an owner about to step down is the last one.
+func (s UserService) requireAnotherOwner(ctx context.Context, orgID int) error {
+	owners, err := s.orgs.CountOwners(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	if owners <= 1 {
+		return ErrLastOrgOwner
+	}
+	return nil
+}
@@ -0,0 +1,397 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"go.uber.org/zap"
+)
+
+// fakeOrgStore holds the roles of the members of organizations by org and
+// user ID, and pending invitations by token hash.
+type fakeOrgStore struct {
+	models.OrgRepository
+	members     map[int]map[int]string
+	invitations map[string]*models.OrgInvitation
+	resolved    map[int]bool
+}
+
+func (f *fakeOrgStore) GetOrg(_ context.Context, orgID int) (*models.Organization, error) {
+	if _, ok := f.members[orgID]; !ok {
+		return nil, ErrOrgNotFound
+	}
+	return &models.Organization{ID: orgID, Name: "Household"}, nil
+}
+
+func (f *fakeOrgStore) LockOrg(context.Context, int) error {
+	return nil
+}
+
+func (f *fakeOrgStore) GetMember(_ context.Context, orgID, userID int) (*models.OrgMember, error) {
+	role, ok := f.members[orgID][userID]
+	if !ok {
+		return nil, ErrOrgMemberNotFound
+	}
+	return &models.OrgMember{OrgID: orgID, UserID: userID, Role: role}, nil
+}
+
+func (f *fakeOrgStore) AddMember(_ context.Context, orgID, userID int, role string) error {
+	f.members[orgID][userID] = role
+	return nil
+}
+
+func (f *fakeOrgStore) SetMemberRole(_ context.Context, orgID, userID int, role string) error {
+	f.members[orgID][userID] = role
+	return nil
+}
+
+func (f *fakeOrgStore) RemoveMember(_ context.Context, orgID, userID int) error {
+	delete(f.members[orgID], userID)
+	return nil
+}
+
+func (f *fakeOrgStore) CountOwners(_ context.Context, orgID int) (int, error) {
+	owners := 0
+	for _, role := range f.members[orgID] {
+		if role == models.OrgRoleOwner {
+			owners++
+		}
+	}
+	return owners, nil
+}
+
+func (f *fakeOrgStore) GetPendingInvitationByHash(_ context.Context, tokenHash string) (*models.OrgInvitation, error) {
+	invitation, ok := f.invitations[tokenHash]
+	if !ok {
+		return nil, ErrInvitationNotFound
+	}
+	if _, resolved := f.resolved[invitation.ID]; resolved {
+		return nil, ErrInvitationNotFound
+	}
+	return invitation, nil
+}
+
+func (f *fakeOrgStore) ResolveInvitation(_ context.Context, invitationID int, accepted bool) error {
+	f.resolved[invitationID] = accepted
+	return nil
+}
+
+type orgUserStore struct {
+	models.UserRepository
+	users map[int]*models.User
+}
+
+func (f *orgUserStore) GetUserByID(_ context.Context, id int) (*models.User, error) {
+	user, ok := f.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// sessionOrgStore remembers the organization each session acts for.
+type sessionOrgStore struct {
+	models.TokenRepository
+	orgs map[string]*int
+}
+
+func (f *sessionOrgStore) SetSessionOrg(_ context.Context, sessionID string, orgID *int) error {
+	f.orgs[sessionID] = orgID
+	return nil
+}
+
+func (f *sessionOrgStore) GetSessionOrg(_ context.Context, sessionID string) (*int, error) {
+	return f.orgs[sessionID], nil
+}
+
+// The members of organization 1 in the org tests
+const (
+	orgOwner  = 1
+	orgAdmin  = 2
+	orgMember = 3
+	orgViewer = 4
+	outsider  = 5
+)
+
+func newOrgTestService() (UserService, *fakeOrgStore, *sessionOrgStore, *fakeAuditStore) {
+	users := &orgUserStore{users: make(map[int]*models.User)}
+	for id := orgOwner; id <= outsider; id++ {
+		users.users[id] = &models.User{ID: id, Email: "user" + strconv.Itoa(id) + "@example.com", Role: DefaultRole}
+	}
+	orgs := &fakeOrgStore{
+		members: map[int]map[int]string{
+			1: {orgOwner: models.OrgRoleOwner, orgAdmin: models.OrgRoleAdmin, orgMember: models.OrgRoleMember, orgViewer: models.OrgRoleViewer},
+			2: {outsider: models.OrgRoleOwner},
+		},
+		invitations: make(map[string]*models.OrgInvitation),
+		resolved:    make(map[int]bool),
+	}
+	sessions := &sessionOrgStore{orgs: make(map[string]*int)}
+	audit := &fakeAuditStore{}
+
+	return UserService{
+		repo:   users,
+		orgs:   orgs,
+		tokens: sessions,
+		roles:  staticRoles{},
+		tx:     fakeTransactor{},
+		audit:  audit,
+		log:    zap.NewNop(),
+	}, orgs, sessions, audit
+}
+
+func asUser(userID int) context.Context {
+	return context.WithValue(context.Background(), "user_id", strconv.Itoa(userID))
+}
+
+func TestSetOrgMemberRole(t *testing.T) {
+	tests := []struct {
+		name        string
+		caller      int
+		target      int
+		role        string
+		secondOwner bool
+		wantErr     error
+	}{
+		{name: "owner makes a member owner", caller: orgOwner, target: orgMember, role: models.OrgRoleOwner},
+		{name: "owner demotes an admin", caller: orgOwner, target: orgAdmin, role: models.OrgRoleViewer},
+		{name: "last owner steps down", caller: orgOwner, target: orgOwner, role: models.OrgRoleAdmin, wantErr: ErrLastOrgOwner},
+		{name: "owner steps down beside another", caller: orgOwner, target: orgOwner, role: models.OrgRoleAdmin, secondOwner: true},
+		{name: "admin demotes a member", caller: orgAdmin, target: orgMember, role: models.OrgRoleViewer},
+		{name: "admin promotes a viewer to admin", caller: orgAdmin, target: orgViewer, role: models.OrgRoleAdmin},
+		{name: "admin grants owner", caller: orgAdmin, target: orgMember, role: models.OrgRoleOwner, wantErr: ErrUnauthorized},
+		{name: "admin makes self owner", caller: orgAdmin, target: orgAdmin, role: models.OrgRoleOwner, wantErr: ErrUnauthorized},
+		{name: "admin demotes an owner", caller: orgAdmin, target: orgOwner, role: models.OrgRoleMember, wantErr: ErrUnauthorized},
+		{name: "member changes a role", caller: orgMember, target: orgViewer, role: models.OrgRoleMember, wantErr: ErrUnauthorized},
+		{name: "outsider changes a role", caller: outsider, target: orgMember, role: models.OrgRoleViewer, wantErr: ErrOrgNotFound},
+		{name: "target is not a member", caller: orgOwner, target: outsider, role: models.OrgRoleViewer, wantErr: ErrOrgMemberNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, orgs, _, audit := newOrgTestService()
+			if tt.secondOwner {
+				orgs.members[1][orgAdmin] = models.OrgRoleOwner
+			}
+			before := orgs.members[1][tt.target]
+
+			err := s.SetOrgMemberRole(asUser(tt.caller), 1, tt.target, tt.role)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SetOrgMemberRole error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr != nil {
+				if got := orgs.members[1][tt.target]; got != before {
+					t.Errorf("role changed to %q although refused", got)
+				}
+				if len(audit.entries) != 0 {
+					t.Errorf("refused change was audited: %+v", audit.entries)
+				}
+				return
+			}
+			if got := orgs.members[1][tt.target]; got != tt.role {
+				t.Errorf("role = %q, want %q", got, tt.role)
+			}
+			if len(audit.entries) != 1 || audit.entries[0].Action != models.AuditOrgMemberRole {
+				t.Errorf("audit entries = %+v, want one %s", audit.entries, models.AuditOrgMemberRole)
+			}
+		})
+	}
+}
+
+func TestRemoveOrgMember(t *testing.T) {
+	tests := []struct {
+		name        string
+		caller      int
+		target      int
+		secondOwner bool
+		wantErr     error
+	}{
+		{name: "member leaves", caller: orgMember, target: orgMember},
+		{name: "viewer leaves", caller: orgViewer, target: orgViewer},
+		{name: "admin leaves", caller: orgAdmin, target: orgAdmin},
+		{name: "last owner leaves", caller: orgOwner, target: orgOwner, wantErr: ErrLastOrgOwner},
+		{name: "owner leaves beside another", caller: orgOwner, target: orgOwner, secondOwner: true},
+		{name: "owner removes an admin", caller: orgOwner, target: orgAdmin},
+		{name: "owner removes another owner", caller: orgOwner, target: orgAdmin, secondOwner: true},
+		{name: "admin removes a member", caller: orgAdmin, target: orgMember},
+		{name: "admin removes an owner", caller: orgAdmin, target: orgOwner, wantErr: ErrUnauthorized},
+		{name: "member removes a viewer", caller: orgMember, target: orgViewer, wantErr: ErrUnauthorized},
+		{name: "outsider removes a member", caller: outsider, target: orgMember, wantErr: ErrOrgNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, orgs, _, audit := newOrgTestService()
+			if tt.secondOwner {
+				orgs.members[1][orgAdmin] = models.OrgRoleOwner
+			}
+
+			err := s.RemoveOrgMember(asUser(tt.caller), 1, tt.target)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RemoveOrgMember error = %v, want %v", err, tt.wantErr)
+			}
+
+			_, stillMember := orgs.members[1][tt.target]
+			if stillMember != (tt.wantErr != nil) {
+				t.Errorf("member still in the organization: %v", stillMember)
+			}
+			wantEntries := 1
+			if tt.wantErr != nil {
+				wantEntries = 0
+			}
+			if len(audit.entries) != wantEntries {
+				t.Errorf("audit entries = %+v, want %d", audit.entries, wantEntries)
+			}
+		})
+	}
+}
+
+func TestAcceptOrgInvitation(t *testing.T) {
+	verified := time.Now()
+	tests := []struct {
+		name     string
+		email    string
+		verified *time.Time
+		wantErr  error
+	}{
+		{name: "verified invitee", email: "Invitee@Example.com", verified: &verified},
+		{name: "unverified invitee", email: "invitee@example.com", wantErr: ErrInvitationEmailMismatch},
+		{name: "someone else", email: "other@example.com", verified: &verified, wantErr: ErrInvitationEmailMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, orgs, _, audit := newOrgTestService()
+			invitee := s.repo.(*orgUserStore).users[outsider]
+			invitee.Email, invitee.EmailVerifiedAt = tt.email, tt.verified
+			orgs.invitations[middleware.HashOpaqueToken("token")] = &models.OrgInvitation{
+				ID: 7, OrgID: 1, Email: "invitee@example.com", Role: models.OrgRoleMember,
+			}
+
+			org, err := s.AcceptOrgInvitation(asUser(outsider), "token")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AcceptOrgInvitation error = %v, want %v", err, tt.wantErr)
+			}
+
+			role, joined := orgs.members[1][outsider]
+			if tt.wantErr != nil {
+				if joined || len(orgs.resolved) != 0 || len(audit.entries) != 0 {
+					t.Errorf("refused invitation changed something: role %q, resolved %v", role, orgs.resolved)
+				}
+				return
+			}
+			if role != models.OrgRoleMember || org.ID != 1 || org.Role != models.OrgRoleMember {
+				t.Errorf("joined as %q, org %+v", role, org)
+			}
+			if accepted, ok := orgs.resolved[7]; !ok || !accepted {
+				t.Error("invitation not marked accepted")
+			}
+			if len(audit.entries) != 1 || audit.entries[0].Action != models.AuditOrgJoin {
+				t.Errorf("audit entries = %+v, want one %s", audit.entries, models.AuditOrgJoin)
+			}
+		})
+	}
+}
+
+func TestDeclineOrgInvitation(t *testing.T) {
+	s, orgs, _, audit := newOrgTestService()
+	orgs.invitations[middleware.HashOpaqueToken("token")] = &models.OrgInvitation{
+		ID: 7, OrgID: 1, Email: "invitee@example.com", Role: models.OrgRoleMember,
+	}
+
+	// The invitee need not be signed in
+	if err := s.DeclineOrgInvitation(context.Background(), "token"); err != nil {
+		t.Fatalf("DeclineOrgInvitation: %v", err)
+	}
+	if accepted, ok := orgs.resolved[7]; !ok || accepted {
+		t.Error("invitation not marked declined")
+	}
+	if len(audit.entries) != 1 || audit.entries[0].Action != models.AuditOrgInviteDecline || audit.entries[0].ActorID != nil {
+		t.Errorf("audit entries = %+v, want one anonymous %s", audit.entries, models.AuditOrgInviteDecline)
+	}
+
+	if err := s.DeclineOrgInvitation(context.Background(), "token"); !errors.Is(err, ErrInvitationNotFound) {
+		t.Errorf("declining twice: got %v, want ErrInvitationNotFound", err)
+	}
+}
+
+func TestSwitchOrg(t *testing.T) {
+	member := context.WithValue(asUser(orgAdmin), "session_id", "session")
+	orgID, otherOrg := 1, 2
+	tokenService := newTestTokenService(t)
+
+	t.Run("member", func(t *testing.T) {
+		s, _, sessions, _ := newOrgTestService()
+		s.tokenService = tokenService
+
+		token, err := s.SwitchOrg(member, &orgID)
+		if err != nil {
+			t.Fatalf("SwitchOrg: %v", err)
+		}
+		if active := sessions.orgs["session"]; active == nil || *active != orgID {
+			t.Errorf("session acts for %v, want organization 1", active)
+		}
+		claims, err := s.tokenService.ValidateToken(token.Token)
+		if err != nil {
+			t.Fatalf("ValidateToken: %v", err)
+		}
+		if claims.OrgID != "1" || claims.OrgRole != models.OrgRoleAdmin || claims.SessionID != "session" {
+			t.Errorf("claims = %+v, want organization 1 as admin", claims)
+		}
+
+		if token, err = s.SwitchOrg(member, nil); err != nil {
+			t.Fatalf("SwitchOrg(nil): %v", err)
+		}
+		if claims, _ := s.tokenService.ValidateToken(token.Token); sessions.orgs["session"] != nil || claims.OrgID != "" {
+			t.Errorf("switching to no organization kept %q", claims.OrgID)
+		}
+	})
+
+	t.Run("non-member", func(t *testing.T) {
+		s, _, sessions, _ := newOrgTestService()
+
+		if _, err := s.SwitchOrg(member, &otherOrg); !errors.Is(err, ErrOrgNotFound) {
+			t.Errorf("SwitchOrg error = %v, want ErrOrgNotFound", err)
+		}
+		if _, ok := sessions.orgs["session"]; ok {
+			t.Error("session switched to an organization of strangers")
+		}
+	})
+
+	t.Run("no session", func(t *testing.T) {
+		s, _, sessions, _ := newOrgTestService()
+		apiKey := context.WithValue(asUser(orgAdmin), "api_key_id", "1")
+
+		for _, ctx := range []context.Context{asUser(orgAdmin), apiKey, context.Background()} {
+			if _, err := s.SwitchOrg(ctx, &orgID); !errors.Is(err, ErrUnauthorized) {
+				t.Errorf("SwitchOrg error = %v, want ErrUnauthorized", err)
+			}
+		}
+		if len(sessions.orgs) != 0 {
+			t.Error("a session-less caller switched organization")
+		}
+	})
+}
+
+func TestOrgClaimsDropLeftOrg(t *testing.T) {
+	s, orgs, sessions, _ := newOrgTestService()
+	orgID := 1
+	sessions.orgs["session"] = &orgID
+
+	gotOrg, gotRole, err := s.orgClaims(context.Background(), orgMember, "session")
+	if err != nil || gotOrg != "1" || gotRole != models.OrgRoleMember {
+		t.Fatalf("orgClaims = %q, %q, %v; want organization 1 as member", gotOrg, gotRole, err)
+	}
+
+	delete(orgs.members[1], orgMember)
+	gotOrg, gotRole, err = s.orgClaims(context.Background(), orgMember, "session")
+	if err != nil || gotOrg != "" || gotRole != "" {
+		t.Errorf("orgClaims after leaving = %q, %q, %v; want no organization", gotOrg, gotRole, err)
+	}
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"go.uber.org/zap"
+)
+
+// GetPreferences returns a user's preferences, or the defaults if they
+// never saved any. Users can read their own; users:read reads anyone's.
+func (s UserService) GetPreferences(ctx context.Context, id int) (*models.UserPreferences, error) {
+	if _, err := s.checkPermissions(ctx, id, middleware.PermUsersRead); err != nil {
+		return nil, err
+	}
+
+	return s.preferences(ctx, id)
+}
+
+// ReadPreferences is GetPreferences for other services, e.g. to format
+// amounts in a user's base currency. It requires preferences:read, which
+// service clients are granted as a scope.
+func (s UserService) ReadPreferences(ctx context.Context, id int) (*models.UserPreferences, error) {
+	if !hasPermission(ctx, middleware.PermPreferencesRead) {
+		return nil, ErrUnauthorized
+	}
+
+	if _, err := s.repo.GetUserByID(ctx, id); err != nil {
+		return nil, err
+	}
+
+	return s.preferences(ctx, id)
+}
+
+// UpdatePreferences replaces a user's preferences. A non-zero version makes
+// the update conditional: it fails with ErrVersionConflict unless the
+// preferences are still at that version, where the defaults are version 0.
+func (s UserService) UpdatePreferences(ctx context.Context, id int, prefs models.Preferences, version int) (*models.UserPreferences, error) {
+	if _, err := s.checkPermissions(ctx, id, middleware.PermUsersWrite); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.repo.GetUserByID(ctx, id); err != nil {
+		return nil, err
+	}
+
+	var saved *models.UserPreferences
+	err := s.withAudit(ctx, models.AuditPreferencesUpdate, id, func(ctx context.Context, entry *models.AuditEntry) error {
+		current, err := s.preferences(ctx, id)
+		if err != nil {
+			return err
+		}
+		if version != 0 && current.Version != version {
+			return ErrVersionConflict
+		}
+
+		if err := recordPreferenceChanges(entry, current.Preferences, prefs); err != nil {
+			return err
+		}
+
+		// Conditional on the version read above, so that nothing changed
+		// between the read and the diff recorded from it
+		saved, err = s.prefs.SavePreferences(ctx, id, prefs, current.Version)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Info("Preferences updated", zap.Int("userID", id), zap.Int("version", saved.Version))
+	return saved, nil
+}
+
+// preferences returns the saved preferences of a user or the defaults.
+func (s UserService) preferences(ctx context.Context, id int) (*models.UserPreferences, error) {
+	prefs, err := s.prefs.GetPreferences(ctx, id)
+	if errors.Is(err, ErrPreferencesNotFound) {
+		return &models.UserPreferences{Preferences: models.DefaultPreferences()}, nil
+	}
+	return prefs, err
+}
+
+// recordPreferenceChanges adds the settings that differ between old and new
+// to entry, named as in the JSON of the preferences.
+func recordPreferenceChanges(entry *models.AuditEntry, old, new models.Preferences) error {
+	before, err := preferenceFields(old)
+	if err != nil {
+		return err
+	}
+	after, err := preferenceFields(new)
+	if err != nil {
+		return err
+	}
+
+	for field, value := range after {
+		if !reflect.DeepEqual(before[field], value) {
+			recordChange(entry, "preferences."+field, before[field], value)
+		}
+	}
+	return nil
+}
+
+func preferenceFields(prefs models.Preferences) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(prefs)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
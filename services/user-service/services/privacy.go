@@ -0,0 +1,378 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"go.uber.org/zap"
+)
+
+const (
+	// How long a finished export can be downloaded before a new one is built
+	dataExportTTL = 24 * time.Hour
+	// A pending export older than this is assumed lost, e.g. to a restart
+	dataExportTimeout = 10 * time.Minute
+)
+
+// DataOwner holds part of a user's personal data. Every owner is asked for
+// its data when the user requests an export and told to forget it when the
+// user is erased.
+type DataOwner interface {
+	// Name is used as the owner's file name in export archives.
+	Name() string
+	// ExportUserData returns a JSON encodable view of the data, or nil if
+	// there is nothing to export.
+	ExportUserData(ctx context.Context, userID int) (interface{}, error)
+	// EraseUserData deletes or anonymizes the data. It must be safe to call
+	// again after a partial failure.
+	EraseUserData(ctx context.Context, userID int) error
+}
+
+// DataOwnerFuncs adapts a pair of functions to a DataOwner. A nil function
+// means the owner has nothing to export or erase.
+type DataOwnerFuncs struct {
+	OwnerName string
+	Export    func(ctx context.Context, userID int) (interface{}, error)
+	Erase     func(ctx context.Context, userID int) error
+}
+
+func (f DataOwnerFuncs) Name() string { return f.OwnerName }
+
+func (f DataOwnerFuncs) ExportUserData(ctx context.Context, userID int) (interface{}, error) {
+	if f.Export == nil {
+		return nil, nil
+	}
+	return f.Export(ctx, userID)
+}
+
+func (f DataOwnerFuncs) EraseUserData(ctx context.Context, userID int) error {
+	if f.Erase == nil {
+		return nil
+	}
+	return f.Erase(ctx, userID)
+}
+
+// DataRegistry lists the owners of personal data, in registration order.
+type DataRegistry struct {
+	mu     sync.RWMutex
+	owners []DataOwner
+}
+
+func NewDataRegistry() *DataRegistry {
+	return &DataRegistry{}
+}
+
+// Register adds an owner. Owners are exported in registration order and
+// erased in reverse, so the user profile, registered first, goes last.
+func (r *DataRegistry) Register(owner DataOwner) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.owners = append(r.owners, owner)
+}
+
+// Owners returns a snapshot of the registered owners.
+func (r *DataRegistry) Owners() []DataOwner {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]DataOwner(nil), r.owners...)
+}
+
+// RegisterDataOwner adds data held outside this service, such as
+// portfolios, to exports and erasures.
+func (s UserService) RegisterDataOwner(owner DataOwner) {
+	s.dataOwners.Register(owner)
+}
+
+// registerDataOwners registers the personal data held by this service.
+func (s UserService) registerDataOwners() {
+	s.RegisterDataOwner(DataOwnerFuncs{
+		OwnerName: "profile",
+		Export: func(ctx context.Context, userID int) (interface{}, error) {
+			return s.repo.GetUserByID(ctx, userID)
+		},
+		Erase: func(ctx context.Context, userID int) error {
+			passwordHash, err := unusablePasswordHash()
+			if err != nil {
+				return err
+			}
+			return s.repo.AnonymizeUser(ctx, userID, passwordHash)
+		},
+	})
+	// Erased before the profile, which forgets the avatar key
+	s.RegisterDataOwner(DataOwnerFuncs{
+		OwnerName: "avatar",
+		Export: func(ctx context.Context, userID int) (interface{}, error) {
+			user, err := s.repo.GetUserByID(ctx, userID)
+			if err != nil || user.AvatarKey == nil {
+				return nil, err
+			}
+			return s.avatars.URLs(*user.AvatarKey), nil
+		},
+		Erase: s.eraseAvatar,
+	})
+	s.RegisterDataOwner(DataOwnerFuncs{
+		OwnerName: "preferences",
+		Export: func(ctx context.Context, userID int) (interface{}, error) {
+			prefs, err := s.prefs.GetPreferences(ctx, userID)
+			if errors.Is(err, ErrPreferencesNotFound) {
+				return nil, nil
+			}
+			return prefs, err
+		},
+		Erase: s.prefs.DeletePreferences,
+	})
+	s.RegisterDataOwner(DataOwnerFuncs{
+		OwnerName: "sessions",
+		Export: func(ctx context.Context, userID int) (interface{}, error) {
+			return s.tokens.ListSessionHistory(ctx, userID)
+		},
+		Erase: s.tokens.DeleteSessions,
+	})
+	s.RegisterDataOwner(DataOwnerFuncs{
+		OwnerName: "identities",
+		Export: func(ctx context.Context, userID int) (interface{}, error) {
+			return s.identities.ListIdentities(ctx, userID)
+		},
+		Erase: s.identities.DeleteIdentities,
+	})
+	s.RegisterDataOwner(DataOwnerFuncs{
+		OwnerName: "organizations",
+		Export: func(ctx context.Context, userID int) (interface{}, error) {
+			return s.orgs.ListUserOrgs(ctx, userID)
+		},
+		Erase: s.orgs.DeleteMemberships,
+	})
+	s.RegisterDataOwner(DataOwnerFuncs{
+		OwnerName: "api_keys",
+		Export: func(ctx context.Context, userID int) (interface{}, error) {
+			return s.apiKeys.ListAPIKeys(ctx, userID)
+		},
+		Erase: s.apiKeys.DeleteAPIKeys,
+	})
+	s.RegisterDataOwner(DataOwnerFuncs{
+		OwnerName: "mfa",
+		Export: func(ctx context.Context, userID int) (interface{}, error) {
+			mfa, err := s.mfa.GetMFA(ctx, userID)
+			if errors.Is(err, ErrMFANotEnrolled) {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			// The secret stays out of the archive, it would let anyone
+			// holding the archive generate codes
+			return map[string]interface{}{
+				"enabled":   mfa.EnabledAt != nil,
+				"enabledAt": mfa.EnabledAt,
+				"createdAt": mfa.CreatedAt,
+			}, nil
+		},
+		Erase: s.mfa.DisableMFA,
+	})
+	s.RegisterDataOwner(DataOwnerFuncs{
+		OwnerName: "audit_log",
+		Export: func(ctx context.Context, userID int) (interface{}, error) {
+			return s.audit.ListUserAuditEntries(ctx, userID)
+		},
+		Erase: s.audit.RedactUserAuditEntries,
+	})
+	s.RegisterDataOwner(DataOwnerFuncs{
+		OwnerName: "data_exports",
+		Erase:     s.exports.DeleteDataExports,
+	})
+}
+
+// RequestDataExport returns the user's latest export if it can still be
+// downloaded or is being built, and otherwise starts a new one. Requires
+// users:read to export another user.
+func (s UserService) RequestDataExport(ctx context.Context, id int) (*models.DataExport, error) {
+	if _, err := s.checkPermissions(ctx, id, middleware.PermUsersRead); err != nil {
+		return nil, err
+	}
+
+	latest, err := s.exports.GetLatestDataExport(ctx, id)
+	switch {
+	case err == nil:
+		if isCurrentDataExport(latest) {
+			return latest, nil
+		}
+	case !errors.Is(err, ErrDataExportNotFound):
+		return nil, err
+	}
+
+	export := &models.DataExport{
+		UserID:    id,
+		Status:    models.DataExportPending,
+		CreatedAt: time.Now(),
+	}
+	if err := s.exports.CreateDataExport(ctx, export); err != nil {
+		return nil, err
+	}
+
+	// The request context ends with the response
+	s.background.Go(func(ctx context.Context) { s.buildDataExport(ctx, export.ID, id) })
+
+	return export, nil
+}
+
+func isCurrentDataExport(export *models.DataExport) bool {
+	switch export.Status {
+	case models.DataExportReady:
+		return export.ExpiresAt != nil && export.ExpiresAt.After(time.Now())
+	case models.DataExportPending:
+		return time.Since(export.CreatedAt) < dataExportTimeout
+	}
+	return false
+}
+
+// buildDataExport collects the data of every owner into a ZIP archive with
+// one JSON file per owner, then emails the user that it is ready. An export
+// cut short by shutdown stays pending until it is stale and requested again.
+func (s UserService) buildDataExport(ctx context.Context, exportID, userID int) {
+	ctx, cancel := context.WithTimeout(ctx, dataExportTimeout)
+	defer cancel()
+
+	logger := s.log.With(zap.Int("exportID", exportID), zap.Int("userID", userID))
+
+	// A panicking owner must not take the server down with it
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("Data export panicked", zap.Any("panic", r))
+			_ = s.exports.FailDataExport(ctx, exportID, "export failed")
+		}
+	}()
+
+	archive, err := s.dataExportArchive(ctx, userID)
+	if err != nil {
+		logger.Error("Failed to build data export", zap.Error(err))
+		_ = s.exports.FailDataExport(ctx, exportID, "export failed")
+		return
+	}
+
+	if err := s.exports.CompleteDataExport(ctx, exportID, archive, time.Now().Add(dataExportTTL)); err != nil {
+		logger.Error("Failed to store data export", zap.Error(err))
+		_ = s.exports.FailDataExport(ctx, exportID, "export failed")
+		return
+	}
+	logger.Info("Data export ready", zap.Int("bytes", len(archive)))
+
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return
+	}
+	if err := s.notifier.SendDataExportReady(ctx, user, dataExportTTL); err != nil {
+		logger.Error("Failed to send data export email", zap.Error(err))
+	}
+}
+
+func (s UserService) dataExportArchive(ctx context.Context, userID int) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+
+	var exported []string
+	for _, owner := range s.dataOwners.Owners() {
+		data, err := owner.ExportUserData(ctx, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %s data: %w", owner.Name(), err)
+		}
+		if data == nil {
+			continue
+		}
+
+		if err := writeJSONFile(archive, owner.Name()+".json", data); err != nil {
+			return nil, err
+		}
+		exported = append(exported, owner.Name())
+	}
+
+	manifest := map[string]interface{}{
+		"userId":      userID,
+		"generatedAt": time.Now().UTC(),
+		"contents":    exported,
+	}
+	if err := writeJSONFile(archive, "manifest.json", manifest); err != nil {
+		return nil, err
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeJSONFile(archive *zip.Writer, name string, data interface{}) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+	return nil
+}
+
+// EraseUser honors a request to be forgotten. The user is logged out
+// everywhere, every data owner erases its data, and the account is left
+// anonymized and deleted until purged. Requires users:delete to erase
+// another user.
+func (s UserService) EraseUser(ctx context.Context, id int) error {
+	currentUser, err := s.checkAccountManagement(ctx, id, middleware.PermUsersDelete)
+	if err != nil {
+		return err
+	}
+
+	// Owners sharing our database erase in one transaction; a failure
+	// elsewhere rolls that back, so the erasure can simply be retried
+	pending := &erasure{}
+	err = s.withAudit(context.WithValue(ctx, erasureKey{}, pending), models.AuditUserErase, id, func(ctx context.Context, _ *models.AuditEntry) error {
+		if err := s.tokens.RevokeAllUserTokens(ctx, id); err != nil {
+			return err
+		}
+
+		owners := s.dataOwners.Owners()
+		for i := len(owners) - 1; i >= 0; i-- {
+			if err := owners[i].EraseUserData(ctx, id); err != nil {
+				return fmt.Errorf("failed to erase %s data: %w", owners[i].Name(), err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, fn := range pending.afterCommit {
+		fn(ctx)
+	}
+
+	s.log.Info("User erased", zap.Int("userID", id), zap.Int("erasedBy", currentUser.ID))
+	return nil
+}
+
+type erasureKey struct{}
+
+// erasure holds the work of an erasure that cannot be rolled back, such as
+// deleting files, to run only once its transaction has committed.
+type erasure struct {
+	afterCommit []func(ctx context.Context)
+}
+
+// afterErasure defers fn until the erasure running in ctx commits. Outside
+// of EraseUser fn runs at once.
+func afterErasure(ctx context.Context, fn func(ctx context.Context)) {
+	if e, ok := ctx.Value(erasureKey{}).(*erasure); ok {
+		e.afterCommit = append(e.afterCommit, fn)
+		return
+	}
+	fn(ctx)
+}
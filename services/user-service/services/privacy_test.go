@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luisVargasGu/stockTracker/common/storage"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"go.uber.org/zap"
+)
+
+// erasureUserStore knows one user and their avatar key.
+type erasureUserStore struct {
+	models.UserRepository
+	user      *models.User
+	avatarKey *string
+}
+
+func (f *erasureUserStore) GetUserByID(_ context.Context, id int) (*models.User, error) {
+	if id != f.user.ID {
+		return nil, ErrUserNotFound
+	}
+	return f.user, nil
+}
+
+func (f *erasureUserStore) ClearAvatar(_ context.Context, _ int) (*string, error) {
+	key := f.avatarKey
+	f.avatarKey = nil
+	return key, nil
+}
+
+type erasureTokenStore struct {
+	models.TokenRepository
+}
+
+func (erasureTokenStore) RevokeAllUserTokens(context.Context, int) error {
+	return nil
+}
+
+// blobRecorder records deleted keys.
+type blobRecorder struct {
+	storage.Store
+	deleted []string
+}
+
+func (b *blobRecorder) Delete(_ context.Context, key string) error {
+	b.deleted = append(b.deleted, key)
+	return nil
+}
+
+func newErasureTestService(ownerErr error) (UserService, *blobRecorder) {
+	key := "abc123.png"
+	blobs := &blobRecorder{}
+	s := UserService{
+		repo:       &erasureUserStore{user: &models.User{ID: 1}, avatarKey: &key},
+		tokens:     erasureTokenStore{},
+		tx:         fakeTransactor{},
+		audit:      &fakeAuditStore{},
+		avatars:    NewAvatarStore(blobs, ""),
+		dataOwners: NewDataRegistry(),
+		log:        zap.NewNop(),
+	}
+	// Registered first, so erased after the avatar
+	s.RegisterDataOwner(DataOwnerFuncs{
+		OwnerName: "portfolio",
+		Erase:     func(context.Context, int) error { return ownerErr },
+	})
+	s.RegisterDataOwner(DataOwnerFuncs{OwnerName: "avatar", Erase: s.eraseAvatar})
+	return s, blobs
+}
+
+func TestEraseUserDeletesAvatarAfterCommit(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "user_id", "1")
+
+	s, blobs := newErasureTestService(nil)
+	if err := s.EraseUser(ctx, 1); err != nil {
+		t.Fatalf("EraseUser: %v", err)
+	}
+	if len(blobs.deleted) != len(AvatarSizes) {
+		t.Errorf("deleted %v, want every size of the avatar", blobs.deleted)
+	}
+	for _, key := range blobs.deleted {
+		if !strings.Contains(key, "abc123") {
+			t.Errorf("deleted unrelated blob %q", key)
+		}
+	}
+
+	// A failure rolls the avatar key back, so the images must stay
+	s, blobs = newErasureTestService(errors.New("portfolio service unavailable"))
+	if err := s.EraseUser(ctx, 1); err == nil {
+		t.Fatal("EraseUser succeeded although an owner failed")
+	}
+	if len(blobs.deleted) != 0 {
+		t.Errorf("deleted %v although the erasure was rolled back", blobs.deleted)
+	}
+}
+
+// failingExportStore cannot store finished exports.
+type failingExportStore struct {
+	models.DataExportRepository
+	failed map[int]string
+}
+
+func (failingExportStore) CompleteDataExport(context.Context, int, []byte, time.Time) error {
+	return errors.New("connection reset")
+}
+
+func (f failingExportStore) FailDataExport(_ context.Context, exportID int, reason string) error {
+	f.failed[exportID] = reason
+	return nil
+}
+
+func TestBuildDataExportFailsWhenItCannotBeStored(t *testing.T) {
+	exports := failingExportStore{failed: make(map[int]string)}
+	s := UserService{exports: exports, dataOwners: NewDataRegistry(), log: zap.NewNop()}
+
+	s.buildDataExport(context.Background(), 7, 1)
+
+	if _, ok := exports.failed[7]; !ok {
+		t.Error("export left pending after it could not be stored")
+	}
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultDeletedUserRetention is how long a soft deleted user can be restored.
+	DefaultDeletedUserRetention = 30 * 24 * time.Hour
+	purgeInterval               = time.Hour
+)
+
+// UserPurger periodically hard deletes users whose soft delete is older than
+// the retention period. Running it on several replicas is harmless.
+type UserPurger struct {
+	repo      models.UserRepository
+	avatars   *AvatarStore
+	retention time.Duration
+	log       *zap.Logger
+}
+
+func NewUserPurger(repo models.UserRepository, avatars *AvatarStore, retention time.Duration, log *zap.Logger) *UserPurger {
+	return &UserPurger{repo: repo, avatars: avatars, retention: retention, log: log}
+}
+
+// Run purges once immediately and then every purgeInterval until ctx is done.
+func (p *UserPurger) Run(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		p.Purge(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge hard deletes every user soft deleted before the retention period.
+func (p *UserPurger) Purge(ctx context.Context) {
+	purged, err := p.repo.PurgeDeletedUsers(ctx, time.Now().Add(-p.retention))
+	if err != nil {
+		p.log.Error("Failed to purge deleted users", zap.Error(err))
+		return
+	}
+
+	for _, user := range purged {
+		if user.AvatarKey == nil {
+			continue
+		}
+		if err := p.avatars.delete(ctx, *user.AvatarKey); err != nil {
+			p.log.Error("Failed to delete avatar of purged user", zap.Int("userID", user.ID), zap.Error(err))
+		}
+	}
+
+	if len(purged) > 0 {
+		p.log.Info("Purged deleted users", zap.Int("count", len(purged)), zap.Duration("retention", p.retention))
+	}
+}
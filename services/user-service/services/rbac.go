@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"go.uber.org/zap"
+)
+
+// DefaultRole is assigned to new registrations. It only grants access to the
+// user's own resources.
+const DefaultRole = "user"
+
+// ListRoles returns the available roles and their permissions.
+func (s UserService) ListRoles(ctx context.Context) ([]*models.Role, error) {
+	if !hasPermission(ctx, middleware.PermRolesManage) {
+		return nil, ErrUnauthorized
+	}
+
+	return s.roles.ListRoles(ctx)
+}
+
+// AssignRole changes the role of a user. Their existing tokens are revoked so
+// that the new permissions apply from the next login or refresh.
+func (s UserService) AssignRole(ctx context.Context, id int, role string) error {
+	currentUser, err := s.extractUserFromContext(ctx)
+	if err != nil {
+		return ErrUnauthorized
+	}
+	if !hasPermission(ctx, middleware.PermRolesManage) {
+		return ErrUnauthorized
+	}
+	// Prevents the last administrator from demoting themselves by accident
+	if currentUser.ID == id {
+		return ErrOwnRole
+	}
+
+	if _, err := s.roles.GetRole(ctx, role); err != nil {
+		return err
+	}
+
+	if err := s.setRole(ctx, id, role); err != nil {
+		return err
+	}
+
+	s.log.Info("Role assigned",
+		zap.Int("userID", id),
+		zap.String("role", role),
+		zap.Int("assignedBy", currentUser.ID))
+	return nil
+}
+
+// setRole changes the role of a user and revokes their tokens. The role
+// must exist.
+func (s UserService) setRole(ctx context.Context, id int, role string) error {
+	target, err := s.repo.GetUserByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return s.withAudit(ctx, models.AuditRoleAssign, id, func(ctx context.Context, entry *models.AuditEntry) error {
+		recordChange(entry, "role", target.Role, role)
+
+		if err := s.repo.SetUserRole(ctx, id, role); err != nil {
+			return err
+		}
+
+		if err := s.tokens.RevokeAllUserTokens(ctx, id); err != nil {
+			s.log.Error("Failed to revoke tokens after role change", zap.Int("userID", id), zap.Error(err))
+			return err
+		}
+		return nil
+	})
+}
+
+// hasPermission reports whether the caller's access token grants perm.
+func hasPermission(ctx context.Context, perm string) bool {
+	granted, _ := ctx.Value("permissions").([]string)
+	for _, p := range granted {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
@@ -3,17 +3,19 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
 	"regexp"
 	"strconv"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/common/utils"
 	"github.com/luisVargasGu/stockTracker/services/user-service/models"
 	"go.uber.org/zap"
 )
 
 var (
-	AdminRole              = "admin"
 	ErrUserNotFound        = errors.New("user not found")
 	ErrInvalidCredentials  = errors.New("invalid credentials")
 	ErrInternalServerError = errors.New("internal server error")
@@ -24,18 +26,128 @@ var (
 	ErrTokenGeneration     = errors.New("token generation failed")
 	ErrUserAlreadyExists   = errors.New("user already exists")
 	ErrUserDeleted         = errors.New("user already deleted")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+	ErrInvalidResetToken   = errors.New("invalid or expired reset token")
+	ErrPasswordReused      = errors.New("password was used recently")
+
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+	ErrMFANotEnrolled           = errors.New("two-factor authentication is not enrolled")
+	ErrMFAAlreadyEnabled        = errors.New("two-factor authentication is already enabled")
+	ErrInvalidMFACode           = errors.New("invalid two-factor authentication code")
+	ErrInvalidMFAToken          = errors.New("invalid or expired MFA token")
+	ErrAccountLocked            = errors.New("account is temporarily locked")
+	ErrTooManyAttempts          = errors.New("too many failed login attempts")
+	ErrRoleNotFound             = errors.New("role not found")
+	ErrOwnRole                  = errors.New("cannot change your own role")
+	ErrAPIKeyNotFound           = errors.New("API key not found")
+	ErrInvalidScope             = errors.New("scope exceeds your permissions")
+	ErrInvalidExpiry            = errors.New("expiry must be in the future")
+	ErrProviderNotFound         = errors.New("identity provider not found")
+	ErrInvalidOIDCState         = errors.New("invalid or expired login state")
+	ErrIdentityNotFound         = errors.New("identity not linked")
+	ErrExternalLoginFailed      = errors.New("external login failed")
+	ErrExternalEmailUnverified  = errors.New("external account has no verified email")
+	ErrSessionNotFound          = errors.New("session not found")
+	ErrDataExportNotFound       = errors.New("data export not found")
+	ErrAvatarNotFound           = errors.New("avatar not found")
+	ErrAvatarTooLarge           = errors.New("avatar exceeds the maximum size")
+	ErrUnsupportedAvatar        = errors.New("avatar must be a JPEG, PNG, GIF or WebP image")
+	ErrInvalidAvatar            = errors.New("avatar is not a valid image")
+	ErrVersionConflict          = errors.New("user was modified by someone else")
+	ErrOrgNotFound              = errors.New("organization not found")
+	ErrOrgMemberNotFound        = errors.New("organization member not found")
+	ErrAlreadyOrgMember         = errors.New("user is already a member of the organization")
+	ErrLastOrgOwner             = errors.New("organization must keep at least one owner")
+	ErrInvitationNotFound       = errors.New("invitation not found")
+	ErrInvalidInvitation        = errors.New("invalid or expired invitation")
+	ErrInvitationEmailMismatch  = errors.New("invitation was sent to a different email address")
+	ErrAccountDisabled          = errors.New("account is disabled")
+	ErrOwnAccount               = errors.New("cannot apply a bulk action to your own account")
+	ErrInvalidImport            = errors.New("invalid import file")
+	ErrImportFailed             = errors.New("import has invalid rows")
+	ErrPreferencesNotFound      = errors.New("preferences not found")
+)
+
+const (
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 24 * time.Hour
+
+	// Failed logins before an account is locked, and the lock duration
+	// which doubles with every further failure
+	accountLockThreshold = 5
+	accountLockBase      = 30 * time.Second
+	accountLockMax       = time.Hour
+
+	// Thumbnail size of the avatar in login responses
+	userInfoAvatarSize = 128
 )
 
 type UserService struct {
 	repo         models.UserRepository
+	tokens       models.TokenRepository
+	mfa          models.MFARepository
+	roles        models.RoleRepository
+	apiKeys      models.APIKeyRepository
+	identities   models.IdentityRepository
+	providers    map[string]IdentityProvider
+	exports      models.DataExportRepository
+	avatars      *AvatarStore
+	orgs         models.OrgRepository
+	prefs        models.PreferencesRepository
+	audit        models.AuditRepository
+	tx           models.Transactor
+	dataOwners   *DataRegistry
 	tokenService middleware.TokenService
+	notifier     *AccountNotifier
+	policy       *middleware.PasswordPolicy
+	throttle     *LoginThrottler
+	background   *Background
 	log          *zap.Logger
 }
 
 func NewUserService(repository models.UserRepository,
+	tokens models.TokenRepository,
+	mfa models.MFARepository,
+	roles models.RoleRepository,
+	apiKeys models.APIKeyRepository,
+	identities models.IdentityRepository,
+	providers map[string]IdentityProvider,
+	exports models.DataExportRepository,
+	avatars *AvatarStore,
+	orgs models.OrgRepository,
+	prefs models.PreferencesRepository,
+	audit models.AuditRepository,
+	tx models.Transactor,
 	tokenService middleware.TokenService,
+	notifier *AccountNotifier,
+	policy *middleware.PasswordPolicy,
+	background *Background,
 	log *zap.Logger) UserService {
-	return UserService{repo: repository, tokenService: tokenService, log: log}
+	s := UserService{
+		repo:         repository,
+		tokens:       tokens,
+		mfa:          mfa,
+		roles:        roles,
+		apiKeys:      apiKeys,
+		identities:   identities,
+		providers:    providers,
+		exports:      exports,
+		avatars:      avatars,
+		orgs:         orgs,
+		prefs:        prefs,
+		audit:        audit,
+		tx:           tx,
+		dataOwners:   NewDataRegistry(),
+		tokenService: tokenService,
+		notifier:     notifier,
+		policy:       policy,
+		throttle:     NewLoginThrottler(),
+		background:   background,
+		log:          log,
+	}
+	s.registerDataOwners()
+	return s
 }
 
 func (s UserService) LoginUser(ctx context.Context, payload models.LoginUserPayload) (*models.LoginResponse, error) {
@@ -45,10 +157,19 @@ func (s UserService) LoginUser(ctx context.Context, payload models.LoginUserPayl
 		Message: "Login failed",
 	}
 
+	if s.throttle.RetryAfter(payload.IP) > 0 {
+		response.Message = ErrTooManyAttempts.Error()
+		return response, ErrTooManyAttempts
+	}
+
 	// Find user by email
 	user, err := s.repo.GetUserByEmail(ctx, payload.Email)
 	if err != nil {
 		if errors.Is(err, ErrUserNotFound) {
+			// Spend the same time as for a real account so that response
+			// times do not reveal which emails are registered
+			middleware.ComparePasswords(dummyPasswordHash(), []byte(payload.Password))
+			s.throttle.Fail(payload.IP)
 			response.Message = ErrInvalidCredentials.Error()
 			return response, ErrInvalidCredentials
 		}
@@ -58,7 +179,17 @@ func (s UserService) LoginUser(ctx context.Context, payload models.LoginUserPayl
 	}
 
 	// Verify password
-	if !middleware.ComparePasswords(user.PasswordHash, []byte(payload.Password)) {
+	passwordOK := middleware.ComparePasswords(user.PasswordHash, []byte(payload.Password))
+
+	// A locked account rejects even the right password until the lock expires
+	if isLocked(user) {
+		s.throttle.Fail(payload.IP)
+		response.Message = ErrInvalidCredentials.Error()
+		return response, ErrAccountLocked
+	}
+
+	if !passwordOK {
+		s.recordFailedLogin(ctx, user.ID, payload.IP)
 		response.Message = ErrInvalidCredentials.Error()
 		return response, ErrInvalidCredentials
 	}
@@ -68,42 +199,433 @@ func (s UserService) LoginUser(ctx context.Context, payload models.LoginUserPayl
 		response.Message = ErrUserDeleted.Error()
 		return response, ErrUserDeleted
 	}
+	if user.DisabledAt != nil {
+		response.Message = ErrAccountDisabled.Error()
+		return response, ErrAccountDisabled
+	}
 
-	// Generate token pair
-	accessToken, err := s.tokenService.GenerateToken(
-		strconv.Itoa(user.ID),
-		user.Email,
-	)
+	return s.continueLogin(ctx, user, response)
+}
+
+// continueLogin follows a successful first factor: it asks for the second
+// factor when MFA is enabled and completes the login otherwise.
+func (s UserService) continueLogin(ctx context.Context, user *models.User, response *models.LoginResponse) (*models.LoginResponse, error) {
+	// A second factor is required before any real token is issued
+	mfaEnabled, err := s.isMFAEnabled(ctx, user.ID)
+	if err != nil {
+		response.Message = ErrInternalServerError.Error()
+		return response, err
+	}
+	if mfaEnabled {
+		mfaToken, expiresAt, err := s.tokenService.GenerateMFAToken(strconv.Itoa(user.ID))
+		if err != nil {
+			response.Message = ErrTokenGeneration.Error()
+			s.log.Error("Error generating MFA token", zap.Error(err))
+			return response, err
+		}
+
+		response.Message = "Two-factor authentication required"
+		response.MFARequired = true
+		response.MFAToken = &models.AuthToken{Token: mfaToken, ExpiresAt: expiresAt}
+		return response, nil
+	}
+
+	return s.completeLogin(ctx, user, response)
+}
+
+// completeLogin issues a token pair for a fully authenticated user.
+func (s UserService) completeLogin(ctx context.Context, user *models.User, response *models.LoginResponse) (*models.LoginResponse, error) {
+	// Every login is a new session, which is also the refresh token family
+	ip, userAgent := clientInfo(ctx)
+	session := &models.Session{
+		ID:         uuid.NewString(),
+		UserID:     user.ID,
+		UserAgent:  userAgent,
+		IP:         ip,
+		CreatedAt:  time.Now(),
+		LastSeenAt: time.Now(),
+	}
+	if err := s.tokens.CreateSession(ctx, session); err != nil {
+		response.Message = ErrInternalServerError.Error()
+		return response, err
+	}
+
+	// Generate token pair, starting a new refresh token family
+	access, refresh, err := s.issueTokens(ctx, user, session.ID, 0)
 	if err != nil {
 		response.Message = ErrTokenGeneration.Error()
 		s.log.Error("Error generating tokens", zap.Error(err))
 		return response, err
 	}
 
-	// Update last login (non-blocking)
-	go func() {
-		if _, err := s.repo.UpdateUser(ctx,
-			user.ID, map[string]interface{}{"updated_at": time.Now()}); err != nil {
+	// Update last login and clear failed attempts (non-blocking). The request
+	// context ends with the response.
+	s.background.Go(func(ctx context.Context) {
+		if err := s.repo.RecordSuccessfulLogin(ctx, user.ID); err != nil {
 			s.log.Error("Failed to update last login", zap.Error(err))
 		}
-	}()
+	})
 
 	// Prepare response
 	response.Success = true
 	response.Message = "Login successful"
-	response.User = &models.UserInfo{
-		ID:    user.ID,
-		Email: user.Email,
-		Name:  user.Name,
-		Role:  user.Role,
+	response.User = s.newUserInfo(user)
+	response.Token = access
+	response.RefreshToken = refresh
+	return response, nil
+}
+
+// RefreshToken exchanges a valid refresh token for a new access/refresh pair.
+// The presented token is consumed; presenting it again is treated as theft and
+// revokes the whole token family.
+func (s UserService) RefreshToken(ctx context.Context, payload models.RefreshTokenPayload) (*models.LoginResponse, error) {
+	response := &models.LoginResponse{
+		Success: false,
+		Message: "Token refresh failed",
 	}
-	response.Token = &models.AuthToken{
-		Token:     accessToken,
-		ExpiresAt: time.Now().Add(24 * time.Hour),
+
+	current, err := s.tokens.GetRefreshTokenByHash(ctx, middleware.HashOpaqueToken(payload.RefreshToken))
+	if err != nil {
+		response.Message = ErrInvalidRefreshToken.Error()
+		if errors.Is(err, ErrInvalidRefreshToken) {
+			return response, ErrInvalidRefreshToken
+		}
+		return response, err
 	}
+
+	logger := s.log.With(zap.Int("userID", current.UserID), zap.String("familyID", current.FamilyID))
+
+	if current.RotatedAt != nil {
+		logger.Warn("Refresh token reuse detected, revoking token family")
+		if err := s.tokens.RevokeTokenFamily(ctx, current.FamilyID); err != nil {
+			logger.Error("Failed to revoke token family", zap.Error(err))
+		}
+		response.Message = ErrInvalidRefreshToken.Error()
+		return response, ErrRefreshTokenReused
+	}
+
+	if current.RevokedAt != nil || time.Now().After(current.ExpiresAt) {
+		response.Message = ErrInvalidRefreshToken.Error()
+		return response, ErrInvalidRefreshToken
+	}
+
+	user, err := s.repo.GetUserByID(ctx, current.UserID)
+	if err != nil {
+		response.Message = ErrInvalidRefreshToken.Error()
+		return response, ErrInvalidRefreshToken
+	}
+
+	if user.DeletedAt != nil {
+		response.Message = ErrUserDeleted.Error()
+		return response, ErrUserDeleted
+	}
+	if user.DisabledAt != nil {
+		response.Message = ErrAccountDisabled.Error()
+		return response, ErrAccountDisabled
+	}
+
+	access, refresh, err := s.issueTokens(ctx, user, current.FamilyID, current.ID)
+	if err != nil {
+		if errors.Is(err, ErrRefreshTokenReused) {
+			// Lost a race against another request presenting the same token
+			logger.Warn("Concurrent refresh token reuse detected, revoking token family")
+			if err := s.tokens.RevokeTokenFamily(ctx, current.FamilyID); err != nil {
+				logger.Error("Failed to revoke token family", zap.Error(err))
+			}
+			response.Message = ErrInvalidRefreshToken.Error()
+			return response, ErrRefreshTokenReused
+		}
+		response.Message = ErrTokenGeneration.Error()
+		logger.Error("Error generating tokens", zap.Error(err))
+		return response, err
+	}
+
+	ip, userAgent := clientInfo(ctx)
+	if err := s.tokens.TouchSession(ctx, current.FamilyID, ip, userAgent); err != nil {
+		logger.Warn("Failed to update session", zap.Error(err))
+	}
+
+	response.Success = true
+	response.Message = "Token refreshed"
+	response.User = s.newUserInfo(user)
+	response.Token = access
+	response.RefreshToken = refresh
 	return response, nil
 }
 
+// tokenSubject describes user for an access token of the given session,
+// including the organization the session acts for.
+func (s UserService) tokenSubject(ctx context.Context, user *models.User, sessionID string) (*middleware.TokenSubject, error) {
+	role, err := s.roles.GetRole(ctx, user.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	orgID, orgRole, err := s.orgClaims(ctx, user.ID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &middleware.TokenSubject{
+		UserID:        strconv.Itoa(user.ID),
+		Username:      user.Email,
+		EmailVerified: user.EmailVerifiedAt != nil,
+		SessionID:     sessionID,
+		Role:          role.Name,
+		Permissions:   role.Permissions,
+		OrgID:         orgID,
+		OrgRole:       orgRole,
+	}, nil
+}
+
+// issueTokens generates an access token and a refresh token belonging to familyID.
+// When rotatedID is non-zero the refresh token with that ID is consumed atomically.
+func (s UserService) issueTokens(ctx context.Context, user *models.User, familyID string, rotatedID int) (*models.AuthToken, *models.AuthToken, error) {
+	subject, err := s.tokenSubject(ctx, user, familyID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accessToken, accessExpiresAt, err := s.tokenService.GenerateToken(*subject)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refreshToken, refreshHash, refreshExpiresAt, err := s.tokenService.GenerateRefreshToken()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	next := &models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: refreshHash,
+		ExpiresAt: refreshExpiresAt,
+		CreatedAt: time.Now(),
+	}
+	if rotatedID != 0 {
+		err = s.tokens.RotateRefreshToken(ctx, rotatedID, next)
+	} else {
+		err = s.tokens.CreateRefreshToken(ctx, next)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &models.AuthToken{Token: accessToken, ExpiresAt: accessExpiresAt},
+		&models.AuthToken{Token: refreshToken, ExpiresAt: refreshExpiresAt},
+		nil
+}
+
+// Logout revokes the access token used for this request and, if supplied,
+// the refresh token family it was issued alongside.
+func (s UserService) Logout(ctx context.Context, payload models.LogoutPayload) error {
+	claims, ok := ctx.Value("claims").(*middleware.AuthClaims)
+	if !ok {
+		return ErrUnauthorized
+	}
+
+	userID, err := strconv.Atoi(claims.UserID)
+	if err != nil {
+		return ErrUnauthorized
+	}
+
+	if err := s.tokens.RevokeAccessToken(ctx, claims.Id, userID, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		return err
+	}
+
+	// Ending the session also revokes its refresh tokens
+	if claims.SessionID != "" {
+		err := s.tokens.RevokeSession(ctx, userID, claims.SessionID)
+		if err != nil && !errors.Is(err, ErrSessionNotFound) {
+			return err
+		}
+	}
+
+	if payload.RefreshToken == "" {
+		return nil
+	}
+
+	refresh, err := s.tokens.GetRefreshTokenByHash(ctx, middleware.HashOpaqueToken(payload.RefreshToken))
+	if err != nil {
+		if errors.Is(err, ErrInvalidRefreshToken) {
+			return nil
+		}
+		return err
+	}
+
+	// Never let one user revoke another user's sessions
+	if refresh.UserID != userID {
+		return nil
+	}
+
+	return s.tokens.RevokeTokenFamily(ctx, refresh.FamilyID)
+}
+
+// LogoutAll revokes every access and refresh token of the current user.
+func (s UserService) LogoutAll(ctx context.Context) error {
+	userID, ok := ctx.Value("user_id").(string)
+	if !ok || isAPIKeyCaller(ctx) {
+		return ErrUnauthorized
+	}
+
+	id, err := strconv.Atoi(userID)
+	if err != nil {
+		return ErrUnauthorized
+	}
+
+	return s.tokens.RevokeAllUserTokens(ctx, id)
+}
+
+// ForgotPassword emails a single-use reset link to the account owner. It
+// succeeds whether or not the email is registered so it cannot be used to
+// discover accounts.
+func (s UserService) ForgotPassword(ctx context.Context, payload models.ForgotPasswordPayload) error {
+	user, err := s.repo.GetUserByEmail(ctx, payload.Email)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if user.DeletedAt != nil || user.DisabledAt != nil {
+		return nil
+	}
+
+	token, err := middleware.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	resetToken := &models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: middleware.HashOpaqueToken(token),
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+		CreatedAt: time.Now(),
+	}
+	if err := s.tokens.CreatePasswordResetToken(ctx, resetToken); err != nil {
+		return err
+	}
+
+	if err := s.notifier.SendPasswordReset(ctx, user, token, passwordResetTTL); err != nil {
+		s.log.Error("Failed to send password reset email", zap.Int("userID", user.ID), zap.Error(err))
+	}
+
+	return nil
+}
+
+// ResetPassword sets a new password using a reset token and signs the user
+// out everywhere.
+func (s UserService) ResetPassword(ctx context.Context, payload models.ResetPasswordPayload) error {
+	// Check the policy first so a weak password does not burn the token
+	if err := s.validatePassword(payload.Password); err != nil {
+		return err
+	}
+
+	// The token is only used up if the password is actually changed
+	var userID int
+	err := s.withAudit(ctx, models.AuditPasswordReset, 0, func(ctx context.Context, entry *models.AuditEntry) error {
+		var err error
+		if userID, err = s.tokens.ConsumePasswordResetToken(ctx, middleware.HashOpaqueToken(payload.Token)); err != nil {
+			return err
+		}
+		entry.TargetID = &userID
+		return s.setPassword(ctx, entry, userID, payload.Password)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.log.Info("Password reset", zap.Int("userID", userID))
+	return nil
+}
+
+// ChangePassword replaces the caller's own password after verifying the
+// current one, then signs them out everywhere.
+func (s UserService) ChangePassword(ctx context.Context, id int, payload models.ChangePasswordPayload) error {
+	// Knowing the current password is required, so nobody can do this on behalf of someone else
+	currentUser, err := s.requireSelf(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if !middleware.ComparePasswords(currentUser.PasswordHash, []byte(payload.CurrentPassword)) {
+		return ErrInvalidCredentials
+	}
+
+	if err := s.validatePassword(payload.NewPassword); err != nil {
+		return err
+	}
+
+	err = s.withAudit(ctx, models.AuditPasswordChange, id, func(ctx context.Context, entry *models.AuditEntry) error {
+		return s.setPassword(ctx, entry, id, payload.NewPassword)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.log.Info("Password changed", zap.Int("userID", id))
+	return nil
+}
+
+// validatePassword applies the configured password policy.
+func (s UserService) validatePassword(password string) error {
+	if err := s.policy.Validate(password); err != nil {
+		return fmt.Errorf("%w: %s", ErrWeakPassword, err)
+	}
+	return nil
+}
+
+// setPassword stores a new password hash after checking it against the
+// user's recent passwords, and revokes all outstanding tokens. Run it within
+// withAudit; the change is recorded in entry.
+func (s UserService) setPassword(ctx context.Context, entry *models.AuditEntry, userID int, password string) error {
+	if s.policy.HistorySize > 0 {
+		history, err := s.repo.GetPasswordHistory(ctx, userID, s.policy.HistorySize)
+		if err != nil {
+			return err
+		}
+
+		// Accounts created before history was kept only have their current hash
+		user, err := s.repo.GetUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		history = append(history, user.PasswordHash)
+
+		for _, previous := range history {
+			if middleware.ComparePasswords(previous, []byte(password)) {
+				return ErrPasswordReused
+			}
+		}
+	}
+
+	hashedPassword, err := middleware.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	recordChange(entry, "password_hash", "", hashedPassword)
+	if _, err := s.repo.UpdateUser(ctx, userID, map[string]interface{}{
+		"password_hash": hashedPassword,
+		"updated_at":    time.Now(),
+	}); err != nil {
+		return err
+	}
+
+	if err := s.repo.AddPasswordHistory(ctx, userID, hashedPassword); err != nil {
+		return err
+	}
+
+	if err := s.tokens.RevokeAllUserTokens(ctx, userID); err != nil {
+		s.log.Error("Failed to revoke tokens after password change", zap.Int("userID", userID), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
 func (s UserService) RegisterUser(ctx context.Context, payload models.RegisterUserPayload) (*models.User, error) {
 	// Check if user already exists
 	existingUser, err := s.repo.GetUserByEmail(ctx, payload.Email)
@@ -119,6 +641,10 @@ func (s UserService) RegisterUser(ctx context.Context, payload models.RegisterUs
 		return nil, ErrUserAlreadyExists
 	}
 
+	if err := s.validatePassword(payload.Password); err != nil {
+		return nil, err
+	}
+
 	// Hash password
 	hashedPassword, err := middleware.HashPassword(payload.Password)
 	if err != nil {
@@ -130,74 +656,300 @@ func (s UserService) RegisterUser(ctx context.Context, payload models.RegisterUs
 		Email:        payload.Email,
 		Name:         payload.Name,
 		PasswordHash: hashedPassword,
-		Role:         "admin",
+		Role:         DefaultRole,
 		CreatedAt:    time.Now(),
 		LastLogin:    time.Now(),
 		UpdatedAt:    time.Now(),
-		Avatar:       []byte(payload.Avatar),
 	}
 
 	// Save user to repository
-	return s.repo.CreateUser(ctx, newUser)
+	createdUser, err := s.repo.CreateUser(ctx, newUser)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.AddPasswordHistory(ctx, createdUser.ID, hashedPassword); err != nil {
+		s.log.Error("Failed to record initial password", zap.Int("userID", createdUser.ID), zap.Error(err))
+	}
+
+	// The account is usable right away, but sensitive actions wait for verification
+	if err := s.sendVerification(ctx, createdUser, createdUser.Email); err != nil {
+		s.log.Error("Failed to send verification email", zap.Int("userID", createdUser.ID), zap.Error(err))
+	}
+
+	return createdUser, nil
+}
+
+// VerifyEmail confirms an address using a token from a verification email.
+// Confirming a pending address makes it the account email and signs the user
+// out everywhere, as with any other credential change.
+func (s UserService) VerifyEmail(ctx context.Context, token string) (*models.User, error) {
+	verification, err := s.tokens.ConsumeEmailVerificationToken(ctx, middleware.HashOpaqueToken(token))
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.repo.GetUserByID(ctx, verification.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case user.PendingEmail != nil && *user.PendingEmail == verification.Email:
+		updatedUser, err := s.repo.UpdateUser(ctx, user.ID, map[string]interface{}{
+			"email":             verification.Email,
+			"pending_email":     nil,
+			"email_verified_at": time.Now(),
+			"updated_at":        time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.tokens.RevokeAllUserTokens(ctx, user.ID); err != nil {
+			s.log.Error("Failed to revoke tokens after email change", zap.Int("userID", user.ID), zap.Error(err))
+			return nil, err
+		}
+
+		s.log.Info("Email change confirmed", zap.Int("userID", user.ID))
+		return updatedUser, nil
+
+	case user.Email == verification.Email:
+		if user.EmailVerifiedAt != nil {
+			return user, nil
+		}
+
+		updatedUser, err := s.repo.UpdateUser(ctx, user.ID, map[string]interface{}{
+			"email_verified_at": time.Now(),
+			"updated_at":        time.Now(),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		s.log.Info("Email verified", zap.Int("userID", user.ID))
+		return updatedUser, nil
+
+	default:
+		// The address was superseded by a later change request
+		return nil, ErrInvalidVerificationToken
+	}
+}
+
+// ResendVerification sends a fresh verification email for the caller's
+// pending address, or for their current one if it is still unverified.
+func (s UserService) ResendVerification(ctx context.Context) error {
+	currentUser, err := s.extractUserFromContext(ctx)
+	if err != nil {
+		return ErrUnauthorized
+	}
+
+	switch {
+	case currentUser.PendingEmail != nil:
+		return s.sendVerification(ctx, currentUser, *currentUser.PendingEmail)
+	case currentUser.EmailVerifiedAt == nil:
+		return s.sendVerification(ctx, currentUser, currentUser.Email)
+	default:
+		return nil
+	}
+}
+
+func (s UserService) sendVerification(ctx context.Context, user *models.User, email string) error {
+	token, err := middleware.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	verification := &models.EmailVerificationToken{
+		UserID:    user.ID,
+		Email:     email,
+		TokenHash: middleware.HashOpaqueToken(token),
+		ExpiresAt: time.Now().Add(emailVerificationTTL),
+		CreatedAt: time.Now(),
+	}
+	if err := s.tokens.CreateEmailVerificationToken(ctx, verification); err != nil {
+		return err
+	}
+
+	return s.notifier.SendEmailVerification(ctx, user, email, token, emailVerificationTTL)
 }
 
-func (s UserService) GetUsers(ctx context.Context, offset, limit int) ([]*models.User, int, error) {
-	// Fetch paginated users
-	users, totalUsers, err := s.repo.GetUsers(ctx, offset, limit)
+// GetUsers lists users matching filter. Requires users:read, and
+// users:delete to see soft deleted users.
+func (s UserService) GetUsers(ctx context.Context, filter models.UserFilter) ([]*models.User, *utils.Total, error) {
+	if !canListUsers(ctx, filter) {
+		return nil, nil, ErrUnauthorized
+	}
+
+	users, total, err := s.repo.GetUsers(ctx, filter)
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, err
 	}
+	for _, user := range users {
+		s.withAvatarURLs(user)
+	}
+	return users, total, nil
+}
 
-	return users, totalUsers, nil
+func canListUsers(ctx context.Context, filter models.UserFilter) bool {
+	if !hasPermission(ctx, middleware.PermUsersRead) {
+		return false
+	}
+	return filter.Deleted == "" || filter.Deleted == models.DeletedExclude ||
+		hasPermission(ctx, middleware.PermUsersDelete)
 }
 
 func (s UserService) GetUserByID(ctx context.Context, id int) (*models.User, error) {
-	currentUser, err := s.checkPermissions(ctx, id)
+	currentUser, err := s.checkPermissions(ctx, id, middleware.PermUsersRead)
 	if err != nil {
 		return nil, err
 	}
 
-	return currentUser, nil
+	if currentUser.ID == id {
+		return s.withAvatarURLs(currentUser), nil
+	}
+
+	user, err := s.repo.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.withAvatarURLs(user), nil
 }
 
-func (s UserService) UpdateUser(ctx context.Context, id int, updates map[string]interface{}) (*models.User, error) {
-	_, err := s.checkPermissions(ctx, id)
+// UpdateUser applies updates to a user. A non-zero version makes the update
+// conditional: it fails with ErrVersionConflict unless the user is still at
+// that version.
+func (s UserService) UpdateUser(ctx context.Context, id int, updates map[string]interface{}, version int) (*models.User, error) {
+	_, err := s.checkPermissions(ctx, id, middleware.PermUsersWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := s.repo.GetUserByID(ctx, id)
 	if err != nil {
 		return nil, err
 	}
+	if version != 0 && target.Version != version {
+		return nil, ErrVersionConflict
+	}
+
+	// A new email only takes effect once the new address is confirmed
+	var pendingEmail string
+	if email, ok := updates["email"].(string); ok {
+		delete(updates, "email")
+
+		if email != target.Email {
+			if isAPIKeyCaller(ctx) {
+				return nil, ErrUnauthorized
+			}
+
+			existing, err := s.repo.GetUserByEmail(ctx, email)
+			if err != nil && !errors.Is(err, ErrUserNotFound) {
+				return nil, err
+			}
+			if existing != nil {
+				return nil, ErrDuplicateEmail
+			}
+
+			pendingEmail = email
+			updates["pending_email"] = email
+			updates["updated_at"] = time.Now()
+		}
+	}
 
-	updatedUser, err := s.repo.UpdateUser(ctx, id, updates)
+	if len(updates) == 0 {
+		return s.withAvatarURLs(target), nil
+	}
+
+	var updatedUser *models.User
+	err = s.withAudit(ctx, models.AuditUserUpdate, id, func(ctx context.Context, entry *models.AuditEntry) error {
+		for field, value := range updates {
+			if field != "updated_at" {
+				recordChange(entry, field, userFieldValue(target, field), value)
+			}
+		}
+
+		// Conditional on the version read above, so that nothing changed
+		// between the read and the diff recorded from it
+		updatedUser, err = s.repo.UpdateUserIfVersion(ctx, id, target.Version, updates)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return updatedUser, nil
-}
+	if pendingEmail != "" {
+		if err := s.sendVerification(ctx, updatedUser, pendingEmail); err != nil {
+			s.log.Error("Failed to send verification email", zap.Int("userID", id), zap.Error(err))
+		}
+	}
 
-func (s UserService) hasAccessToUser(user *models.User, id int) bool {
-	return user.ID == id || user.Role == AdminRole
+	return s.withAvatarURLs(updatedUser), nil
 }
 
-func (s UserService) DeleteUser(ctx context.Context, id int) error {
-	currentUser, err := s.extractUserFromContext(ctx)
-	if err != nil {
-		return ErrUnauthorized
+func (s UserService) newUserInfo(user *models.User) *models.UserInfo {
+	info := &models.UserInfo{
+		ID:            user.ID,
+		Email:         user.Email,
+		Name:          user.Name,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerifiedAt != nil,
 	}
+	if user.AvatarKey != nil {
+		info.AvatarURL = s.avatars.URLs(*user.AvatarKey)[strconv.Itoa(userInfoAvatarSize)]
+	}
+	return info
+}
 
-	// Check if user has permission
-	if !s.hasAccessToUser(currentUser, id) {
-		return ErrUnauthorized
+// hasAccessToUser allows users to act on their own account, and anyone
+// holding perm to act on any account. An API key is limited to its scopes,
+// on its owner's account too.
+func (s UserService) hasAccessToUser(ctx context.Context, user *models.User, id int, perm string) bool {
+	return (user.ID == id && !isAPIKeyCaller(ctx)) || hasPermission(ctx, perm)
+}
+
+// checkAccountManagement is checkPermissions for managing credentials, sessions
+// and the account itself, which API keys never may do, so that a leaked key
+// cannot take the account over or lock its owner out.
+func (s UserService) checkAccountManagement(ctx context.Context, id int, perm string) (*models.User, error) {
+	if isAPIKeyCaller(ctx) {
+		return nil, ErrUnauthorized
 	}
+	return s.checkPermissions(ctx, id, perm)
+}
 
-	err = s.repo.DeleteUser(ctx, id)
-	if err != nil {
+func (s UserService) DeleteUser(ctx context.Context, id int) error {
+	if _, err := s.checkAccountManagement(ctx, id, middleware.PermUsersDelete); err != nil {
 		return err
 	}
 
-	return nil
+	return s.deleteUser(ctx, id)
+}
+
+// deleteUser soft deletes a user and revokes their tokens.
+func (s UserService) deleteUser(ctx context.Context, id int) error {
+	return s.withAudit(ctx, models.AuditUserDelete, id, func(ctx context.Context, _ *models.AuditEntry) error {
+		if err := s.tokens.RevokeAllUserTokens(ctx, id); err != nil {
+			return err
+		}
+		return s.repo.DeleteUser(ctx, id)
+	})
+}
+
+// RestoreUser brings back a soft deleted user before it is purged. The user
+// has to log in again since their tokens were revoked on deletion. Erased
+// users are gone for good and are not found.
+func (s UserService) RestoreUser(ctx context.Context, id int) error {
+	if !hasPermission(ctx, middleware.PermUsersDelete) {
+		return ErrUnauthorized
+	}
+
+	return s.withAudit(ctx, models.AuditUserRestore, id, func(ctx context.Context, _ *models.AuditEntry) error {
+		return s.repo.RestoreUser(ctx, id)
+	})
 }
 
-func (s UserService) checkPermissions(ctx context.Context, id int) (*models.User, error) {
+func (s UserService) checkPermissions(ctx context.Context, id int, perm string) (*models.User, error) {
 	// Authenticate/authorize user from context
 	currentUser, err := s.extractUserFromContext(ctx)
 	if err != nil {
@@ -205,7 +957,7 @@ func (s UserService) checkPermissions(ctx context.Context, id int) (*models.User
 	}
 
 	// Check if user has permission
-	if !s.hasAccessToUser(currentUser, id) {
+	if !s.hasAccessToUser(ctx, currentUser, id, perm) {
 		return nil, ErrUnauthorized
 	}
 
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"go.uber.org/zap"
+)
+
+// refreshTokenStore keeps refresh tokens by hash and records revoked
+// families. raceLost makes the next rotation fail as if another request had
+// rotated the token first.
+type refreshTokenStore struct {
+	models.TokenRepository
+	tokens          map[string]*models.RefreshToken
+	revokedFamilies []string
+	raceLost        bool
+}
+
+func (f *refreshTokenStore) GetRefreshTokenByHash(_ context.Context, hash string) (*models.RefreshToken, error) {
+	token, ok := f.tokens[hash]
+	if !ok {
+		return nil, ErrInvalidRefreshToken
+	}
+	copied := *token
+	return &copied, nil
+}
+
+func (f *refreshTokenStore) RotateRefreshToken(_ context.Context, currentID int, next *models.RefreshToken) error {
+	if f.raceLost {
+		return ErrRefreshTokenReused
+	}
+	for _, token := range f.tokens {
+		if token.ID == currentID {
+			if token.RotatedAt != nil || token.RevokedAt != nil {
+				return ErrRefreshTokenReused
+			}
+			now := time.Now()
+			token.RotatedAt = &now
+		}
+	}
+	next.ID = len(f.tokens) + 1
+	f.tokens[next.TokenHash] = next
+	return nil
+}
+
+func (f *refreshTokenStore) RevokeTokenFamily(_ context.Context, familyID string) error {
+	f.revokedFamilies = append(f.revokedFamilies, familyID)
+	now := time.Now()
+	for _, token := range f.tokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (f *refreshTokenStore) TouchSession(context.Context, string, string, string) error {
+	return nil
+}
+
+func (f *refreshTokenStore) GetSessionOrg(context.Context, string) (*int, error) {
+	return nil, nil
+}
+
+func newRefreshTestService(tokenService middleware.TokenService, user *models.User) (UserService, *refreshTokenStore) {
+	users := &orgUserStore{users: map[int]*models.User{user.ID: user}}
+	tokens := &refreshTokenStore{tokens: map[string]*models.RefreshToken{
+		middleware.HashOpaqueToken("current"): {
+			ID:        1,
+			UserID:    user.ID,
+			FamilyID:  "family",
+			TokenHash: middleware.HashOpaqueToken("current"),
+			ExpiresAt: time.Now().Add(time.Hour),
+			CreatedAt: time.Now(),
+		},
+	}}
+
+	return UserService{
+		repo:         users,
+		tokens:       tokens,
+		roles:        staticRoles{},
+		tokenService: tokenService,
+		log:          zap.NewNop(),
+	}, tokens
+}
+
+func TestRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	tokenService := newTestTokenService(t)
+	current := models.RefreshTokenPayload{RefreshToken: "current"}
+
+	t.Run("rotates the token", func(t *testing.T) {
+		s, tokens := newRefreshTestService(tokenService, &models.User{ID: 1, Role: DefaultRole})
+
+		response, err := s.RefreshToken(ctx, current)
+		if err != nil {
+			t.Fatalf("RefreshToken: %v", err)
+		}
+		if !response.Success || response.Token == nil || response.RefreshToken == nil || response.User.ID != 1 {
+			t.Fatalf("response = %+v", response)
+		}
+		next, ok := tokens.tokens[middleware.HashOpaqueToken(response.RefreshToken.Token)]
+		if !ok || next.FamilyID != "family" || next.UserID != 1 {
+			t.Errorf("successor = %+v, want one in the same family", next)
+		}
+		if tokens.tokens[middleware.HashOpaqueToken("current")].RotatedAt == nil {
+			t.Error("presented token was not marked rotated")
+		}
+		if claims, err := tokenService.ValidateToken(response.Token.Token); err != nil || claims.SessionID != "family" {
+			t.Errorf("access token claims = %+v, %v", claims, err)
+		}
+
+		// The successor keeps working
+		if _, err := s.RefreshToken(ctx, models.RefreshTokenPayload{RefreshToken: response.RefreshToken.Token}); err != nil {
+			t.Errorf("refreshing with the successor: %v", err)
+		}
+	})
+
+	t.Run("reuse of a rotated token revokes the family", func(t *testing.T) {
+		s, tokens := newRefreshTestService(tokenService, &models.User{ID: 1, Role: DefaultRole})
+
+		response, err := s.RefreshToken(ctx, current)
+		if err != nil {
+			t.Fatalf("RefreshToken: %v", err)
+		}
+		if _, err := s.RefreshToken(ctx, current); !errors.Is(err, ErrRefreshTokenReused) {
+			t.Fatalf("replayed token: got %v, want ErrRefreshTokenReused", err)
+		}
+		if len(tokens.revokedFamilies) != 1 || tokens.revokedFamilies[0] != "family" {
+			t.Errorf("revoked families = %v, want [family]", tokens.revokedFamilies)
+		}
+
+		// The legitimate successor dies with the family
+		if _, err := s.RefreshToken(ctx, models.RefreshTokenPayload{RefreshToken: response.RefreshToken.Token}); !errors.Is(err, ErrInvalidRefreshToken) {
+			t.Errorf("successor after reuse: got %v, want ErrInvalidRefreshToken", err)
+		}
+	})
+
+	t.Run("losing a concurrent rotation revokes the family", func(t *testing.T) {
+		s, tokens := newRefreshTestService(tokenService, &models.User{ID: 1, Role: DefaultRole})
+		tokens.raceLost = true
+
+		response, err := s.RefreshToken(ctx, current)
+		if !errors.Is(err, ErrRefreshTokenReused) {
+			t.Fatalf("RefreshToken error = %v, want ErrRefreshTokenReused", err)
+		}
+		if response.Success || response.Token != nil {
+			t.Errorf("response = %+v, want no tokens", response)
+		}
+		if len(tokens.revokedFamilies) != 1 || tokens.revokedFamilies[0] != "family" {
+			t.Errorf("revoked families = %v, want [family]", tokens.revokedFamilies)
+		}
+	})
+
+	invalid := []struct {
+		name    string
+		token   func(*models.RefreshToken)
+		user    *models.User
+		payload string
+		wantErr error
+	}{
+		{name: "unknown token", payload: "unknown", wantErr: ErrInvalidRefreshToken},
+		{name: "revoked token", token: func(r *models.RefreshToken) { r.RevokedAt = timePtr(time.Now()) }, wantErr: ErrInvalidRefreshToken},
+		{name: "expired token", token: func(r *models.RefreshToken) { r.ExpiresAt = time.Now().Add(-time.Second) }, wantErr: ErrInvalidRefreshToken},
+		{name: "deleted user", user: &models.User{ID: 1, Role: DefaultRole, DeletedAt: timePtr(time.Now())}, wantErr: ErrUserDeleted},
+		{name: "disabled user", user: &models.User{ID: 1, Role: DefaultRole, DisabledAt: timePtr(time.Now())}, wantErr: ErrAccountDisabled},
+		{name: "missing user", user: &models.User{ID: 2, Role: DefaultRole}, wantErr: ErrInvalidRefreshToken},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			user := tt.user
+			if user == nil {
+				user = &models.User{ID: 1, Role: DefaultRole}
+			}
+			s, tokens := newRefreshTestService(tokenService, user)
+			if user.ID != 1 {
+				// The token belongs to user 1, who is gone
+				tokens.tokens[middleware.HashOpaqueToken("current")].UserID = 1
+			}
+			if tt.token != nil {
+				tt.token(tokens.tokens[middleware.HashOpaqueToken("current")])
+			}
+			payload := current
+			if tt.payload != "" {
+				payload.RefreshToken = tt.payload
+			}
+
+			response, err := s.RefreshToken(ctx, payload)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RefreshToken error = %v, want %v", err, tt.wantErr)
+			}
+			if response.Success || response.Token != nil || response.RefreshToken != nil {
+				t.Errorf("response = %+v, want no tokens", response)
+			}
+			if tokens.tokens[middleware.HashOpaqueToken("current")].RotatedAt != nil {
+				t.Error("a refused token was rotated")
+			}
+			if len(tokens.revokedFamilies) != 0 {
+				t.Errorf("revoked families = %v, want none", tokens.revokedFamilies)
+			}
+		})
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+)
+
+// maxUserAgentLength keeps clients from storing arbitrary amounts of data.
+const maxUserAgentLength = 512
+
+// ListSessions returns the active sessions of a user, flagging the one the
+// request was made from.
+func (s UserService) ListSessions(ctx context.Context, id int) ([]*models.Session, error) {
+	if _, err := s.checkAccountManagement(ctx, id, middleware.PermUsersRead); err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.tokens.ListSessions(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	currentID, _ := ctx.Value("session_id").(string)
+	for _, session := range sessions {
+		session.Current = currentID != "" && session.ID == currentID
+	}
+	return sessions, nil
+}
+
+// RevokeSession logs a user out of one device. Access tokens of the session
+// are rejected immediately, not only once they expire.
+func (s UserService) RevokeSession(ctx context.Context, id int, sessionID string) error {
+	if _, err := s.checkAccountManagement(ctx, id, middleware.PermUsersWrite); err != nil {
+		return err
+	}
+
+	return s.withAudit(ctx, models.AuditSessionRevoke, id, func(ctx context.Context, entry *models.AuditEntry) error {
+		recordChange(entry, "session", sessionID, nil)
+		return s.tokens.RevokeSession(ctx, id, sessionID)
+	})
+}
+
+// clientInfo returns the caller's IP and user agent as set by LoggingMiddleware.
+func clientInfo(ctx context.Context) (ip, userAgent string) {
+	ip, _ = ctx.Value("client_ip").(string)
+	userAgent, _ = ctx.Value("user_agent").(string)
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return ip, userAgent
+}
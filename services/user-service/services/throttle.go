@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/luisVargasGu/stockTracker/common/middleware"
+	"github.com/luisVargasGu/stockTracker/services/user-service/models"
+	"go.uber.org/zap"
+)
+
+const (
+	// Failures from one IP before it is slowed down
+	ipFailureThreshold = 10
+	ipBlockBase        = time.Second
+	ipBlockMax         = 15 * time.Minute
+	// An IP's history is forgotten after this long without failures
+	ipFailureWindow = 30 * time.Minute
+	sweepInterval   = time.Minute
+)
+
+type ipAttempts struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// LoginThrottler tracks failed logins per client IP and blocks an IP for an
+// exponentially growing period once it crosses a threshold. State is kept
+// in memory, so each replica throttles independently; per-account lockout
+// is enforced in the database and covers attacks spread across replicas.
+type LoginThrottler struct {
+	mu        sync.Mutex
+	attempts  map[string]*ipAttempts
+	lastSweep time.Time
+}
+
+func NewLoginThrottler() *LoginThrottler {
+	return &LoginThrottler{attempts: make(map[string]*ipAttempts)}
+}
+
+// RetryAfter returns how long ip must wait before trying again, or zero.
+func (t *LoginThrottler) RetryAfter(ip string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.attempts[ip]
+	if !ok {
+		return 0
+	}
+	if wait := time.Until(entry.blockedUntil); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Fail records a failed attempt from ip.
+func (t *LoginThrottler) Fail(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.sweep(now)
+
+	entry, ok := t.attempts[ip]
+	if !ok {
+		entry = &ipAttempts{}
+		t.attempts[ip] = entry
+	}
+
+	entry.failures++
+	entry.lastFailure = now
+	if entry.failures >= ipFailureThreshold {
+		entry.blockedUntil = now.Add(backoff(ipBlockBase, ipBlockMax, entry.failures-ipFailureThreshold))
+	}
+}
+
+// sweep drops entries that have been quiet for the whole window.
+func (t *LoginThrottler) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < sweepInterval {
+		return
+	}
+	t.lastSweep = now
+
+	for ip, entry := range t.attempts {
+		if now.Sub(entry.lastFailure) > ipFailureWindow && now.After(entry.blockedUntil) {
+			delete(t.attempts, ip)
+		}
+	}
+}
+
+// backoff returns base doubled n times, capped at max.
+func backoff(base, max time.Duration, n int) time.Duration {
+	d := base
+	for i := 0; i < n; i++ {
+		d *= 2
+		if d >= max {
+			return max
+		}
+	}
+	return d
+}
+
+// recordFailedLogin counts a failed attempt against both the client IP and
+// the account, locking the account once it crosses the threshold.
+func (s UserService) recordFailedLogin(ctx context.Context, userID int, ip string) {
+	s.throttle.Fail(ip)
+
+	lockedUntil, err := s.repo.RecordFailedLogin(ctx, userID,
+		accountLockThreshold, accountLockBase, accountLockMax)
+	if err != nil {
+		s.log.Error("Failed to record failed login", zap.Int("userID", userID), zap.Error(err))
+		return
+	}
+	if lockedUntil != nil && lockedUntil.After(time.Now()) {
+		s.log.Warn("Account locked after failed logins",
+			zap.Int("userID", userID), zap.Time("lockedUntil", *lockedUntil))
+	}
+}
+
+// UnlockUser lifts a lockout before it expires. Requires users:write.
+func (s UserService) UnlockUser(ctx context.Context, id int) error {
+	if !hasPermission(ctx, middleware.PermUsersWrite) {
+		return ErrUnauthorized
+	}
+
+	return s.withAudit(ctx, models.AuditUserUnlock, id, func(ctx context.Context, _ *models.AuditEntry) error {
+		return s.repo.UnlockUser(ctx, id)
+	})
+}
+
+func isLocked(user *models.User) bool {
+	return user.LockedUntil != nil && user.LockedUntil.After(time.Now())
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummyPasswordHash returns a bcrypt hash to compare against when no account
+// matches, so that unknown emails take as long as wrong passwords.
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		hash, err := middleware.HashPassword(uuid.NewString())
+		if err != nil {
+			panic(err)
+		}
+		dummyHash = hash
+	})
+	return dummyHash
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name      string
+		base, max time.Duration
+		n         int
+		want      time.Duration
+	}{
+		{"no doubling", time.Second, time.Minute, 0, time.Second},
+		{"doubled once", time.Second, time.Minute, 1, 2 * time.Second},
+		{"doubled five times", time.Second, time.Minute, 5, 32 * time.Second},
+		{"reaches the cap", time.Second, time.Minute, 6, time.Minute},
+		{"lands on the cap", time.Second, 32 * time.Second, 5, 32 * time.Second},
+		{"far past the cap", time.Second, time.Minute, 1000, time.Minute},
+		{"base at the cap", time.Minute, time.Minute, 0, time.Minute},
+		{"throttler cap", ipBlockBase, ipBlockMax, 10, ipBlockMax},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := backoff(tt.base, tt.max, tt.n); got != tt.want {
+				t.Errorf("backoff(%v, %v, %d) = %v, want %v", tt.base, tt.max, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginThrottler(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		want     time.Duration
+	}{
+		{"no failures", 0, 0},
+		{"one failure", 1, 0},
+		{"just below the threshold", ipFailureThreshold - 1, 0},
+		{"at the threshold", ipFailureThreshold, ipBlockBase},
+		{"one past the threshold", ipFailureThreshold + 1, 2 * ipBlockBase},
+		{"four past the threshold", ipFailureThreshold + 4, 16 * ipBlockBase},
+		{"far past the threshold", ipFailureThreshold + 100, ipBlockMax},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			throttle := NewLoginThrottler()
+			for i := 0; i < tt.failures; i++ {
+				throttle.Fail("192.0.2.1")
+			}
+
+			got := throttle.RetryAfter("192.0.2.1")
+			if got > tt.want || (tt.want > 0 && got < tt.want-time.Second/2) {
+				t.Errorf("RetryAfter = %v, want about %v", got, tt.want)
+			}
+			if other := throttle.RetryAfter("192.0.2.2"); other != 0 {
+				t.Errorf("another IP must wait %v", other)
+			}
+		})
+	}
+}
+
+func TestLoginThrottlerForgetsQuietIPs(t *testing.T) {
+	throttle := NewLoginThrottler()
+	for i := 0; i < ipFailureThreshold; i++ {
+		throttle.Fail("192.0.2.1")
+	}
+	throttle.Fail("192.0.2.2")
+
+	// Both were quiet for the whole window and neither is blocked any more
+	past := time.Now().Add(-ipFailureWindow - time.Minute)
+	for _, entry := range throttle.attempts {
+		entry.lastFailure, entry.blockedUntil = past, past
+	}
+	throttle.lastSweep = past
+
+	throttle.Fail("192.0.2.3")
+	if len(throttle.attempts) != 1 {
+		t.Errorf("kept %d IPs after the sweep, want only the new one", len(throttle.attempts))
+	}
+
+	// A forgotten IP starts over below the threshold
+	throttle.Fail("192.0.2.1")
+	if wait := throttle.RetryAfter("192.0.2.1"); wait != 0 {
+		t.Errorf("forgotten IP must wait %v", wait)
+	}
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Seed of the SHA1 test vectors in RFC 6238 appendix B
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// The RFC lists 8 digit codes; 6 digit codes are their last six digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	key := []byte("12345678901234567890")
+	for _, tt := range tests {
+		if got := totpCode(key, tt.unix/totpPeriod); got != tt.code {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+
+		step, ok := validateTOTP(rfc6238Secret, tt.code, time.Unix(tt.unix, 0))
+		if !ok || step != tt.unix/totpPeriod {
+			t.Errorf("validateTOTP at %d = (%d, %v), want (%d, true)", tt.unix, step, ok, tt.unix/totpPeriod)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	key := []byte("12345678901234567890")
+	// 1111111111 is step 37037037, 1 second into it
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"current step", 0, true},
+		{"previous step", -1, true},
+		{"next step", 1, true},
+		{"two steps behind", -2, false},
+		{"two steps ahead", 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := totpCode(key, current+tt.offset)
+			step, ok := validateTOTP(rfc6238Secret, code, now)
+			if ok != tt.ok {
+				t.Fatalf("validateTOTP(step %+d) ok = %v, want %v", tt.offset, ok, tt.ok)
+			}
+			if ok && step != current+tt.offset {
+				t.Errorf("matched step %d, want %d", step, current+tt.offset)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPStepBoundary(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	// The last second of a step and the first of the next see different
+	// windows
+	last := time.Unix(60*totpPeriod-1, 0)
+	first := time.Unix(60*totpPeriod, 0)
+
+	old := totpCode(key, 58)
+	if _, ok := validateTOTP(rfc6238Secret, old, last); !ok {
+		t.Error("code of the previous step rejected in the last second of the step")
+	}
+	if _, ok := validateTOTP(rfc6238Secret, old, first); ok {
+		t.Error("code two steps old accepted")
+	}
+}
+
+func TestValidateTOTPInput(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		ok     bool
+	}{
+		{"surrounding spaces", rfc6238Secret, " 005924 ", true},
+		{"lower case secret", strings.ToLower(rfc6238Secret), "005924", true},
+		{"wrong code", rfc6238Secret, "005925", false},
+		{"too short", rfc6238Secret, "05924", false},
+		{"too long", rfc6238Secret, "0005924", false},
+		{"eight digit code", rfc6238Secret, "89005924", false},
+		{"empty", rfc6238Secret, "", false},
+		{"invalid secret", "not base32!", "005924", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := validateTOTP(tt.secret, tt.code, now); ok != tt.ok {
+				t.Errorf("validateTOTP(%q) ok = %v, want %v", tt.code, ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != totpSecretBytes {
+		t.Errorf("secret %q decodes to %d bytes, err %v", secret, len(key), err)
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d codes, want %d", len(codes), recoveryCodeCount)
+	}
+
+	format := regexp.MustCompile(`^[` + recoveryAlphabet + `]{5}-[` + recoveryAlphabet + `]{5}$`)
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("code %q is not formatted as xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("duplicate code %q", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	for _, code := range []string{"abcde-fghjk", "ABCDE-FGHJK", "abcdefghjk", "abcde fghjk", " Abcde-Fghjk "} {
+		if got := normalizeRecoveryCode(code); got != "abcdefghjk" {
+			t.Errorf("normalizeRecoveryCode(%q) = %q", code, got)
+		}
+	}
+}